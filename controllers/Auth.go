@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"context"
+	"log"
 	"net/http"
 	"os"
 	"time"
@@ -89,6 +90,8 @@ func insertNewUser(inputVal model.User) error {
 	return err
 }
 
+// createUserCollection creates the user's own collection, which holds
+// their cart.
 func createUserCollection(userID string) error {
 	_, err := helpers.CollectionExistsOrCreate(Client, userID)
 	return err
@@ -146,6 +149,11 @@ func LoginClient(ctx *gin.Context) {
 		false,           // HttpOnly (false for debugging)
 	)
 
+	// Carry over what the user added to their cart before signing in
+	if err := mergeGuestCart(ctx, user.Id); err != nil {
+		log.Printf("failed to merge the guest cart of user %s: %v", user.Id.Hex(), err)
+	}
+
 	// Set Authorization header as well
 	ctx.Header("Authorization", "Bearer "+tokenString)
 
@@ -172,3 +180,13 @@ func Validate(ctx *gin.Context) {
 		"data": user,
 	})
 }
+
+// currentUser returns the user attached by the auth middleware, if any.
+func currentUser(ctx *gin.Context) (model.AuthUser, bool) {
+	value, ok := ctx.Get("user")
+	if !ok {
+		return model.AuthUser{}, false
+	}
+	user, ok := value.(model.AuthUser)
+	return user, ok
+}
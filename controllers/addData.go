@@ -11,9 +11,19 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// AddProduct creates a product. With "template", the id or name of a
+// product template, the body starts from the template's defaults.
 func AddProduct(ctx *gin.Context) {
 
 	inputVals := model.Product{}
+	if ref := ctx.Query("template"); ref != "" {
+		template, err := findTemplate(ref)
+		if err != nil {
+			respondTemplateError(ctx, err, "Failed to get template")
+			return
+		}
+		inputVals = helpers.ProductFromTemplate(*template)
+	}
 	if err := ctx.ShouldBindJSON(&inputVals); err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{
 			"message": invalidBody,
@@ -30,22 +40,56 @@ func AddProduct(ctx *gin.Context) {
 		return
 	}
 
+	if err := checkSKUsAvailable(inputVals); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if err := checkDevicesExist(inputVals.CompatibleDevices); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if err := applyCategoryNames(&inputVals); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+
 	// insert default values
 	inputVals.Id = primitive.NewObjectID()
 	inputVals.TimeStamp.CreatedAt = time.Now()
 	inputVals.TimeStamp.UpdatedAt = time.Now()
 
+	// Ratings come from reviews only
+	inputVals.Rating, inputVals.ReviewCount, inputVals.RatingDistribution = 0, 0, nil
+
 	// Set default values if not provided
-	if inputVals.Comments == nil {
-		inputVals.Comments = []string{}
-	}
-	if inputVals.Rating == 0 {
-		inputVals.Rating = 0.0
-	}
 	if inputVals.Discount == 0 {
 		inputVals.Discount = 0.0
 	}
 
+	if err := assignProductSlugs(&inputVals); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if err := applyInitialStatus(&inputVals); err != nil {
+		respondPublishError(ctx, err)
+		return
+	}
+
 	collection := Client.Database(dbName).Collection(colName)
 	_, err := collection.InsertOne(context.Background(), inputVals)
 	if err != nil {
@@ -56,6 +100,9 @@ func AddProduct(ctx *gin.Context) {
 		return
 	}
 
+	afterProductsSaved(inputVals)
+	recordRevisions(requestActor(ctx), model.RevisionCreate, inputVals)
+
 	ctx.JSON(http.StatusCreated, gin.H{
 		"message":   productAdded,
 		"productId": inputVals.Id,
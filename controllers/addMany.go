@@ -32,6 +32,40 @@ func AddManyProducts(ctx *gin.Context) {
 			})
 			return
 		}
+		if err := checkSKUsAvailable(inputVal); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid body",
+				"error":   err.Error(),
+			})
+			return
+		}
+		if err := checkDevicesExist(inputVal.CompatibleDevices); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid body",
+				"error":   err.Error(),
+			})
+			return
+		}
+	}
+
+	batch := make([]*model.Product, len(inputVals))
+	for i := range inputVals {
+		batch[i] = &inputVals[i]
+	}
+	if err := applyCategoryNames(batch...); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid body",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if err := checkBatchSKUs(inputVals); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid body",
+			"error":   err.Error(),
+		})
+		return
 	}
 
 	// Step 3: Set default values where needed
@@ -40,20 +74,26 @@ func AddManyProducts(ctx *gin.Context) {
 		inputVals[i].TimeStamp.CreatedAt = time.Now() // Set CreatedAt
 		inputVals[i].TimeStamp.UpdatedAt = time.Now() // Set UpdatedAt
 
-		// Default empty slice for comments if not provided
-		if inputVals[i].Comments == nil {
-			inputVals[i].Comments = []string{}
-		}
-		// Set default rating if it's zero (if this is required)
-		if inputVals[i].Rating == 0.0 {
-			inputVals[i].Rating = 0.0
-		}
+		// Ratings come from reviews only
+		inputVals[i].Rating, inputVals[i].ReviewCount, inputVals[i].RatingDistribution = 0, 0, nil
 		// Set default discount if it's zero
 		if inputVals[i].Discount == 0.0 {
 			inputVals[i].Discount = 0.0
 		}
 	}
 
+	if err := assignProductSlugs(batch...); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid body",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if err := applyInitialStatus(batch...); err != nil {
+		respondPublishError(ctx, err)
+		return
+	}
+
 	// Step 4: Ensure that the MongoDB client and collection are valid
 	if Client == nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
@@ -90,6 +130,8 @@ func AddManyProducts(ctx *gin.Context) {
 	}
 
 	// Step 6: Successfully added products
+	afterProductsSaved(inputVals...)
+	recordRevisions(requestActor(ctx), model.RevisionCreate, inputVals...)
 	ctx.JSON(http.StatusCreated, gin.H{
 		"message": "Products added successfully",
 	})
@@ -0,0 +1,409 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joshua/casify/helpers"
+	"github.com/joshua/casify/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const guestCartColName = "guest_carts"
+
+const (
+	// CartCookie holds the id of a guest's cart.
+	CartCookie = "cart_id"
+	// userCartId is the id of the cart document in a user's collection.
+	userCartId = "cart"
+)
+
+const itemNotInCart = "Item not in cart"
+
+// cartAttempts bounds how often updateCart starts over after losing a race
+// with another change to the same cart.
+const cartAttempts = 5
+
+var (
+	// errCartChanged is returned by saveCart when the cart was saved by
+	// another request since it was loaded.
+	errCartChanged = errors.New("cart was changed concurrently")
+	errNotInCart   = errors.New("item not in cart")
+)
+
+func guestCartCollection() *mongo.Collection {
+	return Client.Database(dbName).Collection(guestCartColName)
+}
+
+// userCollection is the collection created for each user at registration.
+func userCollection(userId primitive.ObjectID) *mongo.Collection {
+	return Client.Database(dbName).Collection(userId.Hex())
+}
+
+// ensureCartIndexes expires abandoned guest carts.
+func ensureCartIndexes() error {
+	_, err := guestCartCollection().Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+// cartRef locates a cart: the signed in user's, or a guest's by cookie.
+type cartRef struct {
+	collection *mongo.Collection
+	id         string
+	guest      bool
+}
+
+// resolveCart finds the cart of the request. A guest without a cart gets
+// one, and its cookie, only when create is set; otherwise ok is false.
+func resolveCart(ctx *gin.Context, create bool) (ref cartRef, ok bool) {
+	if user, ok := currentUser(ctx); ok {
+		return cartRef{collection: userCollection(user.Id), id: userCartId}, true
+	}
+	if id, err := ctx.Cookie(CartCookie); err == nil && id != "" {
+		return cartRef{collection: guestCartCollection(), id: id, guest: true}, true
+	}
+	if !create {
+		return cartRef{}, false
+	}
+	id, err := helpers.RandomToken(16)
+	if err != nil {
+		return cartRef{}, false
+	}
+	ctx.SetCookie(CartCookie, id, int(helpers.GuestCartTTLFromEnv().Seconds()), "/", "", false, true)
+	return cartRef{collection: guestCartCollection(), id: id, guest: true}, true
+}
+
+// loadCart returns the cart at ref, empty when it was never saved.
+func loadCart(ref cartRef) (model.Cart, error) {
+	var cart model.Cart
+	err := ref.collection.FindOne(context.Background(), bson.M{"_id": ref.id}).Decode(&cart)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return model.Cart{Id: ref.id, Items: []model.CartItem{}}, nil
+	}
+	return cart, err
+}
+
+// saveCart stores the cart at ref unless it changed since it was loaded,
+// in which case errCartChanged is returned. Guest carts are kept for
+// GUEST_CART_TTL after their last change.
+func saveCart(ref cartRef, cart *model.Cart) error {
+	filter := bson.M{"_id": ref.id, "version": cart.Version}
+	if cart.Version == 0 {
+		// New carts, and carts saved before they had a version
+		filter["version"] = bson.M{"$in": bson.A{0, nil}}
+	}
+	saved := *cart
+	saved.Id = ref.id
+	saved.UpdatedAt = time.Now()
+	saved.ExpiresAt = nil
+	saved.Version++
+	if ref.guest {
+		expires := saved.UpdatedAt.Add(helpers.GuestCartTTLFromEnv())
+		saved.ExpiresAt = &expires
+	}
+	// A lost race misses the filter, and the upsert then collides with
+	// the existing cart
+	_, err := ref.collection.ReplaceOne(context.Background(), filter, saved, options.Replace().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return errCartChanged
+	}
+	if err != nil {
+		return err
+	}
+	*cart = saved
+	return nil
+}
+
+// updateCart applies change to the cart at ref and saves it. When another
+// request saved the cart in between, change is applied again to a fresh
+// copy.
+func updateCart(ref cartRef, change func(cart *model.Cart) error) (model.Cart, error) {
+	for attempt := 1; ; attempt++ {
+		cart, err := loadCart(ref)
+		if err != nil {
+			return model.Cart{}, err
+		}
+		if err := change(&cart); err != nil {
+			return model.Cart{}, err
+		}
+		err = saveCart(ref, &cart)
+		if errors.Is(err, errCartChanged) && attempt < cartAttempts {
+			continue
+		}
+		if err != nil {
+			return model.Cart{}, err
+		}
+		return cart, nil
+	}
+}
+
+// findSKUProducts loads the products selling any of skus.
+func findSKUProducts(skus []string) ([]model.Product, error) {
+	products := []model.Product{}
+	if len(skus) == 0 {
+		return products, nil
+	}
+	cursor, err := Client.Database(dbName).Collection(colName).Find(context.Background(), bson.M{
+		"$or": []bson.M{
+			{"sku": bson.M{"$in": skus}},
+			{"variants.sku": bson.M{"$in": skus}},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(context.Background(), &products); err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+// priceCart re-prices a cart against the catalog and current stock.
+func priceCart(cart model.Cart) (helpers.CartView, error) {
+	skus := make([]string, len(cart.Items))
+	for i, item := range cart.Items {
+		skus[i] = item.SKU
+	}
+	products, err := findSKUProducts(skus)
+	if err != nil {
+		return helpers.CartView{}, err
+	}
+	levels, err := loadStockLevels(skus)
+	if err != nil {
+		return helpers.CartView{}, err
+	}
+	return helpers.PriceCart(cart, products, levels), nil
+}
+
+// checkCartStock makes sure quantity units of sku are available.
+func checkCartStock(sku string, quantity int) error {
+	levels, err := loadStockLevels([]string{sku})
+	if err != nil {
+		return err
+	}
+	if len(levels) == 0 || levels[0].Available() < quantity {
+		return &errInsufficientStock{SKU: sku, Requested: quantity}
+	}
+	return nil
+}
+
+func respondCart(ctx *gin.Context, status int, message string, cart model.Cart) {
+	view, err := priceCart(cart)
+	if err != nil {
+		respondCartError(ctx, err)
+		return
+	}
+	body := gin.H{"data": view}
+	if message != "" {
+		body["message"] = message
+	}
+	ctx.JSON(status, body)
+}
+
+func respondCartError(ctx *gin.Context, err error) {
+	var insufficient *errInsufficientStock
+	switch {
+	case errors.As(err, &insufficient):
+		respondStockError(ctx, err)
+	case errors.Is(err, helpers.ErrCartLimit):
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+	case errors.Is(err, errNotInCart):
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": itemNotInCart,
+		})
+	case errors.Is(err, errCartChanged):
+		ctx.JSON(http.StatusConflict, gin.H{
+			"message": "Cart is being changed by another request, try again",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, helpers.ErrSKUNotSold):
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": "Item not available",
+			"error":   err.Error(),
+		})
+	default:
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to update cart",
+			"error":   err.Error(),
+		})
+	}
+}
+
+// mergeGuestCart moves the guest cart of the request into the user's cart
+// and forgets the guest cart. It runs when a guest signs in.
+func mergeGuestCart(ctx *gin.Context, userId primitive.ObjectID) error {
+	id, err := ctx.Cookie(CartCookie)
+	if err != nil || id == "" {
+		return nil
+	}
+	guestRef := cartRef{collection: guestCartCollection(), id: id, guest: true}
+	guest, err := loadCart(guestRef)
+	if err != nil {
+		return err
+	}
+	if len(guest.Items) > 0 {
+		userRef := cartRef{collection: userCollection(userId), id: userCartId}
+		_, err := updateCart(userRef, func(cart *model.Cart) error {
+			*cart = helpers.MergeCarts(*cart, guest, helpers.CartLimitsFromEnv())
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := guestCartCollection().DeleteOne(context.Background(), bson.M{"_id": id}); err != nil {
+		log.Printf("failed to delete merged guest cart %s: %v", id, err)
+	}
+	ctx.SetCookie(CartCookie, "", -1, "/", "", false, true)
+	return nil
+}
+
+// addToCart adds quantity units of sku to the cart at ref at today's
+// price, as long as they are in stock.
+func addToCart(ref cartRef, sku string, quantity int) (model.Cart, error) {
+	products, err := findSKUProducts([]string{sku})
+	if err != nil {
+		return model.Cart{}, err
+	}
+	var item model.CartItem
+	err = fmt.Errorf("%w: %s", helpers.ErrSKUNotSold, sku)
+	for _, p := range products {
+		if item, err = helpers.CartItemFor(p, sku, quantity, time.Now()); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return model.Cart{}, err
+	}
+
+	return updateCart(ref, func(cart *model.Cart) error {
+		if err := helpers.AddCartItem(cart, item, helpers.CartLimitsFromEnv()); err != nil {
+			return err
+		}
+		for _, line := range cart.Items {
+			if line.SKU == sku {
+				return checkCartStock(sku, line.Quantity)
+			}
+		}
+		return nil
+	})
+}
+
+// GetCart returns the cart of the signed in user or guest, re-priced
+// against the current catalog.
+func GetCart(ctx *gin.Context) {
+	cart := model.Cart{Items: []model.CartItem{}}
+	if ref, ok := resolveCart(ctx, false); ok {
+		var err error
+		if cart, err = loadCart(ref); err != nil {
+			respondCartError(ctx, err)
+			return
+		}
+	}
+	respondCart(ctx, http.StatusOK, "", cart)
+}
+
+// AddCartItem adds "quantity" (default 1) units of "sku" to the cart. The
+// price is snapshotted so later changes can be pointed out.
+func AddCartItem(ctx *gin.Context) {
+	var input struct {
+		SKU      string `json:"sku" binding:"required"`
+		Quantity int    `json:"quantity"`
+	}
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	if input.Quantity == 0 {
+		input.Quantity = 1
+	}
+
+	ref, ok := resolveCart(ctx, true)
+	if !ok {
+		respondCartError(ctx, errors.New("failed to create a guest cart"))
+		return
+	}
+	cart, err := addToCart(ref, input.SKU, input.Quantity)
+	if err != nil {
+		respondCartError(ctx, err)
+		return
+	}
+	respondCart(ctx, http.StatusOK, "Item added to cart", cart)
+}
+
+// UpdateCartItem sets the quantity of a SKU in the cart. A quantity of 0
+// removes it.
+func UpdateCartItem(ctx *gin.Context) {
+	var input struct {
+		Quantity *int `json:"quantity" binding:"required"`
+	}
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	changeCartItem(ctx, *input.Quantity)
+}
+
+// RemoveCartItem removes a SKU from the cart.
+func RemoveCartItem(ctx *gin.Context) {
+	changeCartItem(ctx, 0)
+}
+
+func changeCartItem(ctx *gin.Context, quantity int) {
+	sku := ctx.Param("sku")
+	ref, ok := resolveCart(ctx, false)
+	if !ok {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": itemNotInCart,
+		})
+		return
+	}
+	cart, err := updateCart(ref, func(cart *model.Cart) error {
+		found, err := helpers.SetCartQuantity(cart, sku, quantity, helpers.CartLimitsFromEnv())
+		if !found {
+			return errNotInCart
+		}
+		if err == nil && quantity > 0 {
+			err = checkCartStock(sku, quantity)
+		}
+		return err
+	})
+	if err != nil {
+		respondCartError(ctx, err)
+		return
+	}
+	respondCart(ctx, http.StatusOK, "Cart updated", cart)
+}
+
+// ClearCart empties the cart.
+func ClearCart(ctx *gin.Context) {
+	if ref, ok := resolveCart(ctx, false); ok {
+		if _, err := ref.collection.DeleteOne(context.Background(), bson.M{"_id": ref.id}); err != nil {
+			respondCartError(ctx, err)
+			return
+		}
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Cart cleared",
+	})
+}
@@ -0,0 +1,623 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joshua/casify/helpers"
+	"github.com/joshua/casify/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const categoryColName = "categories"
+
+const (
+	categoryNotFound = "Category not found"
+	categoryExists   = "Category already exists"
+)
+
+func categoryCollection() *mongo.Collection {
+	return Client.Database(dbName).Collection(categoryColName)
+}
+
+// ensureCategoryIndexes makes slugs unique and backs subtree lookups on
+// products.
+func ensureCategoryIndexes() error {
+	if _, err := categoryCollection().Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "slug", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		return err
+	}
+	collection := Client.Database(dbName).Collection(colName)
+	_, err := collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "category_ids", Value: 1}},
+	})
+	return err
+}
+
+// loadCategoryTree reads every category into a tree. The catalog has few
+// enough categories that this is cheap.
+func loadCategoryTree() (*helpers.CategoryTree, error) {
+	cursor, err := categoryCollection().Find(context.Background(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	var categories []model.Category
+	if err := cursor.All(context.Background(), &categories); err != nil {
+		return nil, err
+	}
+	return helpers.BuildCategoryTree(categories), nil
+}
+
+// categoryFilter builds the listing filter for the "category_id" query
+// parameter: products in the category, given by id or slug, or anywhere
+// below it.
+func categoryFilter(ref string) (helpers.FilterNode, error) {
+	tree, err := loadCategoryTree()
+	if err != nil {
+		return nil, err
+	}
+	node, ok := tree.Lookup(ref)
+	if !ok {
+		return nil, &queryError{message: "Invalid category", err: fmt.Errorf("unknown category %q", ref)}
+	}
+	ids := tree.Subtree(node.Id)
+	values := make([]interface{}, len(ids))
+	for i, id := range ids {
+		values[i] = id
+	}
+	return helpers.NewIn("category_id", values)
+}
+
+// applyCategoryNames fills the category names of products that reference
+// the tree, so name based filters and facets keep working.
+func applyCategoryNames(products ...*model.Product) error {
+	var tree *helpers.CategoryTree
+	for _, p := range products {
+		if len(p.CategoryIds) == 0 {
+			continue
+		}
+		if tree == nil {
+			var err error
+			if tree, err = loadCategoryTree(); err != nil {
+				return err
+			}
+		}
+		names, err := tree.Names(p.CategoryIds)
+		if err != nil {
+			return err
+		}
+		p.Category = names
+	}
+	return nil
+}
+
+// syncCategoryProducts refreshes the category names of every product that
+// references one of ids, e.g. after a rename by actor.
+func syncCategoryProducts(actor primitive.ObjectID, ids ...primitive.ObjectID) error {
+	tree, err := loadCategoryTree()
+	if err != nil {
+		return err
+	}
+	collection := Client.Database(dbName).Collection(colName)
+	cursor, err := collection.Find(context.Background(), bson.M{"category_ids": bson.M{"$in": ids}})
+	if err != nil {
+		return err
+	}
+	var products []model.Product
+	if err := cursor.All(context.Background(), &products); err != nil {
+		return err
+	}
+
+	for i, p := range products {
+		var known []primitive.ObjectID
+		for _, id := range p.CategoryIds {
+			if _, ok := tree.Get(id); ok {
+				known = append(known, id)
+			}
+		}
+		names, _ := tree.Names(known)
+		update := bson.M{"$set": bson.M{"category_ids": known, "category": names}}
+		if len(known) == 0 {
+			update = bson.M{"$unset": bson.M{"category_ids": "", "category": ""}}
+		}
+		if _, err := collection.UpdateOne(context.Background(), bson.M{"_id": p.Id}, update); err != nil {
+			return err
+		}
+		products[i].CategoryIds = known
+		products[i].Category = names
+	}
+	afterProductsSaved(products...)
+	recordRevisions(actor, model.RevisionUpdate, products...)
+	return nil
+}
+
+func respondCategoryLoadError(ctx *gin.Context, err error) {
+	ctx.JSON(http.StatusInternalServerError, gin.H{
+		"message": "Failed to load categories",
+		"error":   err.Error(),
+	})
+}
+
+// categoryInput is the body accepted when creating or updating a category.
+type categoryInput struct {
+	Name        string              `json:"name" binding:"required"`
+	Slug        string              `json:"slug"`
+	ParentId    *primitive.ObjectID `json:"parent_id"`
+	SortOrder   int                 `json:"sort_order"`
+	Description string              `json:"description"`
+	SEO         model.CategorySEO   `json:"seo"`
+}
+
+// resolveSlug returns the requested slug, or one derived from the name that
+// no other category uses.
+func (in categoryInput) resolveSlug(tree *helpers.CategoryTree, id primitive.ObjectID) (string, error) {
+	if in.Slug != "" {
+		slug := helpers.Slugify(in.Slug)
+		if slug != in.Slug {
+			return "", fmt.Errorf("slug may only contain lowercase letters, digits and dashes")
+		}
+		return slug, nil
+	}
+	base := helpers.Slugify(in.Name)
+	if base == "" {
+		return "", errors.New("name must contain letters or digits")
+	}
+	return helpers.UniqueSlug(base, func(slug string) bool { return tree.SlugTaken(slug, id) }), nil
+}
+
+// GetCategories returns the whole category tree.
+func GetCategories(ctx *gin.Context) {
+	tree, err := loadCategoryTree()
+	if err != nil {
+		respondCategoryLoadError(ctx, err)
+		return
+	}
+	roots := tree.Roots
+	if roots == nil {
+		roots = []*helpers.CategoryNode{}
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"data": roots,
+	})
+}
+
+// GetCategory returns a category, given by id or slug, with its children
+// and breadcrumbs.
+func GetCategory(ctx *gin.Context) {
+	tree, err := loadCategoryTree()
+	if err != nil {
+		respondCategoryLoadError(ctx, err)
+		return
+	}
+	node, ok := tree.Lookup(ctx.Param("id"))
+	if !ok {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": categoryNotFound,
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"data":        node,
+		"breadcrumbs": tree.Breadcrumbs(node.Id),
+	})
+}
+
+// GetCategoryProducts lists the products in a category and everything
+// below it, with the usual listing filters and pagination.
+func GetCategoryProducts(ctx *gin.Context) {
+	subtree, err := categoryFilter(ctx.Param("id"))
+	if err != nil {
+		var qErr *queryError
+		if errors.As(err, &qErr) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": categoryNotFound,
+				"error":   qErr.err.Error(),
+			})
+			return
+		}
+		respondCategoryLoadError(ctx, err)
+		return
+	}
+	node, err := parseProductFilter(ctx)
+	if err != nil {
+		respondQueryError(ctx, err)
+		return
+	}
+	params, err := parseListParams(ctx, "-created_at")
+	if err != nil {
+		respondQueryError(ctx, err)
+		return
+	}
+	findProductPage(ctx, helpers.CompileFilter(helpers.And(subtree, node, helpers.LiveProductFilter())), params, nil)
+}
+
+// AddCategory creates a category. The slug is derived from the name unless
+// one is given.
+func AddCategory(ctx *gin.Context) {
+	var input categoryInput
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	tree, err := loadCategoryTree()
+	if err != nil {
+		respondCategoryLoadError(ctx, err)
+		return
+	}
+
+	category := model.Category{
+		Id:          primitive.NewObjectID(),
+		Name:        strings.TrimSpace(input.Name),
+		ParentId:    input.ParentId,
+		SortOrder:   input.SortOrder,
+		Description: input.Description,
+		SEO:         input.SEO,
+	}
+	if err := tree.CheckParent(category.Id, category.ParentId); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	if category.Slug, err = input.resolveSlug(tree, category.Id); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	category.TimeStamp.CreatedAt = time.Now()
+	category.TimeStamp.UpdatedAt = time.Now()
+
+	if _, err := categoryCollection().InsertOne(context.Background(), category); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"message": categoryExists,
+				"error":   err.Error(),
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to add category",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"message":    "Category added successfully",
+		"categoryId": category.Id,
+		"slug":       category.Slug,
+	})
+}
+
+// UpdateCategory replaces the details of a category, including moving it
+// to another parent.
+func UpdateCategory(ctx *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	var input categoryInput
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	tree, err := loadCategoryTree()
+	if err != nil {
+		respondCategoryLoadError(ctx, err)
+		return
+	}
+	current, ok := tree.Get(id)
+	if !ok {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": categoryNotFound,
+		})
+		return
+	}
+	if err := tree.CheckParent(id, input.ParentId); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	// Keep the slug stable across renames unless a new one is asked for
+	if input.Slug == "" {
+		input.Slug = current.Slug
+	}
+	slug, err := input.resolveSlug(tree, id)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	set := bson.M{
+		"name":                  strings.TrimSpace(input.Name),
+		"slug":                  slug,
+		"sort_order":            input.SortOrder,
+		"description":           input.Description,
+		"seo":                   input.SEO,
+		"time_stamp.updated_at": time.Now(),
+	}
+	update := bson.M{"$set": set}
+	if input.ParentId != nil {
+		set["parent_id"] = input.ParentId
+	} else {
+		update["$unset"] = bson.M{"parent_id": ""}
+	}
+
+	var category model.Category
+	err = categoryCollection().FindOneAndUpdate(context.Background(), bson.M{"_id": id}, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&category)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"message": categoryExists,
+				"error":   err.Error(),
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to update category",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if category.Name != current.Name {
+		if err := syncCategoryProducts(requestActor(ctx), id); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Category updated but products were not updated",
+				"error":   err.Error(),
+			})
+			return
+		}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Category updated successfully",
+		"data":    category,
+	})
+}
+
+// DeleteCategory removes a category without children and drops it from
+// every product.
+func DeleteCategory(ctx *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	children, err := categoryCollection().CountDocuments(context.Background(), bson.M{"parent_id": id})
+	if err != nil {
+		respondCategoryLoadError(ctx, err)
+		return
+	}
+	if children > 0 {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"message": "Category has subcategories",
+			"error":   "move or delete the subcategories first",
+		})
+		return
+	}
+
+	result, err := categoryCollection().DeleteOne(context.Background(), bson.M{"_id": id})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to delete category",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if result.DeletedCount == 0 {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": categoryNotFound,
+		})
+		return
+	}
+
+	if err := syncCategoryProducts(requestActor(ctx), id); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Category deleted but products were not updated",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Category deleted successfully",
+	})
+}
+
+// MigrateCategories maps the free-form category strings of existing
+// products onto the category tree. The body may hold:
+//
+//	mapping         legacy string to category id or slug, e.g.
+//	                {"Leather Cases": "leather"}
+//	create_missing  create categories for unmatched strings (default true)
+//	dry_run         only report what would change
+//
+// Strings like "Cases > iPhone" create or match a path in the tree.
+func MigrateCategories(ctx *gin.Context) {
+	var input struct {
+		Mapping       map[string]string `json:"mapping"`
+		CreateMissing *bool             `json:"create_missing"`
+		DryRun        bool              `json:"dry_run"`
+	}
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	create := input.CreateMissing == nil || *input.CreateMissing
+
+	tree, err := loadCategoryTree()
+	if err != nil {
+		respondCategoryLoadError(ctx, err)
+		return
+	}
+	overrides := map[string]primitive.ObjectID{}
+	for legacy, ref := range input.Mapping {
+		node, ok := tree.Lookup(ref)
+		if !ok {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": invalidBody,
+				"error":   fmt.Sprintf("mapping for %q refers to unknown category %q", legacy, ref),
+			})
+			return
+		}
+		overrides[strings.ToLower(strings.TrimSpace(legacy))] = node.Id
+	}
+
+	collection := Client.Database(dbName).Collection(colName)
+	values, err := collection.Distinct(context.Background(), "category", bson.M{})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to read product categories",
+			"error":   err.Error(),
+		})
+		return
+	}
+	var legacy []string
+	for _, v := range values {
+		if s, ok := v.(string); ok && strings.TrimSpace(s) != "" {
+			legacy = append(legacy, s)
+		}
+	}
+
+	plan := helpers.PlanCategoryMigration(legacy, tree, overrides, create)
+	if input.DryRun {
+		ctx.JSON(http.StatusOK, gin.H{
+			"message": "Dry run, nothing was changed",
+			"data":    plan,
+		})
+		return
+	}
+
+	now := time.Now()
+	for _, category := range plan.Created {
+		category.TimeStamp = model.TimeStamp{CreatedAt: now, UpdatedAt: now}
+		if _, err := categoryCollection().InsertOne(context.Background(), category); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed to create categories",
+				"error":   err.Error(),
+			})
+			return
+		}
+	}
+
+	updated, err := applyCategoryMigration(requestActor(ctx), plan, tree)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Categories created but products were not updated",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":  "Categories migrated successfully",
+		"data":     plan,
+		"products": updated,
+	})
+}
+
+// applyCategoryMigration points every product with legacy categories at the
+// mapped tree categories and renames the strings to the category names.
+// Unmapped strings are kept as they are. Each changed product gets a
+// revision by actor.
+func applyCategoryMigration(actor primitive.ObjectID, plan helpers.CategoryMigration, tree *helpers.CategoryTree) (int, error) {
+	collection := Client.Database(dbName).Collection(colName)
+	cursor, err := collection.Find(context.Background(), bson.M{"category.0": bson.M{"$exists": true}})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(context.Background())
+
+	var changed []model.Product
+	defer func() { recordRevisions(actor, model.RevisionUpdate, changed...) }()
+	updated := 0
+	for cursor.Next(context.Background()) {
+		var p model.Product
+		if err := cursor.Decode(&p); err != nil {
+			return updated, err
+		}
+
+		ids := append([]primitive.ObjectID(nil), p.CategoryIds...)
+		seen := map[primitive.ObjectID]bool{}
+		for _, id := range ids {
+			seen[id] = true
+		}
+		var unmapped []string
+		for _, name := range p.Category {
+			id, ok := plan.Mapping[name]
+			if !ok {
+				unmapped = append(unmapped, name)
+				continue
+			}
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) == len(p.CategoryIds) {
+			continue
+		}
+
+		names, err := tree.Names(ids)
+		if err != nil {
+			return updated, err
+		}
+		names = append(names, unmapped...)
+		if _, err := collection.UpdateOne(context.Background(), bson.M{"_id": p.Id},
+			bson.M{"$set": bson.M{"category_ids": ids, "category": names}}); err != nil {
+			return updated, err
+		}
+		p.CategoryIds, p.Category = ids, names
+		changed = append(changed, p)
+		updated++
+	}
+	if err := cursor.Err(); err != nil {
+		return updated, err
+	}
+
+	if updated > 0 {
+		productFeeds.invalidate()
+		if err := loadProductIndexes(); err != nil {
+			log.Printf("failed to reload search indexes: %v", err)
+		}
+	}
+	return updated, nil
+}
@@ -0,0 +1,489 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joshua/casify/helpers"
+	"github.com/joshua/casify/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const collectionColName = "collections"
+
+const (
+	collectionNotFound = "Collection not found"
+	collectionExists   = "Collection already exists"
+)
+
+func collectionCollection() *mongo.Collection {
+	return Client.Database(dbName).Collection(collectionColName)
+}
+
+// ensureCollectionIndexes makes collection slugs unique.
+func ensureCollectionIndexes() error {
+	_, err := collectionCollection().Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "slug", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// loadCollections returns every collection ordered by title.
+func loadCollections() ([]model.Collection, error) {
+	cursor, err := collectionCollection().Find(context.Background(), bson.M{},
+		options.Find().SetSort(bson.D{{Key: "title", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	collections := []model.Collection{}
+	if err := cursor.All(context.Background(), &collections); err != nil {
+		return nil, err
+	}
+	return collections, nil
+}
+
+// checkProductsExist verifies every id refers to a stored product.
+func checkProductsExist(ids []primitive.ObjectID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	collection := Client.Database(dbName).Collection(colName)
+	count, err := collection.CountDocuments(context.Background(), bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return err
+	}
+	if int(count) != len(ids) {
+		return errors.New("some listed products do not exist")
+	}
+	return nil
+}
+
+// findProductsByIds loads products and returns them in the order of ids.
+func findProductsByIds(ids []primitive.ObjectID) ([]model.Product, error) {
+	if len(ids) == 0 {
+		return []model.Product{}, nil
+	}
+	collection := Client.Database(dbName).Collection(colName)
+	cursor, err := collection.Find(context.Background(), bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return nil, err
+	}
+	var products []model.Product
+	if err := cursor.All(context.Background(), &products); err != nil {
+		return nil, err
+	}
+	return helpers.OrderByIds(products, ids), nil
+}
+
+// collectionSort is the order of a collection's rule matched products.
+// Manual collections keep their own order, which cursors follow by id.
+func collectionSort(c model.Collection) []helpers.SortField {
+	if c.Type == model.CollectionManual {
+		return nil
+	}
+	sort, _ := helpers.ParseSort(c.Sort)
+	if len(sort) == 0 {
+		sort, _ = helpers.ParseSort("-created_at")
+	}
+	return sort
+}
+
+// collectionProducts returns one page of a collection's live products, the
+// total number of them and the cursor of the next page, empty on the last
+// one. Pinned products always come first.
+func collectionProducts(c model.Collection, params helpers.ListParams) ([]model.Product, int64, string, error) {
+	manual := c.Type == model.CollectionManual
+	ordered := c.Pinned
+	if manual {
+		ordered = helpers.CollectionOrder(c)
+	}
+	head, err := findProductsByIds(ordered)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	head = liveProducts(head)
+
+	// A cursor from the ordered products continues after them, any other
+	// one is in the rule matched products
+	start, inRule := 0, false
+	if params.After != nil {
+		id, _ := params.After[len(params.After)-1].(primitive.ObjectID)
+		start = -1
+		for i, p := range head {
+			if p.Id == id {
+				start = i + 1
+				break
+			}
+		}
+		if start < 0 {
+			if manual {
+				return nil, 0, "", &queryError{message: "Invalid cursor", err: errors.New("the collection changed, start from the first page")}
+			}
+			start, inRule = len(head), true
+		}
+	}
+	end := start + params.Limit
+	if end > len(head) {
+		end = len(head)
+	}
+	page := append([]model.Product{}, head[start:end]...)
+	total := int64(len(head))
+	hasMore := end < len(head)
+
+	if !manual {
+		rule, err := helpers.CollectionRule(c)
+		if err != nil {
+			return nil, 0, "", err
+		}
+		filter := bson.M{"$and": []bson.M{
+			helpers.CompileFilter(helpers.And(rule, helpers.LiveProductFilter())),
+			{"_id": bson.M{"$nin": c.Pinned}},
+		}}
+		collection := Client.Database(dbName).Collection(colName)
+		matched, err := collection.CountDocuments(context.Background(), filter)
+		if err != nil {
+			return nil, 0, "", err
+		}
+		total += matched
+
+		if remaining := params.Limit - len(page); remaining == 0 {
+			hasMore = hasMore || matched > 0
+		} else {
+			query := filter
+			if inRule {
+				query = bson.M{"$and": []bson.M{filter, helpers.CursorFilter(params.Sort, params.After)}}
+			}
+			// Fetch one extra product to learn whether another page exists
+			opts := options.Find().
+				SetSort(helpers.SortDocument(params.Sort)).
+				SetLimit(int64(remaining + 1))
+			cursor, err := collection.Find(context.Background(), query, opts)
+			if err != nil {
+				return nil, 0, "", err
+			}
+			var rest []model.Product
+			if err := cursor.All(context.Background(), &rest); err != nil {
+				return nil, 0, "", err
+			}
+			if len(rest) > remaining {
+				hasMore, rest = true, rest[:remaining]
+			}
+			page = append(page, rest...)
+		}
+	}
+
+	if !hasMore {
+		return page, total, "", nil
+	}
+	last, err := bson.Marshal(page[len(page)-1])
+	if err != nil {
+		return nil, 0, "", err
+	}
+	next, err := helpers.EncodeCursor(params.Sort, last)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	return page, total, next, nil
+}
+
+// collectionSlug returns the requested slug or derives a free one from the
+// title.
+func collectionSlug(c model.Collection) (string, error) {
+	if c.Slug != "" {
+		if helpers.Slugify(c.Slug) != c.Slug {
+			return "", errors.New("slug may only contain lowercase letters, digits and dashes")
+		}
+		return c.Slug, nil
+	}
+	base := helpers.Slugify(c.Title)
+	if base == "" {
+		return "", errors.New("title must contain letters or digits")
+	}
+	var lookupErr error
+	slug := helpers.UniqueSlug(base, func(slug string) bool {
+		count, err := collectionCollection().CountDocuments(context.Background(),
+			bson.M{"slug": slug, "_id": bson.M{"$ne": c.Id}})
+		if err != nil {
+			lookupErr = err
+			return false
+		}
+		return count > 0
+	})
+	return slug, lookupErr
+}
+
+// bindCollection reads and validates a collection from the request body.
+func bindCollection(ctx *gin.Context) (model.Collection, bool) {
+	var c model.Collection
+	if err := ctx.ShouldBindJSON(&c); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return c, false
+	}
+	c.Title = strings.TrimSpace(c.Title)
+	if err := helpers.ValidateCollection(c); err != nil {
+		respondCollectionInputError(ctx, err)
+		return c, false
+	}
+	if err := checkProductsExist(append(append([]primitive.ObjectID{}, c.ProductIds...), c.Pinned...)); err != nil {
+		respondCollectionInputError(ctx, err)
+		return c, false
+	}
+	return c, true
+}
+
+func respondCollectionInputError(ctx *gin.Context, err error) {
+	var fErr *helpers.FilterError
+	if errors.As(err, &fErr) {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message":  "Invalid rule",
+			"error":    fErr.Error(),
+			"position": fErr.Pos,
+			"token":    fErr.Token,
+		})
+		return
+	}
+	ctx.JSON(http.StatusBadRequest, gin.H{
+		"message": invalidBody,
+		"error":   err.Error(),
+	})
+}
+
+// GetCollections lists the collections currently visible to shoppers.
+func GetCollections(ctx *gin.Context) {
+	collections, err := loadCollections()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to get collections",
+			"error":   err.Error(),
+		})
+		return
+	}
+	now := time.Now()
+	live := []model.Collection{}
+	for _, c := range collections {
+		if helpers.CollectionLive(c, now) {
+			live = append(live, c)
+		}
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"data": live,
+	})
+}
+
+// GetAllCollections lists every collection, including scheduled and
+// expired ones.
+func GetAllCollections(ctx *gin.Context) {
+	collections, err := loadCollections()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to get collections",
+			"error":   err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"data": collections,
+	})
+}
+
+// GetCollection returns a live collection by slug with a page of its
+// products, paginated with "cursor" and "limit".
+func GetCollection(ctx *gin.Context) {
+	var c model.Collection
+	err := collectionCollection().FindOne(context.Background(), bson.M{"slug": ctx.Param("slug")}).Decode(&c)
+	if err != nil || !helpers.CollectionLive(c, time.Now()) {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": collectionNotFound,
+		})
+		return
+	}
+
+	params, err := parsePageParams(ctx, collectionSort(c), false)
+	if err != nil {
+		respondQueryError(ctx, err)
+		return
+	}
+
+	products, total, next, err := collectionProducts(c, params)
+	var qErr *queryError
+	if errors.As(err, &qErr) {
+		respondQueryError(ctx, err)
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to get collection products",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	response := gin.H{
+		"collection": c,
+		"data":       products,
+		"total":      total,
+	}
+	addPageLinks(ctx, response, params, next, next != "")
+	ctx.JSON(http.StatusOK, response)
+}
+
+// GetProductCollections lists the live collections a product belongs to.
+func GetProductCollections(ctx *gin.Context) {
+	product, err := findLiveProductById(ctx)
+	if err != nil {
+		handleProductError(ctx, err)
+		return
+	}
+	collections, err := loadCollections()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to get collections",
+			"error":   err.Error(),
+		})
+		return
+	}
+	now := time.Now()
+	matches := []model.Collection{}
+	for _, c := range collections {
+		if helpers.CollectionLive(c, now) && helpers.CollectionContains(c, *product) {
+			matches = append(matches, c)
+		}
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"data": matches,
+	})
+}
+
+// AddCollection creates a manual or rule collection.
+func AddCollection(ctx *gin.Context) {
+	c, ok := bindCollection(ctx)
+	if !ok {
+		return
+	}
+	c.Id = primitive.NewObjectID()
+	slug, err := collectionSlug(c)
+	if err != nil {
+		respondCollectionInputError(ctx, err)
+		return
+	}
+	c.Slug = slug
+	c.TimeStamp.CreatedAt = time.Now()
+	c.TimeStamp.UpdatedAt = time.Now()
+
+	if _, err := collectionCollection().InsertOne(context.Background(), c); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"message": collectionExists,
+				"error":   err.Error(),
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to add collection",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"message":      "Collection added successfully",
+		"collectionId": c.Id,
+		"slug":         c.Slug,
+	})
+}
+
+// UpdateCollection replaces a collection, including its product order and
+// pins.
+func UpdateCollection(ctx *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	var current model.Collection
+	if err := collectionCollection().FindOne(context.Background(), bson.M{"_id": id}).Decode(&current); err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": collectionNotFound,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	c, ok := bindCollection(ctx)
+	if !ok {
+		return
+	}
+	c.Id = id
+	// Keep the slug stable unless a new one is asked for
+	if c.Slug == "" {
+		c.Slug = current.Slug
+	}
+	if c.Slug, err = collectionSlug(c); err != nil {
+		respondCollectionInputError(ctx, err)
+		return
+	}
+	c.TimeStamp = model.TimeStamp{CreatedAt: current.TimeStamp.CreatedAt, UpdatedAt: time.Now()}
+
+	if _, err := collectionCollection().ReplaceOne(context.Background(), bson.M{"_id": id}, c); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"message": collectionExists,
+				"error":   err.Error(),
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to update collection",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Collection updated successfully",
+		"data":    c,
+	})
+}
+
+// DeleteCollection removes a collection. Its products are not touched.
+func DeleteCollection(ctx *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	result, err := collectionCollection().DeleteOne(context.Background(), bson.M{"_id": id})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to delete collection",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if result.DeletedCount == 0 {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": collectionNotFound,
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Collection deleted successfully",
+	})
+}
@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -9,6 +10,7 @@ import (
 	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/joshua/casify/helpers"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -79,4 +81,97 @@ func init() {
 	if err := ConnectToMongoDB(); err != nil {
 		log.Fatalf("MongoDB connection failed: %v", err)
 	}
+	provider, err := helpers.PaymentProviderFromEnv()
+	switch {
+	case errors.Is(err, helpers.ErrNoPaymentProvider):
+		log.Printf("warning: %v, checkout and payments are disabled", err)
+	case err != nil:
+		log.Fatalf("payment provider: %v", err)
+	}
+	payments = provider
+	if err := ensureIndexes(); err != nil {
+		log.Printf("failed to create indexes: %v", err)
+	}
+	if err := backfillProductSlugs(); err != nil {
+		log.Printf("failed to assign product slugs: %v", err)
+	}
+	if err := backfillProductSKUs(); err != nil {
+		log.Printf("failed to assign product skus: %v", err)
+	}
+	if err := backfillProductStatus(); err != nil {
+		log.Printf("failed to publish existing products: %v", err)
+	}
+	if err := backfillReviewStatus(); err != nil {
+		log.Printf("failed to approve existing reviews: %v", err)
+	}
+	if err := backfillReviewAggregates(); err != nil {
+		log.Printf("failed to compute product ratings: %v", err)
+	}
+	if err := loadProductIndexes(); err != nil {
+		log.Printf("failed to load search indexes: %v", err)
+	}
+	if err := loadDeviceSuggestions(); err != nil {
+		log.Printf("failed to load device suggestions: %v", err)
+	}
+	registerEventHandlers()
+	registerNotificationHandlers()
+	startReservationSweeper()
+	startProductScheduler()
+}
+
+// ensureIndexes creates the indexes the controllers rely on. Creating an
+// index that already exists is a no-op.
+func ensureIndexes() error {
+	if err := ensureProductIndexes(); err != nil {
+		return err
+	}
+	if err := ensureDeviceIndexes(); err != nil {
+		return err
+	}
+	if err := ensureInventoryIndexes(); err != nil {
+		return err
+	}
+	if err := ensureWarehouseIndexes(); err != nil {
+		return err
+	}
+	if err := ensureCategoryIndexes(); err != nil {
+		return err
+	}
+	if err := ensureCollectionIndexes(); err != nil {
+		return err
+	}
+	if err := ensureSlugIndexes(); err != nil {
+		return err
+	}
+	if err := ensureLifecycleIndexes(); err != nil {
+		return err
+	}
+	if err := ensureRevisionIndexes(); err != nil {
+		return err
+	}
+	if err := ensureTemplateIndexes(); err != nil {
+		return err
+	}
+	if err := ensureReviewIndexes(); err != nil {
+		return err
+	}
+	if err := ensureQuestionIndexes(); err != nil {
+		return err
+	}
+	if err := ensureCartIndexes(); err != nil {
+		return err
+	}
+	if err := ensureWishlistIndexes(); err != nil {
+		return err
+	}
+	if err := ensureOrderIndexes(); err != nil {
+		return err
+	}
+	if err := ensureNotificationIndexes(); err != nil {
+		return err
+	}
+	if err := ensureIdempotencyIndexes(); err != nil {
+		return err
+	}
+	return nil
 }
@@ -5,13 +5,33 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/joshua/casify/model"
 	"go.mongodb.org/mongo-driver/bson"
 )
 
 func DeleteManyProducts(ctx *gin.Context) {
 
 	collection := Client.Database(dbName).Collection(colName)
-	_, err := collection.DeleteMany(context.Background(), bson.M{})
+
+	// Keep the last state of every product in its revision history
+	cursor, err := collection.Find(context.Background(), bson.M{})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": productsNotDeleted,
+			"error":   err.Error(),
+		})
+		return
+	}
+	var products []model.Product
+	if err := cursor.All(context.Background(), &products); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": productsNotDeleted,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	_, err = collection.DeleteMany(context.Background(), bson.M{})
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"message": productsNotDeleted,
@@ -19,6 +39,9 @@ func DeleteManyProducts(ctx *gin.Context) {
 		})
 		return
 	}
+	afterProductsCleared()
+	recordRevisions(requestActor(ctx), model.RevisionDelete, products...)
+
 	ctx.JSON(http.StatusOK, gin.H{
 		"message": productsNotDeleted,
 	})
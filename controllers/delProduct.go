@@ -34,6 +34,8 @@ func DeleteProduct(ctx *gin.Context) {
 		})
 		return
 	}
+	afterProductsDeleted(id)
+	recordRevisions(requestActor(ctx), model.RevisionDelete, product)
 
 	ctx.JSON(http.StatusOK, gin.H{
 		"message": productDeleted,
@@ -0,0 +1,454 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joshua/casify/helpers"
+	"github.com/joshua/casify/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const deviceColName = "devices"
+
+// deviceSuggestSource prefixes the suggestion sources owned by devices.
+const deviceSuggestSource = "device:"
+
+const (
+	deviceNotFound = "Device not found"
+	deviceAdded    = "Device added successfully"
+	deviceUpdated  = "Device updated successfully"
+	deviceDeleted  = "Device deleted successfully"
+	deviceExists   = "Device already exists"
+)
+
+func deviceCollection() *mongo.Collection {
+	return Client.Database(dbName).Collection(deviceColName)
+}
+
+// ensureDeviceIndexes makes brand and model unique regardless of case.
+func ensureDeviceIndexes() error {
+	_, err := deviceCollection().Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "brand", Value: 1}, {Key: "model", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetCollation(&options.Collation{Locale: "en", Strength: 2}),
+	})
+	return err
+}
+
+func validateDevice(d model.Device) error {
+	if strings.TrimSpace(d.Brand) == "" || strings.TrimSpace(d.Model) == "" {
+		return errors.New("brand and model are required")
+	}
+	if d.Dimensions.Height < 0 || d.Dimensions.Width < 0 || d.Dimensions.Depth < 0 {
+		return errors.New("dimensions must be non-negative")
+	}
+	return nil
+}
+
+// loadDeviceSuggestions adds every device model to the autocomplete index.
+func loadDeviceSuggestions() error {
+	cursor, err := deviceCollection().Find(context.Background(), bson.M{})
+	if err != nil {
+		return err
+	}
+	var devices []model.Device
+	if err := cursor.All(context.Background(), &devices); err != nil {
+		return err
+	}
+	suggestIndex.RemoveSourcesWithPrefix(deviceSuggestSource)
+	for _, d := range devices {
+		indexDeviceSuggestion(d)
+	}
+	return nil
+}
+
+func indexDeviceSuggestion(d model.Device) {
+	suggestIndex.SetSource(deviceSuggestSource+d.Id.Hex(), []helpers.SuggestItem{
+		{Text: d.Name(), Kind: helpers.SuggestDevice, Weight: 1},
+	})
+}
+
+// findDeviceIds returns the ids of devices whose brand and model contain
+// every word of the query, e.g. "galaxy s24".
+func findDeviceIds(query string) ([]primitive.ObjectID, error) {
+	var conditions []bson.M
+	for _, word := range strings.Fields(query) {
+		re := primitive.Regex{Pattern: regexp.QuoteMeta(word), Options: "i"}
+		conditions = append(conditions, bson.M{"$or": []bson.M{{"brand": re}, {"model": re}}})
+	}
+	if len(conditions) == 0 {
+		return nil, nil
+	}
+
+	opts := options.Find().SetProjection(bson.M{"_id": 1})
+	cursor, err := deviceCollection().Find(context.Background(), bson.M{"$and": conditions}, opts)
+	if err != nil {
+		return nil, err
+	}
+	var devices []model.Device
+	if err := cursor.All(context.Background(), &devices); err != nil {
+		return nil, err
+	}
+	ids := make([]primitive.ObjectID, len(devices))
+	for i, d := range devices {
+		ids[i] = d.Id
+	}
+	return ids, nil
+}
+
+// deviceFilter builds the listing filter for the "device" query parameter,
+// which is either a device id or a search such as "galaxy s24".
+func deviceFilter(value string) (helpers.FilterNode, error) {
+	if id, err := primitive.ObjectIDFromHex(value); err == nil {
+		return helpers.NewCompare("device", helpers.OpEq, id)
+	}
+	ids, err := findDeviceIds(value)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]interface{}, len(ids))
+	for i, id := range ids {
+		values[i] = id
+	}
+	// An empty list matches nothing, which is the right answer for an
+	// unknown device.
+	return helpers.NewIn("device", values)
+}
+
+// checkDevicesExist verifies every id refers to a stored device.
+func checkDevicesExist(ids []primitive.ObjectID) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	unique := map[primitive.ObjectID]bool{}
+	for _, id := range ids {
+		unique[id] = true
+	}
+	count, err := deviceCollection().CountDocuments(context.Background(), bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return err
+	}
+	if int(count) != len(unique) {
+		return errors.New("compatible_devices contains unknown devices")
+	}
+	return nil
+}
+
+func parseDeviceId(ctx *gin.Context) (primitive.ObjectID, bool) {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return id, false
+	}
+	return id, true
+}
+
+// AddDevice stores a new device in the catalog.
+func AddDevice(ctx *gin.Context) {
+	var device model.Device
+	if err := ctx.ShouldBindJSON(&device); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	if err := validateDevice(device); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	device.Id = primitive.NewObjectID()
+	device.TimeStamp.CreatedAt = time.Now()
+	device.TimeStamp.UpdatedAt = time.Now()
+
+	if _, err := deviceCollection().InsertOne(context.Background(), device); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"message": deviceExists,
+				"error":   err.Error(),
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to add device",
+			"error":   err.Error(),
+		})
+		return
+	}
+	indexDeviceSuggestion(device)
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"message":  deviceAdded,
+		"deviceId": device.Id,
+	})
+}
+
+// GetDevices lists devices, optionally narrowed by "brand" or a "q" search.
+func GetDevices(ctx *gin.Context) {
+	filter := bson.M{}
+	if brand := strings.TrimSpace(ctx.Query("brand")); brand != "" {
+		filter["brand"] = primitive.Regex{Pattern: "^" + regexp.QuoteMeta(brand) + "$", Options: "i"}
+	}
+	if q := strings.TrimSpace(ctx.Query("q")); q != "" {
+		ids, err := findDeviceIds(q)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed to get devices",
+				"error":   err.Error(),
+			})
+			return
+		}
+		filter["_id"] = bson.M{"$in": ids}
+	}
+
+	params, err := parsePageParams(ctx, helpers.FixedSort("brand,model"), false)
+	if err != nil {
+		respondQueryError(ctx, err)
+		return
+	}
+
+	devices := []model.Device{}
+	next, hasMore, err := findPage(deviceCollection(), filter, params, &devices)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to get devices",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	total, err := deviceCollection().CountDocuments(context.Background(), filter)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to count devices",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	response := gin.H{
+		"data":  devices,
+		"total": total,
+	}
+	addPageLinks(ctx, response, params, next, hasMore)
+	ctx.JSON(http.StatusOK, response)
+}
+
+// GetDevice returns a single device.
+func GetDevice(ctx *gin.Context) {
+	id, ok := parseDeviceId(ctx)
+	if !ok {
+		return
+	}
+
+	var device model.Device
+	if err := deviceCollection().FindOne(context.Background(), bson.M{"_id": id}).Decode(&device); err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": deviceNotFound,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"data": device,
+	})
+}
+
+// UpdateDevice replaces the details of a device.
+func UpdateDevice(ctx *gin.Context) {
+	id, ok := parseDeviceId(ctx)
+	if !ok {
+		return
+	}
+
+	var input model.Device
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	if err := validateDevice(input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	update := bson.M{"$set": bson.M{
+		"brand":                 input.Brand,
+		"model":                 input.Model,
+		"generation":            input.Generation,
+		"dimensions":            input.Dimensions,
+		"camera_layout":         input.CameraLayout,
+		"time_stamp.updated_at": time.Now(),
+	}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var device model.Device
+	err := deviceCollection().FindOneAndUpdate(context.Background(), bson.M{"_id": id}, update, opts).Decode(&device)
+	if err != nil {
+		switch {
+		case mongo.IsDuplicateKeyError(err):
+			ctx.JSON(http.StatusConflict, gin.H{
+				"message": deviceExists,
+				"error":   err.Error(),
+			})
+		case errors.Is(err, mongo.ErrNoDocuments):
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": deviceNotFound,
+				"error":   err.Error(),
+			})
+		default:
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed to update device",
+				"error":   err.Error(),
+			})
+		}
+		return
+	}
+	indexDeviceSuggestion(device)
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": deviceUpdated,
+		"data":    device,
+	})
+}
+
+// DeleteDevice removes a device and drops it from every product's
+// compatibility list.
+func DeleteDevice(ctx *gin.Context) {
+	id, ok := parseDeviceId(ctx)
+	if !ok {
+		return
+	}
+
+	result, err := deviceCollection().DeleteOne(context.Background(), bson.M{"_id": id})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to delete device",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if result.DeletedCount == 0 {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": deviceNotFound,
+		})
+		return
+	}
+	suggestIndex.RemoveSource(deviceSuggestSource + id.Hex())
+
+	if err := removeDeviceFromProducts(requestActor(ctx), id); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Device deleted but products were not updated",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": deviceDeleted,
+	})
+}
+
+// removeDeviceFromProducts pulls a device id from every product and syncs
+// the changed products with the derived indexes, recording actor in their
+// revisions.
+func removeDeviceFromProducts(actor, id primitive.ObjectID) error {
+	collection := Client.Database(dbName).Collection(colName)
+	filter := bson.M{"compatible_devices": id}
+
+	cursor, err := collection.Find(context.Background(), filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	var affected []model.Product
+	if err := cursor.All(context.Background(), &affected); err != nil {
+		return err
+	}
+	if len(affected) == 0 {
+		return nil
+	}
+
+	ids := make([]primitive.ObjectID, len(affected))
+	for i, p := range affected {
+		ids[i] = p.Id
+	}
+	update := bson.M{
+		"$pull": bson.M{"compatible_devices": id},
+		"$set":  bson.M{"time_stamp.updated_at": time.Now()},
+	}
+	if _, err := collection.UpdateMany(context.Background(), bson.M{"_id": bson.M{"$in": ids}}, update); err != nil {
+		return err
+	}
+
+	cursor, err = collection.Find(context.Background(), bson.M{"_id": bson.M{"$in": ids}})
+	if err != nil {
+		return err
+	}
+	var updated []model.Product
+	if err := cursor.All(context.Background(), &updated); err != nil {
+		return err
+	}
+	afterProductsSaved(updated...)
+	recordRevisions(actor, model.RevisionUpdate, updated...)
+	return nil
+}
+
+// GetDeviceProducts lists the products compatible with a device, with the
+// same filters and pagination as FilterProducts.
+func GetDeviceProducts(ctx *gin.Context) {
+	id, ok := parseDeviceId(ctx)
+	if !ok {
+		return
+	}
+
+	count, err := deviceCollection().CountDocuments(context.Background(), bson.M{"_id": id})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to get device",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if count == 0 {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": deviceNotFound,
+		})
+		return
+	}
+
+	node, err := parseProductFilter(ctx)
+	if err != nil {
+		respondQueryError(ctx, err)
+		return
+	}
+	params, err := parseListParams(ctx, "-created_at")
+	if err != nil {
+		respondQueryError(ctx, err)
+		return
+	}
+
+	byDevice, _ := helpers.NewCompare("device", helpers.OpEq, id)
+	findProductPage(ctx, helpers.CompileFilter(helpers.And(node, byDevice, helpers.LiveProductFilter())), params, nil)
+}
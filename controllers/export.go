@@ -0,0 +1,116 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joshua/casify/helpers"
+	"github.com/joshua/casify/model"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// exportBatchSize is how many documents are pulled from MongoDB per round
+// trip while streaming an export.
+const exportBatchSize = 500
+
+// exportFlushEvery controls how often buffered rows are pushed to the client.
+const exportFlushEvery = 200
+
+// ExportProducts streams the catalog as CSV, NDJSON or XLSX. It accepts the
+// same filters as FilterProducts plus "format", a comma separated "fields"
+// list selecting the exported columns and, like the admin product list,
+// "status". Products in every state are exported unless status is given.
+func ExportProducts(ctx *gin.Context) {
+	format := strings.ToLower(ctx.DefaultQuery("format", helpers.ExportCSV))
+	contentType, ok := helpers.ExportContentTypes[format]
+	if !ok {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid export format",
+			"error":   fmt.Sprintf("format must be one of %s, %s or %s", helpers.ExportCSV, helpers.ExportNDJSON, helpers.ExportXLSX),
+		})
+		return
+	}
+
+	cols, err := helpers.SelectExportColumns(ctx.Query("fields"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid export fields",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	node, err := parseProductFilter(ctx)
+	if err != nil {
+		respondQueryError(ctx, err)
+		return
+	}
+	byStatus, err := parseStatusFilter(ctx)
+	if err != nil {
+		respondQueryError(ctx, err)
+		return
+	}
+	filter := helpers.CompileFilter(helpers.And(node, byStatus))
+
+	sort, err := helpers.ParseSort(ctx.DefaultQuery("sort", "asc"))
+	if err != nil {
+		respondQueryError(ctx, &queryError{message: "Invalid sort", err: err})
+		return
+	}
+
+	collection := Client.Database(dbName).Collection(colName)
+	opts := options.Find().SetSort(helpers.SortDocument(sort)).SetBatchSize(exportBatchSize)
+	cursor, err := collection.Find(context.Background(), filter, opts)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to get products",
+			"error":   err.Error(),
+		})
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	filename := fmt.Sprintf("products-%s.%s", time.Now().Format("20060102"), format)
+	ctx.Header("Content-Type", contentType)
+	ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	ctx.Status(http.StatusOK)
+
+	writer, err := helpers.NewExportWriter(format, ctx.Writer, cols)
+	if err != nil {
+		log.Printf("failed to start export: %v", err)
+		return
+	}
+
+	// The status line is already sent, so failures past this point can only
+	// be logged and the stream cut short.
+	rows := 0
+	for cursor.Next(context.Background()) {
+		var product model.Product
+		if err := cursor.Decode(&product); err != nil {
+			log.Printf("failed to decode product during export: %v", err)
+			return
+		}
+		if err := writer.WriteProduct(product); err != nil {
+			log.Printf("failed to write product during export: %v", err)
+			return
+		}
+		rows++
+		if rows%exportFlushEvery == 0 {
+			ctx.Writer.Flush()
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		log.Printf("export cursor failed: %v", err)
+		return
+	}
+	if err := writer.Close(); err != nil {
+		log.Printf("failed to finish export: %v", err)
+		return
+	}
+	ctx.Writer.Flush()
+}
@@ -0,0 +1,29 @@
+package controllers
+
+import (
+	"context"
+
+	"github.com/joshua/casify/helpers"
+)
+
+// aggregateFacets counts facet values for the products matching filter with
+// a single $facet aggregation.
+func aggregateFacets(filter helpers.FilterNode) (helpers.Facets, error) {
+	collection := Client.Database(dbName).Collection(colName)
+	cursor, err := collection.Aggregate(context.Background(), helpers.FacetPipeline(filter))
+	if err != nil {
+		return helpers.Facets{}, err
+	}
+	defer cursor.Close(context.Background())
+
+	var result helpers.FacetResult
+	if cursor.Next(context.Background()) {
+		if err := cursor.Decode(&result); err != nil {
+			return helpers.Facets{}, err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return helpers.Facets{}, err
+	}
+	return result.Facets(), nil
+}
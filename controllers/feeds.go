@@ -0,0 +1,193 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joshua/casify/helpers"
+	"github.com/joshua/casify/model"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	googleFeedName = "google"
+	metaFeedName   = "meta"
+)
+
+// defaultFeedTTL is how long a generated feed is served before it is rebuilt.
+// Product writes invalidate the cache earlier.
+const defaultFeedTTL = time.Hour
+
+// feedReport lists products left out of the feeds because they miss
+// attributes the ad platforms require.
+type feedReport struct {
+	Checked     int                 `json:"checked"`
+	Valid       int                 `json:"valid"`
+	Invalid     []invalidFeedRecord `json:"invalid"`
+	GeneratedAt time.Time           `json:"generated_at"`
+}
+
+type invalidFeedRecord struct {
+	Id      string   `json:"id"`
+	Title   string   `json:"title"`
+	Missing []string `json:"missing"`
+}
+
+// feedCache keeps the rendered feeds and their validation report in memory.
+type feedCache struct {
+	mu          sync.Mutex
+	feeds       map[string][]byte
+	report      *feedReport
+	generatedAt time.Time
+}
+
+var productFeeds = &feedCache{}
+
+func (c *feedCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.feeds = nil
+	c.report = nil
+}
+
+// get returns the cached feeds and report, rebuilding them when they are
+// missing or older than the configured TTL.
+func (c *feedCache) get() (map[string][]byte, *feedReport, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.feeds != nil && time.Since(c.generatedAt) < feedTTL() {
+		return c.feeds, c.report, nil
+	}
+
+	feeds, report, err := buildFeeds()
+	if err != nil {
+		return nil, nil, err
+	}
+	c.feeds = feeds
+	c.report = report
+	c.generatedAt = report.GeneratedAt
+	return feeds, report, nil
+}
+
+func feedTTL() time.Duration {
+	if ttl, err := time.ParseDuration(os.Getenv("FEED_CACHE_TTL")); err == nil && ttl > 0 {
+		return ttl
+	}
+	return defaultFeedTTL
+}
+
+// buildFeeds renders every feed format in a single pass over the catalog.
+func buildFeeds() (map[string][]byte, *feedReport, error) {
+	cfg := helpers.FeedConfigFromEnv()
+	collection := Client.Database(dbName).Collection(colName)
+
+	// Stock is loaded once for the whole catalog rather than per product
+	stockCursor, err := inventoryCollection().Find(context.Background(), bson.M{})
+	if err != nil {
+		return nil, nil, err
+	}
+	var levels []model.StockLevel
+	if err := stockCursor.All(context.Background(), &levels); err != nil {
+		return nil, nil, err
+	}
+	stock := make(map[string]model.StockLevel, len(levels))
+	for _, level := range levels {
+		stock[level.SKU] = level
+	}
+
+	cursor, err := collection.Find(context.Background(), helpers.CompileFilter(helpers.LiveProductFilter()))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer cursor.Close(context.Background())
+
+	report := &feedReport{Invalid: []invalidFeedRecord{}, GeneratedAt: time.Now()}
+	var items []helpers.FeedItem
+	for cursor.Next(context.Background()) {
+		var product model.Product
+		if err := cursor.Decode(&product); err != nil {
+			return nil, nil, err
+		}
+		report.Checked++
+
+		var productLevels []model.StockLevel
+		for _, sku := range helpers.SellableSKUs(product) {
+			if level, ok := stock[sku]; ok {
+				productLevels = append(productLevels, level)
+			}
+		}
+		item := helpers.BuildFeedItem(product, helpers.BuildAvailability(product, productLevels), cfg)
+		if missing := helpers.MissingFeedAttributes(item); len(missing) > 0 {
+			report.Invalid = append(report.Invalid, invalidFeedRecord{
+				Id:      item.ID,
+				Title:   product.Title,
+				Missing: missing,
+			})
+			continue
+		}
+		report.Valid++
+		items = append(items, item)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	var google, meta bytes.Buffer
+	if err := helpers.WriteGoogleFeed(&google, items, cfg); err != nil {
+		return nil, nil, err
+	}
+	if err := helpers.WriteMetaFeed(&meta, items); err != nil {
+		return nil, nil, err
+	}
+
+	feeds := map[string][]byte{
+		googleFeedName: google.Bytes(),
+		metaFeedName:   meta.Bytes(),
+	}
+	return feeds, report, nil
+}
+
+func serveFeed(ctx *gin.Context, name, contentType string) {
+	feeds, report, err := productFeeds.get()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to generate feed",
+			"error":   err.Error(),
+		})
+		return
+	}
+	ctx.Header("Last-Modified", report.GeneratedAt.UTC().Format(http.TimeFormat))
+	ctx.Data(http.StatusOK, contentType, feeds[name])
+}
+
+// GoogleProductFeed serves the catalog as a Google Merchant Center RSS feed.
+func GoogleProductFeed(ctx *gin.Context) {
+	serveFeed(ctx, googleFeedName, "application/rss+xml; charset=utf-8")
+}
+
+// MetaProductFeed serves the catalog as a Meta catalog CSV feed.
+func MetaProductFeed(ctx *gin.Context) {
+	serveFeed(ctx, metaFeedName, "text/csv; charset=utf-8")
+}
+
+// FeedReport lists products excluded from the feeds and the attributes they
+// are missing.
+func FeedReport(ctx *gin.Context) {
+	_, report, err := productFeeds.get()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to generate feed",
+			"error":   err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"data": report,
+	})
+}
@@ -1,130 +1,186 @@
 package controllers
 
 import (
-	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/joshua/casify/model"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"github.com/joshua/casify/helpers"
 )
 
+// queryError is returned when a query string parameter cannot be parsed. The
+// message is shown to the client next to the underlying error.
+type queryError struct {
+	message string
+	err     error
+}
+
+func (e *queryError) Error() string {
+	return fmt.Sprintf("%s: %v", e.message, e.err)
+}
+
 func FilterProducts(ctx *gin.Context) {
 
-	// Retrieve filter parameters from query string
-	nameFilter := ctx.DefaultQuery("name", "")
-	priceFilter := ctx.DefaultQuery("price", "")
-	categoryFilter := ctx.DefaultQuery("category", "")
-	ratingFilter := ctx.DefaultQuery("rating", "")
-	discountFilter := ctx.DefaultQuery("discount", "")
-	sortFilter := ctx.DefaultQuery("sort", "asc")
-
-	// Initialize filter for database query
-	filter := bson.M{}
-	if nameFilter != "" {
-		filter["title"] = bson.M{"$regex": nameFilter, "$options": "i"} // Case-insensitive regex search
+	node, err := parseProductFilter(ctx)
+	if err != nil {
+		respondQueryError(ctx, err)
+		return
+	}
+	node = helpers.And(node, helpers.LiveProductFilter())
+
+	params, err := parseListParams(ctx, "asc")
+	if err != nil {
+		respondQueryError(ctx, err)
+		return
 	}
-	if priceFilter != "" {
-		price, err := strconv.ParseFloat(strings.TrimSpace(priceFilter), 64)
+
+	// Facet counts are only computed when asked for
+	var extra gin.H
+	if ctx.Query("facets") == "true" {
+		facets, err := aggregateFacets(node)
 		if err != nil {
-			ctx.JSON(http.StatusBadRequest, gin.H{
-				"message": "Invalid price format",
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed to compute facets",
 				"error":   err.Error(),
 			})
 			return
 		}
-		// Apply price filter based on sort order (ascending or descending)
-		if sortFilter == "asc" {
-			filter["price"] = bson.M{"$lte": price}
-		} else if sortFilter == "desc" {
-			filter["price"] = bson.M{"$gte": price}
-		}
+		extra = gin.H{"facets": facets}
 	}
-	if categoryFilter != "" {
-		// Split the category filter into individual categories
-		categories := strings.Split(categoryFilter, ",")
-
-		// Create a slice to hold our search conditions
-		var orConditions []bson.M
-
-		// For each category, create a search condition
-		for _, category := range categories {
-			// Create a condition that searches for the category (case-insensitive)
-			orConditions = append(orConditions, bson.M{
-				"category": bson.M{
-					"$regex":   category, // Partial match
-					"$options": "i",      // Case-insensitive
-				},
-			})
-		}
 
-		// Add the OR conditions to the filter
-		filter["$or"] = orConditions
+	// Return the filtered products in the response
+	findProductPage(ctx, helpers.CompileFilter(node), params, extra)
+}
+
+// parseProductFilter combines the "filter" expression with the simple filter
+// parameters into one validated expression. Every condition is ANDed, so
+// the parameters can no longer overwrite one another.
+//
+//	filter       expression, e.g. price>=10 AND category IN (magsafe, leather)
+//	name         title contains
+//	category     comma separated, any category contains
+//	min_price    price at least
+//	max_price    price at most
+//	price        legacy bound: at most with sort=asc (the default), at
+//	             least with sort=desc, ignored with any other sort
+//	rating       rating at least
+//	discount     discount at least
+//	device       device id, or a device search such as "galaxy s24"
+//	category_id  category id or slug, including its subcategories
+func parseProductFilter(ctx *gin.Context) (helpers.FilterNode, error) {
+	expr, err := helpers.ParseFilter(ctx.Query("filter"))
+	if err != nil {
+		return nil, err
 	}
-	if ratingFilter != "" {
-		rating, err := strconv.ParseFloat(ratingFilter, 64)
-		if err != nil {
-			ctx.JSON(http.StatusBadRequest, gin.H{
-				"message": "Invalid rating format",
-				"error":   err.Error(),
-			})
-			return
+	nodes := []helpers.FilterNode{expr}
+
+	if name := strings.TrimSpace(ctx.Query("name")); name != "" {
+		node, _ := helpers.NewCompare("title", helpers.OpContains, name)
+		nodes = append(nodes, node)
+	}
+
+	if categoryFilter := ctx.Query("category"); categoryFilter != "" {
+		// Any of the listed categories may match
+		var orConditions []helpers.FilterNode
+		for _, category := range strings.Split(categoryFilter, ",") {
+			if category = strings.TrimSpace(category); category == "" {
+				continue
+			}
+			node, _ := helpers.NewCompare("category", helpers.OpContains, category)
+			orConditions = append(orConditions, node)
 		}
-		// Use a range to account for potential type differences
-		filter["rating"] = bson.M{
-			"$gte": rating,
+		if len(orConditions) > 0 {
+			nodes = append(nodes, &helpers.OrNode{Children: orConditions})
 		}
 	}
-	if discountFilter != "" {
-		discount, err := strconv.ParseFloat(discountFilter, 64)
+
+	if ref := strings.TrimSpace(ctx.Query("category_id")); ref != "" {
+		node, err := categoryFilter(ref)
 		if err != nil {
-			ctx.JSON(http.StatusBadRequest, gin.H{
-				"message": "Invalid discount format",
-				"error":   err.Error(),
-			})
-			return
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+
+	if device := strings.TrimSpace(ctx.Query("device")); device != "" {
+		node, err := deviceFilter(device)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+
+	numeric := []struct {
+		param   string
+		field   string
+		op      string
+		message string
+	}{
+		{"min_price", "price", helpers.OpGte, "Invalid price format"},
+		{"max_price", "price", helpers.OpLte, "Invalid price format"},
+		{"rating", "rating", helpers.OpGte, "Invalid rating format"},
+		{"discount", "discount", helpers.OpGte, "Invalid discount format"},
+	}
+	for _, n := range numeric {
+		raw := strings.TrimSpace(ctx.Query(n.param))
+		if raw == "" {
+			continue
 		}
-		filter["discount"] = bson.M{
-			"$gte": discount,
+		value, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, &queryError{message: n.message, err: err}
 		}
+		node, _ := helpers.NewCompare(n.field, n.op, value)
+		nodes = append(nodes, node)
 	}
 
-	// Define the sort order (default to ascending if not specified)
-	sortOrder := bson.D{}
-	if sortFilter == "asc" {
-		sortOrder = bson.D{primitive.E{Key: "price", Value: 1}} // 1 for ascending
-	} else if sortFilter == "desc" {
-		sortOrder = bson.D{primitive.E{Key: "price", Value: -1}} // -1 for descending
+	// "price" keeps its original meaning, with the bound following the
+	// legacy sort direction
+	if raw := strings.TrimSpace(ctx.Query("price")); raw != "" {
+		price, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, &queryError{message: "Invalid price format", err: err}
+		}
+		switch ctx.DefaultQuery("sort", "asc") {
+		case "asc":
+			node, _ := helpers.NewCompare("price", helpers.OpLte, price)
+			nodes = append(nodes, node)
+		case "desc":
+			node, _ := helpers.NewCompare("price", helpers.OpGte, price)
+			nodes = append(nodes, node)
+		}
 	}
 
-	// Query the MongoDB database
-	collection := Client.Database(dbName).Collection(colName) // Assuming Client, dbName, and colName are defined elsewhere
+	return helpers.And(nodes...), nil
+}
 
-	cursor, err := collection.Find(context.Background(), filter, options.Find().SetSort(sortOrder))
-	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Failed to get products",
-			"error":   err.Error(),
+// respondQueryError writes a 400 response for a bad query parameter. Any
+// other error, such as a failed lookup, is reported as a server error.
+func respondQueryError(ctx *gin.Context, err error) {
+	var fErr *helpers.FilterError
+	if errors.As(err, &fErr) {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message":  "Invalid filter",
+			"error":    fErr.Error(),
+			"position": fErr.Pos,
+			"token":    fErr.Token,
 		})
 		return
 	}
-	defer cursor.Close(context.Background())
 
-	var products []model.Product
-	if err := cursor.All(context.Background(), &products); err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Failed to process products",
-			"error":   err.Error(),
+	var qErr *queryError
+	if errors.As(err, &qErr) {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": qErr.message,
+			"error":   qErr.err.Error(),
 		})
 		return
 	}
-
-	// Return the filtered products in the response
-	ctx.JSON(http.StatusOK, gin.H{
-		"data": products,
+	ctx.JSON(http.StatusInternalServerError, gin.H{
+		"message": "unexpected error",
+		"error":   err.Error(),
 	})
 }
@@ -1,38 +1,17 @@
 package controllers
 
 import (
-	"context"
-	"net/http"
-
 	"github.com/gin-gonic/gin"
-	"github.com/joshua/casify/model"
-	"go.mongodb.org/mongo-driver/bson"
+	"github.com/joshua/casify/helpers"
 )
 
 func GetProducts(ctx *gin.Context) {
 
-	collection := Client.Database(dbName).Collection(colName)
-
-	cursor, err := collection.Find(context.Background(), bson.M{})
-
+	params, err := parseListParams(ctx, "-created_at")
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Failed to get products",
-			"error":   err.Error(),
-		})
+		respondQueryError(ctx, err)
 		return
 	}
 
-	var products []model.Product
-
-	if err := cursor.All(context.Background(), &products); err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"message": "Failed to get products",
-			"error":   err.Error(),
-		})
-		return
-	}
-	ctx.JSON(http.StatusOK, gin.H{
-		"data": products,
-	})
+	findProductPage(ctx, helpers.CompileFilter(helpers.LiveProductFilter()), params, nil)
 }
@@ -6,6 +6,7 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/joshua/casify/helpers"
 	"github.com/joshua/casify/model"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -13,15 +14,50 @@ import (
 
 func GetById(ctx *gin.Context) {
 
-	product, err := findProductById(ctx)
+	product, err := findLiveProductById(ctx)
 	if err != nil {
 		handleProductError(ctx, err)
 		return
 	}
+	respondProduct(ctx, product)
+}
+
+// respondProduct writes a product with its live stock, breadcrumbs and
+// canonical URL.
+func respondProduct(ctx *gin.Context, product *model.Product) {
+	availability, err := productAvailability(*product)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to load stock",
+			"error":   err.Error(),
+		})
+		return
+	}
+	// Variants show live stock rather than the quantity they were created with
+	applyLiveStock(product, availability)
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"data": product,
-	})
+	response := gin.H{
+		"data":          product,
+		"canonical_url": helpers.FeedConfigFromEnv().BaseURL + helpers.ProductPath(*product),
+	}
+	if availability != nil {
+		response["availability"] = availability
+	}
+	if len(product.CategoryIds) > 0 {
+		tree, err := loadCategoryTree()
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed to load categories",
+				"error":   err.Error(),
+			})
+			return
+		}
+		response["breadcrumbs"] = tree.Breadcrumbs(product.CategoryIds[0])
+	}
+	if matrix := helpers.BuildVariantMatrix(*product); matrix != nil {
+		response["variants"] = matrix
+	}
+	ctx.JSON(http.StatusOK, response)
 }
 
 func findProductById(ctx *gin.Context) (*model.Product, error) {
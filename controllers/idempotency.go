@@ -0,0 +1,25 @@
+package controllers
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const idempotencyColName = "idempotency_keys"
+
+// IdempotencyCollection holds the stored responses of requests sent with an
+// Idempotency-Key.
+func IdempotencyCollection() *mongo.Collection {
+	return Client.Database(dbName).Collection(idempotencyColName)
+}
+
+func ensureIdempotencyIndexes() error {
+	_, err := IdempotencyCollection().Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
@@ -0,0 +1,805 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joshua/casify/helpers"
+	"github.com/joshua/casify/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	inventoryColName   = "inventory"
+	reservationColName = "stock_reservations"
+	ledgerColName      = "stock_ledger"
+)
+
+const (
+	stockNotFound       = "Stock level not found"
+	reservationNotFound = "Reservation not found"
+	insufficientStock   = "Insufficient stock"
+)
+
+// reservationSweepInterval is how often expired reservations are released.
+const reservationSweepInterval = time.Minute
+
+// events carries store events such as low stock alerts to their subscribers.
+var events = helpers.NewEventBus()
+
+// errInsufficientStock is returned when a reservation or adjustment needs
+// more stock than is available.
+type errInsufficientStock struct {
+	SKU       string
+	Requested int
+}
+
+func (e *errInsufficientStock) Error() string {
+	return fmt.Sprintf("not enough stock for %s (%d requested)", e.SKU, e.Requested)
+}
+
+// errReservationState is returned when a reservation is no longer held, or
+// has expired when it is committed.
+var errReservationState = errors.New("reservation is not held")
+
+func inventoryCollection() *mongo.Collection {
+	return Client.Database(dbName).Collection(inventoryColName)
+}
+
+func reservationCollection() *mongo.Collection {
+	return Client.Database(dbName).Collection(reservationColName)
+}
+
+func ledgerCollection() *mongo.Collection {
+	return Client.Database(dbName).Collection(ledgerColName)
+}
+
+// ensureInventoryIndexes makes SKUs unique and backs the ledger and
+// reservation lookups.
+func ensureInventoryIndexes() error {
+	if _, err := inventoryCollection().Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "sku", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		return err
+	}
+	if _, err := reservationCollection().Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "expires_at", Value: 1}}},
+		{Keys: bson.D{{Key: "reference", Value: 1}}},
+	}); err != nil {
+		return err
+	}
+	_, err := ledgerCollection().Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "sku", Value: 1}, {Key: "created_at", Value: -1}},
+	})
+	return err
+}
+
+// registerEventHandlers subscribes the default handlers to store events.
+func registerEventHandlers() {
+	logAlert := func(event helpers.Event) {
+		if alert, ok := event.Payload.(helpers.StockAlert); ok {
+			log.Printf("%s: %s has %d left (threshold %d)", event.Topic, alert.SKU, alert.Available, alert.Threshold)
+		}
+	}
+	events.Subscribe(helpers.EventLowStock, logAlert)
+	events.Subscribe(helpers.EventOutOfStock, logAlert)
+}
+
+// publishStockAlert emits a low or out of stock event when a change of
+// delta available units pushed level across its threshold.
+func publishStockAlert(level model.StockLevel, delta int) {
+	after := level.Available()
+	topic := helpers.StockAlertTopic(after-delta, after, level.LowStockThreshold)
+	if topic == "" {
+		return
+	}
+	events.Publish(topic, helpers.StockAlert{
+		SKU:       level.SKU,
+		Available: after,
+		Threshold: level.LowStockThreshold,
+	})
+}
+
+// seedInventory creates a stock level for every new SKU of the products.
+// A variant's stock is only used as its initial quantity and is placed in
+// the default warehouse; after that stock is managed through the inventory
+// endpoints.
+func seedInventory(products ...model.Product) {
+	warehouse, err := defaultWarehouse()
+	if err != nil {
+		log.Printf("failed to load default warehouse: %v", err)
+	}
+	for _, p := range products {
+		initial := map[string]int{}
+		for _, v := range p.Variants {
+			initial[v.SKU] = v.Stock
+		}
+		for _, sku := range helpers.SellableSKUs(p) {
+			now := time.Now()
+			update := bson.M{
+				"$set": bson.M{"product_id": p.Id},
+				"$setOnInsert": bson.M{
+					"on_hand":             initial[sku],
+					"reserved":            0,
+					"low_stock_threshold": 0,
+					"updated_at":          now,
+				},
+			}
+			result, err := inventoryCollection().UpdateOne(context.Background(), bson.M{"sku": sku}, update, options.Update().SetUpsert(true))
+			if err != nil {
+				log.Printf("failed to seed stock for %s: %v", sku, err)
+				continue
+			}
+			if result.UpsertedCount > 0 && initial[sku] > 0 {
+				entry := model.StockAdjustment{
+					SKU:         sku,
+					Delta:       initial[sku],
+					OnHandAfter: initial[sku],
+					Reason:      model.AdjustRestock,
+					Note:        "initial stock",
+				}
+				if warehouse != nil {
+					entry.WarehouseId = warehouse.Id
+					if err := changeWarehouseStock(sku, warehouse.Id, initial[sku]); err != nil {
+						log.Printf("failed to place stock for %s: %v", sku, err)
+					}
+				}
+				recordAdjustment(entry)
+			}
+		}
+	}
+}
+
+// recordAdjustment appends an entry to the inventory ledger. A failure is
+// logged rather than returned since the stock change has already happened.
+func recordAdjustment(entry model.StockAdjustment) {
+	entry.Id = primitive.NewObjectID()
+	entry.CreatedAt = time.Now()
+	if _, err := ledgerCollection().InsertOne(context.Background(), entry); err != nil {
+		log.Printf("failed to record stock adjustment for %s: %v", entry.SKU, err)
+	}
+}
+
+// loadStockLevels returns the stock levels of the given SKUs.
+func loadStockLevels(skus []string) ([]model.StockLevel, error) {
+	levels := []model.StockLevel{}
+	if len(skus) == 0 {
+		return levels, nil
+	}
+	cursor, err := inventoryCollection().Find(context.Background(), bson.M{"sku": bson.M{"$in": skus}})
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(context.Background(), &levels); err != nil {
+		return nil, err
+	}
+	return levels, nil
+}
+
+// productAvailability loads the stock of a product's SKUs.
+func productAvailability(p model.Product) (*helpers.ProductAvailability, error) {
+	skus := helpers.SellableSKUs(p)
+	if len(skus) == 0 {
+		return nil, nil
+	}
+	levels, err := loadStockLevels(skus)
+	if err != nil {
+		return nil, err
+	}
+	return helpers.BuildAvailability(p, levels), nil
+}
+
+// applyLiveStock replaces the quantity variants were created with by their
+// available stock.
+func applyLiveStock(p *model.Product, availability *helpers.ProductAvailability) {
+	if availability == nil {
+		return
+	}
+	for i, v := range p.Variants {
+		p.Variants[i].Stock = availability.SKUs[v.SKU].Available
+	}
+}
+
+// applyListStock applies the live stock of a page of products, loaded in
+// one query.
+func applyListStock(products []model.Product) error {
+	var skus []string
+	for _, p := range products {
+		skus = append(skus, helpers.SellableSKUs(p)...)
+	}
+	levels, err := loadStockLevels(skus)
+	if err != nil {
+		return err
+	}
+	for i := range products {
+		applyLiveStock(&products[i], helpers.BuildAvailability(products[i], levels))
+	}
+	return nil
+}
+
+// reserveStock holds stock for every item under one reference. Each SKU is
+// reserved with a conditional update that only matches while enough stock
+// is available, so concurrent checkouts can never oversell. If any item
+// cannot be reserved the ones already held are released again.
+func reserveStock(reference string, items []helpers.StockRequest) ([]model.StockReservation, error) {
+	items, err := helpers.NormalizeStockRequests(items)
+	if err != nil {
+		return nil, err
+	}
+
+	expires := time.Now().Add(helpers.ReservationTTLFromEnv())
+	reservations := make([]model.StockReservation, 0, len(items))
+	rollback := func() {
+		for _, r := range reservations {
+			if _, err := releaseReservation(r.Id); err != nil {
+				log.Printf("failed to roll back reservation %s: %v", r.Id.Hex(), err)
+			}
+		}
+	}
+
+	for _, item := range items {
+		filter := bson.M{
+			"sku": item.SKU,
+			"$expr": bson.M{"$gte": bson.A{
+				bson.M{"$subtract": bson.A{"$on_hand", "$reserved"}},
+				item.Quantity,
+			}},
+		}
+		update := bson.M{
+			"$inc": bson.M{"reserved": item.Quantity},
+			"$set": bson.M{"updated_at": time.Now()},
+		}
+		var level model.StockLevel
+		err := inventoryCollection().FindOneAndUpdate(context.Background(), filter, update,
+			options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&level)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			rollback()
+			return nil, &errInsufficientStock{SKU: item.SKU, Requested: item.Quantity}
+		}
+		if err != nil {
+			rollback()
+			return nil, err
+		}
+
+		now := time.Now()
+		reservation := model.StockReservation{
+			Id:        primitive.NewObjectID(),
+			SKU:       item.SKU,
+			Quantity:  item.Quantity,
+			Reference: reference,
+			Status:    model.ReservationHeld,
+			ExpiresAt: expires,
+			TimeStamp: model.TimeStamp{CreatedAt: now, UpdatedAt: now},
+		}
+		if _, err := reservationCollection().InsertOne(context.Background(), reservation); err != nil {
+			// Give back the stock the reservation document was meant to hold.
+			inventoryCollection().UpdateOne(context.Background(), bson.M{"sku": item.SKU},
+				bson.M{"$inc": bson.M{"reserved": -item.Quantity}})
+			rollback()
+			return nil, err
+		}
+		reservations = append(reservations, reservation)
+		publishStockAlert(level, -item.Quantity)
+	}
+	return reservations, nil
+}
+
+// transitionReservation moves a held reservation to status. Only one caller
+// can win the transition, which keeps commit and release idempotent. An
+// expired hold can still be released but no longer committed, as the sweep
+// may be giving its stock back at the same time.
+func transitionReservation(id primitive.ObjectID, status string) (*model.StockReservation, error) {
+	now := time.Now()
+	filter := bson.M{"_id": id, "status": model.ReservationHeld}
+	if status == model.ReservationCommitted {
+		filter["expires_at"] = bson.M{"$gt": now}
+	}
+	var reservation model.StockReservation
+	err := reservationCollection().FindOneAndUpdate(context.Background(),
+		filter,
+		bson.M{"$set": bson.M{"status": status, "time_stamp.updated_at": now}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&reservation)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		count, countErr := reservationCollection().CountDocuments(context.Background(), bson.M{"_id": id})
+		if countErr == nil && count > 0 {
+			return nil, errReservationState
+		}
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &reservation, nil
+}
+
+// undoReservationTransition puts a reservation moved to status back on
+// hold when the stock counts could not follow, so the move can be retried.
+func undoReservationTransition(reservation *model.StockReservation, status string) {
+	_, err := reservationCollection().UpdateOne(context.Background(),
+		bson.M{"_id": reservation.Id, "status": status},
+		bson.M{"$set": bson.M{"status": model.ReservationHeld, "time_stamp.updated_at": time.Now()}})
+	if err != nil {
+		log.Printf("failed to put reservation %s back on hold: %v", reservation.Id.Hex(), err)
+	}
+}
+
+// commitReservation turns held stock into a sale: the quantity leaves both
+// on hand and reserved, is taken from the warehouses the allocator picks,
+// and a ledger entry is written for each warehouse.
+func commitReservation(id primitive.ObjectID, actor primitive.ObjectID) (*model.StockReservation, error) {
+	reservation, err := transitionReservation(id, model.ReservationCommitted)
+	if err != nil {
+		return nil, err
+	}
+
+	var level model.StockLevel
+	err = inventoryCollection().FindOneAndUpdate(context.Background(),
+		bson.M{"sku": reservation.SKU},
+		bson.M{
+			"$inc": bson.M{"on_hand": -reservation.Quantity, "reserved": -reservation.Quantity},
+			"$set": bson.M{"updated_at": time.Now()},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&level)
+	if err != nil {
+		undoReservationTransition(reservation, model.ReservationCommitted)
+		return nil, err
+	}
+
+	entry := model.StockAdjustment{
+		SKU:         reservation.SKU,
+		Delta:       -reservation.Quantity,
+		OnHandAfter: level.OnHand,
+		Reason:      model.AdjustSale,
+		Reference:   reservation.Reference,
+		Actor:       actor,
+	}
+	shipments, err := deductFromWarehouses(reservation.SKU, reservation.Quantity)
+	if err != nil {
+		// The sale stands; the per location counts need a correction.
+		log.Printf("failed to take %d %s from warehouses: %v", reservation.Quantity, reservation.SKU, err)
+	}
+	if len(shipments) == 0 {
+		recordAdjustment(entry)
+	}
+	for _, shipment := range shipments {
+		for _, item := range shipment.Items {
+			entry.WarehouseId = shipment.WarehouseId
+			entry.Delta = -item.Quantity
+			recordAdjustment(entry)
+		}
+	}
+	return reservation, nil
+}
+
+// releaseReservation gives held stock back.
+func releaseReservation(id primitive.ObjectID) (*model.StockReservation, error) {
+	reservation, err := transitionReservation(id, model.ReservationReleased)
+	if err != nil {
+		return nil, err
+	}
+	_, err = inventoryCollection().UpdateOne(context.Background(),
+		bson.M{"sku": reservation.SKU},
+		bson.M{
+			"$inc": bson.M{"reserved": -reservation.Quantity},
+			"$set": bson.M{"updated_at": time.Now()},
+		})
+	if err != nil {
+		undoReservationTransition(reservation, model.ReservationReleased)
+		return nil, err
+	}
+	return reservation, nil
+}
+
+// releaseExpiredReservations releases every held reservation past its
+// expiry.
+func releaseExpiredReservations() error {
+	filter := bson.M{"status": model.ReservationHeld, "expires_at": bson.M{"$lte": time.Now()}}
+	cursor, err := reservationCollection().Find(context.Background(), filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	var expired []model.StockReservation
+	if err := cursor.All(context.Background(), &expired); err != nil {
+		return err
+	}
+	for _, r := range expired {
+		// A concurrent commit may have won; that is not an error here.
+		if _, err := releaseReservation(r.Id); err != nil && !errors.Is(err, errReservationState) {
+			log.Printf("failed to release reservation %s: %v", r.Id.Hex(), err)
+		}
+	}
+	return nil
+}
+
+// startReservationSweeper periodically releases expired reservations and
+// cancels the unpaid orders they held stock for.
+func startReservationSweeper() {
+	go func() {
+		ticker := time.NewTicker(reservationSweepInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := releaseExpiredReservations(); err != nil {
+				log.Printf("failed to release expired reservations: %v", err)
+			}
+			if err := cancelExpiredOrders(); err != nil {
+				log.Printf("failed to cancel expired orders: %v", err)
+			}
+		}
+	}()
+}
+
+// adjustStock applies change.Delta to the on-hand quantity of change.SKU at
+// change.WarehouseId and records it in the ledger. A decrease never takes
+// on hand below what is already reserved, nor a warehouse below zero.
+func adjustStock(change model.StockAdjustment) (*model.StockLevel, error) {
+	sku, delta := change.SKU, change.Delta
+	if err := checkWarehouse(change.WarehouseId); err != nil {
+		return nil, err
+	}
+	count, err := inventoryCollection().CountDocuments(context.Background(), bson.M{"sku": sku})
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+	if !change.WarehouseId.IsZero() {
+		if err := changeWarehouseStock(sku, change.WarehouseId, delta); err != nil {
+			return nil, err
+		}
+	}
+
+	filter := bson.M{"sku": sku}
+	if delta < 0 {
+		filter["$expr"] = bson.M{"$gte": bson.A{
+			bson.M{"$subtract": bson.A{"$on_hand", "$reserved"}},
+			-delta,
+		}}
+	}
+	var level model.StockLevel
+	err = inventoryCollection().FindOneAndUpdate(context.Background(), filter,
+		bson.M{
+			"$inc": bson.M{"on_hand": delta},
+			"$set": bson.M{"updated_at": time.Now()},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&level)
+	if err != nil {
+		if !change.WarehouseId.IsZero() {
+			if undoErr := changeWarehouseStock(sku, change.WarehouseId, -delta); undoErr != nil {
+				log.Printf("failed to undo warehouse change of %s: %v", sku, undoErr)
+			}
+		}
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			// The SKU exists, so the reserved quantity blocked the decrease.
+			return nil, &errInsufficientStock{SKU: sku, Requested: -delta}
+		}
+		return nil, err
+	}
+
+	change.OnHandAfter = level.OnHand
+	recordAdjustment(change)
+	publishStockAlert(level, delta)
+	return &level, nil
+}
+
+// respondStockError writes the response for an inventory operation error.
+func respondStockError(ctx *gin.Context, err error) {
+	var insufficient *errInsufficientStock
+	switch {
+	case errors.As(err, &insufficient):
+		ctx.JSON(http.StatusConflict, gin.H{
+			"message": insufficientStock,
+			"error":   err.Error(),
+			"sku":     insufficient.SKU,
+		})
+	case errors.Is(err, errReservationState):
+		ctx.JSON(http.StatusConflict, gin.H{
+			"message": "Reservation already completed",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, errWarehouseRequired), errors.Is(err, errUnknownWarehouse):
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+	case errors.Is(err, mongo.ErrNoDocuments):
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": stockNotFound,
+			"error":   err.Error(),
+		})
+	default:
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to update stock",
+			"error":   err.Error(),
+		})
+	}
+}
+
+// GetInventory lists stock levels. "q" narrows by SKU prefix and
+// "low_stock=true" keeps only SKUs at or below their threshold.
+func GetInventory(ctx *gin.Context) {
+	filter := bson.M{}
+	if q := strings.TrimSpace(ctx.Query("q")); q != "" {
+		filter["sku"] = primitive.Regex{Pattern: "^" + regexp.QuoteMeta(q), Options: "i"}
+	}
+	if ctx.Query("low_stock") == "true" {
+		filter["$expr"] = bson.M{"$lte": bson.A{
+			bson.M{"$subtract": bson.A{"$on_hand", "$reserved"}},
+			"$low_stock_threshold",
+		}}
+	}
+
+	params, err := parsePageParams(ctx, helpers.FixedSort("sku"), true)
+	if err != nil {
+		respondQueryError(ctx, err)
+		return
+	}
+
+	levels := []model.StockLevel{}
+	next, hasMore, err := findPage(inventoryCollection(), filter, params, &levels)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to get inventory",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	total, err := inventoryCollection().CountDocuments(context.Background(), filter)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to count inventory",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	response := gin.H{
+		"data":  levels,
+		"total": total,
+	}
+	addPageLinks(ctx, response, params, next, hasMore)
+	ctx.JSON(http.StatusOK, response)
+}
+
+// GetStockLevel returns the stock of a single SKU and how it is spread
+// across warehouses.
+func GetStockLevel(ctx *gin.Context) {
+	var level model.StockLevel
+	err := inventoryCollection().FindOne(context.Background(), bson.M{"sku": ctx.Param("sku")}).Decode(&level)
+	if err != nil {
+		respondStockError(ctx, err)
+		return
+	}
+
+	cursor, err := warehouseStockCollection().Find(context.Background(), bson.M{"sku": level.SKU},
+		options.Find().SetSort(bson.D{{Key: "warehouse_id", Value: 1}}))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to get stock locations",
+			"error":   err.Error(),
+		})
+		return
+	}
+	locations := []model.WarehouseStock{}
+	if err := cursor.All(context.Background(), &locations); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to get stock locations",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"data":      level,
+		"locations": locations,
+	})
+}
+
+// AdjustStock applies a manual stock change such as a restock or a damaged
+// unit write-off.
+func AdjustStock(ctx *gin.Context) {
+	var input struct {
+		Delta       int                `json:"delta"`
+		Reason      string             `json:"reason"`
+		Note        string             `json:"note"`
+		WarehouseId primitive.ObjectID `json:"warehouse_id"`
+	}
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	if err := helpers.ValidateAdjustment(input.Delta, input.Reason); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	user, _ := currentUser(ctx)
+	level, err := adjustStock(model.StockAdjustment{
+		SKU:         ctx.Param("sku"),
+		Delta:       input.Delta,
+		Reason:      input.Reason,
+		Note:        input.Note,
+		WarehouseId: input.WarehouseId,
+		Actor:       user.Id,
+	})
+	if err != nil {
+		respondStockError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Stock adjusted successfully",
+		"data":    level,
+	})
+}
+
+// SetStockThreshold changes the low stock threshold of a SKU.
+func SetStockThreshold(ctx *gin.Context) {
+	var input struct {
+		Threshold int `json:"threshold"`
+	}
+	if err := ctx.ShouldBindJSON(&input); err != nil || input.Threshold < 0 {
+		if err == nil {
+			err = errors.New("threshold must be non-negative")
+		}
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	var level model.StockLevel
+	err := inventoryCollection().FindOneAndUpdate(context.Background(),
+		bson.M{"sku": ctx.Param("sku")},
+		bson.M{"$set": bson.M{"low_stock_threshold": input.Threshold, "updated_at": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&level)
+	if err != nil {
+		respondStockError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Threshold updated successfully",
+		"data":    level,
+	})
+}
+
+// GetStockLedger lists the adjustments of a SKU, newest first.
+func GetStockLedger(ctx *gin.Context) {
+	params, err := parsePageParams(ctx, helpers.FixedSort("-created_at"), true)
+	if err != nil {
+		respondQueryError(ctx, err)
+		return
+	}
+
+	entries := []model.StockAdjustment{}
+	next, hasMore, err := findPage(ledgerCollection(), bson.M{"sku": ctx.Param("sku")}, params, &entries)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to get stock ledger",
+			"error":   err.Error(),
+		})
+		return
+	}
+	response := gin.H{
+		"data": entries,
+	}
+	addPageLinks(ctx, response, params, next, hasMore)
+	ctx.JSON(http.StatusOK, response)
+}
+
+// ReserveStock holds stock for a list of items under a reference such as a
+// checkout id. Either every item is reserved or none is.
+func ReserveStock(ctx *gin.Context) {
+	var input struct {
+		Reference string                 `json:"reference"`
+		Items     []helpers.StockRequest `json:"items" binding:"required"`
+	}
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if _, err := helpers.NormalizeStockRequests(input.Items); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	reservations, err := reserveStock(input.Reference, input.Items)
+	if err != nil {
+		respondStockError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusCreated, gin.H{
+		"message": "Stock reserved successfully",
+		"data":    reservations,
+	})
+}
+
+func parseReservationId(ctx *gin.Context) (primitive.ObjectID, bool) {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return id, false
+	}
+	return id, true
+}
+
+// CommitReservation turns a held reservation into a sale.
+func CommitReservation(ctx *gin.Context) {
+	id, ok := parseReservationId(ctx)
+	if !ok {
+		return
+	}
+	user, _ := currentUser(ctx)
+	reservation, err := commitReservation(id, user.Id)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": reservationNotFound,
+				"error":   err.Error(),
+			})
+			return
+		}
+		respondStockError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Reservation committed successfully",
+		"data":    reservation,
+	})
+}
+
+// ReleaseReservation gives the stock of a held reservation back.
+func ReleaseReservation(ctx *gin.Context) {
+	id, ok := parseReservationId(ctx)
+	if !ok {
+		return
+	}
+	reservation, err := releaseReservation(id)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": reservationNotFound,
+				"error":   err.Error(),
+			})
+			return
+		}
+		respondStockError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Reservation released successfully",
+		"data":    reservation,
+	})
+}
@@ -0,0 +1,276 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joshua/casify/helpers"
+	"github.com/joshua/casify/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const productStatusUpdated = "Product status updated"
+
+// ensureLifecycleIndexes backs the public status filter and the scheduler.
+func ensureLifecycleIndexes() error {
+	collection := Client.Database(dbName).Collection(colName)
+	_, err := collection.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "publish_at", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "unpublish_at", Value: 1}}},
+	})
+	return err
+}
+
+// backfillProductStatus publishes the products created before the
+// lifecycle existed, since shoppers could already see them.
+func backfillProductStatus() error {
+	collection := Client.Database(dbName).Collection(colName)
+	result, err := collection.UpdateMany(context.Background(),
+		bson.M{"$or": []bson.M{{"status": bson.M{"$exists": false}}, {"status": ""}}},
+		bson.M{"$set": bson.M{"status": model.ProductPublished}})
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount > 0 {
+		log.Printf("published %d products without a status", result.ModifiedCount)
+	}
+	return nil
+}
+
+// applyInitialStatus puts new products in the state they were created with,
+// or in draft when none was given.
+func applyInitialStatus(products ...*model.Product) error {
+	now := time.Now()
+	for _, p := range products {
+		if p.Status == "" {
+			p.Status = model.ProductDraft
+			p.PublishAt, p.UnpublishAt = nil, nil
+			continue
+		}
+		change := helpers.StatusChange{Status: p.Status, PublishAt: p.PublishAt, UnpublishAt: p.UnpublishAt}
+		updated, err := helpers.ApplyStatusChange(*p, change, now)
+		if err != nil {
+			return err
+		}
+		*p = updated
+	}
+	return nil
+}
+
+// publishDueProducts moves scheduled products live, and live products past
+// their unpublish time to the archive.
+func publishDueProducts() error {
+	now := time.Now()
+	collection := Client.Database(dbName).Collection(colName)
+	cursor, err := collection.Find(context.Background(), bson.M{"$or": []bson.M{
+		{"status": model.ProductScheduled, "publish_at": bson.M{"$lte": now}},
+		{"status": model.ProductPublished, "unpublish_at": bson.M{"$lte": now}},
+	}})
+	if err != nil {
+		return err
+	}
+	var due []model.Product
+	if err := cursor.All(context.Background(), &due); err != nil {
+		return err
+	}
+
+	var changed []model.Product
+	for _, p := range due {
+		status, ok := helpers.DueStatus(p, now)
+		if !ok {
+			continue
+		}
+		// The status condition skips products changed since they were read
+		var updated model.Product
+		err := collection.FindOneAndUpdate(context.Background(),
+			bson.M{"_id": p.Id, "status": p.Status},
+			bson.M{"$set": bson.M{"status": status, "time_stamp.updated_at": now}},
+			options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&updated)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		changed = append(changed, updated)
+	}
+	if len(changed) > 0 {
+		afterProductsSaved(changed...)
+		recordRevisions(primitive.NilObjectID, model.RevisionUpdate, changed...)
+		log.Printf("updated the status of %d scheduled products", len(changed))
+	}
+	return nil
+}
+
+// startProductScheduler periodically applies product publishing schedules.
+func startProductScheduler() {
+	go func() {
+		ticker := time.NewTicker(helpers.SchedulerIntervalFromEnv())
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := publishDueProducts(); err != nil {
+				log.Printf("failed to apply product schedules: %v", err)
+			}
+		}
+	}()
+}
+
+// findLiveProductById loads a product shoppers can see. Products that are
+// not live are reported as not found.
+func findLiveProductById(ctx *gin.Context) (*model.Product, error) {
+	product, err := findProductById(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !helpers.ProductLive(*product) {
+		return nil, fmt.Errorf("product not found: %w", mongo.ErrNoDocuments)
+	}
+	return product, nil
+}
+
+// liveProducts drops the products shoppers cannot see.
+func liveProducts(products []model.Product) []model.Product {
+	live := make([]model.Product, 0, len(products))
+	for _, p := range products {
+		if helpers.ProductLive(p) {
+			live = append(live, p)
+		}
+	}
+	return live
+}
+
+// respondPublishError writes a 400 for a rejected status change, listing
+// the missing attributes when the product is incomplete.
+func respondPublishError(ctx *gin.Context, err error) {
+	response := gin.H{
+		"message": invalidBody,
+		"error":   err.Error(),
+	}
+	var pErr *helpers.PublishError
+	if errors.As(err, &pErr) {
+		response["missing"] = pErr.Missing
+	}
+	ctx.JSON(http.StatusBadRequest, response)
+}
+
+// SetProductStatus moves a product through its lifecycle. The body holds
+// the new status and, when publishing or scheduling, the optional
+// publish_at and unpublish_at times.
+func SetProductStatus(ctx *gin.Context) {
+	product, err := findProductById(ctx)
+	if err != nil {
+		handleProductError(ctx, err)
+		return
+	}
+	var change helpers.StatusChange
+	if err := ctx.ShouldBindJSON(&change); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	now := time.Now()
+	updated, err := helpers.ApplyStatusChange(*product, change, now)
+	if err != nil {
+		respondPublishError(ctx, err)
+		return
+	}
+
+	set := bson.M{"status": updated.Status, "time_stamp.updated_at": now}
+	unset := bson.M{}
+	if updated.PublishAt != nil {
+		set["publish_at"] = updated.PublishAt
+	} else {
+		unset["publish_at"] = ""
+	}
+	if updated.UnpublishAt != nil {
+		set["unpublish_at"] = updated.UnpublishAt
+	} else {
+		unset["unpublish_at"] = ""
+	}
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	collection := Client.Database(dbName).Collection(colName)
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var saved model.Product
+	if err := collection.FindOneAndUpdate(context.Background(), bson.M{"_id": product.Id}, update, opts).Decode(&saved); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to update product status",
+			"error":   err.Error(),
+		})
+		return
+	}
+	afterProductsSaved(saved)
+	recordRevisions(requestActor(ctx), model.RevisionUpdate, saved)
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": productStatusUpdated,
+		"data":    saved,
+	})
+}
+
+// GetAdminProducts lists products in every state, with the same filters and
+// pagination as FilterProducts. "status" narrows the list to a comma
+// separated set of states.
+func GetAdminProducts(ctx *gin.Context) {
+	node, err := parseProductFilter(ctx)
+	if err != nil {
+		respondQueryError(ctx, err)
+		return
+	}
+	params, err := parseAdminListParams(ctx, "-created_at")
+	if err != nil {
+		respondQueryError(ctx, err)
+		return
+	}
+
+	byStatus, err := parseStatusFilter(ctx)
+	if err != nil {
+		respondQueryError(ctx, err)
+		return
+	}
+
+	findProductPage(ctx, helpers.CompileFilter(helpers.And(node, byStatus)), params, nil)
+}
+
+// parseStatusFilter reads the "status" parameter of admin views, a comma
+// separated set of states. It returns nil when none was given.
+func parseStatusFilter(ctx *gin.Context) (helpers.FilterNode, error) {
+	raw := strings.TrimSpace(ctx.Query("status"))
+	if raw == "" {
+		return nil, nil
+	}
+	var statuses []interface{}
+	for _, status := range strings.Split(raw, ",") {
+		status = strings.TrimSpace(status)
+		if !helpers.ValidProductStatus(status) {
+			return nil, &queryError{message: "Invalid status", err: fmt.Errorf("unknown status %q", status)}
+		}
+		statuses = append(statuses, status)
+	}
+	return helpers.NewIn("status", statuses)
+}
+
+// GetAdminProduct returns a product whatever its status.
+func GetAdminProduct(ctx *gin.Context) {
+	product, err := findProductById(ctx)
+	if err != nil {
+		handleProductError(ctx, err)
+		return
+	}
+	respondProduct(ctx, product)
+}
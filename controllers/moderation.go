@@ -0,0 +1,240 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joshua/casify/helpers"
+	"github.com/joshua/casify/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// respondModerationQueue lists the documents of collection by moderation
+// status, oldest first so the queue is worked in order. "status" defaults
+// to pending. results points to a slice of the collection's model.
+func respondModerationQueue(ctx *gin.Context, collection *mongo.Collection, results interface{}) {
+	status := ctx.DefaultQuery("status", model.ReviewPending)
+	if !helpers.ValidReviewStatus(status) {
+		respondQueryError(ctx, &queryError{message: "Invalid status", err: fmt.Errorf("unknown status %q", status)})
+		return
+	}
+	params, err := parsePageParams(ctx, helpers.FixedSort("time_stamp.created_at"), true)
+	if err != nil {
+		respondQueryError(ctx, err)
+		return
+	}
+
+	filter := bson.M{"status": status}
+	total, err := collection.CountDocuments(context.Background(), filter)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to get moderation queue",
+			"error":   err.Error(),
+		})
+		return
+	}
+	next, hasMore, err := findPage(collection, filter, params, results)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to get moderation queue",
+			"error":   err.Error(),
+		})
+		return
+	}
+	response := gin.H{
+		"data":  results,
+		"total": total,
+	}
+	addPageLinks(ctx, response, params, next, hasMore)
+	ctx.JSON(http.StatusOK, response)
+}
+
+// bindRejectionReason reads the required "reason" of a rejection.
+func bindRejectionReason(ctx *gin.Context) (string, bool) {
+	var input struct {
+		Reason string `json:"reason" binding:"required"`
+	}
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return "", false
+	}
+	reason := strings.TrimSpace(input.Reason)
+	if reason == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   "reason is required",
+		})
+		return "", false
+	}
+	return reason, true
+}
+
+// moderationParam reads the id of the document to moderate from the path.
+func moderationParam(ctx *gin.Context) (primitive.ObjectID, bool) {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return id, false
+	}
+	return id, true
+}
+
+// moderate sets the status of a document of collection and decodes the
+// result into out. reason is only kept for rejections.
+func moderate(ctx *gin.Context, collection *mongo.Collection, id primitive.ObjectID, status, reason string, out interface{}) error {
+	set := bson.M{
+		"status":       status,
+		"moderated_by": requestActor(ctx),
+		"moderated_at": time.Now(),
+	}
+	update := bson.M{"$set": set}
+	if reason != "" {
+		set["rejection_reason"] = reason
+	} else {
+		update["$unset"] = bson.M{"rejection_reason": ""}
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	return collection.FindOneAndUpdate(context.Background(), bson.M{"_id": id}, update, opts).Decode(out)
+}
+
+// GetReviewQueue lists reviews by moderation status.
+func GetReviewQueue(ctx *gin.Context) {
+	respondModerationQueue(ctx, reviewCollection(), &[]model.Review{})
+}
+
+// moderateReview sets the status of the review named in the path and
+// refreshes the rating of its product.
+func moderateReview(ctx *gin.Context, status, reason string) (*model.Review, bool) {
+	id, ok := moderationParam(ctx)
+	if !ok {
+		return nil, false
+	}
+	var review model.Review
+	if err := moderate(ctx, reviewCollection(), id, status, reason, &review); err != nil {
+		respondReviewError(ctx, err, "Failed to moderate review")
+		return nil, false
+	}
+	if err := refreshReviewAggregate(review.ProductId); err != nil {
+		log.Printf("failed to update the rating of product %s: %v", review.ProductId.Hex(), err)
+	}
+	return &review, true
+}
+
+// ApproveReview publishes a review.
+func ApproveReview(ctx *gin.Context) {
+	review, ok := moderateReview(ctx, model.ReviewApproved, "")
+	if !ok {
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Review approved",
+		"data":    review,
+	})
+}
+
+// RejectReview hides a review. The body must give a "reason", which is
+// passed on to the author.
+func RejectReview(ctx *gin.Context) {
+	reason, ok := bindRejectionReason(ctx)
+	if !ok {
+		return
+	}
+	review, ok := moderateReview(ctx, model.ReviewRejected, reason)
+	if !ok {
+		return
+	}
+	events.Publish(helpers.EventReviewRejected, helpers.ReviewRejection{
+		ReviewId:  review.Id,
+		ProductId: review.ProductId,
+		UserId:    review.UserId,
+		Title:     review.Title,
+		Reason:    reason,
+	})
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Review rejected",
+		"data":    review,
+	})
+}
+
+// GetQuestionQueue lists questions by moderation status.
+func GetQuestionQueue(ctx *gin.Context) {
+	respondModerationQueue(ctx, questionCollection(), &[]model.Question{})
+}
+
+// GetAnswerQueue lists answers by moderation status.
+func GetAnswerQueue(ctx *gin.Context) {
+	respondModerationQueue(ctx, answerCollection(), &[]model.Answer{})
+}
+
+// ApproveQuestion publishes a question.
+func ApproveQuestion(ctx *gin.Context) {
+	moderateQuestion(ctx, model.ReviewApproved, "")
+}
+
+// RejectQuestion hides a question. The body must give a "reason".
+func RejectQuestion(ctx *gin.Context) {
+	if reason, ok := bindRejectionReason(ctx); ok {
+		moderateQuestion(ctx, model.ReviewRejected, reason)
+	}
+}
+
+func moderateQuestion(ctx *gin.Context, status, reason string) {
+	id, ok := moderationParam(ctx)
+	if !ok {
+		return
+	}
+	var question model.Question
+	if err := moderate(ctx, questionCollection(), id, status, reason, &question); err != nil {
+		respondQuestionError(ctx, err, questionNotFound, "Failed to moderate question")
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Question " + status,
+		"data":    question,
+	})
+}
+
+// ApproveAnswer publishes an answer.
+func ApproveAnswer(ctx *gin.Context) {
+	moderateAnswer(ctx, model.ReviewApproved, "")
+}
+
+// RejectAnswer hides an answer. The body must give a "reason".
+func RejectAnswer(ctx *gin.Context) {
+	if reason, ok := bindRejectionReason(ctx); ok {
+		moderateAnswer(ctx, model.ReviewRejected, reason)
+	}
+}
+
+func moderateAnswer(ctx *gin.Context, status, reason string) {
+	id, ok := moderationParam(ctx)
+	if !ok {
+		return
+	}
+	var answer model.Answer
+	if err := moderate(ctx, answerCollection(), id, status, reason, &answer); err != nil {
+		respondQuestionError(ctx, err, answerNotFound, "Failed to moderate answer")
+		return
+	}
+	if err := refreshAnswerCount(answer.QuestionId); err != nil {
+		log.Printf("failed to count the answers of question %s: %v", answer.QuestionId.Hex(), err)
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Answer " + status,
+		"data":    answer,
+	})
+}
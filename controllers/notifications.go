@@ -0,0 +1,162 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joshua/casify/helpers"
+	"github.com/joshua/casify/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const notificationColName = "notifications"
+
+func notificationCollection() *mongo.Collection {
+	return Client.Database(dbName).Collection(notificationColName)
+}
+
+// ensureNotificationIndexes backs the inbox listing.
+func ensureNotificationIndexes() error {
+	_, err := notificationCollection().Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}},
+	})
+	return err
+}
+
+// notify saves a notification to a user's inbox.
+func notify(notification model.Notification) error {
+	notification.Id = primitive.NewObjectID()
+	notification.CreatedAt = time.Now()
+	_, err := notificationCollection().InsertOne(context.Background(), notification)
+	return err
+}
+
+// registerNotificationHandlers turns store events into notifications.
+func registerNotificationHandlers() {
+	events.Subscribe(helpers.EventReviewRejected, notifyReviewRejected)
+	events.Subscribe(helpers.EventPriceDropped, notifyPriceDrop)
+}
+
+// notifyReviewRejected tells the author of a rejected review why it was not
+// published.
+func notifyReviewRejected(event helpers.Event) {
+	rejection, ok := event.Payload.(helpers.ReviewRejection)
+	if !ok {
+		return
+	}
+	name := "a product"
+	var product model.Product
+	err := Client.Database(dbName).Collection(colName).FindOne(context.Background(),
+		bson.M{"_id": rejection.ProductId},
+		options.FindOne().SetProjection(bson.M{"title": 1})).Decode(&product)
+	if err == nil && product.Title != "" {
+		name = product.Title
+	}
+	err = notify(model.Notification{
+		UserId:    rejection.UserId,
+		Kind:      model.NotifyReviewRejected,
+		Title:     "Your review was not published",
+		Message:   fmt.Sprintf("Your review of %s was not published: %s", name, rejection.Reason),
+		Reference: rejection.ReviewId,
+	})
+	if err != nil {
+		log.Printf("failed to notify user %s of rejected review %s: %v", rejection.UserId.Hex(), rejection.ReviewId.Hex(), err)
+	}
+}
+
+// notifyPriceDrop tells a user an item on their wishlist got cheaper.
+func notifyPriceDrop(event helpers.Event) {
+	drop, ok := event.Payload.(helpers.PriceDrop)
+	if !ok {
+		return
+	}
+	err := notify(model.Notification{
+		UserId:    drop.UserId,
+		Kind:      model.NotifyPriceDrop,
+		Title:     "A saved item is cheaper",
+		Message:   fmt.Sprintf("%s dropped from %.2f to %.2f", drop.Title, drop.OldPrice, drop.NewPrice),
+		Reference: drop.ProductId,
+	})
+	if err != nil {
+		log.Printf("failed to notify user %s of a price drop on %s: %v", drop.UserId.Hex(), drop.ProductId.Hex(), err)
+	}
+}
+
+// GetNotifications lists the signed in user's notifications, newest first.
+// "unread=true" leaves out the ones already read.
+func GetNotifications(ctx *gin.Context) {
+	params, err := parsePageParams(ctx, helpers.FixedSort("-created_at"), false)
+	if err != nil {
+		respondQueryError(ctx, err)
+		return
+	}
+
+	user, _ := currentUser(ctx)
+	filter := bson.M{"user_id": user.Id}
+	if ctx.Query("unread") == "true" {
+		filter["read"] = false
+	}
+	total, err := notificationCollection().CountDocuments(context.Background(), filter)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to get notifications",
+			"error":   err.Error(),
+		})
+		return
+	}
+	notifications := []model.Notification{}
+	next, hasMore, err := findPage(notificationCollection(), filter, params, &notifications)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to get notifications",
+			"error":   err.Error(),
+		})
+		return
+	}
+	response := gin.H{
+		"data":  notifications,
+		"total": total,
+	}
+	addPageLinks(ctx, response, params, next, hasMore)
+	ctx.JSON(http.StatusOK, response)
+}
+
+// MarkNotificationRead marks one of the signed in user's notifications as
+// read.
+func MarkNotificationRead(ctx *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	user, _ := currentUser(ctx)
+	result, err := notificationCollection().UpdateOne(context.Background(),
+		bson.M{"_id": id, "user_id": user.Id},
+		bson.M{"$set": bson.M{"read": true}})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to update notification",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if result.MatchedCount == 0 {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": "Notification not found",
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Notification marked as read",
+	})
+}
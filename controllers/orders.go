@@ -0,0 +1,453 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joshua/casify/helpers"
+	"github.com/joshua/casify/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const orderColName = "orders"
+
+const orderNotFound = "Order not found"
+
+// errOrderChanged is returned when an order changed state while a
+// transition was being applied.
+var errOrderChanged = errors.New("the order was changed by another request")
+
+func orderCollection() *mongo.Collection {
+	return Client.Database(dbName).Collection(orderColName)
+}
+
+// ensureOrderIndexes makes order numbers unique and backs the order
+// listings, the expiry sweep, the purchase check of reviews and payment
+// webhooks.
+func ensureOrderIndexes() error {
+	_, err := orderCollection().Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "number", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "time_stamp.created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "time_stamp.created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "expires_at", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "product_ids", Value: 1}, {Key: "status", Value: 1}}},
+		{Keys: bson.D{{Key: "payment.intent_id", Value: 1}}},
+	})
+	return err
+}
+
+// hasPurchasedProduct reports whether a user has a paid, unrefunded order
+// containing a product.
+func hasPurchasedProduct(userId, productId primitive.ObjectID) (bool, error) {
+	count, err := orderCollection().CountDocuments(context.Background(), bson.M{
+		"user_id":     userId,
+		"product_ids": productId,
+		"status":      bson.M{"$in": helpers.PurchasedOrderStatuses},
+	}, options.Count().SetLimit(1))
+	return count > 0, err
+}
+
+// transitionOrder moves an order to status and records it in the order's
+// history. The update only matches while the order is still in the state
+// it was read in, so two requests cannot both move it. Stock held for the
+// order is committed when it is paid and released when it is cancelled,
+// along with its payment intent. A paid order whose stock is no longer held
+// is flagged with a StockIssue.
+func transitionOrder(order *model.Order, status string, actor primitive.ObjectID, note string, set bson.M) (*model.Order, error) {
+	if err := helpers.CheckOrderTransition(order.Status, status); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if set == nil {
+		set = bson.M{}
+	}
+	set["status"] = status
+	set["time_stamp.updated_at"] = now
+	switch status {
+	case model.OrderPaid:
+		set["payment.status"] = model.PaymentSucceeded
+	case model.OrderRefunded:
+		set["payment.status"] = model.PaymentRefunded
+	}
+	update := bson.M{
+		"$set": set,
+		"$push": bson.M{"history": model.OrderTransition{
+			From: order.Status, To: status, Actor: actor, Note: note, At: now,
+		}},
+	}
+	var saved model.Order
+	err := orderCollection().FindOneAndUpdate(context.Background(),
+		bson.M{"_id": order.Id, "status": order.Status}, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&saved)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, errOrderChanged
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range saved.ReservationIds {
+		var err error
+		switch status {
+		case model.OrderPaid:
+			_, err = commitReservation(id, actor)
+		case model.OrderCancelled:
+			_, err = releaseReservation(id)
+			if errors.Is(err, errReservationState) {
+				// Already released when the reservation expired
+				err = nil
+			}
+		}
+		if err != nil {
+			log.Printf("failed to settle reservation %s of order %s: %v", id.Hex(), saved.Number, err)
+			if status == model.OrderPaid {
+				saved.StockIssue = fmt.Sprintf("reservation %s was not committed: %v", id.Hex(), err)
+			}
+		}
+	}
+	if status == model.OrderCancelled {
+		cancelOrderPayment(&saved)
+	}
+	if saved.StockIssue != "" {
+		if _, err := orderCollection().UpdateOne(context.Background(), bson.M{"_id": saved.Id},
+			bson.M{"$set": bson.M{"stock_issue": saved.StockIssue}}); err != nil {
+			log.Printf("failed to flag the stock issue of order %s: %v", saved.Number, err)
+		}
+	}
+	return &saved, nil
+}
+
+// cancelExpiredOrders cancels pending orders that were not paid before
+// their stock reservation ran out.
+func cancelExpiredOrders() error {
+	cursor, err := orderCollection().Find(context.Background(), bson.M{
+		"status":     model.OrderPending,
+		"expires_at": bson.M{"$lte": time.Now()},
+	})
+	if err != nil {
+		return err
+	}
+	var orders []model.Order
+	if err := cursor.All(context.Background(), &orders); err != nil {
+		return err
+	}
+	for i := range orders {
+		_, err := transitionOrder(&orders[i], model.OrderCancelled, primitive.NilObjectID, "Payment not received in time", nil)
+		if err != nil && !errors.Is(err, errOrderChanged) {
+			log.Printf("failed to cancel expired order %s: %v", orders[i].Number, err)
+		}
+	}
+	return nil
+}
+
+func respondOrderError(ctx *gin.Context, err error, message string) {
+	var transition *helpers.TransitionError
+	var insufficient *errInsufficientStock
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": orderNotFound,
+			"error":   err.Error(),
+		})
+	case errors.As(err, &transition), errors.Is(err, errOrderChanged):
+		ctx.JSON(http.StatusConflict, gin.H{
+			"message": "Order cannot be changed",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, helpers.ErrEmptyCart):
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+	case errors.Is(err, helpers.ErrSKUNotSold):
+		respondCartError(ctx, err)
+	case errors.As(err, &insufficient):
+		respondStockError(ctx, err)
+	default:
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": message,
+			"error":   err.Error(),
+		})
+	}
+}
+
+// findOrder loads the order named in the path. Customers only see their
+// own orders; admins see all.
+func findOrder(ctx *gin.Context) (*model.Order, bool) {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return nil, false
+	}
+	filter := bson.M{"_id": id}
+	if user, _ := currentUser(ctx); user.Role != model.RoleAdmin {
+		filter["user_id"] = user.Id
+	}
+	var order model.Order
+	if err := orderCollection().FindOne(context.Background(), filter).Decode(&order); err != nil {
+		respondOrderError(ctx, err, "Failed to get order")
+		return nil, false
+	}
+	return &order, true
+}
+
+// findOrderPage writes one page of the orders matching filter, newest
+// first. "status" narrows by state, and admin views may page by offset.
+func findOrderPage(ctx *gin.Context, filter bson.M, admin bool) {
+	params, err := parsePageParams(ctx, helpers.FixedSort("-time_stamp.created_at"), admin)
+	if err != nil {
+		respondQueryError(ctx, err)
+		return
+	}
+	if status := ctx.Query("status"); status != "" {
+		if !helpers.ValidOrderStatus(status) {
+			respondQueryError(ctx, &queryError{message: "Invalid status", err: fmt.Errorf("unknown status %q", status)})
+			return
+		}
+		filter["status"] = status
+	}
+
+	total, err := orderCollection().CountDocuments(context.Background(), filter)
+	if err != nil {
+		respondOrderError(ctx, err, "Failed to get orders")
+		return
+	}
+	orders := []model.Order{}
+	next, hasMore, err := findPage(orderCollection(), filter, params, &orders)
+	if err != nil {
+		respondOrderError(ctx, err, "Failed to get orders")
+		return
+	}
+	response := gin.H{
+		"data":  orders,
+		"total": total,
+	}
+	addPageLinks(ctx, response, params, next, hasMore)
+	ctx.JSON(http.StatusOK, response)
+}
+
+// GetShippingMethods lists the delivery options offered at checkout.
+func GetShippingMethods(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{
+		"data":     helpers.ShippingMethods,
+		"currency": helpers.StoreCurrencyFromEnv(),
+	})
+}
+
+// Checkout turns the signed in user's cart into a pending order at current
+// prices. The order's stock is reserved and a payment intent opened; an
+// order not paid before the reservation expires is cancelled.
+func Checkout(ctx *gin.Context) {
+	var input struct {
+		ShippingAddress model.Address `json:"shipping_address" binding:"required"`
+		ShippingMethod  string        `json:"shipping_method" binding:"required"`
+		PaymentMethod   string        `json:"payment_method"`
+	}
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	method, ok := helpers.FindShippingMethod(input.ShippingMethod)
+	if !ok {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   fmt.Sprintf("unknown shipping method %q", input.ShippingMethod),
+		})
+		return
+	}
+	if err := helpers.ValidateAddress(input.ShippingAddress); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	user, _ := currentUser(ctx)
+	ref := cartRef{collection: userCollection(user.Id), id: userCartId}
+	cart, err := loadCart(ref)
+	if err != nil {
+		respondOrderError(ctx, err, "Failed to check out")
+		return
+	}
+	view, err := priceCart(cart)
+	if err != nil {
+		respondOrderError(ctx, err, "Failed to check out")
+		return
+	}
+	lines, err := helpers.OrderLinesFromCart(view)
+	if err != nil {
+		respondOrderError(ctx, err, "Failed to check out")
+		return
+	}
+
+	now := time.Now()
+	order := helpers.NewOrder(user.Id, lines, method, input.ShippingAddress, helpers.StoreCurrencyFromEnv(), now)
+	suffix, err := helpers.RandomToken(3)
+	if err != nil {
+		respondOrderError(ctx, err, "Failed to check out")
+		return
+	}
+	order.Number = helpers.OrderNumber(now, suffix)
+
+	// Stock is held before a payment is opened, so a sold out cart never
+	// leaves an intent behind at the provider
+	reservations, err := reserveStock(order.Number, helpers.StockRequestsForOrder(lines))
+	if err != nil {
+		respondOrderError(ctx, err, "Failed to check out")
+		return
+	}
+	order.ExpiresAt = now.Add(helpers.ReservationTTLFromEnv())
+	for _, r := range reservations {
+		order.ReservationIds = append(order.ReservationIds, r.Id)
+		order.ExpiresAt = r.ExpiresAt
+	}
+	err = createPaymentIntent(&order, strings.TrimSpace(input.PaymentMethod))
+	if err == nil {
+		_, err = orderCollection().InsertOne(context.Background(), order)
+	}
+	if err != nil {
+		for _, r := range reservations {
+			if _, err := releaseReservation(r.Id); err != nil {
+				log.Printf("failed to release reservation %s: %v", r.Id.Hex(), err)
+			}
+		}
+		respondOrderError(ctx, err, "Failed to check out")
+		return
+	}
+	// Items added to the cart while checking out stay in it
+	_, err = updateCart(ref, func(cart *model.Cart) error {
+		helpers.RemoveOrderedItems(cart, order.Lines)
+		return nil
+	})
+	if err != nil {
+		log.Printf("failed to empty the cart of user %s after order %s: %v", user.Id.Hex(), order.Number, err)
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"message": "Order placed",
+		"data":    order,
+	})
+}
+
+// GetOrders lists the signed in user's orders.
+func GetOrders(ctx *gin.Context) {
+	user, _ := currentUser(ctx)
+	findOrderPage(ctx, bson.M{"user_id": user.Id}, false)
+}
+
+// GetOrder returns one of the signed in user's orders.
+func GetOrder(ctx *gin.Context) {
+	order, ok := findOrder(ctx)
+	if !ok {
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"data": order,
+	})
+}
+
+// CancelOrder lets a customer cancel an order that has not been paid.
+func CancelOrder(ctx *gin.Context) {
+	order, ok := findOrder(ctx)
+	if !ok {
+		return
+	}
+	saved, err := transitionOrder(order, model.OrderCancelled, requestActor(ctx), "Cancelled by customer", nil)
+	if err != nil {
+		respondOrderError(ctx, err, "Failed to cancel order")
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Order cancelled",
+		"data":    saved,
+	})
+}
+
+// GetAdminOrders lists every order. "user_id" narrows to one customer and
+// "stock_issue=true" to paid orders whose stock could not be committed.
+func GetAdminOrders(ctx *gin.Context) {
+	filter := bson.M{}
+	if ctx.Query("stock_issue") == "true" {
+		filter["stock_issue"] = bson.M{"$exists": true}
+	}
+	if raw := ctx.Query("user_id"); raw != "" {
+		userId, err := primitive.ObjectIDFromHex(raw)
+		if err != nil {
+			respondQueryError(ctx, &queryError{message: "Invalid user_id", err: err})
+			return
+		}
+		filter["user_id"] = userId
+	}
+	findOrderPage(ctx, filter, true)
+}
+
+// SetOrderStatus moves an order along the state machine. The body holds
+// "status", an optional "note" for the history and, when shipping, a
+// "tracking_number". Refunding an order refunds its payment first.
+func SetOrderStatus(ctx *gin.Context) {
+	var input struct {
+		Status         string `json:"status" binding:"required"`
+		Note           string `json:"note"`
+		TrackingNumber string `json:"tracking_number"`
+	}
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	if !helpers.ValidOrderStatus(input.Status) {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   fmt.Sprintf("unknown status %q", input.Status),
+		})
+		return
+	}
+	order, ok := findOrder(ctx)
+	if !ok {
+		return
+	}
+	set := bson.M{}
+	if tracking := strings.TrimSpace(input.TrackingNumber); tracking != "" {
+		set["tracking_number"] = tracking
+	}
+	if input.Status == model.OrderRefunded {
+		if err := helpers.CheckOrderTransition(order.Status, input.Status); err != nil {
+			respondOrderError(ctx, err, "Failed to update order")
+			return
+		}
+		if err := refundOrderPayment(ctx.Request.Context(), order); err != nil {
+			ctx.JSON(http.StatusBadGateway, gin.H{
+				"message": "Refund failed",
+				"error":   err.Error(),
+			})
+			return
+		}
+	}
+	saved, err := transitionOrder(order, input.Status, requestActor(ctx), strings.TrimSpace(input.Note), set)
+	if err != nil {
+		respondOrderError(ctx, err, "Failed to update order")
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Order updated",
+		"data":    saved,
+	})
+}
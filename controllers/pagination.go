@@ -0,0 +1,328 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joshua/casify/helpers"
+	"github.com/joshua/casify/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// parseListParams reads the pagination query parameters shared by the
+// product listing endpoints:
+//
+//	limit   page size (default 20, max 100)
+//	cursor  opaque cursor from a previous page's next_cursor
+//	offset  skip this many items instead of using cursors (admin views only)
+//	sort    comma separated fields, "-" prefix for descending
+//	fields  comma separated fields to include in each item
+//	count   "true" to include the total number of matches
+func parseListParams(ctx *gin.Context, defaultSort string) (helpers.ListParams, error) {
+	return parseListParamsWithOffset(ctx, defaultSort, false)
+}
+
+// parseAdminListParams is parseListParams for admin views, which may also
+// page by offset.
+func parseAdminListParams(ctx *gin.Context, defaultSort string) (helpers.ListParams, error) {
+	return parseListParamsWithOffset(ctx, defaultSort, true)
+}
+
+func parseListParamsWithOffset(ctx *gin.Context, defaultSort string, allowOffset bool) (helpers.ListParams, error) {
+	sort, err := helpers.ParseSort(ctx.DefaultQuery("sort", defaultSort))
+	if err != nil {
+		return helpers.ListParams{}, &queryError{message: "Invalid sort", err: err}
+	}
+	params, err := parsePageParams(ctx, sort, allowOffset)
+	if err != nil {
+		return params, err
+	}
+	if params.Fields, err = helpers.ParseFields(ctx.Query("fields")); err != nil {
+		return params, &queryError{message: "Invalid fields", err: err}
+	}
+	params.Count = ctx.Query("count") == "true"
+	return params, nil
+}
+
+// parsePageParams reads "limit", "cursor" and, in admin views, "offset" for
+// a listing in the given order. Listings other than products pick their
+// order themselves.
+func parsePageParams(ctx *gin.Context, sort []helpers.SortField, allowOffset bool) (helpers.ListParams, error) {
+	params := helpers.ListParams{Sort: sort}
+	var err error
+
+	if params.Limit, err = helpers.ParseLimit(ctx.Query("limit")); err != nil {
+		return params, &queryError{message: "Invalid limit", err: err}
+	}
+
+	offset, hasOffset := ctx.GetQuery("offset")
+	cursor := ctx.Query("cursor")
+	if hasOffset && cursor != "" {
+		return params, &queryError{message: "Invalid pagination", err: fmt.Errorf("cursor and offset cannot be combined")}
+	}
+	if hasOffset {
+		if !allowOffset {
+			return params, &queryError{message: "Invalid pagination", err: fmt.Errorf("offset pagination is only available in admin views, use cursor")}
+		}
+		params.UseOffset = true
+		if params.Offset, err = strconv.Atoi(offset); err != nil || params.Offset < 0 {
+			return params, &queryError{message: "Invalid offset", err: fmt.Errorf("offset must be a non-negative integer")}
+		}
+	}
+	if cursor != "" {
+		if params.After, err = helpers.DecodeCursor(cursor, params.Sort); err != nil {
+			return params, &queryError{message: "Invalid cursor", err: err}
+		}
+	}
+	return params, nil
+}
+
+// findPage loads one page of the documents of collection matching filter,
+// in the order of params, into results, a pointer to a slice. It returns
+// the cursor of the next page, empty when paging by offset, and whether
+// more documents follow.
+func findPage(collection *mongo.Collection, filter bson.M, params helpers.ListParams, results interface{}) (string, bool, error) {
+	query := filter
+	if params.After != nil {
+		query = bson.M{"$and": []bson.M{filter, helpers.CursorFilter(params.Sort, params.After)}}
+	}
+	// Fetch one extra document to learn whether another page exists.
+	opts := options.Find().
+		SetSort(helpers.SortDocument(params.Sort)).
+		SetLimit(int64(params.Limit + 1))
+	if params.UseOffset {
+		opts.SetSkip(int64(params.Offset))
+	}
+	cursor, err := collection.Find(context.Background(), query, opts)
+	if err != nil {
+		return "", false, err
+	}
+	defer cursor.Close(context.Background())
+
+	var docs []bson.Raw
+	for cursor.Next(context.Background()) {
+		docs = append(docs, append(bson.Raw(nil), cursor.Current...))
+	}
+	if err := cursor.Err(); err != nil {
+		return "", false, err
+	}
+	hasMore := len(docs) > params.Limit
+	if hasMore {
+		docs = docs[:params.Limit]
+	}
+
+	page := reflect.ValueOf(results).Elem()
+	for _, doc := range docs {
+		item := reflect.New(page.Type().Elem())
+		if err := bson.Unmarshal(doc, item.Interface()); err != nil {
+			return "", false, err
+		}
+		page = reflect.Append(page, item.Elem())
+	}
+	reflect.ValueOf(results).Elem().Set(page)
+
+	if !hasMore || params.UseOffset {
+		return "", hasMore, nil
+	}
+	next, err := helpers.EncodeCursor(params.Sort, docs[len(docs)-1])
+	if err != nil {
+		return "", false, err
+	}
+	return next, hasMore, nil
+}
+
+// addPageLinks adds has_more and the position of the next page to response,
+// and sets the matching Link header.
+func addPageLinks(ctx *gin.Context, response gin.H, params helpers.ListParams, next string, hasMore bool) {
+	response["has_more"] = hasMore
+	links := []string{}
+
+	if params.UseOffset {
+		response["offset"] = params.Offset
+		response["limit"] = params.Limit
+		links = append(links, pageLink(ctx, "first", map[string]string{"offset": "0"}))
+		if params.Offset > 0 {
+			prev := params.Offset - params.Limit
+			if prev < 0 {
+				prev = 0
+			}
+			links = append(links, pageLink(ctx, "prev", map[string]string{"offset": strconv.Itoa(prev)}))
+		}
+		if hasMore {
+			links = append(links, pageLink(ctx, "next", map[string]string{"offset": strconv.Itoa(params.Offset + params.Limit)}))
+		}
+	} else if next != "" {
+		response["next_cursor"] = next
+		links = append(links, pageLink(ctx, "next", map[string]string{"cursor": next}))
+	}
+
+	if len(links) > 0 {
+		ctx.Header("Link", strings.Join(links, ", "))
+	}
+}
+
+// findProductPage runs a paginated product query and writes the response,
+// including next_cursor, the optional total and a Link header. Entries in
+// extra are added to the response body as is.
+func findProductPage(ctx *gin.Context, filter bson.M, params helpers.ListParams, extra gin.H) {
+	collection := Client.Database(dbName).Collection(colName)
+
+	query := filter
+	if params.After != nil {
+		query = bson.M{"$and": []bson.M{filter, helpers.CursorFilter(params.Sort, params.After)}}
+	}
+
+	// Fetch one extra document to learn whether another page exists.
+	opts := options.Find().
+		SetSort(helpers.SortDocument(params.Sort)).
+		SetLimit(int64(params.Limit + 1))
+	if params.UseOffset {
+		opts.SetSkip(int64(params.Offset))
+	}
+	if proj := helpers.ProjectionDocument(params.Fields, params.Sort); proj != nil {
+		opts.SetProjection(proj)
+	}
+
+	cursor, err := collection.Find(context.Background(), query, opts)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to get products",
+			"error":   err.Error(),
+		})
+		return
+	}
+	defer cursor.Close(context.Background())
+
+	products := make([]model.Product, 0, params.Limit)
+	var last bson.Raw
+	hasMore := false
+	for cursor.Next(context.Background()) {
+		if len(products) == params.Limit {
+			hasMore = true
+			break
+		}
+		var product model.Product
+		if err := cursor.Decode(&product); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed to process products",
+				"error":   err.Error(),
+			})
+			return
+		}
+		products = append(products, product)
+		last = append(bson.Raw(nil), cursor.Current...)
+	}
+	if err := cursor.Err(); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to process products",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	// Variants show live stock, as on the product page
+	if err := applyListStock(products); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to load stock",
+			"error":   err.Error(),
+		})
+		return
+	}
+	items := make([]interface{}, 0, len(products))
+	for _, product := range products {
+		item, err := renderListItem(product, params.Fields)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed to process products",
+				"error":   err.Error(),
+			})
+			return
+		}
+		items = append(items, item)
+	}
+
+	response := gin.H{
+		"data": items,
+	}
+	for key, value := range extra {
+		response[key] = value
+	}
+	var next string
+	if hasMore && !params.UseOffset {
+		if next, err = helpers.EncodeCursor(params.Sort, last); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed to process products",
+				"error":   err.Error(),
+			})
+			return
+		}
+	}
+
+	if params.Count {
+		total, err := collection.CountDocuments(context.Background(), filter)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed to count products",
+				"error":   err.Error(),
+			})
+			return
+		}
+		response["total"] = total
+	}
+
+	addPageLinks(ctx, response, params, next, hasMore)
+	ctx.JSON(http.StatusOK, response)
+}
+
+// productListItem is a listed product with the option matrix of its
+// variants, if it has any.
+type productListItem struct {
+	model.Product
+	VariantMatrix *helpers.VariantMatrix `json:"variant_matrix,omitempty"`
+}
+
+// renderListItem returns the product with its variant matrix, or only the
+// requested fields when a projection was asked for.
+func renderListItem(product model.Product, fields []string) (interface{}, error) {
+	if len(fields) == 0 {
+		return productListItem{Product: product, VariantMatrix: helpers.BuildVariantMatrix(product)}, nil
+	}
+	return helpers.ProjectProduct(product, fields)
+}
+
+// pageLink builds one entry of a Link header pointing at the current URL with
+// some query parameters replaced.
+func pageLink(ctx *gin.Context, rel string, set map[string]string) string {
+	u := *ctx.Request.URL
+	q := u.Query()
+	for key, value := range set {
+		q.Set(key, value)
+	}
+	u.RawQuery = q.Encode()
+	return fmt.Sprintf(`<%s>; rel="%s"`, u.RequestURI(), rel)
+}
+
+// ensureProductIndexes creates the indexes backing the sortable fields and
+// SKU lookups.
+func ensureProductIndexes() error {
+	collection := Client.Database(dbName).Collection(colName)
+	var models []mongo.IndexModel
+	for _, path := range helpers.ProductSortFields {
+		models = append(models, mongo.IndexModel{Keys: bson.D{{Key: path, Value: 1}, {Key: "_id", Value: 1}}})
+	}
+	// SKUs are unique across products and their variants
+	sparseUnique := options.Index().SetUnique(true).SetSparse(true)
+	models = append(models,
+		mongo.IndexModel{Keys: bson.D{{Key: "sku", Value: 1}}, Options: sparseUnique},
+		mongo.IndexModel{Keys: bson.D{{Key: "variants.sku", Value: 1}}, Options: sparseUnique},
+	)
+	_, err := collection.Indexes().CreateMany(context.Background(), models)
+	return err
+}
@@ -0,0 +1,411 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joshua/casify/helpers"
+	"github.com/joshua/casify/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const paymentEventColName = "payment_events"
+
+// payments is the gateway orders are paid through, chosen by
+// PAYMENT_PROVIDER. It is nil when no provider is configured.
+var payments helpers.PaymentProvider
+
+// RequirePayments rejects requests to payment routes with 503 when no
+// payment provider is configured.
+func RequirePayments(ctx *gin.Context) {
+	if payments == nil {
+		ctx.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+			"message": "Payments are not available",
+		})
+		return
+	}
+	ctx.Next()
+}
+
+func paymentEventCollection() *mongo.Collection {
+	return Client.Database(dbName).Collection(paymentEventColName)
+}
+
+// createPaymentIntent opens the payment of an order for its total with the
+// configured provider.
+func createPaymentIntent(order *model.Order, paymentMethod string) error {
+	intent, err := payments.CreateIntent(context.Background(), helpers.IntentRequest{
+		Amount:        helpers.ToMinorUnits(order.Total),
+		Currency:      order.Currency,
+		Reference:     order.Number,
+		PaymentMethod: paymentMethod,
+	})
+	if err != nil {
+		return err
+	}
+	order.Payment = model.Payment{
+		Method:       payments.Name(),
+		IntentId:     intent.Id,
+		ClientSecret: intent.ClientSecret,
+		Status:       model.PaymentRequired,
+		Amount:       order.Total,
+		Currency:     order.Currency,
+	}
+	return nil
+}
+
+// setPaymentStatus records the payment state of a pending order.
+func setPaymentStatus(order *model.Order, status string) error {
+	_, err := orderCollection().UpdateOne(context.Background(),
+		bson.M{"_id": order.Id, "status": model.OrderPending},
+		bson.M{"$set": bson.M{"payment.status": status, "time_stamp.updated_at": time.Now()}})
+	order.Payment.Status = status
+	return err
+}
+
+// cancelOrderPayment cancels the payment intent of a cancelled order, so
+// the customer can no longer pay for it.
+func cancelOrderPayment(order *model.Order) {
+	if order.Payment.IntentId == "" || payments == nil || order.Payment.Method != payments.Name() {
+		return
+	}
+	if err := payments.CancelIntent(context.Background(), order.Payment.IntentId); err != nil {
+		log.Printf("failed to cancel the payment of order %s: %v", order.Number, err)
+	}
+}
+
+// settleCancelledPayment refunds money taken for an order that was
+// cancelled first, as the order will not ship, and describes what was
+// done.
+func settleCancelledPayment(order *model.Order) (*model.Order, string, error) {
+	if order.Payment.Status == model.PaymentRefunded {
+		return order, "ignored: payment already refunded", nil
+	}
+	if err := payments.Refund(context.Background(), order.Payment.IntentId, 0); err != nil {
+		return order, "", err
+	}
+	_, err := orderCollection().UpdateOne(context.Background(), bson.M{"_id": order.Id},
+		bson.M{"$set": bson.M{"payment.status": model.PaymentRefunded, "time_stamp.updated_at": time.Now()}})
+	if err != nil {
+		return order, "", err
+	}
+	order.Payment.Status = model.PaymentRefunded
+	log.Printf("refunded payment of cancelled order %s", order.Number)
+	return order, "refunded: order is cancelled", nil
+}
+
+// applyPaymentEvent updates the order a verified webhook event is about
+// and describes what was done. Events for unknown intents, of unhandled
+// types or that no longer apply to the order are ignored.
+func applyPaymentEvent(event helpers.PaymentEvent) (*model.Order, string, error) {
+	var order model.Order
+	err := orderCollection().FindOne(context.Background(), bson.M{
+		"payment.method":    payments.Name(),
+		"payment.intent_id": event.IntentId,
+	}).Decode(&order)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, "ignored: unknown payment intent", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	note := "Reported by " + payments.Name()
+	switch event.Type {
+	case helpers.PaymentEventAuthorized:
+		// Completed by the customer, e.g. after 3-D Secure; the hold is
+		// captured here and the order paid
+		if order.Status == model.OrderCancelled {
+			if err := payments.CancelIntent(context.Background(), order.Payment.IntentId); err != nil {
+				return &order, "", err
+			}
+			return &order, "canceled: order is cancelled", nil
+		}
+		if order.Status != model.OrderPending {
+			return &order, "ignored: order is " + order.Status, nil
+		}
+		if event.Amount != helpers.ToMinorUnits(order.Total) {
+			log.Printf("authorization of order %s was %d, expected %.2f", order.Number, event.Amount, order.Total)
+			return &order, "ignored: amount does not match the order", nil
+		}
+		var intent helpers.PaymentIntent
+		if intent, err = payments.CaptureIntent(context.Background(), order.Payment.IntentId); err != nil {
+			return &order, "", err
+		}
+		if intent.Status != helpers.IntentSucceeded {
+			return &order, "ignored: payment is " + intent.Status, nil
+		}
+		_, err = transitionOrder(&order, model.OrderPaid, order.UserId, "Captured by "+payments.Name(), nil)
+	case helpers.PaymentEventSucceeded:
+		if order.Status == model.OrderCancelled {
+			return settleCancelledPayment(&order)
+		}
+		if order.Status != model.OrderPending {
+			return &order, "ignored: order is " + order.Status, nil
+		}
+		if event.Amount != helpers.ToMinorUnits(order.Total) {
+			log.Printf("payment of order %s was %d, expected %.2f", order.Number, event.Amount, order.Total)
+			return &order, "ignored: amount does not match the order", nil
+		}
+		_, err = transitionOrder(&order, model.OrderPaid, order.UserId, note, nil)
+	case helpers.PaymentEventFailed:
+		if order.Status != model.OrderPending {
+			return &order, "ignored: order is " + order.Status, nil
+		}
+		err = setPaymentStatus(&order, model.PaymentFailed)
+	case helpers.PaymentEventRefunded:
+		if helpers.CheckOrderTransition(order.Status, model.OrderRefunded) != nil {
+			return &order, "ignored: order is " + order.Status, nil
+		}
+		if event.Amount < helpers.ToMinorUnits(order.Total) {
+			log.Printf("order %s was partly refunded: %d of %.2f", order.Number, event.Amount, order.Total)
+			return &order, "ignored: partial refund", nil
+		}
+		_, err = transitionOrder(&order, model.OrderRefunded, order.UserId, note, nil)
+	default:
+		return &order, "ignored: unhandled event type", nil
+	}
+	if errors.Is(err, errOrderChanged) && (event.Type == helpers.PaymentEventAuthorized || event.Type == helpers.PaymentEventSucceeded) {
+		// Paid while the order was being cancelled
+		if err := orderCollection().FindOne(context.Background(), bson.M{"_id": order.Id}).Decode(&order); err != nil {
+			return &order, "", err
+		}
+		if order.Status == model.OrderCancelled {
+			return settleCancelledPayment(&order)
+		}
+	}
+	if errors.Is(err, errOrderChanged) {
+		return &order, "ignored: order changed concurrently", nil
+	}
+	if err != nil {
+		return &order, "", err
+	}
+	return &order, "applied", nil
+}
+
+// paymentEventProcessing is the outcome of payment events that are being
+// applied.
+const paymentEventProcessing = "processing"
+
+// paymentEventLease is how long an event may stay in processing before a
+// redelivery takes it over, e.g. after the server crashed applying it.
+const paymentEventLease = 5 * time.Minute
+
+// claimPaymentEvent stores a payment event as being processed and reports
+// whether this request is the one to apply it. Records stuck in processing
+// for longer than paymentEventLease are taken over.
+func claimPaymentEvent(record model.PaymentEventRecord) (bool, error) {
+	_, err := paymentEventCollection().InsertOne(context.Background(), record)
+	if !mongo.IsDuplicateKeyError(err) {
+		return err == nil, err
+	}
+	result, err := paymentEventCollection().UpdateOne(context.Background(), bson.M{
+		"_id":         record.Id,
+		"outcome":     paymentEventProcessing,
+		"received_at": bson.M{"$lt": record.ReceivedAt.Add(-paymentEventLease)},
+	}, bson.M{"$set": bson.M{"received_at": record.ReceivedAt}})
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+// PaymentWebhook receives payment events from the provider. Each event is
+// applied once: its id is stored before processing, so replays are
+// acknowledged without effect, and removed again if processing fails so
+// the provider's retry is not lost. Deliveries that arrive while the event
+// is still being processed get 409, so the provider retries them in case
+// processing fails.
+func PaymentWebhook(ctx *gin.Context) {
+	payload, err := ctx.GetRawData()
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	event, err := payments.VerifyWebhook(payload, ctx.GetHeader(payments.SignatureHeader()))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid webhook",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	record := model.PaymentEventRecord{
+		Id:         payments.Name() + ":" + event.Id,
+		Type:       event.Type,
+		IntentId:   event.IntentId,
+		Outcome:    paymentEventProcessing,
+		ReceivedAt: time.Now(),
+	}
+	claimed, err := claimPaymentEvent(record)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to process event",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if !claimed {
+		var existing model.PaymentEventRecord
+		err := paymentEventCollection().FindOne(context.Background(), bson.M{"_id": record.Id}).Decode(&existing)
+		if err != nil || existing.Outcome == paymentEventProcessing {
+			// Not done yet and may still fail, so the provider must retry
+			ctx.JSON(http.StatusConflict, gin.H{
+				"message": "Event is being processed",
+			})
+			return
+		}
+		ctx.JSON(http.StatusOK, gin.H{
+			"message": "Event already processed",
+		})
+		return
+	}
+
+	order, outcome, err := applyPaymentEvent(event)
+	if err != nil {
+		if _, delErr := paymentEventCollection().DeleteOne(context.Background(), bson.M{"_id": record.Id}); delErr != nil {
+			log.Printf("failed to forget payment event %s: %v", record.Id, delErr)
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to process event",
+			"error":   err.Error(),
+		})
+		return
+	}
+	set := bson.M{"outcome": outcome}
+	if order != nil {
+		set["order_id"] = order.Id
+	}
+	if _, err := paymentEventCollection().UpdateOne(context.Background(), bson.M{"_id": record.Id}, bson.M{"$set": set}); err != nil {
+		log.Printf("failed to record the outcome of payment event %s: %v", record.Id, err)
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": outcome,
+	})
+}
+
+// orderPaymentMargin is how long before it expires an order can no longer
+// be paid, so a payment cannot be taken while the order is being swept.
+const orderPaymentMargin = 30 * time.Second
+
+// PayOrder confirms the payment of one of the signed in user's pending
+// orders, with "payment_method" when the client collected one. Authorized
+// payments are captured straight away and the order marked paid. When the
+// provider needs the customer to act, the client secret is returned and
+// the order is updated by webhook later.
+func PayOrder(ctx *gin.Context) {
+	var input struct {
+		PaymentMethod string `json:"payment_method"`
+	}
+	if body, _ := ctx.GetRawData(); len(body) > 0 {
+		if err := json.Unmarshal(body, &input); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": invalidBody,
+				"error":   err.Error(),
+			})
+			return
+		}
+	}
+	order, ok := findOrder(ctx)
+	if !ok {
+		return
+	}
+	if order.Status != model.OrderPending {
+		respondOrderError(ctx, &helpers.TransitionError{From: order.Status, To: model.OrderPaid}, "Failed to pay order")
+		return
+	}
+	// Orders whose reservation ran out, or is about to, are cancelled by the
+	// sweeper and must not be charged
+	if time.Now().Add(orderPaymentMargin).After(order.ExpiresAt) {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"message": "Order expired, check out again",
+			"data":    order,
+		})
+		return
+	}
+
+	intent, err := payments.ConfirmIntent(ctx.Request.Context(), order.Payment.IntentId, strings.TrimSpace(input.PaymentMethod))
+	if err == nil && intent.Status == helpers.IntentRequiresCapture {
+		intent, err = payments.CaptureIntent(ctx.Request.Context(), order.Payment.IntentId)
+	}
+	if errors.Is(err, helpers.ErrPaymentDeclined) {
+		if err := setPaymentStatus(order, model.PaymentFailed); err != nil {
+			log.Printf("failed to record declined payment of order %s: %v", order.Number, err)
+		}
+		ctx.JSON(http.StatusPaymentRequired, gin.H{
+			"message": "Payment declined",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{
+			"message": "Payment failed",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if intent.Status != helpers.IntentSucceeded {
+		ctx.JSON(http.StatusAccepted, gin.H{
+			"message":       "Payment needs to be completed by the customer",
+			"status":        intent.Status,
+			"client_secret": intent.ClientSecret,
+		})
+		return
+	}
+	saved, err := transitionOrder(order, model.OrderPaid, requestActor(ctx), "Paid with "+payments.Name(), nil)
+	if errors.Is(err, errOrderChanged) {
+		// Either the provider's webhook got there first or the order was
+		// cancelled meanwhile, and the payment must be returned
+		saved, ok = findOrder(ctx)
+		if !ok {
+			return
+		}
+		switch {
+		case saved.Payment.Status == model.PaymentSucceeded:
+			err = nil
+		case saved.Status == model.OrderCancelled:
+			if _, _, err := settleCancelledPayment(saved); err != nil {
+				log.Printf("failed to refund payment of cancelled order %s: %v", saved.Number, err)
+			}
+			ctx.JSON(http.StatusConflict, gin.H{
+				"message": "Order was cancelled, the payment is refunded",
+				"data":    saved,
+			})
+			return
+		}
+	}
+	if err != nil {
+		respondOrderError(ctx, err, "Failed to pay order")
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Order paid",
+		"data":    saved,
+	})
+}
+
+// refundOrderPayment returns the payment of an order through the provider
+// before the order is marked refunded.
+func refundOrderPayment(ctx context.Context, order *model.Order) error {
+	if order.Payment.Status != model.PaymentSucceeded {
+		return nil
+	}
+	if payments == nil || order.Payment.Method != payments.Name() {
+		return fmt.Errorf("order %s was paid with %s, which is not configured", order.Number, order.Payment.Method)
+	}
+	return payments.Refund(ctx, order.Payment.IntentId, 0)
+}
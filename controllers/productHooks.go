@@ -0,0 +1,34 @@
+package controllers
+
+import (
+	"github.com/joshua/casify/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// afterProductsSaved runs after products are inserted or updated so data
+// derived from the catalog stays in sync with the collection.
+func afterProductsSaved(products ...model.Product) {
+	productFeeds.invalidate()
+	seedInventory(products...)
+	for _, p := range products {
+		productIndex.Upsert(p)
+		indexProductSuggestions(p)
+	}
+	checkPriceDrops(products...)
+}
+
+// afterProductsDeleted runs after products are removed by id.
+func afterProductsDeleted(ids ...primitive.ObjectID) {
+	productFeeds.invalidate()
+	for _, id := range ids {
+		productIndex.Remove(id)
+		suggestIndex.RemoveSource(productSuggestSource + id.Hex())
+	}
+}
+
+// afterProductsCleared runs after the whole product collection is emptied.
+func afterProductsCleared() {
+	productFeeds.invalidate()
+	productIndex.Clear()
+	suggestIndex.RemoveSourcesWithPrefix(productSuggestSource)
+}
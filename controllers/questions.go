@@ -0,0 +1,439 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joshua/casify/helpers"
+	"github.com/joshua/casify/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	questionColName = "questions"
+	answerColName   = "answers"
+)
+
+const (
+	questionNotFound = "Question not found"
+	answerNotFound   = "Answer not found"
+)
+
+// questionSorts maps the "sort" parameter of question listings to a sort
+// order.
+var questionSorts = map[string][]helpers.SortField{
+	"newest":        helpers.FixedSort("-time_stamp.created_at"),
+	"oldest":        helpers.FixedSort("time_stamp.created_at"),
+	"most_answered": helpers.FixedSort("-answer_count,-time_stamp.created_at"),
+}
+
+func questionCollection() *mongo.Collection {
+	return Client.Database(dbName).Collection(questionColName)
+}
+
+func answerCollection() *mongo.Collection {
+	return Client.Database(dbName).Collection(answerColName)
+}
+
+// ensureQuestionIndexes backs the question and answer listings and the
+// moderation queues.
+func ensureQuestionIndexes() error {
+	_, err := questionCollection().Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "product_id", Value: 1}, {Key: "status", Value: 1}, {Key: "time_stamp.created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "time_stamp.created_at", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "time_stamp.created_at", Value: -1}}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = answerCollection().Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "question_id", Value: 1}, {Key: "status", Value: 1}, {Key: "upvotes", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "time_stamp.created_at", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "time_stamp.created_at", Value: -1}}},
+	})
+	return err
+}
+
+func respondQuestionError(ctx *gin.Context, err error, notFound, message string) {
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": notFound,
+			"error":   err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusInternalServerError, gin.H{
+		"message": message,
+		"error":   err.Error(),
+	})
+}
+
+func bindPost(ctx *gin.Context, validate func(helpers.PostInput) error) (helpers.PostInput, bool) {
+	var input helpers.PostInput
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return input, false
+	}
+	input.Body = strings.TrimSpace(input.Body)
+	if err := validate(input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return input, false
+	}
+	return input, true
+}
+
+// screenPost runs the automated screen on a question or answer by user.
+// duplicate matches earlier posts in collection with the same text.
+func screenPost(collection *mongo.Collection, userId primitive.ObjectID, body string, duplicate bson.M) ([]string, error) {
+	recent, err := collection.CountDocuments(context.Background(), bson.M{
+		"user_id":               userId,
+		"time_stamp.created_at": bson.M{"$gte": time.Now().Add(-time.Hour)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	copies, err := collection.CountDocuments(context.Background(), duplicate, options.Count().SetLimit(1))
+	if err != nil {
+		return nil, err
+	}
+	return helpers.ScreenText(body, int(recent), copies > 0, helpers.ScreenConfigFromEnv()), nil
+}
+
+// refreshAnswerCount recomputes the number of approved answers to a
+// question.
+func refreshAnswerCount(questionId primitive.ObjectID) error {
+	count, err := answerCollection().CountDocuments(context.Background(),
+		bson.M{"question_id": questionId, "status": model.ReviewApproved})
+	if err != nil {
+		return err
+	}
+	_, err = questionCollection().UpdateOne(context.Background(),
+		bson.M{"_id": questionId},
+		bson.M{"$set": bson.M{"answer_count": count}})
+	return err
+}
+
+// attachAnswers loads the approved answers of questions, most upvoted
+// first.
+func attachAnswers(questions []model.Question) error {
+	if len(questions) == 0 {
+		return nil
+	}
+	ids := make([]primitive.ObjectID, len(questions))
+	for i, q := range questions {
+		ids[i] = q.Id
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "upvotes", Value: -1}, {Key: "time_stamp.created_at", Value: 1}})
+	cursor, err := answerCollection().Find(context.Background(),
+		bson.M{"question_id": bson.M{"$in": ids}, "status": model.ReviewApproved}, opts)
+	if err != nil {
+		return err
+	}
+	var answers []model.Answer
+	if err := cursor.All(context.Background(), &answers); err != nil {
+		return err
+	}
+	byQuestion := map[primitive.ObjectID][]model.Answer{}
+	for _, a := range answers {
+		byQuestion[a.QuestionId] = append(byQuestion[a.QuestionId], a)
+	}
+	for i := range questions {
+		questions[i].Answers = byQuestion[questions[i].Id]
+	}
+	return nil
+}
+
+// GetProductQuestions lists the approved questions of a live product with
+// their approved answers. "sort" is newest (default), oldest or
+// most_answered, and pages follow "cursor".
+func GetProductQuestions(ctx *gin.Context) {
+	product, err := findLiveProductById(ctx)
+	if err != nil {
+		handleProductError(ctx, err)
+		return
+	}
+	sort, ok := questionSorts[ctx.DefaultQuery("sort", "newest")]
+	if !ok {
+		respondQueryError(ctx, &queryError{message: "Invalid sort", err: fmt.Errorf("sort must be newest, oldest or most_answered")})
+		return
+	}
+	params, err := parsePageParams(ctx, sort, false)
+	if err != nil {
+		respondQueryError(ctx, err)
+		return
+	}
+
+	filter := bson.M{"product_id": product.Id, "status": model.ReviewApproved}
+	total, err := questionCollection().CountDocuments(context.Background(), filter)
+	if err != nil {
+		respondQuestionError(ctx, err, questionNotFound, "Failed to get questions")
+		return
+	}
+	questions := []model.Question{}
+	next, hasMore, err := findPage(questionCollection(), filter, params, &questions)
+	if err != nil {
+		respondQuestionError(ctx, err, questionNotFound, "Failed to get questions")
+		return
+	}
+	if err := attachAnswers(questions); err != nil {
+		respondQuestionError(ctx, err, questionNotFound, "Failed to get questions")
+		return
+	}
+	response := gin.H{
+		"data":  questions,
+		"total": total,
+	}
+	addPageLinks(ctx, response, params, next, hasMore)
+	ctx.JSON(http.StatusOK, response)
+}
+
+// AddQuestion lets the signed in user ask about a live product. Questions
+// are screened like reviews.
+func AddQuestion(ctx *gin.Context) {
+	product, err := findLiveProductById(ctx)
+	if err != nil {
+		handleProductError(ctx, err)
+		return
+	}
+	input, ok := bindPost(ctx, helpers.ValidateQuestion)
+	if !ok {
+		return
+	}
+	user, _ := currentUser(ctx)
+	flags, err := screenPost(questionCollection(), user.Id, input.Body, bson.M{
+		"product_id": product.Id,
+		"user_id":    user.Id,
+		"body":       input.Body,
+	})
+	if err != nil {
+		respondQuestionError(ctx, err, questionNotFound, "Failed to add question")
+		return
+	}
+
+	question := model.Question{
+		Id:        primitive.NewObjectID(),
+		ProductId: product.Id,
+		UserId:    user.Id,
+		Body:      input.Body,
+		Status:    helpers.ScreenedStatus(flags),
+		Flags:     flags,
+	}
+	question.TimeStamp.CreatedAt = time.Now()
+	question.TimeStamp.UpdatedAt = time.Now()
+	if _, err := questionCollection().InsertOne(context.Background(), question); err != nil {
+		respondQuestionError(ctx, err, questionNotFound, "Failed to add question")
+		return
+	}
+	message := "Question added successfully"
+	if question.Status == model.ReviewPending {
+		message = "Question submitted for moderation"
+	}
+	ctx.JSON(http.StatusCreated, gin.H{
+		"message": message,
+		"data":    question,
+	})
+}
+
+// AddAnswer answers an approved question. Staff answers are published
+// straight away; customers must have bought the product and are screened.
+func AddAnswer(ctx *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	var question model.Question
+	err = questionCollection().FindOne(context.Background(), bson.M{"_id": id, "status": model.ReviewApproved}).Decode(&question)
+	if err != nil {
+		respondQuestionError(ctx, err, questionNotFound, "Failed to add answer")
+		return
+	}
+	input, ok := bindPost(ctx, helpers.ValidateAnswer)
+	if !ok {
+		return
+	}
+
+	user, _ := currentUser(ctx)
+	answer := model.Answer{
+		Id:         primitive.NewObjectID(),
+		QuestionId: question.Id,
+		ProductId:  question.ProductId,
+		UserId:     user.Id,
+		Body:       input.Body,
+		Staff:      user.Role == model.RoleAdmin,
+		Status:     model.ReviewApproved,
+	}
+	if !answer.Staff {
+		verified, err := hasPurchasedProduct(user.Id, question.ProductId)
+		if err != nil {
+			respondQuestionError(ctx, err, questionNotFound, "Failed to add answer")
+			return
+		}
+		if !verified {
+			ctx.JSON(http.StatusForbidden, gin.H{
+				"message": "Only staff and customers who bought this product can answer",
+			})
+			return
+		}
+		answer.VerifiedPurchase = true
+		answer.Flags, err = screenPost(answerCollection(), user.Id, input.Body, bson.M{
+			"question_id": question.Id,
+			"body":        input.Body,
+		})
+		if err != nil {
+			respondQuestionError(ctx, err, answerNotFound, "Failed to add answer")
+			return
+		}
+		answer.Status = helpers.ScreenedStatus(answer.Flags)
+	}
+	answer.TimeStamp.CreatedAt = time.Now()
+	answer.TimeStamp.UpdatedAt = time.Now()
+	if _, err := answerCollection().InsertOne(context.Background(), answer); err != nil {
+		respondQuestionError(ctx, err, answerNotFound, "Failed to add answer")
+		return
+	}
+	if err := refreshAnswerCount(question.Id); err != nil {
+		log.Printf("failed to count the answers of question %s: %v", question.Id.Hex(), err)
+	}
+	message := "Answer added successfully"
+	if answer.Status == model.ReviewPending {
+		message = "Answer submitted for moderation"
+	}
+	ctx.JSON(http.StatusCreated, gin.H{
+		"message": message,
+		"data":    answer,
+	})
+}
+
+// UpvoteAnswer counts the signed in user's vote for an approved answer.
+// Each user votes once and not for their own answers.
+func UpvoteAnswer(ctx *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	user, _ := currentUser(ctx)
+	var answer model.Answer
+	err = answerCollection().FindOneAndUpdate(context.Background(),
+		bson.M{"_id": id, "status": model.ReviewApproved, "user_id": bson.M{"$ne": user.Id}, "voters": bson.M{"$ne": user.Id}},
+		bson.M{"$addToSet": bson.M{"voters": user.Id}, "$inc": bson.M{"upvotes": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&answer)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// Tell a repeated or own vote apart from a missing answer
+		err = answerCollection().FindOne(context.Background(), bson.M{"_id": id, "status": model.ReviewApproved}).Decode(&answer)
+		if err == nil {
+			message := "You have already upvoted this answer"
+			if answer.UserId == user.Id {
+				message = "You cannot upvote your own answer"
+			}
+			ctx.JSON(http.StatusConflict, gin.H{
+				"message": message,
+			})
+			return
+		}
+	}
+	if err != nil {
+		respondQuestionError(ctx, err, answerNotFound, "Failed to upvote answer")
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Answer upvoted",
+		"data":    answer,
+	})
+}
+
+// checkPostOwner reports whether the signed in user may remove a post by
+// author. Admins may remove any.
+func checkPostOwner(ctx *gin.Context, author primitive.ObjectID) bool {
+	user, _ := currentUser(ctx)
+	if author != user.Id && user.Role != model.RoleAdmin {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"message": "You can only remove your own posts",
+		})
+		return false
+	}
+	return true
+}
+
+// DeleteQuestion removes a question and its answers.
+func DeleteQuestion(ctx *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	var question model.Question
+	if err := questionCollection().FindOne(context.Background(), bson.M{"_id": id}).Decode(&question); err != nil {
+		respondQuestionError(ctx, err, questionNotFound, "Failed to delete question")
+		return
+	}
+	if !checkPostOwner(ctx, question.UserId) {
+		return
+	}
+	if _, err := questionCollection().DeleteOne(context.Background(), bson.M{"_id": id}); err != nil {
+		respondQuestionError(ctx, err, questionNotFound, "Failed to delete question")
+		return
+	}
+	if _, err := answerCollection().DeleteMany(context.Background(), bson.M{"question_id": id}); err != nil {
+		respondQuestionError(ctx, err, answerNotFound, "Failed to delete question")
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Question deleted successfully",
+	})
+}
+
+// DeleteAnswer removes an answer.
+func DeleteAnswer(ctx *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	var answer model.Answer
+	if err := answerCollection().FindOne(context.Background(), bson.M{"_id": id}).Decode(&answer); err != nil {
+		respondQuestionError(ctx, err, answerNotFound, "Failed to delete answer")
+		return
+	}
+	if !checkPostOwner(ctx, answer.UserId) {
+		return
+	}
+	if _, err := answerCollection().DeleteOne(context.Background(), bson.M{"_id": id}); err != nil {
+		respondQuestionError(ctx, err, answerNotFound, "Failed to delete answer")
+		return
+	}
+	if err := refreshAnswerCount(answer.QuestionId); err != nil {
+		log.Printf("failed to count the answers of question %s: %v", answer.QuestionId.Hex(), err)
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Answer deleted successfully",
+	})
+}
@@ -0,0 +1,399 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joshua/casify/helpers"
+	"github.com/joshua/casify/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const reviewColName = "reviews"
+
+const (
+	reviewNotFound = "Review not found"
+	reviewExists   = "You have already reviewed this product"
+)
+
+// reviewSorts maps the "sort" parameter of review listings to a sort order.
+var reviewSorts = map[string][]helpers.SortField{
+	"newest":  helpers.FixedSort("-time_stamp.created_at"),
+	"oldest":  helpers.FixedSort("time_stamp.created_at"),
+	"highest": helpers.FixedSort("-rating,-time_stamp.created_at"),
+	"lowest":  helpers.FixedSort("rating,-time_stamp.created_at"),
+}
+
+func reviewCollection() *mongo.Collection {
+	return Client.Database(dbName).Collection(reviewColName)
+}
+
+// ensureReviewIndexes allows one review per user and product and backs the
+// review listings and the automated screen.
+func ensureReviewIndexes() error {
+	_, err := reviewCollection().Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "product_id", Value: 1}, {Key: "user_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "product_id", Value: 1}, {Key: "time_stamp.created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "time_stamp.created_at", Value: 1}}},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "time_stamp.created_at", Value: -1}}},
+		{Keys: bson.D{{Key: "fingerprint", Value: 1}}},
+	})
+	return err
+}
+
+// refreshReviewAggregate recomputes the rating, review count and rating
+// distribution of a product from its approved reviews.
+func refreshReviewAggregate(productId primitive.ObjectID) error {
+	cursor, err := reviewCollection().Aggregate(context.Background(), mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"product_id": productId, "status": model.ReviewApproved}}},
+		{{Key: "$group", Value: bson.M{"_id": "$rating", "count": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return err
+	}
+	var groups []struct {
+		Rating int `bson:"_id"`
+		Count  int `bson:"count"`
+	}
+	if err := cursor.All(context.Background(), &groups); err != nil {
+		return err
+	}
+	stars := map[int]int{}
+	for _, g := range groups {
+		stars[g.Rating] = g.Count
+	}
+	summary := helpers.SummarizeRatings(stars)
+
+	var product model.Product
+	err = Client.Database(dbName).Collection(colName).FindOneAndUpdate(context.Background(),
+		bson.M{"_id": productId},
+		bson.M{"$set": bson.M{
+			"rating":              summary.Average,
+			"review_count":        summary.Count,
+			"rating_distribution": summary.Distribution,
+		}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&product)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// Reviews of deleted products are kept in case the product is restored
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	afterProductsSaved(product)
+	return nil
+}
+
+// backfillReviewAggregates replaces the client supplied ratings of products
+// created before reviews existed with the aggregate of their reviews.
+func backfillReviewAggregates() error {
+	collection := Client.Database(dbName).Collection(colName)
+	cursor, err := collection.Find(context.Background(),
+		bson.M{"review_count": bson.M{"$exists": false}},
+		options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	var products []model.Product
+	if err := cursor.All(context.Background(), &products); err != nil {
+		return err
+	}
+	for _, p := range products {
+		if err := refreshReviewAggregate(p.Id); err != nil {
+			return err
+		}
+	}
+	if len(products) > 0 {
+		log.Printf("computed ratings of %d products from their reviews", len(products))
+	}
+	return nil
+}
+
+// backfillReviewStatus approves the reviews posted before moderation
+// existed, which were already public.
+func backfillReviewStatus() error {
+	result, err := reviewCollection().UpdateMany(context.Background(),
+		bson.M{"status": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"status": model.ReviewApproved}})
+	if err != nil {
+		return err
+	}
+	if result.ModifiedCount > 0 {
+		log.Printf("approved %d existing reviews", result.ModifiedCount)
+	}
+	return nil
+}
+
+// screenReview runs the automated screen on a review by user. except is the
+// review being edited, left out of the duplicate and rate checks. It returns
+// the flags raised and the fingerprint of the text.
+func screenReview(userId primitive.ObjectID, input helpers.ReviewInput, except primitive.ObjectID) ([]string, string, error) {
+	fingerprint := helpers.ReviewFingerprint(input)
+	others := bson.M{"$ne": except}
+
+	recent, err := reviewCollection().CountDocuments(context.Background(), bson.M{
+		"_id":                   others,
+		"user_id":               userId,
+		"time_stamp.created_at": bson.M{"$gte": time.Now().Add(-time.Hour)},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	duplicate := false
+	if fingerprint != "" {
+		err := reviewCollection().FindOne(context.Background(),
+			bson.M{"_id": others, "fingerprint": fingerprint},
+			options.FindOne().SetProjection(bson.M{"_id": 1})).Err()
+		switch {
+		case err == nil:
+			duplicate = true
+		case !errors.Is(err, mongo.ErrNoDocuments):
+			return nil, "", err
+		}
+	}
+	flags := helpers.ScreenReview(input, int(recent), duplicate, helpers.ScreenConfigFromEnv())
+	return flags, fingerprint, nil
+}
+
+// reviewSavedMessage tells the author whether their review is live.
+func reviewSavedMessage(review model.Review, saved string) string {
+	if review.Status == model.ReviewPending {
+		return "Review submitted for moderation"
+	}
+	return saved
+}
+
+// findOwnReview loads the review named in the path and checks the signed in
+// user wrote it. Admins may act on any review.
+func findOwnReview(ctx *gin.Context) (*model.Review, bool) {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return nil, false
+	}
+	var review model.Review
+	if err := reviewCollection().FindOne(context.Background(), bson.M{"_id": id}).Decode(&review); err != nil {
+		respondReviewError(ctx, err, "Failed to get review")
+		return nil, false
+	}
+	user, _ := currentUser(ctx)
+	if review.UserId != user.Id && user.Role != model.RoleAdmin {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"message": "You can only change your own reviews",
+		})
+		return nil, false
+	}
+	return &review, true
+}
+
+func respondReviewError(ctx *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": reviewNotFound,
+			"error":   err.Error(),
+		})
+	case mongo.IsDuplicateKeyError(err):
+		ctx.JSON(http.StatusConflict, gin.H{
+			"message": reviewExists,
+			"error":   err.Error(),
+		})
+	default:
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": message,
+			"error":   err.Error(),
+		})
+	}
+}
+
+func bindReview(ctx *gin.Context) (helpers.ReviewInput, bool) {
+	var input helpers.ReviewInput
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return input, false
+	}
+	input = input.Trimmed()
+	if err := helpers.ValidateReview(input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return input, false
+	}
+	return input, true
+}
+
+// GetProductReviews lists the approved reviews of a live product with its
+// rating summary. "sort" is newest (default), oldest, highest or lowest,
+// and pages follow "cursor".
+func GetProductReviews(ctx *gin.Context) {
+	product, err := findLiveProductById(ctx)
+	if err != nil {
+		handleProductError(ctx, err)
+		return
+	}
+	sort, ok := reviewSorts[ctx.DefaultQuery("sort", "newest")]
+	if !ok {
+		respondQueryError(ctx, &queryError{message: "Invalid sort", err: fmt.Errorf("sort must be newest, oldest, highest or lowest")})
+		return
+	}
+	params, err := parsePageParams(ctx, sort, false)
+	if err != nil {
+		respondQueryError(ctx, err)
+		return
+	}
+
+	filter := bson.M{"product_id": product.Id, "status": model.ReviewApproved}
+	total, err := reviewCollection().CountDocuments(context.Background(), filter)
+	if err != nil {
+		respondReviewError(ctx, err, "Failed to get reviews")
+		return
+	}
+	reviews := []model.Review{}
+	next, hasMore, err := findPage(reviewCollection(), filter, params, &reviews)
+	if err != nil {
+		respondReviewError(ctx, err, "Failed to get reviews")
+		return
+	}
+	response := gin.H{
+		"data":    reviews,
+		"summary": helpers.ProductRatingSummary(*product),
+		"total":   total,
+	}
+	addPageLinks(ctx, response, params, next, hasMore)
+	ctx.JSON(http.StatusOK, response)
+}
+
+// AddReview lets the signed in user review a live product once. Reviews
+// that pass the automated screen are published straight away, the others
+// wait in the moderation queue.
+func AddReview(ctx *gin.Context) {
+	product, err := findLiveProductById(ctx)
+	if err != nil {
+		handleProductError(ctx, err)
+		return
+	}
+	input, ok := bindReview(ctx)
+	if !ok {
+		return
+	}
+	user, _ := currentUser(ctx)
+	verified, err := hasPurchasedProduct(user.Id, product.Id)
+	if err != nil {
+		respondReviewError(ctx, err, "Failed to add review")
+		return
+	}
+	flags, fingerprint, err := screenReview(user.Id, input, primitive.NilObjectID)
+	if err != nil {
+		respondReviewError(ctx, err, "Failed to add review")
+		return
+	}
+
+	review := model.Review{
+		Id:               primitive.NewObjectID(),
+		ProductId:        product.Id,
+		UserId:           user.Id,
+		Rating:           input.Rating,
+		Title:            input.Title,
+		Body:             input.Body,
+		Photos:           input.Photos,
+		VerifiedPurchase: verified,
+		Status:           helpers.ScreenedStatus(flags),
+		Flags:            flags,
+		Fingerprint:      fingerprint,
+	}
+	review.TimeStamp.CreatedAt = time.Now()
+	review.TimeStamp.UpdatedAt = time.Now()
+	if _, err := reviewCollection().InsertOne(context.Background(), review); err != nil {
+		respondReviewError(ctx, err, "Failed to add review")
+		return
+	}
+	if err := refreshReviewAggregate(product.Id); err != nil {
+		log.Printf("failed to update the rating of product %s: %v", product.Id.Hex(), err)
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"message": reviewSavedMessage(review, "Review added successfully"),
+		"data":    review,
+	})
+}
+
+// UpdateReview replaces the rating and text of a review. The new text is
+// screened again, so an edit can send an approved review back to the queue.
+func UpdateReview(ctx *gin.Context) {
+	review, ok := findOwnReview(ctx)
+	if !ok {
+		return
+	}
+	input, ok := bindReview(ctx)
+	if !ok {
+		return
+	}
+
+	flags, fingerprint, err := screenReview(review.UserId, input, review.Id)
+	if err != nil {
+		respondReviewError(ctx, err, "Failed to update review")
+		return
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"rating":                input.Rating,
+			"title":                 input.Title,
+			"body":                  input.Body,
+			"photos":                input.Photos,
+			"status":                helpers.ScreenedStatus(flags),
+			"flags":                 flags,
+			"fingerprint":           fingerprint,
+			"time_stamp.updated_at": time.Now(),
+		},
+		"$unset": bson.M{"rejection_reason": "", "moderated_by": "", "moderated_at": ""},
+	}
+	var saved model.Review
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if err := reviewCollection().FindOneAndUpdate(context.Background(), bson.M{"_id": review.Id}, update, opts).Decode(&saved); err != nil {
+		respondReviewError(ctx, err, "Failed to update review")
+		return
+	}
+	if err := refreshReviewAggregate(review.ProductId); err != nil {
+		log.Printf("failed to update the rating of product %s: %v", review.ProductId.Hex(), err)
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": reviewSavedMessage(saved, "Review updated successfully"),
+		"data":    saved,
+	})
+}
+
+// DeleteReview removes a review.
+func DeleteReview(ctx *gin.Context) {
+	review, ok := findOwnReview(ctx)
+	if !ok {
+		return
+	}
+	if _, err := reviewCollection().DeleteOne(context.Background(), bson.M{"_id": review.Id}); err != nil {
+		respondReviewError(ctx, err, "Failed to delete review")
+		return
+	}
+	if err := refreshReviewAggregate(review.ProductId); err != nil {
+		log.Printf("failed to update the rating of product %s: %v", review.ProductId.Hex(), err)
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Review deleted successfully",
+	})
+}
@@ -0,0 +1,390 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joshua/casify/helpers"
+	"github.com/joshua/casify/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const revisionColName = "product_revisions"
+
+const revisionNotFound = "Revision not found"
+
+func revisionCollection() *mongo.Collection {
+	return Client.Database(dbName).Collection(revisionColName)
+}
+
+// ensureRevisionIndexes keeps one revision per product and version.
+func ensureRevisionIndexes() error {
+	_, err := revisionCollection().Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "product_id", Value: 1}, {Key: "version", Value: -1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// latestRevision returns the newest revision of a product, or nil when it
+// has none yet.
+func latestRevision(productId primitive.ObjectID) (*model.ProductRevision, error) {
+	var revision model.ProductRevision
+	err := revisionCollection().FindOne(context.Background(),
+		bson.M{"product_id": productId},
+		options.FindOne().SetSort(bson.D{{Key: "version", Value: -1}})).Decode(&revision)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
+
+// findRevision loads one version of a product.
+func findRevision(productId primitive.ObjectID, version int) (*model.ProductRevision, error) {
+	var revision model.ProductRevision
+	err := revisionCollection().FindOne(context.Background(),
+		bson.M{"product_id": productId, "version": version}).Decode(&revision)
+	if err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
+
+// revisionAttempts bounds how often appendRevision retries when concurrent
+// writes to a product claim the same version.
+const revisionAttempts = 5
+
+// appendRevision stores the next version of a product, diffed against the
+// previous one. Products written before revisions existed start from an
+// empty product. The unique index on product and version decides races;
+// the loser diffs against the winner and takes the version after it.
+func appendRevision(revision model.ProductRevision) (*model.ProductRevision, error) {
+	for attempt := 1; ; attempt++ {
+		saved, err := insertNextRevision(revision)
+		if mongo.IsDuplicateKeyError(err) && attempt < revisionAttempts {
+			continue
+		}
+		return saved, err
+	}
+}
+
+func insertNextRevision(revision model.ProductRevision) (*model.ProductRevision, error) {
+	previous, err := latestRevision(revision.ProductId)
+	if err != nil {
+		return nil, err
+	}
+	revision.Version = 1
+	before := model.Product{}
+	if previous != nil {
+		revision.Version = previous.Version + 1
+		if previous.Action != model.RevisionDelete {
+			before = previous.Snapshot
+		}
+	}
+	if revision.Action == model.RevisionDelete {
+		revision.Changes = []model.FieldChange{}
+	} else {
+		revision.Changes = helpers.DiffProducts(before, revision.Snapshot)
+	}
+	revision.Id = primitive.NewObjectID()
+	revision.CreatedAt = time.Now()
+	if _, err := revisionCollection().InsertOne(context.Background(), revision); err != nil {
+		return nil, err
+	}
+	return &revision, nil
+}
+
+// recordRevisions stores a revision of each product written by actor. A
+// failure does not undo the write, so it is only logged.
+func recordRevisions(actor primitive.ObjectID, action string, products ...model.Product) {
+	for _, p := range products {
+		revision := model.ProductRevision{ProductId: p.Id, Action: action, Snapshot: p, Actor: actor}
+		if _, err := appendRevision(revision); err != nil {
+			log.Printf("failed to record %s revision of product %s: %v", action, p.Id.Hex(), err)
+		}
+	}
+}
+
+// requestActor is the signed in user making a request, or the zero id for
+// anonymous requests and background jobs.
+func requestActor(ctx *gin.Context) primitive.ObjectID {
+	user, _ := currentUser(ctx)
+	return user.Id
+}
+
+// parseRevisionParams reads the product id and, when present, the version
+// from the path.
+func parseRevisionParams(ctx *gin.Context) (primitive.ObjectID, int, bool) {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return id, 0, false
+	}
+	raw := ctx.Param("version")
+	if raw == "" {
+		return id, 0, true
+	}
+	version, err := strconv.Atoi(raw)
+	if err != nil || version < 1 {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   "version must be a positive integer",
+		})
+		return id, 0, false
+	}
+	return id, version, true
+}
+
+func respondRevisionError(ctx *gin.Context, err error) {
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": revisionNotFound,
+			"error":   err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusInternalServerError, gin.H{
+		"message": "Failed to get revisions",
+		"error":   err.Error(),
+	})
+}
+
+// GetProductRevisions lists the revisions of a product, newest first. It
+// also works for deleted products.
+func GetProductRevisions(ctx *gin.Context) {
+	id, _, ok := parseRevisionParams(ctx)
+	if !ok {
+		return
+	}
+	params, err := parsePageParams(ctx, helpers.FixedSort("-version"), true)
+	if err != nil {
+		respondQueryError(ctx, err)
+		return
+	}
+
+	filter := bson.M{"product_id": id}
+	total, err := revisionCollection().CountDocuments(context.Background(), filter)
+	if err != nil {
+		respondRevisionError(ctx, err)
+		return
+	}
+	revisions := []model.ProductRevision{}
+	next, hasMore, err := findPage(revisionCollection(), filter, params, &revisions)
+	if err != nil {
+		respondRevisionError(ctx, err)
+		return
+	}
+	response := gin.H{
+		"data":  revisions,
+		"total": total,
+	}
+	addPageLinks(ctx, response, params, next, hasMore)
+	ctx.JSON(http.StatusOK, response)
+}
+
+// GetProductRevision returns one revision with its full snapshot.
+func GetProductRevision(ctx *gin.Context) {
+	id, version, ok := parseRevisionParams(ctx)
+	if !ok {
+		return
+	}
+	revision, err := findRevision(id, version)
+	if err != nil {
+		respondRevisionError(ctx, err)
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"data": revision,
+	})
+}
+
+// DiffProductRevisions compares two revisions of a product. "from" and "to"
+// are versions; "to" defaults to the latest one.
+func DiffProductRevisions(ctx *gin.Context) {
+	id, _, ok := parseRevisionParams(ctx)
+	if !ok {
+		return
+	}
+	from, err := strconv.Atoi(ctx.Query("from"))
+	if err != nil || from < 1 {
+		respondQueryError(ctx, &queryError{message: "Invalid from", err: fmt.Errorf("from must be a positive integer")})
+		return
+	}
+
+	var target *model.ProductRevision
+	if raw := ctx.Query("to"); raw != "" {
+		to, err := strconv.Atoi(raw)
+		if err != nil || to < 1 {
+			respondQueryError(ctx, &queryError{message: "Invalid to", err: fmt.Errorf("to must be a positive integer")})
+			return
+		}
+		if target, err = findRevision(id, to); err != nil {
+			respondRevisionError(ctx, err)
+			return
+		}
+	} else {
+		if target, err = latestRevision(id); err != nil {
+			respondRevisionError(ctx, err)
+			return
+		}
+		if target == nil {
+			respondRevisionError(ctx, mongo.ErrNoDocuments)
+			return
+		}
+	}
+	source, err := findRevision(id, from)
+	if err != nil {
+		respondRevisionError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"data": gin.H{
+			"from":    source.Version,
+			"to":      target.Version,
+			"changes": helpers.DiffProducts(source.Snapshot, target.Snapshot),
+		},
+	})
+}
+
+// RollbackProduct restores the content of a revision as a new version. The
+// product keeps its current lifecycle status, and a deleted product is
+// brought back as a draft.
+func RollbackProduct(ctx *gin.Context) {
+	id, version, ok := parseRevisionParams(ctx)
+	if !ok {
+		return
+	}
+	revision, err := findRevision(id, version)
+	if err != nil {
+		respondRevisionError(ctx, err)
+		return
+	}
+	if revision.Action == model.RevisionDelete {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   "cannot roll back to a deletion, pick an earlier version",
+		})
+		return
+	}
+
+	restored := revision.Snapshot
+	restored.Id = id
+	restored.Status = model.ProductDraft
+	restored.PublishAt, restored.UnpublishAt = nil, nil
+	restored.PreviousSlugs = nil
+
+	collection := Client.Database(dbName).Collection(colName)
+	var current model.Product
+	err = collection.FindOne(context.Background(), bson.M{"_id": id}).Decode(&current)
+	exists := err == nil
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to get product",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if exists {
+		restored.Status = current.Status
+		restored.PublishAt, restored.UnpublishAt = current.PublishAt, current.UnpublishAt
+		restored.PreviousSlugs = current.PreviousSlugs
+		restored.TimeStamp.CreatedAt = current.TimeStamp.CreatedAt
+		restored.Rating, restored.ReviewCount, restored.RatingDistribution = current.Rating, current.ReviewCount, current.RatingDistribution
+		if restored.Slug == "" {
+			restored.Slug = current.Slug
+		}
+		changes := bson.M{"slug": restored.Slug}
+		if err := applySlugChange(current, changes); err != nil {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"message": invalidBody,
+				"error":   err.Error(),
+			})
+			return
+		}
+		if previous, ok := changes["previous_slugs"].([]string); ok {
+			restored.PreviousSlugs = previous
+		}
+	} else if err := assignProductSlugs(&restored); err != nil {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	if err := checkSKUsAvailable(restored); err != nil {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	if helpers.ProductLive(restored) || restored.Status == model.ProductScheduled {
+		if err := helpers.CheckPublishable(restored); err != nil {
+			respondPublishError(ctx, err)
+			return
+		}
+	}
+	if len(restored.CategoryIds) > 0 {
+		// Categories may have been renamed since the revision was taken
+		if err := applyCategoryNames(&restored); err != nil {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"message": invalidBody,
+				"error":   err.Error(),
+			})
+			return
+		}
+	}
+	restored.TimeStamp.UpdatedAt = time.Now()
+
+	if _, err := collection.ReplaceOne(context.Background(), bson.M{"_id": id}, restored, options.Replace().SetUpsert(true)); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to restore product",
+			"error":   err.Error(),
+		})
+		return
+	}
+	afterProductsSaved(restored)
+	if !exists {
+		// Reviews written before the product was deleted count again
+		if err := refreshReviewAggregate(id); err != nil {
+			log.Printf("failed to update the rating of product %s: %v", id.Hex(), err)
+		}
+	}
+
+	saved, err := appendRevision(model.ProductRevision{
+		ProductId:    id,
+		Action:       model.RevisionRollback,
+		Snapshot:     restored,
+		RestoredFrom: version,
+		Actor:        requestActor(ctx),
+	})
+	if err != nil {
+		log.Printf("failed to record rollback revision of product %s: %v", id.Hex(), err)
+	}
+
+	response := gin.H{
+		"message": "Product restored",
+		"data":    restored,
+	}
+	if saved != nil {
+		response["version"] = saved.Version
+	}
+	ctx.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,143 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joshua/casify/helpers"
+	"github.com/joshua/casify/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// productIndex is the in-memory full-text index over the catalog. It is
+// loaded at startup and kept in sync by the product write hooks.
+var productIndex = helpers.NewSearchIndex()
+
+// loadProductIndexes rebuilds the search and suggestion indexes from the
+// products collection.
+func loadProductIndexes() error {
+	collection := Client.Database(dbName).Collection(colName)
+	cursor, err := collection.Find(context.Background(), bson.M{})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(context.Background())
+
+	var products []model.Product
+	if err := cursor.All(context.Background(), &products); err != nil {
+		return err
+	}
+	productIndex.Replace(products)
+
+	suggestIndex.RemoveSourcesWithPrefix(productSuggestSource)
+	for _, p := range products {
+		indexProductSuggestions(p)
+	}
+	return nil
+}
+
+// searchSort is the order of search hits, best match first, which their
+// cursors follow.
+var searchSort = helpers.FixedSort("-score")
+
+// SearchProducts runs a ranked full-text search over titles, descriptions,
+// features and categories. It accepts the listing filters plus "q",
+// "limit", "cursor" and "facets".
+func SearchProducts(ctx *gin.Context) {
+	query := strings.TrimSpace(ctx.Query("q"))
+	if query == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "Missing search query",
+			"error":   "q is required",
+		})
+		return
+	}
+
+	params, err := parsePageParams(ctx, searchSort, false)
+	if err != nil {
+		respondQueryError(ctx, err)
+		return
+	}
+
+	filter, err := parseProductFilter(ctx)
+	if err != nil {
+		respondQueryError(ctx, err)
+		return
+	}
+	filter = helpers.And(filter, helpers.LiveProductFilter())
+
+	// Facets are counted over every text match, each ignoring its own filter
+	textHits := productIndex.Search(query, nil)
+	hits := make([]helpers.SearchHit, 0, len(textHits))
+	for _, hit := range textHits {
+		if helpers.EvalFilter(filter, hit.Product) {
+			hits = append(hits, hit)
+		}
+	}
+	total := len(hits)
+	start := 0
+	if params.After != nil {
+		if start, err = searchPosition(hits, params.After); err != nil {
+			respondQueryError(ctx, &queryError{message: "Invalid cursor", err: err})
+			return
+		}
+	}
+	end := start + params.Limit
+	if end > total {
+		end = total
+	}
+
+	var next string
+	if end < total {
+		if next, err = searchCursor(hits[end-1]); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed to search products",
+				"error":   err.Error(),
+			})
+			return
+		}
+	}
+	response := gin.H{
+		"data":  hits[start:end],
+		"total": total,
+	}
+	addPageLinks(ctx, response, params, next, end < total)
+	if ctx.Query("facets") == "true" {
+		products := make([]model.Product, len(textHits))
+		for i, hit := range textHits {
+			products[i] = hit.Product
+		}
+		response["facets"] = helpers.ComputeFacets(products, filter)
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+// searchCursor builds the cursor of the page after hit.
+func searchCursor(hit helpers.SearchHit) (string, error) {
+	doc, err := bson.Marshal(bson.M{"score": hit.Score, "_id": hit.Product.Id})
+	if err != nil {
+		return "", err
+	}
+	return helpers.EncodeCursor(searchSort, doc)
+}
+
+// searchPosition returns the index of the first hit ranked after the cursor
+// values, so pages stay in place when the catalog changes between them.
+func searchPosition(hits []helpers.SearchHit, after []interface{}) (int, error) {
+	score, ok := after[0].(float64)
+	id, isId := after[1].(primitive.ObjectID)
+	if !ok || !isId {
+		return 0, errors.New("malformed cursor")
+	}
+	return sort.Search(len(hits), func(i int) bool {
+		if hits[i].Score != score {
+			return hits[i].Score < score
+		}
+		return hits[i].Product.Id.Hex() > id.Hex()
+	}), nil
+}
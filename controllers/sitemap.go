@@ -0,0 +1,214 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joshua/casify/helpers"
+	"github.com/joshua/casify/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	sitemapProducts    = "products"
+	sitemapCategories  = "categories"
+	sitemapCollections = "collections"
+)
+
+const sitemapContentType = "application/xml; charset=utf-8"
+
+// sitemapSource lists the storefront pages the sitemap covers. Categories
+// and collections are few and loaded whole; products are paged from the
+// database.
+type sitemapSource struct {
+	base        string
+	products    int
+	categories  []helpers.SitemapURL
+	collections []helpers.SitemapURL
+}
+
+func loadSitemapSource() (*sitemapSource, error) {
+	src := &sitemapSource{base: helpers.FeedConfigFromEnv().BaseURL}
+
+	count, err := Client.Database(dbName).Collection(colName).CountDocuments(context.Background(), helpers.CompileFilter(helpers.LiveProductFilter()))
+	if err != nil {
+		return nil, err
+	}
+	src.products = int(count)
+
+	var categories []model.Category
+	cursor, err := categoryCollection().Find(context.Background(), bson.M{},
+		options.Find().SetSort(bson.D{{Key: "slug", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(context.Background(), &categories); err != nil {
+		return nil, err
+	}
+	for _, c := range categories {
+		src.categories = append(src.categories, helpers.SitemapURL{
+			Loc:     src.base + helpers.CategoryPath(c),
+			LastMod: c.TimeStamp.UpdatedAt,
+		})
+	}
+
+	collections, err := loadCollections()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for _, c := range collections {
+		if helpers.CollectionLive(c, now) {
+			src.collections = append(src.collections, helpers.SitemapURL{
+				Loc:     src.base + helpers.CollectionPath(c),
+				LastMod: c.TimeStamp.UpdatedAt,
+			})
+		}
+	}
+	return src, nil
+}
+
+// productURLs returns one page of live product locations in id order.
+func (s *sitemapSource) productURLs(offset, limit int) ([]helpers.SitemapURL, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetSkip(int64(offset)).
+		SetLimit(int64(limit)).
+		SetProjection(bson.M{"slug": 1, "time_stamp": 1})
+	cursor, err := Client.Database(dbName).Collection(colName).Find(context.Background(), helpers.CompileFilter(helpers.LiveProductFilter()), opts)
+	if err != nil {
+		return nil, err
+	}
+	var products []model.Product
+	if err := cursor.All(context.Background(), &products); err != nil {
+		return nil, err
+	}
+	urls := make([]helpers.SitemapURL, len(products))
+	for i, p := range products {
+		urls[i] = helpers.SitemapURL{Loc: s.base + helpers.ProductPath(p), LastMod: p.TimeStamp.UpdatedAt}
+	}
+	return urls, nil
+}
+
+// sections returns the size of each sitemap section.
+func (s *sitemapSource) sections() map[string]int {
+	return map[string]int{
+		sitemapProducts:    s.products,
+		sitemapCategories:  len(s.categories),
+		sitemapCollections: len(s.collections),
+	}
+}
+
+// page returns page n, counted from 1, of a section.
+func (s *sitemapSource) page(section string, n, size int) ([]helpers.SitemapURL, bool, error) {
+	total, ok := s.sections()[section]
+	if !ok || n > helpers.SitemapPages(total, size) {
+		return nil, false, nil
+	}
+	offset := (n - 1) * size
+	end := offset + size
+	switch section {
+	case sitemapProducts:
+		urls, err := s.productURLs(offset, size)
+		return urls, true, err
+	case sitemapCategories:
+		return s.categories[offset:min(end, total)], true, nil
+	default:
+		return s.collections[offset:min(end, total)], true, nil
+	}
+}
+
+func respondSitemapError(ctx *gin.Context, err error) {
+	ctx.JSON(http.StatusInternalServerError, gin.H{
+		"message": "Failed to generate sitemap",
+		"error":   err.Error(),
+	})
+}
+
+// Sitemap serves the storefront sitemap. Catalogs too large for one file
+// get a sitemap index pointing at the pages served by SitemapPage.
+func Sitemap(ctx *gin.Context) {
+	src, err := loadSitemapSource()
+	if err != nil {
+		respondSitemapError(ctx, err)
+		return
+	}
+	size := helpers.SitemapPageSizeFromEnv()
+
+	var buf bytes.Buffer
+	if src.products+len(src.categories)+len(src.collections) <= size {
+		urls, err := src.productURLs(0, size)
+		if err != nil {
+			respondSitemapError(ctx, err)
+			return
+		}
+		urls = append(urls, src.categories...)
+		urls = append(urls, src.collections...)
+		if err := helpers.WriteURLSet(&buf, urls); err != nil {
+			respondSitemapError(ctx, err)
+			return
+		}
+		ctx.Data(http.StatusOK, sitemapContentType, buf.Bytes())
+		return
+	}
+
+	// The index points at the API's own sitemap pages. The address comes
+	// from configuration, never from the Host header the client sent.
+	base := helpers.APIBaseURLFromEnv()
+	sections := src.sections()
+	var sitemaps []helpers.SitemapURL
+	for _, section := range []string{sitemapProducts, sitemapCategories, sitemapCollections} {
+		for n := 1; n <= helpers.SitemapPages(sections[section], size); n++ {
+			sitemaps = append(sitemaps, helpers.SitemapURL{
+				Loc: fmt.Sprintf("%s/api/v1/sitemaps/%s", base, helpers.SitemapFileName(section, n)),
+			})
+		}
+	}
+	if err := helpers.WriteSitemapIndex(&buf, sitemaps); err != nil {
+		respondSitemapError(ctx, err)
+		return
+	}
+	ctx.Data(http.StatusOK, sitemapContentType, buf.Bytes())
+}
+
+// SitemapPage serves one page of a sitemap section, such as
+// products-2.xml.
+func SitemapPage(ctx *gin.Context) {
+	section, n, err := helpers.ParseSitemapFileName(ctx.Param("file"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": "Sitemap not found",
+			"error":   err.Error(),
+		})
+		return
+	}
+	src, err := loadSitemapSource()
+	if err != nil {
+		respondSitemapError(ctx, err)
+		return
+	}
+	urls, ok, err := src.page(section, n, helpers.SitemapPageSizeFromEnv())
+	if err != nil {
+		respondSitemapError(ctx, err)
+		return
+	}
+	if !ok {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": "Sitemap not found",
+			"error":   fmt.Sprintf("no sitemap named %q", ctx.Param("file")),
+		})
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := helpers.WriteURLSet(&buf, urls); err != nil {
+		respondSitemapError(ctx, err)
+		return
+	}
+	ctx.Data(http.StatusOK, sitemapContentType, buf.Bytes())
+}
@@ -0,0 +1,219 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joshua/casify/helpers"
+	"github.com/joshua/casify/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var errSlugTaken = errors.New("slug is already used by another product")
+
+// ensureSlugIndexes makes product slugs unique and backs redirects from
+// old slugs.
+func ensureSlugIndexes() error {
+	collection := Client.Database(dbName).Collection(colName)
+	_, err := collection.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "slug", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+		{Keys: bson.D{{Key: "previous_slugs", Value: 1}}},
+	})
+	return err
+}
+
+// productSlugTaken reports whether a product other than except uses slug,
+// now or as a former slug that still redirects.
+func productSlugTaken(slug string, except primitive.ObjectID) (bool, error) {
+	collection := Client.Database(dbName).Collection(colName)
+	count, err := collection.CountDocuments(context.Background(), bson.M{
+		"_id": bson.M{"$ne": except},
+		"$or": []bson.M{{"slug": slug}, {"previous_slugs": slug}},
+	})
+	return count > 0, err
+}
+
+// checkProductSlug validates a slug chosen by hand.
+func checkProductSlug(slug string, id primitive.ObjectID) error {
+	if slug == "" || helpers.Slugify(slug) != slug {
+		return errors.New("slug may only contain lowercase letters, digits and dashes")
+	}
+	taken, err := productSlugTaken(slug, id)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return errSlugTaken
+	}
+	return nil
+}
+
+// generateProductSlug derives a free slug from a title. Slugs in reserved
+// are treated as taken, which keeps a batch of new products apart.
+func generateProductSlug(title string, id primitive.ObjectID, reserved map[string]bool) (string, error) {
+	base := helpers.Slugify(title)
+	if base == "" {
+		base = "product"
+	}
+	var lookupErr error
+	slug := helpers.UniqueSlug(base, func(slug string) bool {
+		if reserved[slug] {
+			return true
+		}
+		taken, err := productSlugTaken(slug, id)
+		if err != nil {
+			lookupErr = err
+			return false
+		}
+		return taken
+	})
+	return slug, lookupErr
+}
+
+// assignProductSlugs gives new products a slug derived from their title, or
+// checks the one they were given.
+func assignProductSlugs(products ...*model.Product) error {
+	reserved := map[string]bool{}
+	for _, p := range products {
+		p.PreviousSlugs = nil
+		if p.Slug != "" {
+			if reserved[p.Slug] {
+				return errSlugTaken
+			}
+			if err := checkProductSlug(p.Slug, p.Id); err != nil {
+				return err
+			}
+		} else {
+			slug, err := generateProductSlug(p.Title, p.Id, reserved)
+			if err != nil {
+				return err
+			}
+			p.Slug = slug
+		}
+		reserved[p.Slug] = true
+	}
+	return nil
+}
+
+// applySlugChange works out the slug of an updated product. An explicit
+// slug wins; otherwise a new title moves the product to a slug derived from
+// it. The old slug is kept so links to it can be redirected.
+func applySlugChange(current model.Product, changes bson.M) error {
+	slug, hasSlug := changes["slug"].(string)
+	title, hasTitle := changes["title"].(string)
+
+	switch {
+	case hasSlug:
+		if slug != current.Slug {
+			if err := checkProductSlug(slug, current.Id); err != nil {
+				return err
+			}
+		}
+	case hasTitle && title != current.Title:
+		generated, err := generateProductSlug(title, current.Id, map[string]bool{})
+		if err != nil {
+			return err
+		}
+		slug = generated
+	default:
+		return nil
+	}
+	if slug == current.Slug {
+		return nil
+	}
+
+	previous := []string{}
+	for _, old := range current.PreviousSlugs {
+		if old != slug {
+			previous = append(previous, old)
+		}
+	}
+	if current.Slug != "" {
+		previous = append(previous, current.Slug)
+	}
+	changes["slug"] = slug
+	changes["previous_slugs"] = previous
+	return nil
+}
+
+// backfillProductSlugs gives every product created before slugs existed a
+// slug derived from its title.
+func backfillProductSlugs() error {
+	collection := Client.Database(dbName).Collection(colName)
+	filter := bson.M{"$or": []bson.M{{"slug": bson.M{"$exists": false}}, {"slug": ""}}}
+	cursor, err := collection.Find(context.Background(), filter, options.Find().SetProjection(bson.M{"title": 1}))
+	if err != nil {
+		return err
+	}
+	var products []model.Product
+	if err := cursor.All(context.Background(), &products); err != nil {
+		return err
+	}
+	for _, p := range products {
+		slug, err := generateProductSlug(p.Title, p.Id, map[string]bool{})
+		if err != nil {
+			return err
+		}
+		if _, err := collection.UpdateOne(context.Background(), bson.M{"_id": p.Id}, bson.M{"$set": bson.M{"slug": slug}}); err != nil {
+			return err
+		}
+	}
+	if len(products) > 0 {
+		log.Printf("assigned slugs to %d products", len(products))
+	}
+	return nil
+}
+
+// GetProductBySlug returns a live product by its slug. A former slug, or the
+// product id, answers with a 301 pointing at the current slug.
+func GetProductBySlug(ctx *gin.Context) {
+	ref := ctx.Param("id")
+	collection := Client.Database(dbName).Collection(colName)
+
+	var product model.Product
+	live := helpers.CompileFilter(helpers.LiveProductFilter())
+	err := collection.FindOne(context.Background(), bson.M{"$and": []bson.M{{"slug": ref}, live}}).Decode(&product)
+	if err == nil {
+		respondProduct(ctx, &product)
+		return
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to get product",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	filter := bson.M{"previous_slugs": ref}
+	if id, idErr := primitive.ObjectIDFromHex(ref); idErr == nil {
+		filter = bson.M{"$or": []bson.M{filter, {"_id": id}}}
+	}
+	if err := collection.FindOne(context.Background(), bson.M{"$and": []bson.M{filter, live}}).Decode(&product); err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": productNotFound,
+			"error":   err.Error(),
+		})
+		return
+	}
+	if product.Slug == "" {
+		respondProduct(ctx, &product)
+		return
+	}
+
+	location := fmt.Sprintf("/api/v1/products/%s", product.Slug)
+	ctx.Header("Location", location)
+	ctx.JSON(http.StatusMovedPermanently, gin.H{
+		"message":       "Product moved",
+		"slug":          product.Slug,
+		"location":      location,
+		"canonical_url": helpers.FeedConfigFromEnv().BaseURL + helpers.ProductPath(product),
+	})
+}
@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joshua/casify/helpers"
+	"github.com/joshua/casify/model"
+)
+
+const (
+	defaultSuggestLimit = 8
+	maxSuggestLimit     = 20
+)
+
+// productSuggestSource prefixes the suggestion sources owned by products.
+const productSuggestSource = "product:"
+
+// suggestIndex backs search-as-you-type. Like productIndex it is loaded at
+// startup and refreshed by the product write hooks.
+var suggestIndex = helpers.NewSuggestIndex()
+
+// productSuggestions lists the autocomplete terms a product contributes.
+// Better rated products rank higher; categories rank by how many products
+// use them.
+func productSuggestions(p model.Product) []helpers.SuggestItem {
+	items := []helpers.SuggestItem{{Text: p.Title, Kind: helpers.SuggestTitle, Weight: 1 + p.Rating}}
+	for _, c := range p.Category {
+		items = append(items, helpers.SuggestItem{Text: c, Kind: helpers.SuggestCategory, Weight: 1})
+	}
+	return items
+}
+
+// indexProductSuggestions offers a product's terms while it is live and
+// withdraws them otherwise.
+func indexProductSuggestions(p model.Product) {
+	if !helpers.ProductLive(p) {
+		suggestIndex.RemoveSource(productSuggestSource + p.Id.Hex())
+		return
+	}
+	suggestIndex.SetSource(productSuggestSource+p.Id.Hex(), productSuggestions(p))
+}
+
+// SuggestSearch returns autocomplete suggestions for the text typed so far.
+func SuggestSearch(ctx *gin.Context) {
+	query := strings.TrimSpace(ctx.Query("q"))
+	if query == "" {
+		ctx.JSON(http.StatusOK, gin.H{
+			"data": []helpers.Suggestion{},
+		})
+		return
+	}
+
+	limit := defaultSuggestLimit
+	if raw := ctx.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": "Invalid limit",
+				"error":   "limit must be a positive integer",
+			})
+			return
+		}
+		limit = min(n, maxSuggestLimit)
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"data": suggestIndex.Suggest(query, limit),
+	})
+}
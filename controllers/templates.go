@@ -0,0 +1,320 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joshua/casify/helpers"
+	"github.com/joshua/casify/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const templateColName = "product_templates"
+
+const (
+	templateNotFound = "Template not found"
+	templateExists   = "Template already exists"
+)
+
+func templateCollection() *mongo.Collection {
+	return Client.Database(dbName).Collection(templateColName)
+}
+
+// ensureTemplateIndexes makes template names unique.
+func ensureTemplateIndexes() error {
+	_, err := templateCollection().Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "name", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// findTemplate loads a template by id or name.
+func findTemplate(ref string) (*model.ProductTemplate, error) {
+	filter := bson.M{"name": ref}
+	if id, err := primitive.ObjectIDFromHex(ref); err == nil {
+		filter = bson.M{"$or": []bson.M{filter, {"_id": id}}}
+	}
+	var template model.ProductTemplate
+	if err := templateCollection().FindOne(context.Background(), filter).Decode(&template); err != nil {
+		return nil, err
+	}
+	return &template, nil
+}
+
+// checkTemplate validates a template and fills in the names of its
+// categories.
+func checkTemplate(template *model.ProductTemplate) error {
+	template.Name = strings.TrimSpace(template.Name)
+	if err := helpers.ValidateTemplate(*template); err != nil {
+		return err
+	}
+	named := model.Product{CategoryIds: template.CategoryIds, Category: template.Category}
+	if err := applyCategoryNames(&named); err != nil {
+		return err
+	}
+	template.Category = named.Category
+	return nil
+}
+
+func respondTemplateError(ctx *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": templateNotFound,
+			"error":   err.Error(),
+		})
+	case mongo.IsDuplicateKeyError(err):
+		ctx.JSON(http.StatusConflict, gin.H{
+			"message": templateExists,
+			"error":   err.Error(),
+		})
+	default:
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": message,
+			"error":   err.Error(),
+		})
+	}
+}
+
+// GetTemplates lists every product template by name.
+func GetTemplates(ctx *gin.Context) {
+	cursor, err := templateCollection().Find(context.Background(), bson.M{},
+		options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+	if err != nil {
+		respondTemplateError(ctx, err, "Failed to get templates")
+		return
+	}
+	templates := []model.ProductTemplate{}
+	if err := cursor.All(context.Background(), &templates); err != nil {
+		respondTemplateError(ctx, err, "Failed to get templates")
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"data": templates,
+	})
+}
+
+// GetTemplate returns one template by id or name.
+func GetTemplate(ctx *gin.Context) {
+	template, err := findTemplate(ctx.Param("id"))
+	if err != nil {
+		respondTemplateError(ctx, err, "Failed to get template")
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"data": template,
+	})
+}
+
+// AddTemplate saves a new product template.
+func AddTemplate(ctx *gin.Context) {
+	var template model.ProductTemplate
+	if err := ctx.ShouldBindJSON(&template); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	if err := checkTemplate(&template); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	template.Id = primitive.NewObjectID()
+	template.TimeStamp.CreatedAt = time.Now()
+	template.TimeStamp.UpdatedAt = time.Now()
+
+	if _, err := templateCollection().InsertOne(context.Background(), template); err != nil {
+		respondTemplateError(ctx, err, "Failed to add template")
+		return
+	}
+	ctx.JSON(http.StatusCreated, gin.H{
+		"message":    "Template added successfully",
+		"templateId": template.Id,
+	})
+}
+
+// UpdateTemplate replaces the defaults of a template.
+func UpdateTemplate(ctx *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	var template model.ProductTemplate
+	if err := ctx.ShouldBindJSON(&template); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	if err := checkTemplate(&template); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	update := bson.M{"$set": bson.M{
+		"name":                  template.Name,
+		"description":           template.Description,
+		"details":               template.Details,
+		"category":              template.Category,
+		"category_ids":          template.CategoryIds,
+		"time_stamp.updated_at": time.Now(),
+	}}
+	var saved model.ProductTemplate
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if err := templateCollection().FindOneAndUpdate(context.Background(), bson.M{"_id": id}, update, opts).Decode(&saved); err != nil {
+		respondTemplateError(ctx, err, "Failed to update template")
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Template updated successfully",
+		"data":    saved,
+	})
+}
+
+// DeleteTemplate removes a template. Products created from it are kept.
+func DeleteTemplate(ctx *gin.Context) {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	result, err := templateCollection().DeleteOne(context.Background(), bson.M{"_id": id})
+	if err != nil {
+		respondTemplateError(ctx, err, "Failed to delete template")
+		return
+	}
+	if result.DeletedCount == 0 {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": templateNotFound,
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Template deleted successfully",
+	})
+}
+
+// CloneProduct copies a product into a new draft. The optional body holds
+// "overrides", product fields to change on the copy, and "sku_suffix",
+// appended to every SKU so the copy can be sold separately.
+func CloneProduct(ctx *gin.Context) {
+	original, err := findProductById(ctx)
+	if err != nil {
+		handleProductError(ctx, err)
+		return
+	}
+
+	var input struct {
+		Overrides json.RawMessage `json:"overrides"`
+		SKUSuffix string          `json:"sku_suffix"`
+	}
+	body, err := ctx.GetRawData()
+	if err == nil && len(body) > 0 {
+		err = json.Unmarshal(body, &input)
+	}
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	clone := helpers.CloneProduct(*original, strings.TrimSpace(input.SKUSuffix), time.Now())
+	if len(input.Overrides) > 0 {
+		// Overrides accept the fields UpdateProduct does
+		_, err := helpers.ProductUpdateDocument(input.Overrides, productReadOnlyFields...)
+		if err == nil {
+			err = json.Unmarshal(input.Overrides, &clone)
+		}
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": invalidBody,
+				"error":   err.Error(),
+			})
+			return
+		}
+	}
+
+	if err := helpers.ValidateProductInput(clone); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	if err := checkSKUsAvailable(clone); err != nil {
+		if input.SKUSuffix == "" {
+			err = fmt.Errorf("%w; set sku_suffix or override the variants", err)
+		}
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	if err := checkDevicesExist(clone.CompatibleDevices); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	if err := applyCategoryNames(&clone); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	if err := assignProductSlugs(&clone); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	clone.Status = model.ProductDraft
+
+	collection := Client.Database(dbName).Collection(colName)
+	if _, err := collection.InsertOne(context.Background(), clone); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": failedToAddProduct,
+			"error":   err.Error(),
+		})
+		return
+	}
+	afterProductsSaved(clone)
+	recordRevisions(requestActor(ctx), model.RevisionCreate, clone)
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"message":   productAdded,
+		"productId": clone.Id,
+		"data":      clone,
+	})
+}
@@ -2,12 +2,16 @@ package controllers
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/joshua/casify/helpers"
 	"github.com/joshua/casify/model"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 func UpdateProduct(ctx *gin.Context) {
@@ -23,22 +27,140 @@ func UpdateProduct(ctx *gin.Context) {
 		return
 	}
 
+	// Only the fields present in the body are changed
+	body, err := ctx.GetRawData()
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	changes, err := helpers.ProductUpdateDocument(body, productReadOnlyFields...)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	// Changes are validated against the product as it will be saved
+	current, err := findProductById(ctx)
+	if err != nil {
+		handleProductError(ctx, err)
+		return
+	}
+	merged := *current
+	if err := json.Unmarshal(body, &merged); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	if touchesVariants(changes) {
+		if err := helpers.ValidateVariants(merged); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": invalidBody,
+				"error":   err.Error(),
+			})
+			return
+		}
+		if err := checkSKUsAvailable(merged); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": invalidBody,
+				"error":   err.Error(),
+			})
+			return
+		}
+	}
+
+	// A new slug, or a new title, moves the product and keeps the old slug
+	// for redirects
+	if err := applySlugChange(*current, changes); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	if slug, ok := changes["slug"].(string); ok {
+		merged.Slug = slug
+	}
+
+	if ids, ok := changes["category_ids"].([]primitive.ObjectID); ok && len(ids) > 0 {
+		named := model.Product{CategoryIds: ids}
+		if err := applyCategoryNames(&named); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": invalidBody,
+				"error":   err.Error(),
+			})
+			return
+		}
+		changes["category"] = named.Category
+		merged.Category = named.Category
+	}
+
+	// Live and scheduled products must not lose what they need to go live
+	if current.Status == model.ProductPublished || current.Status == model.ProductScheduled {
+		if err := helpers.CheckStillPublishable(*current, merged); err != nil {
+			respondPublishError(ctx, err)
+			return
+		}
+	}
+
+	if devices, ok := changes["compatible_devices"].([]primitive.ObjectID); ok {
+		if err := checkDevicesExist(devices); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": invalidBody,
+				"error":   err.Error(),
+			})
+			return
+		}
+	}
+	changes["time_stamp.updated_at"] = time.Now()
+
 	filter := bson.M{"_id": id}
-	update := bson.M{"$set": ctx.Request.Body}
+	update := bson.M{"$set": changes}
 
 	var product model.Product
 
 	collection := Client.Database(dbName).Collection(colName)
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
 
-	if err := collection.FindOneAndUpdate(context.Background(), filter, update).Decode(&product); err != nil {
+	if err := collection.FindOneAndUpdate(context.Background(), filter, update, opts).Decode(&product); err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{
 			"message": productNotFound,
 			"error":   err.Error(),
 		})
 		return
 	}
+	afterProductsSaved(product)
+	recordRevisions(requestActor(ctx), model.RevisionUpdate, product)
+
 	ctx.JSON(http.StatusOK, gin.H{
 		"message": productUpdated,
 		"data":    product,
 	})
 }
+
+// productReadOnlyFields cannot be changed by UpdateProduct. Status changes
+// go through SetProductStatus so they are validated, and ratings are
+// computed from reviews.
+var productReadOnlyFields = []string{
+	"id", "time_stamp", "previous_slugs",
+	"status", "publish_at", "unpublish_at",
+	"rating", "review_count", "rating_distribution",
+}
+
+// touchesVariants reports whether an update changes SKUs, options or
+// variants.
+func touchesVariants(changes bson.M) bool {
+	for _, key := range []string{"sku", "options", "variants"} {
+		if _, ok := changes[key]; ok {
+			return true
+		}
+	}
+	return false
+}
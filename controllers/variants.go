@@ -0,0 +1,120 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joshua/casify/helpers"
+	"github.com/joshua/casify/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// checkSKUsAvailable makes sure no other product already uses one of the
+// product's SKUs, either as its own SKU or on a variant.
+func checkSKUsAvailable(p model.Product) error {
+	skus := helpers.ProductSKUs(p)
+	if len(skus) == 0 {
+		return nil
+	}
+
+	filter := bson.M{
+		"_id": bson.M{"$ne": p.Id},
+		"$or": []bson.M{
+			{"sku": bson.M{"$in": skus}},
+			{"variants.sku": bson.M{"$in": skus}},
+		},
+	}
+	collection := Client.Database(dbName).Collection(colName)
+	var other model.Product
+	err := collection.FindOne(context.Background(), filter, options.FindOne().SetProjection(bson.M{"title": 1})).Decode(&other)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("a sku is already used by product %s", other.Id.Hex())
+}
+
+// checkBatchSKUs rejects SKUs repeated across a batch of new products.
+func checkBatchSKUs(products []model.Product) error {
+	seen := map[string]bool{}
+	for _, p := range products {
+		for _, sku := range helpers.ProductSKUs(p) {
+			if seen[sku] {
+				return fmt.Errorf("sku %q is used twice", sku)
+			}
+			seen[sku] = true
+		}
+	}
+	return nil
+}
+
+// backfillProductSKUs gives every product with neither a SKU nor variants,
+// such as those created before SKUs existed, a product SKU derived from its
+// id, and seeds its stock level, so it can be stocked, carted and ordered
+// like new products.
+func backfillProductSKUs() error {
+	collection := Client.Database(dbName).Collection(colName)
+	filter := bson.M{
+		"$and": []bson.M{
+			{"$or": []bson.M{{"sku": bson.M{"$exists": false}}, {"sku": ""}}},
+			{"$or": []bson.M{{"variants": bson.M{"$exists": false}}, {"variants": bson.M{"$size": 0}}}},
+		},
+	}
+	cursor, err := collection.Find(context.Background(), filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return err
+	}
+	var products []model.Product
+	if err := cursor.All(context.Background(), &products); err != nil {
+		return err
+	}
+	for i := range products {
+		products[i].SKU = "CS-" + strings.ToUpper(products[i].Id.Hex())
+		if _, err := collection.UpdateOne(context.Background(), bson.M{"_id": products[i].Id},
+			bson.M{"$set": bson.M{"sku": products[i].SKU}}); err != nil {
+			return err
+		}
+	}
+	seedInventory(products...)
+	if len(products) > 0 {
+		log.Printf("assigned skus to %d products", len(products))
+	}
+	return nil
+}
+
+// GetProductVariants returns the option matrix of a product with live
+// stock.
+func GetProductVariants(ctx *gin.Context) {
+	product, err := findLiveProductById(ctx)
+	if err != nil {
+		handleProductError(ctx, err)
+		return
+	}
+
+	availability, err := productAvailability(*product)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to load stock",
+			"error":   err.Error(),
+		})
+		return
+	}
+	applyLiveStock(product, availability)
+
+	matrix := helpers.BuildVariantMatrix(*product)
+	if matrix == nil {
+		matrix = &helpers.VariantMatrix{Axes: []model.ProductOption{}, Cells: []helpers.VariantCell{}}
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"data": matrix,
+	})
+}
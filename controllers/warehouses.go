@@ -0,0 +1,553 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joshua/casify/helpers"
+	"github.com/joshua/casify/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	warehouseColName      = "warehouses"
+	warehouseStockColName = "warehouse_stock"
+)
+
+const (
+	warehouseNotFound = "Warehouse not found"
+	warehouseExists   = "Warehouse already exists"
+)
+
+var (
+	errWarehouseRequired = errors.New("warehouse_id is required once warehouses exist")
+	errUnknownWarehouse  = errors.New("unknown warehouse")
+)
+
+func warehouseCollection() *mongo.Collection {
+	return Client.Database(dbName).Collection(warehouseColName)
+}
+
+func warehouseStockCollection() *mongo.Collection {
+	return Client.Database(dbName).Collection(warehouseStockColName)
+}
+
+// ensureWarehouseIndexes makes warehouse codes unique and keeps one stock
+// document per SKU and warehouse.
+func ensureWarehouseIndexes() error {
+	if _, err := warehouseCollection().Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "code", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		return err
+	}
+	_, err := warehouseStockCollection().Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "sku", Value: 1}, {Key: "warehouse_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+func validateWarehouse(w model.Warehouse) error {
+	if strings.TrimSpace(w.Code) == "" || strings.TrimSpace(w.Name) == "" {
+		return errors.New("code and name are required")
+	}
+	return nil
+}
+
+// loadWarehouses returns every warehouse.
+func loadWarehouses() ([]model.Warehouse, error) {
+	cursor, err := warehouseCollection().Find(context.Background(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	warehouses := []model.Warehouse{}
+	if err := cursor.All(context.Background(), &warehouses); err != nil {
+		return nil, err
+	}
+	return warehouses, nil
+}
+
+// hasWarehouses reports whether stock is tracked per location.
+func hasWarehouses() (bool, error) {
+	count, err := warehouseCollection().CountDocuments(context.Background(), bson.M{}, options.Count().SetLimit(1))
+	return count > 0, err
+}
+
+// defaultWarehouse is the active warehouse first in fulfillment order, or
+// nil when there is none.
+func defaultWarehouse() (*model.Warehouse, error) {
+	warehouses, err := loadWarehouses()
+	if err != nil {
+		return nil, err
+	}
+	ordered := helpers.FulfillmentOrder(warehouses)
+	if len(ordered) == 0 {
+		return nil, nil
+	}
+	return &ordered[0], nil
+}
+
+// loadWarehouseInventory returns the per warehouse stock of the given SKUs.
+func loadWarehouseInventory(skus []string) (helpers.WarehouseInventory, error) {
+	cursor, err := warehouseStockCollection().Find(context.Background(), bson.M{"sku": bson.M{"$in": skus}})
+	if err != nil {
+		return nil, err
+	}
+	var rows []model.WarehouseStock
+	if err := cursor.All(context.Background(), &rows); err != nil {
+		return nil, err
+	}
+	stock := helpers.WarehouseInventory{}
+	for _, row := range rows {
+		if stock[row.WarehouseId] == nil {
+			stock[row.WarehouseId] = map[string]int{}
+		}
+		stock[row.WarehouseId][row.SKU] = row.OnHand
+	}
+	return stock, nil
+}
+
+// changeWarehouseStock adds delta to the on-hand quantity of a SKU at a
+// warehouse. A decrease only applies while the warehouse holds enough.
+func changeWarehouseStock(sku string, warehouseId primitive.ObjectID, delta int) error {
+	filter := bson.M{"sku": sku, "warehouse_id": warehouseId}
+	update := bson.M{
+		"$inc": bson.M{"on_hand": delta},
+		"$set": bson.M{"updated_at": time.Now()},
+	}
+	if delta < 0 {
+		filter["on_hand"] = bson.M{"$gte": -delta}
+		result, err := warehouseStockCollection().UpdateOne(context.Background(), filter, update)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return &errInsufficientStock{SKU: sku, Requested: -delta}
+		}
+		return nil
+	}
+	_, err := warehouseStockCollection().UpdateOne(context.Background(), filter, update, options.Update().SetUpsert(true))
+	return err
+}
+
+// checkWarehouse resolves the warehouse a stock change applies to. A zero
+// id is only allowed while no warehouses exist.
+func checkWarehouse(id primitive.ObjectID) error {
+	if id.IsZero() {
+		exists, err := hasWarehouses()
+		if err != nil {
+			return err
+		}
+		if exists {
+			return errWarehouseRequired
+		}
+		return nil
+	}
+	count, err := warehouseCollection().CountDocuments(context.Background(), bson.M{"_id": id})
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return errUnknownWarehouse
+	}
+	return nil
+}
+
+// deductFromWarehouses takes a sold quantity out of the warehouses chosen by
+// the allocator and returns the shipments it was taken from. Nothing is
+// deducted while no warehouses exist.
+func deductFromWarehouses(sku string, qty int) ([]helpers.Shipment, error) {
+	warehouses, err := loadWarehouses()
+	if err != nil || len(warehouses) == 0 {
+		return nil, err
+	}
+	stock, err := loadWarehouseInventory([]string{sku})
+	if err != nil {
+		return nil, err
+	}
+	allocation, err := helpers.AllocateFulfillment([]helpers.StockRequest{{SKU: sku, Quantity: qty}}, warehouses, stock)
+	if err != nil {
+		return nil, err
+	}
+	for _, shipment := range allocation.Shipments {
+		for _, item := range shipment.Items {
+			if err := changeWarehouseStock(item.SKU, shipment.WarehouseId, -item.Quantity); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return allocation.Shipments, nil
+}
+
+// assignUnlocatedStock moves stock counted before warehouses existed into
+// the first warehouse so per location quantities add up to the totals.
+func assignUnlocatedStock(warehouseId primitive.ObjectID) error {
+	cursor, err := inventoryCollection().Find(context.Background(), bson.M{"on_hand": bson.M{"$gt": 0}})
+	if err != nil {
+		return err
+	}
+	var levels []model.StockLevel
+	if err := cursor.All(context.Background(), &levels); err != nil {
+		return err
+	}
+	for _, level := range levels {
+		if err := changeWarehouseStock(level.SKU, warehouseId, level.OnHand); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseWarehouseId(ctx *gin.Context) (primitive.ObjectID, bool) {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return id, false
+	}
+	return id, true
+}
+
+// AddWarehouse creates a warehouse, active unless "active" is false. The
+// first warehouse takes over all stock counted so far.
+func AddWarehouse(ctx *gin.Context) {
+	warehouse := model.Warehouse{Active: true}
+	if err := ctx.ShouldBindJSON(&warehouse); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	if err := validateWarehouse(warehouse); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	first, err := hasWarehouses()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to add warehouse",
+			"error":   err.Error(),
+		})
+		return
+	}
+	first = !first
+
+	warehouse.Id = primitive.NewObjectID()
+	warehouse.TimeStamp.CreatedAt = time.Now()
+	warehouse.TimeStamp.UpdatedAt = time.Now()
+	if _, err := warehouseCollection().InsertOne(context.Background(), warehouse); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"message": warehouseExists,
+				"error":   err.Error(),
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to add warehouse",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	if first {
+		if err := assignUnlocatedStock(warehouse.Id); err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Warehouse added but existing stock was not assigned",
+				"error":   err.Error(),
+			})
+			return
+		}
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"message":     "Warehouse added successfully",
+		"warehouseId": warehouse.Id,
+	})
+}
+
+// GetWarehouses lists warehouses in fulfillment order, inactive ones last.
+func GetWarehouses(ctx *gin.Context) {
+	warehouses, err := loadWarehouses()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to get warehouses",
+			"error":   err.Error(),
+		})
+		return
+	}
+	ordered := helpers.FulfillmentOrder(warehouses)
+	for _, w := range warehouses {
+		if !w.Active {
+			ordered = append(ordered, w)
+		}
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"data": ordered,
+	})
+}
+
+// UpdateWarehouse replaces the details of a warehouse. Like new ones, it
+// stays active unless "active" is false.
+func UpdateWarehouse(ctx *gin.Context) {
+	id, ok := parseWarehouseId(ctx)
+	if !ok {
+		return
+	}
+
+	input := model.Warehouse{Active: true}
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	if err := validateWarehouse(input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	update := bson.M{"$set": bson.M{
+		"code":                  input.Code,
+		"name":                  input.Name,
+		"address":               input.Address,
+		"priority":              input.Priority,
+		"active":                input.Active,
+		"time_stamp.updated_at": time.Now(),
+	}}
+	var warehouse model.Warehouse
+	err := warehouseCollection().FindOneAndUpdate(context.Background(), bson.M{"_id": id}, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&warehouse)
+	if err != nil {
+		switch {
+		case mongo.IsDuplicateKeyError(err):
+			ctx.JSON(http.StatusConflict, gin.H{
+				"message": warehouseExists,
+				"error":   err.Error(),
+			})
+		case errors.Is(err, mongo.ErrNoDocuments):
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": warehouseNotFound,
+				"error":   err.Error(),
+			})
+		default:
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"message": "Failed to update warehouse",
+				"error":   err.Error(),
+			})
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Warehouse updated successfully",
+		"data":    warehouse,
+	})
+}
+
+// DeleteWarehouse removes a warehouse that no longer holds any stock.
+func DeleteWarehouse(ctx *gin.Context) {
+	id, ok := parseWarehouseId(ctx)
+	if !ok {
+		return
+	}
+
+	stocked, err := warehouseStockCollection().CountDocuments(context.Background(),
+		bson.M{"warehouse_id": id, "on_hand": bson.M{"$gt": 0}})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to delete warehouse",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if stocked > 0 {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"message": "Warehouse still holds stock",
+			"error":   fmt.Sprintf("%d skus are stocked here, transfer them first", stocked),
+		})
+		return
+	}
+
+	result, err := warehouseCollection().DeleteOne(context.Background(), bson.M{"_id": id})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to delete warehouse",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if result.DeletedCount == 0 {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": warehouseNotFound,
+		})
+		return
+	}
+	if _, err := warehouseStockCollection().DeleteMany(context.Background(), bson.M{"warehouse_id": id}); err != nil {
+		log.Printf("failed to remove stock rows of warehouse %s: %v", id.Hex(), err)
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Warehouse deleted successfully",
+	})
+}
+
+// TransferStock moves a quantity of a SKU from one warehouse to another.
+// The SKU's total on hand does not change.
+func TransferStock(ctx *gin.Context) {
+	var input struct {
+		SKU      string             `json:"sku" binding:"required"`
+		From     primitive.ObjectID `json:"from" binding:"required"`
+		To       primitive.ObjectID `json:"to" binding:"required"`
+		Quantity int                `json:"quantity" binding:"required"`
+		Note     string             `json:"note"`
+	}
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	if input.Quantity <= 0 || input.From == input.To {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   "quantity must be positive and the warehouses must differ",
+		})
+		return
+	}
+	for _, id := range []primitive.ObjectID{input.From, input.To} {
+		if err := checkWarehouse(id); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": invalidBody,
+				"error":   err.Error(),
+			})
+			return
+		}
+	}
+
+	if err := changeWarehouseStock(input.SKU, input.From, -input.Quantity); err != nil {
+		respondStockError(ctx, err)
+		return
+	}
+	if err := changeWarehouseStock(input.SKU, input.To, input.Quantity); err != nil {
+		// Put the stock back where it came from.
+		if undoErr := changeWarehouseStock(input.SKU, input.From, input.Quantity); undoErr != nil {
+			log.Printf("failed to undo transfer of %s: %v", input.SKU, undoErr)
+		}
+		respondStockError(ctx, err)
+		return
+	}
+
+	user, _ := currentUser(ctx)
+	for _, leg := range []struct {
+		warehouse primitive.ObjectID
+		delta     int
+	}{{input.From, -input.Quantity}, {input.To, input.Quantity}} {
+		var row model.WarehouseStock
+		err := warehouseStockCollection().FindOne(context.Background(),
+			bson.M{"sku": input.SKU, "warehouse_id": leg.warehouse}).Decode(&row)
+		if err != nil {
+			log.Printf("failed to load stock of %s at warehouse %s: %v", input.SKU, leg.warehouse.Hex(), err)
+		}
+		recordAdjustment(model.StockAdjustment{
+			SKU:         input.SKU,
+			Delta:       leg.delta,
+			OnHandAfter: row.OnHand,
+			WarehouseId: leg.warehouse,
+			Reason:      model.AdjustTransfer,
+			Note:        input.Note,
+			Actor:       user.Id,
+		})
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Stock transferred successfully",
+	})
+}
+
+// AllocateOrder shows which warehouses would ship a list of items without
+// changing any stock.
+func AllocateOrder(ctx *gin.Context) {
+	var input struct {
+		Items []helpers.StockRequest `json:"items" binding:"required"`
+	}
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	items, err := helpers.NormalizeStockRequests(input.Items)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	warehouses, err := loadWarehouses()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to allocate order",
+			"error":   err.Error(),
+		})
+		return
+	}
+	skus := make([]string, len(items))
+	for i, item := range items {
+		skus[i] = item.SKU
+	}
+	stock, err := loadWarehouseInventory(skus)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to allocate order",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	allocation, err := helpers.AllocateFulfillment(items, warehouses, stock)
+	if err != nil {
+		var short *helpers.AllocationError
+		if errors.As(err, &short) {
+			ctx.JSON(http.StatusConflict, gin.H{
+				"message": insufficientStock,
+				"error":   err.Error(),
+				"sku":     short.SKU,
+			})
+			return
+		}
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to allocate order",
+			"error":   err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"data": allocation,
+	})
+}
@@ -0,0 +1,749 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joshua/casify/helpers"
+	"github.com/joshua/casify/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	wishlistShareColName = "wishlist_shares"
+	priceWatchColName    = "price_watches"
+)
+
+const (
+	wishlistNotFound     = "Wishlist not found"
+	wishlistItemNotFound = "Item not on this list"
+	wishlistExists       = "A list with this name already exists"
+)
+
+func wishlistShareCollection() *mongo.Collection {
+	return Client.Database(dbName).Collection(wishlistShareColName)
+}
+
+func priceWatchCollection() *mongo.Collection {
+	return Client.Database(dbName).Collection(priceWatchColName)
+}
+
+// ensureWishlistIndexes backs share revocation and the price drop check.
+// The lists themselves live in per-user collections.
+func ensureWishlistIndexes() error {
+	_, err := wishlistShareCollection().Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "wishlist_id", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = priceWatchCollection().Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{Keys: bson.D{{Key: "product_id", Value: 1}}},
+		{Keys: bson.D{{Key: "item_id", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "wishlist_id", Value: 1}}},
+	})
+	return err
+}
+
+// wishlistAttempts bounds how often updateWishlistItems starts over after
+// losing a race with another change to the same list.
+const wishlistAttempts = 5
+
+var (
+	// errWishlistExists is returned when a user already has a list by a
+	// name.
+	errWishlistExists = errors.New("wishlist name taken")
+	// errWishlistChanged is returned by saveWishlistItems when the items
+	// were saved by another request since the list was loaded.
+	errWishlistChanged = errors.New("wishlist was changed concurrently")
+	// errWishlistItemGone stops removeWishlistItem when the item is not on
+	// the list.
+	errWishlistItemGone = errors.New("item not on wishlist")
+)
+
+func respondWishlistError(ctx *gin.Context, err error, message string) {
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": wishlistNotFound,
+			"error":   err.Error(),
+		})
+	case errors.Is(err, errWishlistExists):
+		ctx.JSON(http.StatusConflict, gin.H{
+			"message": wishlistExists,
+			"error":   err.Error(),
+		})
+	case errors.Is(err, errWishlistChanged):
+		ctx.JSON(http.StatusConflict, gin.H{
+			"message": "Wishlist is being changed by another request, try again",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, helpers.ErrAlreadyWishlisted):
+		ctx.JSON(http.StatusConflict, gin.H{
+			"message": "Item already on this list",
+			"error":   err.Error(),
+		})
+	case errors.Is(err, helpers.ErrWishlistLimit):
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+	case errors.Is(err, helpers.ErrSKUNotSold):
+		respondCartError(ctx, err)
+	default:
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": message,
+			"error":   err.Error(),
+		})
+	}
+}
+
+// findUserWishlist loads a list of the user by id.
+func findUserWishlist(userId, id primitive.ObjectID) (*model.Wishlist, error) {
+	var wishlist model.Wishlist
+	err := userCollection(userId).FindOne(context.Background(),
+		bson.M{"_id": id, "type": model.WishlistType}).Decode(&wishlist)
+	if err != nil {
+		return nil, err
+	}
+	return &wishlist, nil
+}
+
+// findWishlist loads the signed in user's list named in the path.
+func findWishlist(ctx *gin.Context) (*model.Wishlist, bool) {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return nil, false
+	}
+	user, _ := currentUser(ctx)
+	wishlist, err := findUserWishlist(user.Id, id)
+	if err != nil {
+		respondWishlistError(ctx, err, "Failed to get wishlist")
+		return nil, false
+	}
+	return wishlist, true
+}
+
+// createWishlist saves a new, empty list for the user.
+func createWishlist(userId primitive.ObjectID, name string) (*model.Wishlist, error) {
+	collection := userCollection(userId)
+	count, err := collection.CountDocuments(context.Background(), bson.M{"type": model.WishlistType})
+	if err != nil {
+		return nil, err
+	}
+	if count >= helpers.MaxWishlists {
+		return nil, fmt.Errorf("%w: a user keeps at most %d lists", helpers.ErrWishlistLimit, helpers.MaxWishlists)
+	}
+	if err := checkWishlistName(userId, name, primitive.NilObjectID); err != nil {
+		return nil, err
+	}
+	wishlist := model.Wishlist{
+		Id:    primitive.NewObjectID(),
+		Type:  model.WishlistType,
+		Name:  name,
+		Items: []model.WishlistItem{},
+	}
+	wishlist.TimeStamp.CreatedAt = time.Now()
+	wishlist.TimeStamp.UpdatedAt = time.Now()
+	if _, err := collection.InsertOne(context.Background(), wishlist); err != nil {
+		return nil, err
+	}
+	return &wishlist, nil
+}
+
+// checkWishlistName makes sure the user has no other list by name.
+func checkWishlistName(userId primitive.ObjectID, name string, except primitive.ObjectID) error {
+	err := userCollection(userId).FindOne(context.Background(), bson.M{
+		"_id":  bson.M{"$ne": except},
+		"type": model.WishlistType,
+		"name": name,
+	}).Err()
+	if err == nil {
+		return fmt.Errorf("%w: %s", errWishlistExists, name)
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	return err
+}
+
+// saveWishlistItems stores the items of a list unless they changed since
+// the list was loaded, in which case errWishlistChanged is returned.
+func saveWishlistItems(userId primitive.ObjectID, wishlist *model.Wishlist) error {
+	filter := bson.M{"_id": wishlist.Id, "version": wishlist.Version}
+	if wishlist.Version == 0 {
+		// New lists, and lists saved before they had a version
+		filter["version"] = bson.M{"$in": bson.A{0, nil}}
+	}
+	updatedAt := time.Now()
+	result, err := userCollection(userId).UpdateOne(context.Background(), filter,
+		bson.M{"$set": bson.M{
+			"items":                 wishlist.Items,
+			"version":               wishlist.Version + 1,
+			"time_stamp.updated_at": updatedAt,
+		}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return errWishlistChanged
+	}
+	wishlist.Version++
+	wishlist.TimeStamp.UpdatedAt = updatedAt
+	return nil
+}
+
+// updateWishlistItems applies change to the items of a list and saves
+// them. When another request saved the list in between, the list is
+// reloaded and change applied again.
+func updateWishlistItems(userId primitive.ObjectID, wishlist *model.Wishlist, change func(wishlist *model.Wishlist) error) error {
+	for attempt := 1; ; attempt++ {
+		updated := *wishlist
+		updated.Items = append([]model.WishlistItem(nil), wishlist.Items...)
+		if err := change(&updated); err != nil {
+			return err
+		}
+		err := saveWishlistItems(userId, &updated)
+		if errors.Is(err, errWishlistChanged) && attempt < wishlistAttempts {
+			fresh, err := findUserWishlist(userId, wishlist.Id)
+			if err != nil {
+				return err
+			}
+			*wishlist = *fresh
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		*wishlist = updated
+		return nil
+	}
+}
+
+// addToWishlist adds a product, or one of its SKUs, to a list and starts
+// watching its price.
+func addToWishlist(userId primitive.ObjectID, wishlist *model.Wishlist, productId primitive.ObjectID, sku string) (model.WishlistItem, error) {
+	var product model.Product
+	err := Client.Database(dbName).Collection(colName).FindOne(context.Background(), bson.M{"_id": productId}).Decode(&product)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		err = fmt.Errorf("%w: product %s", helpers.ErrSKUNotSold, productId.Hex())
+	}
+	if err != nil {
+		return model.WishlistItem{}, err
+	}
+	item, err := helpers.NewWishlistItem(product, sku, time.Now())
+	if err != nil {
+		return item, err
+	}
+	err = updateWishlistItems(userId, wishlist, func(wishlist *model.Wishlist) error {
+		return helpers.AddWishlistItem(wishlist, item)
+	})
+	if err != nil {
+		return item, err
+	}
+	_, err = priceWatchCollection().InsertOne(context.Background(), model.PriceWatch{
+		UserId:     userId,
+		WishlistId: wishlist.Id,
+		ItemId:     item.Id,
+		ProductId:  item.ProductId,
+		SKU:        item.SKU,
+		Price:      item.PriceWhenAdded,
+	})
+	if err != nil {
+		log.Printf("failed to watch the price of wishlist item %s: %v", item.Id.Hex(), err)
+	}
+	return item, nil
+}
+
+// removeWishlistItem takes an item off a list and stops watching it. ok is
+// false when the item is not on the list.
+func removeWishlistItem(userId primitive.ObjectID, wishlist *model.Wishlist, itemId primitive.ObjectID) (model.WishlistItem, bool, error) {
+	var removed model.WishlistItem
+	err := updateWishlistItems(userId, wishlist, func(wishlist *model.Wishlist) error {
+		for i, item := range wishlist.Items {
+			if item.Id == itemId {
+				removed = item
+				wishlist.Items = append(wishlist.Items[:i], wishlist.Items[i+1:]...)
+				return nil
+			}
+		}
+		return errWishlistItemGone
+	})
+	if errors.Is(err, errWishlistItemGone) {
+		return model.WishlistItem{}, false, nil
+	}
+	if err != nil {
+		return removed, true, err
+	}
+	if _, err := priceWatchCollection().DeleteOne(context.Background(), bson.M{"item_id": itemId}); err != nil {
+		log.Printf("failed to stop watching wishlist item %s: %v", itemId.Hex(), err)
+	}
+	return removed, true, nil
+}
+
+// checkPriceDrops compares the prices of saved products with the last
+// price their watchers saw and publishes an event for every drop.
+func checkPriceDrops(products ...model.Product) {
+	byId := map[primitive.ObjectID]model.Product{}
+	ids := make([]primitive.ObjectID, 0, len(products))
+	for _, p := range products {
+		byId[p.Id] = p
+		ids = append(ids, p.Id)
+	}
+	cursor, err := priceWatchCollection().Find(context.Background(), bson.M{"product_id": bson.M{"$in": ids}})
+	if err != nil {
+		log.Printf("failed to check wishlist prices: %v", err)
+		return
+	}
+	var watches []model.PriceWatch
+	if err := cursor.All(context.Background(), &watches); err != nil {
+		log.Printf("failed to check wishlist prices: %v", err)
+		return
+	}
+	for _, watch := range watches {
+		p := byId[watch.ProductId]
+		price, ok := helpers.ItemPrice(p, watch.SKU)
+		if !ok || !helpers.ProductLive(p) || price == watch.Price {
+			continue
+		}
+		if helpers.PriceDropped(watch.Price, price) {
+			events.Publish(helpers.EventPriceDropped, helpers.PriceDrop{
+				UserId:     watch.UserId,
+				WishlistId: watch.WishlistId,
+				ProductId:  p.Id,
+				SKU:        watch.SKU,
+				Title:      p.Title,
+				OldPrice:   watch.Price,
+				NewPrice:   price,
+			})
+		}
+		// Remember the new price so only further drops notify again
+		_, err := priceWatchCollection().UpdateOne(context.Background(),
+			bson.M{"_id": watch.Id}, bson.M{"$set": bson.M{"price": price}})
+		if err != nil {
+			log.Printf("failed to update watched price of %s: %v", watch.ItemId.Hex(), err)
+		}
+	}
+}
+
+// respondWishlist writes a list with its items resolved against the
+// catalog.
+func respondWishlist(ctx *gin.Context, status int, message string, wishlist model.Wishlist) {
+	ids := make([]primitive.ObjectID, len(wishlist.Items))
+	for i, item := range wishlist.Items {
+		ids[i] = item.ProductId
+	}
+	products := []model.Product{}
+	if len(ids) > 0 {
+		cursor, err := Client.Database(dbName).Collection(colName).Find(context.Background(), bson.M{"_id": bson.M{"$in": ids}})
+		if err == nil {
+			err = cursor.All(context.Background(), &products)
+		}
+		if err != nil {
+			respondWishlistError(ctx, err, "Failed to get wishlist")
+			return
+		}
+	}
+	body := gin.H{"data": helpers.PriceWishlist(wishlist, products)}
+	if message != "" {
+		body["message"] = message
+	}
+	ctx.JSON(status, body)
+}
+
+func bindWishlistName(ctx *gin.Context) (string, bool) {
+	var input struct {
+		Name string `json:"name" binding:"required"`
+	}
+	err := ctx.ShouldBindJSON(&input)
+	if err == nil {
+		input.Name = strings.TrimSpace(input.Name)
+		err = helpers.ValidateWishlistName(input.Name)
+	}
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return "", false
+	}
+	return input.Name, true
+}
+
+func parseWishlistItemId(ctx *gin.Context) (primitive.ObjectID, bool) {
+	id, err := primitive.ObjectIDFromHex(ctx.Param("item"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return id, false
+	}
+	return id, true
+}
+
+// GetWishlists lists the signed in user's lists without their items.
+func GetWishlists(ctx *gin.Context) {
+	user, _ := currentUser(ctx)
+	cursor, err := userCollection(user.Id).Find(context.Background(),
+		bson.M{"type": model.WishlistType},
+		options.Find().SetSort(bson.D{{Key: "time_stamp.created_at", Value: 1}}))
+	if err != nil {
+		respondWishlistError(ctx, err, "Failed to get wishlists")
+		return
+	}
+	wishlists := []model.Wishlist{}
+	if err := cursor.All(context.Background(), &wishlists); err != nil {
+		respondWishlistError(ctx, err, "Failed to get wishlists")
+		return
+	}
+	summaries := make([]gin.H, len(wishlists))
+	for i, w := range wishlists {
+		summaries[i] = gin.H{
+			"id":          w.Id,
+			"name":        w.Name,
+			"item_count":  len(w.Items),
+			"share_token": w.ShareToken,
+			"time_stamp":  w.TimeStamp,
+		}
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"data": summaries,
+	})
+}
+
+// AddWishlist creates a named list.
+func AddWishlist(ctx *gin.Context) {
+	name, ok := bindWishlistName(ctx)
+	if !ok {
+		return
+	}
+	user, _ := currentUser(ctx)
+	wishlist, err := createWishlist(user.Id, name)
+	if err != nil {
+		respondWishlistError(ctx, err, "Failed to add wishlist")
+		return
+	}
+	respondWishlist(ctx, http.StatusCreated, "Wishlist added successfully", *wishlist)
+}
+
+// GetWishlist returns one of the signed in user's lists with current
+// prices.
+func GetWishlist(ctx *gin.Context) {
+	wishlist, ok := findWishlist(ctx)
+	if !ok {
+		return
+	}
+	respondWishlist(ctx, http.StatusOK, "", *wishlist)
+}
+
+// RenameWishlist changes the name of a list.
+func RenameWishlist(ctx *gin.Context) {
+	wishlist, ok := findWishlist(ctx)
+	if !ok {
+		return
+	}
+	name, ok := bindWishlistName(ctx)
+	if !ok {
+		return
+	}
+	user, _ := currentUser(ctx)
+	if err := checkWishlistName(user.Id, name, wishlist.Id); err != nil {
+		respondWishlistError(ctx, err, "Failed to rename wishlist")
+		return
+	}
+	wishlist.Name = name
+	wishlist.TimeStamp.UpdatedAt = time.Now()
+	_, err := userCollection(user.Id).UpdateOne(context.Background(),
+		bson.M{"_id": wishlist.Id},
+		bson.M{"$set": bson.M{"name": name, "time_stamp.updated_at": wishlist.TimeStamp.UpdatedAt}})
+	if err != nil {
+		respondWishlistError(ctx, err, "Failed to rename wishlist")
+		return
+	}
+	respondWishlist(ctx, http.StatusOK, "Wishlist renamed", *wishlist)
+}
+
+// DeleteWishlist removes a list, its share link and its price watches.
+func DeleteWishlist(ctx *gin.Context) {
+	wishlist, ok := findWishlist(ctx)
+	if !ok {
+		return
+	}
+	user, _ := currentUser(ctx)
+	if _, err := userCollection(user.Id).DeleteOne(context.Background(), bson.M{"_id": wishlist.Id}); err != nil {
+		respondWishlistError(ctx, err, "Failed to delete wishlist")
+		return
+	}
+	if _, err := wishlistShareCollection().DeleteMany(context.Background(), bson.M{"wishlist_id": wishlist.Id}); err != nil {
+		log.Printf("failed to revoke the share link of wishlist %s: %v", wishlist.Id.Hex(), err)
+	}
+	if _, err := priceWatchCollection().DeleteMany(context.Background(), bson.M{"wishlist_id": wishlist.Id}); err != nil {
+		log.Printf("failed to stop watching wishlist %s: %v", wishlist.Id.Hex(), err)
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Wishlist deleted successfully",
+	})
+}
+
+// AddWishlistItem saves a product to a list. "sku" picks a variant.
+func AddWishlistItem(ctx *gin.Context) {
+	wishlist, ok := findWishlist(ctx)
+	if !ok {
+		return
+	}
+	var input struct {
+		ProductId primitive.ObjectID `json:"product_id" binding:"required"`
+		SKU       string             `json:"sku"`
+	}
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+	user, _ := currentUser(ctx)
+	if _, err := addToWishlist(user.Id, wishlist, input.ProductId, strings.TrimSpace(input.SKU)); err != nil {
+		respondWishlistError(ctx, err, "Failed to add item")
+		return
+	}
+	respondWishlist(ctx, http.StatusOK, "Item added to wishlist", *wishlist)
+}
+
+// RemoveWishlistItem takes an item off a list.
+func RemoveWishlistItem(ctx *gin.Context) {
+	wishlist, ok := findWishlist(ctx)
+	if !ok {
+		return
+	}
+	itemId, ok := parseWishlistItemId(ctx)
+	if !ok {
+		return
+	}
+	user, _ := currentUser(ctx)
+	_, found, err := removeWishlistItem(user.Id, wishlist, itemId)
+	if !found {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": wishlistItemNotFound,
+		})
+		return
+	}
+	if err != nil {
+		respondWishlistError(ctx, err, "Failed to remove item")
+		return
+	}
+	respondWishlist(ctx, http.StatusOK, "Item removed from wishlist", *wishlist)
+}
+
+// MoveWishlistItemToCart puts an item in the cart, "quantity" units
+// (default 1), and takes it off the list.
+func MoveWishlistItemToCart(ctx *gin.Context) {
+	wishlist, ok := findWishlist(ctx)
+	if !ok {
+		return
+	}
+	itemId, ok := parseWishlistItemId(ctx)
+	if !ok {
+		return
+	}
+	var input struct {
+		Quantity int `json:"quantity"`
+	}
+	if body, _ := ctx.GetRawData(); len(body) > 0 {
+		if err := json.Unmarshal(body, &input); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"message": invalidBody,
+				"error":   err.Error(),
+			})
+			return
+		}
+	}
+	if input.Quantity == 0 {
+		input.Quantity = 1
+	}
+
+	var item *model.WishlistItem
+	for i := range wishlist.Items {
+		if wishlist.Items[i].Id == itemId {
+			item = &wishlist.Items[i]
+		}
+	}
+	if item == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": wishlistItemNotFound,
+		})
+		return
+	}
+	var product model.Product
+	err := Client.Database(dbName).Collection(colName).FindOne(context.Background(), bson.M{"_id": item.ProductId}).Decode(&product)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		err = fmt.Errorf("%w: product %s", helpers.ErrSKUNotSold, item.ProductId.Hex())
+	}
+	if err != nil {
+		respondCartError(ctx, err)
+		return
+	}
+	sku, err := helpers.CartSKU(product, item.SKU)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": invalidBody,
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	user, _ := currentUser(ctx)
+	cart, err := addToCart(cartRef{collection: userCollection(user.Id), id: userCartId}, sku, input.Quantity)
+	if err != nil {
+		respondCartError(ctx, err)
+		return
+	}
+	if _, _, err := removeWishlistItem(user.Id, wishlist, itemId); err != nil {
+		log.Printf("failed to remove moved item %s from wishlist %s: %v", itemId.Hex(), wishlist.Id.Hex(), err)
+	}
+	respondCart(ctx, http.StatusOK, "Item moved to cart", cart)
+}
+
+// SaveCartItemForLater moves a SKU from the cart to the user's "Saved for
+// later" list, creating the list on first use.
+func SaveCartItemForLater(ctx *gin.Context) {
+	user, _ := currentUser(ctx)
+	ref := cartRef{collection: userCollection(user.Id), id: userCartId}
+	cart, err := loadCart(ref)
+	if err != nil {
+		respondCartError(ctx, err)
+		return
+	}
+	sku := ctx.Param("sku")
+	var line *model.CartItem
+	for i := range cart.Items {
+		if cart.Items[i].SKU == sku {
+			line = &cart.Items[i]
+		}
+	}
+	if line == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": itemNotInCart,
+		})
+		return
+	}
+
+	var wishlist model.Wishlist
+	err = userCollection(user.Id).FindOne(context.Background(),
+		bson.M{"type": model.WishlistType, "name": model.SavedForLater}).Decode(&wishlist)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		var created *model.Wishlist
+		if created, err = createWishlist(user.Id, model.SavedForLater); err == nil {
+			wishlist = *created
+		}
+	}
+	if err != nil {
+		respondWishlistError(ctx, err, "Failed to save item for later")
+		return
+	}
+	_, err = addToWishlist(user.Id, &wishlist, line.ProductId, sku)
+	if err != nil && !errors.Is(err, helpers.ErrAlreadyWishlisted) {
+		respondWishlistError(ctx, err, "Failed to save item for later")
+		return
+	}
+
+	cart, err = updateCart(ref, func(cart *model.Cart) error {
+		helpers.SetCartQuantity(cart, sku, 0, helpers.CartLimitsFromEnv())
+		return nil
+	})
+	if err != nil {
+		respondCartError(ctx, err)
+		return
+	}
+	respondCart(ctx, http.StatusOK, "Item saved for later", cart)
+}
+
+// ShareWishlist creates a share link for a list, or returns the existing
+// one.
+func ShareWishlist(ctx *gin.Context) {
+	wishlist, ok := findWishlist(ctx)
+	if !ok {
+		return
+	}
+	user, _ := currentUser(ctx)
+	if wishlist.ShareToken == "" {
+		token, err := helpers.RandomToken(16)
+		if err == nil {
+			_, err = wishlistShareCollection().InsertOne(context.Background(), model.WishlistShare{
+				Token:      token,
+				UserId:     user.Id,
+				WishlistId: wishlist.Id,
+				CreatedAt:  time.Now(),
+			})
+		}
+		if err == nil {
+			_, err = userCollection(user.Id).UpdateOne(context.Background(),
+				bson.M{"_id": wishlist.Id}, bson.M{"$set": bson.M{"share_token": token}})
+		}
+		if err != nil {
+			respondWishlistError(ctx, err, "Failed to share wishlist")
+			return
+		}
+		wishlist.ShareToken = token
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Wishlist shared",
+		"token":   wishlist.ShareToken,
+		"url":     "/api/v1/wishlists/shared/" + wishlist.ShareToken,
+	})
+}
+
+// UnshareWishlist revokes the share link of a list.
+func UnshareWishlist(ctx *gin.Context) {
+	wishlist, ok := findWishlist(ctx)
+	if !ok {
+		return
+	}
+	user, _ := currentUser(ctx)
+	if _, err := wishlistShareCollection().DeleteMany(context.Background(), bson.M{"wishlist_id": wishlist.Id}); err != nil {
+		respondWishlistError(ctx, err, "Failed to unshare wishlist")
+		return
+	}
+	_, err := userCollection(user.Id).UpdateOne(context.Background(),
+		bson.M{"_id": wishlist.Id}, bson.M{"$unset": bson.M{"share_token": ""}})
+	if err != nil {
+		respondWishlistError(ctx, err, "Failed to unshare wishlist")
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "Wishlist is no longer shared",
+	})
+}
+
+// GetSharedWishlist shows a shared list to anyone with its link. The share
+// token itself is not echoed back.
+func GetSharedWishlist(ctx *gin.Context) {
+	var share model.WishlistShare
+	err := wishlistShareCollection().FindOne(context.Background(), bson.M{"_id": ctx.Param("token")}).Decode(&share)
+	if err != nil {
+		respondWishlistError(ctx, err, "Failed to get wishlist")
+		return
+	}
+	wishlist, err := findUserWishlist(share.UserId, share.WishlistId)
+	if err != nil {
+		respondWishlistError(ctx, err, "Failed to get wishlist")
+		return
+	}
+	wishlist.ShareToken = ""
+	respondWishlist(ctx, http.StatusOK, "", *wishlist)
+}
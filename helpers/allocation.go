@@ -0,0 +1,115 @@
+package helpers
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/joshua/casify/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// WarehouseInventory maps a warehouse to the on-hand quantity of each SKU
+// stored there.
+type WarehouseInventory map[primitive.ObjectID]map[string]int
+
+// Shipment is the part of an order sent from one warehouse.
+type Shipment struct {
+	WarehouseId primitive.ObjectID `json:"warehouse_id"`
+	Code        string             `json:"code"`
+	Items       []StockRequest     `json:"items"`
+}
+
+// Allocation is the plan for fulfilling an order.
+type Allocation struct {
+	Shipments []Shipment `json:"shipments"`
+	Split     bool       `json:"split"`
+}
+
+// AllocationError reports a SKU the warehouses cannot cover.
+type AllocationError struct {
+	SKU       string
+	Requested int
+	Short     int
+}
+
+func (e *AllocationError) Error() string {
+	return fmt.Sprintf("warehouses are %d short of %d %s", e.Short, e.Requested, e.SKU)
+}
+
+// FulfillmentOrder sorts active warehouses by priority, breaking ties by
+// code so the order never depends on how they were loaded.
+func FulfillmentOrder(warehouses []model.Warehouse) []model.Warehouse {
+	active := make([]model.Warehouse, 0, len(warehouses))
+	for _, w := range warehouses {
+		if w.Active {
+			active = append(active, w)
+		}
+	}
+	sort.SliceStable(active, func(i, j int) bool {
+		if active[i].Priority != active[j].Priority {
+			return active[i].Priority < active[j].Priority
+		}
+		return active[i].Code < active[j].Code
+	})
+	return active
+}
+
+// AllocateFulfillment picks the warehouses an order ships from. The first
+// warehouse in priority order that holds every item ships the whole order.
+// When none does, each item is taken from warehouses in priority order
+// until it is covered, splitting the order into several shipments. The
+// result only depends on its inputs.
+func AllocateFulfillment(items []StockRequest, warehouses []model.Warehouse, stock WarehouseInventory) (*Allocation, error) {
+	items, err := NormalizeStockRequests(items)
+	if err != nil {
+		return nil, err
+	}
+	ordered := FulfillmentOrder(warehouses)
+
+	for _, w := range ordered {
+		if canFulfill(items, stock[w.Id]) {
+			return &Allocation{Shipments: []Shipment{{WarehouseId: w.Id, Code: w.Code, Items: items}}}, nil
+		}
+	}
+
+	taken := map[primitive.ObjectID][]StockRequest{}
+	for _, item := range items {
+		remaining := item.Quantity
+		for _, w := range ordered {
+			if remaining == 0 {
+				break
+			}
+			available := stock[w.Id][item.SKU]
+			if available <= 0 {
+				continue
+			}
+			qty := available
+			if qty > remaining {
+				qty = remaining
+			}
+			taken[w.Id] = append(taken[w.Id], StockRequest{SKU: item.SKU, Quantity: qty})
+			remaining -= qty
+		}
+		if remaining > 0 {
+			return nil, &AllocationError{SKU: item.SKU, Requested: item.Quantity, Short: remaining}
+		}
+	}
+
+	allocation := &Allocation{}
+	for _, w := range ordered {
+		if lines, ok := taken[w.Id]; ok {
+			allocation.Shipments = append(allocation.Shipments, Shipment{WarehouseId: w.Id, Code: w.Code, Items: lines})
+		}
+	}
+	allocation.Split = len(allocation.Shipments) > 1
+	return allocation, nil
+}
+
+func canFulfill(items []StockRequest, stock map[string]int) bool {
+	for _, item := range items {
+		if stock[item.SKU] < item.Quantity {
+			return false
+		}
+	}
+	return true
+}
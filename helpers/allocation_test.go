@@ -0,0 +1,93 @@
+package helpers
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/joshua/casify/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestAllocateFulfillment(t *testing.T) {
+	east := model.Warehouse{Id: primitive.NewObjectID(), Code: "EAST", Priority: 1, Active: true}
+	west := model.Warehouse{Id: primitive.NewObjectID(), Code: "WEST", Priority: 1, Active: true}
+	north := model.Warehouse{Id: primitive.NewObjectID(), Code: "NORTH", Priority: 2, Active: true}
+	closed := model.Warehouse{Id: primitive.NewObjectID(), Code: "ALPHA", Priority: 0, Active: false}
+
+	tests := []struct {
+		name       string
+		items      []StockRequest
+		warehouses []model.Warehouse
+		stock      WarehouseInventory
+		want       *Allocation
+		wantErr    *AllocationError
+	}{
+		{
+			name:       "single warehouse",
+			items:      []StockRequest{{SKU: "case-black", Quantity: 2}, {SKU: "case-red", Quantity: 1}},
+			warehouses: []model.Warehouse{north},
+			stock:      WarehouseInventory{north.Id: {"case-black": 5, "case-red": 1}},
+			want: &Allocation{Shipments: []Shipment{
+				{WarehouseId: north.Id, Code: "NORTH", Items: []StockRequest{{SKU: "case-black", Quantity: 2}, {SKU: "case-red", Quantity: 1}}},
+			}},
+		},
+		{
+			name:       "priority tie broken by code",
+			items:      []StockRequest{{SKU: "case-black", Quantity: 1}},
+			warehouses: []model.Warehouse{north, west, east, closed},
+			stock: WarehouseInventory{
+				closed.Id: {"case-black": 10},
+				west.Id:   {"case-black": 10},
+				east.Id:   {"case-black": 10},
+				north.Id:  {"case-black": 10},
+			},
+			want: &Allocation{Shipments: []Shipment{
+				{WarehouseId: east.Id, Code: "EAST", Items: []StockRequest{{SKU: "case-black", Quantity: 1}}},
+			}},
+		},
+		{
+			name:       "split across warehouses",
+			items:      []StockRequest{{SKU: "case-black", Quantity: 4}, {SKU: "case-red", Quantity: 1}},
+			warehouses: []model.Warehouse{north, east},
+			stock: WarehouseInventory{
+				east.Id:  {"case-black": 3},
+				north.Id: {"case-black": 2, "case-red": 1},
+			},
+			want: &Allocation{Split: true, Shipments: []Shipment{
+				{WarehouseId: east.Id, Code: "EAST", Items: []StockRequest{{SKU: "case-black", Quantity: 3}}},
+				{WarehouseId: north.Id, Code: "NORTH", Items: []StockRequest{{SKU: "case-black", Quantity: 1}, {SKU: "case-red", Quantity: 1}}},
+			}},
+		},
+		{
+			name:       "not enough stock",
+			items:      []StockRequest{{SKU: "case-black", Quantity: 6}},
+			warehouses: []model.Warehouse{east, north, closed},
+			stock: WarehouseInventory{
+				closed.Id: {"case-black": 10},
+				east.Id:   {"case-black": 3},
+				north.Id:  {"case-black": 1},
+			},
+			wantErr: &AllocationError{SKU: "case-black", Requested: 6, Short: 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := AllocateFulfillment(tt.items, tt.warehouses, tt.stock)
+			if tt.wantErr != nil {
+				var allocErr *AllocationError
+				if !errors.As(err, &allocErr) || *allocErr != *tt.wantErr {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("allocation = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
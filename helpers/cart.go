@@ -0,0 +1,224 @@
+package helpers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/joshua/casify/model"
+)
+
+// CartLimits bounds what a cart may hold.
+type CartLimits struct {
+	MaxQuantity int
+	MaxLines    int
+}
+
+// CartLimitsFromEnv reads CART_MAX_QUANTITY, the most units of one SKU
+// (default 10), and CART_MAX_LINES, the most SKUs in a cart (default 50).
+func CartLimitsFromEnv() CartLimits {
+	limits := CartLimits{MaxQuantity: 10, MaxLines: 50}
+	if n, err := strconv.Atoi(os.Getenv("CART_MAX_QUANTITY")); err == nil && n > 0 {
+		limits.MaxQuantity = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("CART_MAX_LINES")); err == nil && n > 0 {
+		limits.MaxLines = n
+	}
+	return limits
+}
+
+// GuestCartTTLFromEnv reads GUEST_CART_TTL, a Go duration such as "720h",
+// how long an untouched guest cart is kept. It defaults to 30 days.
+func GuestCartTTLFromEnv() time.Duration {
+	if ttl, err := time.ParseDuration(os.Getenv("GUEST_CART_TTL")); err == nil && ttl > 0 {
+		return ttl
+	}
+	return 30 * 24 * time.Hour
+}
+
+var (
+	// ErrSKUNotSold is returned for SKUs no live product sells.
+	ErrSKUNotSold = errors.New("sku is not for sale")
+	// ErrCartLimit is returned when a change breaks the cart limits.
+	ErrCartLimit = errors.New("cart limit exceeded")
+)
+
+// CartItemFor builds the cart item for a SKU of p at today's sale price.
+func CartItemFor(p model.Product, sku string, quantity int, now time.Time) (model.CartItem, error) {
+	item := model.CartItem{SKU: sku, ProductId: p.Id, Title: p.Title, Quantity: quantity, AddedAt: now}
+	price, options, ok := SKUPrice(p, sku)
+	if !ok || !ProductLive(p) {
+		return item, fmt.Errorf("%w: %s", ErrSKUNotSold, sku)
+	}
+	item.UnitPrice, item.Options = price, options
+	return item, nil
+}
+
+// SKUPrice returns the sale price and option values of a SKU of p, after
+// the product discount. ok is false when p does not sell the SKU.
+func SKUPrice(p model.Product, sku string) (price float64, options map[string]string, ok bool) {
+	for _, v := range p.Variants {
+		if v.SKU == sku {
+			return roundCents(DiscountedPrice(VariantPrice(p, v), p.Discount)), v.Options, true
+		}
+	}
+	if len(p.Variants) == 0 && p.SKU != "" && p.SKU == sku {
+		return roundCents(DiscountedPrice(p.Price, p.Discount)), nil, true
+	}
+	return 0, nil, false
+}
+
+func roundCents(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+func checkCartQuantity(quantity int, limits CartLimits) error {
+	if quantity < 1 {
+		return fmt.Errorf("%w: quantity must be at least 1", ErrCartLimit)
+	}
+	if quantity > limits.MaxQuantity {
+		return fmt.Errorf("%w: at most %d of an item can be ordered", ErrCartLimit, limits.MaxQuantity)
+	}
+	return nil
+}
+
+// AddCartItem adds item to the cart, adding to the quantity when the SKU is
+// already there. The price snapshot is kept from the first add.
+func AddCartItem(cart *model.Cart, item model.CartItem, limits CartLimits) error {
+	for i, existing := range cart.Items {
+		if existing.SKU == item.SKU {
+			if err := checkCartQuantity(existing.Quantity+item.Quantity, limits); err != nil {
+				return err
+			}
+			cart.Items[i].Quantity += item.Quantity
+			return nil
+		}
+	}
+	if err := checkCartQuantity(item.Quantity, limits); err != nil {
+		return err
+	}
+	if len(cart.Items) >= limits.MaxLines {
+		return fmt.Errorf("%w: a cart holds at most %d items", ErrCartLimit, limits.MaxLines)
+	}
+	cart.Items = append(cart.Items, item)
+	return nil
+}
+
+// SetCartQuantity changes the quantity of a SKU in the cart. Zero removes
+// it. ok is false when the SKU is not in the cart.
+func SetCartQuantity(cart *model.Cart, sku string, quantity int, limits CartLimits) (ok bool, err error) {
+	for i, item := range cart.Items {
+		if item.SKU != sku {
+			continue
+		}
+		if quantity == 0 {
+			cart.Items = append(cart.Items[:i], cart.Items[i+1:]...)
+			return true, nil
+		}
+		if err := checkCartQuantity(quantity, limits); err != nil {
+			return true, err
+		}
+		cart.Items[i].Quantity = quantity
+		return true, nil
+	}
+	return false, nil
+}
+
+// MergeCarts adds a guest cart to a user's cart. Quantities of SKUs in both
+// are summed up to the quantity limit; guest items that do not fit the
+// line limit are dropped.
+func MergeCarts(user, guest model.Cart, limits CartLimits) model.Cart {
+	merged := user
+	merged.Items = append([]model.CartItem(nil), user.Items...)
+	for _, item := range guest.Items {
+		found := false
+		for i := range merged.Items {
+			if merged.Items[i].SKU == item.SKU {
+				merged.Items[i].Quantity = min(merged.Items[i].Quantity+item.Quantity, limits.MaxQuantity)
+				found = true
+				break
+			}
+		}
+		if !found && len(merged.Items) < limits.MaxLines {
+			item.Quantity = min(item.Quantity, limits.MaxQuantity)
+			merged.Items = append(merged.Items, item)
+		}
+	}
+	return merged
+}
+
+// RemoveOrderedItems takes the quantities of an order out of the cart it
+// was placed from, keeping anything added meanwhile.
+func RemoveOrderedItems(cart *model.Cart, lines []model.OrderLine) {
+	ordered := map[string]int{}
+	for _, line := range lines {
+		ordered[line.SKU] += line.Quantity
+	}
+	kept := cart.Items[:0]
+	for _, item := range cart.Items {
+		item.Quantity -= ordered[item.SKU]
+		if item.Quantity > 0 {
+			kept = append(kept, item)
+		}
+	}
+	cart.Items = kept
+}
+
+// CartLine is a cart item priced against the current catalog.
+type CartLine struct {
+	model.CartItem
+	// Price is what the item sells for now.
+	Price        float64 `json:"price"`
+	PriceChanged bool    `json:"price_changed"`
+	LineTotal    float64 `json:"line_total"`
+	// Purchasable is false when the product was unpublished or the SKU
+	// removed. Such lines are left out of the subtotal.
+	Purchasable bool `json:"purchasable"`
+	InStock     bool `json:"in_stock"`
+}
+
+// CartView is a cart as shown to the shopper.
+type CartView struct {
+	Items     []CartLine `json:"items"`
+	ItemCount int        `json:"item_count"`
+	Subtotal  float64    `json:"subtotal"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// PriceCart re-prices a cart against the products selling its SKUs and
+// their stock levels.
+func PriceCart(cart model.Cart, products []model.Product, levels []model.StockLevel) CartView {
+	bySKU := map[string]model.Product{}
+	for _, p := range products {
+		for _, sku := range SellableSKUs(p) {
+			bySKU[sku] = p
+		}
+	}
+	available := map[string]int{}
+	for _, level := range levels {
+		available[level.SKU] = level.Available()
+	}
+
+	view := CartView{Items: []CartLine{}, ExpiresAt: cart.ExpiresAt, UpdatedAt: cart.UpdatedAt}
+	for _, item := range cart.Items {
+		line := CartLine{CartItem: item, Price: item.UnitPrice}
+		if p, ok := bySKU[item.SKU]; ok && ProductLive(p) {
+			line.Price, _, line.Purchasable = SKUPrice(p, item.SKU)
+			line.Title = p.Title
+		}
+		if line.Purchasable {
+			line.PriceChanged = line.Price != item.UnitPrice
+			line.LineTotal = roundCents(line.Price * float64(item.Quantity))
+			line.InStock = available[item.SKU] >= item.Quantity
+			view.Subtotal += line.LineTotal
+			view.ItemCount += item.Quantity
+		}
+		view.Items = append(view.Items, line)
+	}
+	view.Subtotal = roundCents(view.Subtotal)
+	return view
+}
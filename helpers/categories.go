@@ -0,0 +1,291 @@
+package helpers
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/joshua/casify/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var slugSeparators = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Slugify turns a name into a lowercase, URL safe slug, e.g.
+// "Cases > iPhone 15" becomes "cases-iphone-15".
+func Slugify(name string) string {
+	return strings.Trim(slugSeparators.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+// UniqueSlug returns base, or base with the lowest numeric suffix that
+// taken reports as free.
+func UniqueSlug(base string, taken func(slug string) bool) string {
+	if !taken(base) {
+		return base
+	}
+	for i := 2; ; i++ {
+		slug := fmt.Sprintf("%s-%d", base, i)
+		if !taken(slug) {
+			return slug
+		}
+	}
+}
+
+// CategoryNode is a category with its children, ordered by sort order and
+// then name.
+type CategoryNode struct {
+	model.Category
+	Children []*CategoryNode `json:"children"`
+}
+
+// Breadcrumb is one step of the path from a root category.
+type Breadcrumb struct {
+	Id   primitive.ObjectID `json:"id"`
+	Name string             `json:"name"`
+	Slug string             `json:"slug"`
+}
+
+// CategoryTree indexes the category tree by id and slug.
+type CategoryTree struct {
+	Roots  []*CategoryNode
+	byId   map[primitive.ObjectID]*CategoryNode
+	bySlug map[string]*CategoryNode
+}
+
+// BuildCategoryTree links categories to their parents. Categories whose
+// parent is missing are treated as roots.
+func BuildCategoryTree(categories []model.Category) *CategoryTree {
+	t := &CategoryTree{
+		byId:   make(map[primitive.ObjectID]*CategoryNode, len(categories)),
+		bySlug: make(map[string]*CategoryNode, len(categories)),
+	}
+	for _, c := range categories {
+		node := &CategoryNode{Category: c, Children: []*CategoryNode{}}
+		t.byId[c.Id] = node
+		t.bySlug[c.Slug] = node
+	}
+	for _, c := range categories {
+		t.link(t.byId[c.Id])
+	}
+	t.sort(t.Roots)
+	return t
+}
+
+func (t *CategoryTree) link(node *CategoryNode) {
+	if node.ParentId != nil {
+		if parent, ok := t.byId[*node.ParentId]; ok {
+			parent.Children = append(parent.Children, node)
+			return
+		}
+	}
+	t.Roots = append(t.Roots, node)
+}
+
+func (t *CategoryTree) sort(nodes []*CategoryNode) {
+	sort.SliceStable(nodes, func(i, j int) bool {
+		if nodes[i].SortOrder != nodes[j].SortOrder {
+			return nodes[i].SortOrder < nodes[j].SortOrder
+		}
+		return nodes[i].Name < nodes[j].Name
+	})
+	for _, n := range nodes {
+		t.sort(n.Children)
+	}
+}
+
+// Add inserts a new category into the tree.
+func (t *CategoryTree) Add(c model.Category) *CategoryNode {
+	node := &CategoryNode{Category: c, Children: []*CategoryNode{}}
+	t.byId[c.Id] = node
+	t.bySlug[c.Slug] = node
+	t.link(node)
+	return node
+}
+
+// Get returns the category with the given id.
+func (t *CategoryTree) Get(id primitive.ObjectID) (*CategoryNode, bool) {
+	node, ok := t.byId[id]
+	return node, ok
+}
+
+// Lookup finds a category by id or by slug.
+func (t *CategoryTree) Lookup(ref string) (*CategoryNode, bool) {
+	if id, err := primitive.ObjectIDFromHex(ref); err == nil {
+		if node, ok := t.byId[id]; ok {
+			return node, true
+		}
+	}
+	node, ok := t.bySlug[ref]
+	return node, ok
+}
+
+// SlugTaken reports whether a category other than except uses slug.
+func (t *CategoryTree) SlugTaken(slug string, except primitive.ObjectID) bool {
+	node, ok := t.bySlug[slug]
+	return ok && node.Id != except
+}
+
+// Subtree returns the id of a category and of every category below it.
+func (t *CategoryTree) Subtree(id primitive.ObjectID) []primitive.ObjectID {
+	node, ok := t.byId[id]
+	if !ok {
+		return nil
+	}
+	ids := []primitive.ObjectID{}
+	var walk func(n *CategoryNode)
+	walk = func(n *CategoryNode) {
+		ids = append(ids, n.Id)
+		for _, child := range n.Children {
+			walk(child)
+		}
+	}
+	walk(node)
+	return ids
+}
+
+// Breadcrumbs returns the path from the root down to a category.
+func (t *CategoryTree) Breadcrumbs(id primitive.ObjectID) []Breadcrumb {
+	crumbs := []Breadcrumb{}
+	seen := map[primitive.ObjectID]bool{}
+	for node, ok := t.byId[id]; ok && !seen[node.Id]; {
+		seen[node.Id] = true
+		crumbs = append(crumbs, Breadcrumb{Id: node.Id, Name: node.Name, Slug: node.Slug})
+		if node.ParentId == nil {
+			break
+		}
+		node, ok = t.byId[*node.ParentId]
+	}
+	for i, j := 0, len(crumbs)-1; i < j; i, j = i+1, j-1 {
+		crumbs[i], crumbs[j] = crumbs[j], crumbs[i]
+	}
+	return crumbs
+}
+
+// CheckParent verifies that parent can become the parent of id: it must
+// exist and must not be id itself or one of its descendants.
+func (t *CategoryTree) CheckParent(id primitive.ObjectID, parent *primitive.ObjectID) error {
+	if parent == nil {
+		return nil
+	}
+	if _, ok := t.byId[*parent]; !ok {
+		return errors.New("parent category does not exist")
+	}
+	for _, descendant := range t.Subtree(id) {
+		if descendant == *parent {
+			return errors.New("a category cannot be moved below itself")
+		}
+	}
+	return nil
+}
+
+// Child returns the child of parent, or the root when parent is nil, whose
+// name has the same slug as name.
+func (t *CategoryTree) Child(parent *primitive.ObjectID, name string) (*CategoryNode, bool) {
+	siblings := t.Roots
+	if parent != nil {
+		node, ok := t.byId[*parent]
+		if !ok {
+			return nil, false
+		}
+		siblings = node.Children
+	}
+	slug := Slugify(name)
+	for _, s := range siblings {
+		if Slugify(s.Name) == slug {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// Names returns the names of the categories with the given ids, failing on
+// an unknown id.
+func (t *CategoryTree) Names(ids []primitive.ObjectID) ([]string, error) {
+	names := make([]string, 0, len(ids))
+	for _, id := range ids {
+		node, ok := t.byId[id]
+		if !ok {
+			return nil, fmt.Errorf("unknown category %s", id.Hex())
+		}
+		names = append(names, node.Name)
+	}
+	return names, nil
+}
+
+// ParseCategoryPath splits a legacy category such as "Cases > iPhone" or
+// "Cases/iPhone" into its segments.
+func ParseCategoryPath(legacy string) []string {
+	var segments []string
+	for _, part := range strings.FieldsFunc(legacy, func(r rune) bool { return r == '>' || r == '/' }) {
+		if part = strings.TrimSpace(part); part != "" {
+			segments = append(segments, part)
+		}
+	}
+	return segments
+}
+
+// CategoryMigration is the plan for mapping free-form category strings
+// onto the tree.
+type CategoryMigration struct {
+	// Mapping maps each legacy string to the category it becomes.
+	Mapping map[string]primitive.ObjectID `json:"mapping"`
+	// Created lists the categories to create, parents before children.
+	Created  []model.Category `json:"created"`
+	Unmapped []string         `json:"unmapped"`
+}
+
+// PlanCategoryMigration maps legacy category strings onto the tree. An
+// entry in overrides, keyed by the lowercased legacy string, wins. Other
+// strings are read as a path and matched segment by segment on slugs, so
+// "Leather" and "leather" land on the same category. Missing segments are
+// planned as new categories when create is set and added to tree.
+func PlanCategoryMigration(legacy []string, tree *CategoryTree, overrides map[string]primitive.ObjectID, create bool) CategoryMigration {
+	plan := CategoryMigration{
+		Mapping:  map[string]primitive.ObjectID{},
+		Created:  []model.Category{},
+		Unmapped: []string{},
+	}
+	sorted := append([]string(nil), legacy...)
+	sort.Strings(sorted)
+
+	for _, name := range sorted {
+		if id, ok := overrides[strings.ToLower(strings.TrimSpace(name))]; ok {
+			plan.Mapping[name] = id
+			continue
+		}
+
+		var parent *primitive.ObjectID
+		var node *CategoryNode
+		segments := ParseCategoryPath(name)
+		for _, segment := range segments {
+			child, ok := tree.Child(parent, segment)
+			if !ok {
+				if !create || Slugify(segment) == "" {
+					node = nil
+					break
+				}
+				category := model.Category{
+					Id:       primitive.NewObjectID(),
+					Name:     segment,
+					ParentId: parent,
+				}
+				category.Slug = UniqueSlug(Slugify(segment), func(slug string) bool {
+					return tree.SlugTaken(slug, primitive.NilObjectID)
+				})
+				child = tree.Add(category)
+				plan.Created = append(plan.Created, category)
+			}
+			node = child
+			id := child.Id
+			parent = &id
+		}
+		if node == nil {
+			plan.Unmapped = append(plan.Unmapped, name)
+			continue
+		}
+		plan.Mapping[name] = node.Id
+	}
+	return plan
+}
@@ -0,0 +1,141 @@
+package helpers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/joshua/casify/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// maxCollectionProducts caps the hand-picked list of a manual collection.
+const maxCollectionProducts = 500
+
+// ValidateCollection checks a collection's type, rule and schedule.
+func ValidateCollection(c model.Collection) error {
+	if strings.TrimSpace(c.Title) == "" {
+		return errors.New("title is required")
+	}
+	switch c.Type {
+	case model.CollectionManual:
+		if c.Rule != "" {
+			return errors.New("manual collections cannot have a rule")
+		}
+		if len(c.ProductIds) > maxCollectionProducts {
+			return fmt.Errorf("manual collections hold at most %d products", maxCollectionProducts)
+		}
+		if err := checkUniqueIds(c.ProductIds, "product_ids"); err != nil {
+			return err
+		}
+	case model.CollectionRule:
+		if strings.TrimSpace(c.Rule) == "" {
+			return errors.New("rule collections need a rule")
+		}
+		if len(c.ProductIds) > 0 {
+			return errors.New("rule collections cannot list products, pin them instead")
+		}
+		if _, err := ParseFilter(c.Rule); err != nil {
+			return err
+		}
+		if _, err := ParseSort(c.Sort); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("type must be %q or %q", model.CollectionManual, model.CollectionRule)
+	}
+	if err := checkUniqueIds(c.Pinned, "pinned"); err != nil {
+		return err
+	}
+	if c.StartsAt != nil && c.EndsAt != nil && !c.EndsAt.After(*c.StartsAt) {
+		return errors.New("ends_at must be after starts_at")
+	}
+	return nil
+}
+
+func checkUniqueIds(ids []primitive.ObjectID, field string) error {
+	seen := make(map[primitive.ObjectID]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			return fmt.Errorf("%s lists %s twice", field, id.Hex())
+		}
+		seen[id] = true
+	}
+	return nil
+}
+
+// CollectionLive reports whether a collection is inside its scheduling
+// window at now.
+func CollectionLive(c model.Collection, now time.Time) bool {
+	if c.StartsAt != nil && now.Before(*c.StartsAt) {
+		return false
+	}
+	if c.EndsAt != nil && !now.Before(*c.EndsAt) {
+		return false
+	}
+	return true
+}
+
+// CollectionRule parses the membership rule of a rule collection.
+func CollectionRule(c model.Collection) (FilterNode, error) {
+	if c.Type != model.CollectionRule {
+		return nil, errors.New("not a rule collection")
+	}
+	return ParseFilter(c.Rule)
+}
+
+// CollectionContains reports whether a product belongs to a collection,
+// either by being listed, pinned or matching the rule.
+func CollectionContains(c model.Collection, p model.Product) bool {
+	for _, id := range c.Pinned {
+		if id == p.Id {
+			return true
+		}
+	}
+	if c.Type == model.CollectionManual {
+		for _, id := range c.ProductIds {
+			if id == p.Id {
+				return true
+			}
+		}
+		return false
+	}
+	node, err := CollectionRule(c)
+	if err != nil {
+		return false
+	}
+	return EvalFilter(node, p)
+}
+
+// CollectionOrder returns the products of a manual collection in display
+// order: pinned products first, then the listed ones not already pinned.
+func CollectionOrder(c model.Collection) []primitive.ObjectID {
+	ids := append([]primitive.ObjectID{}, c.Pinned...)
+	pinned := make(map[primitive.ObjectID]bool, len(c.Pinned))
+	for _, id := range c.Pinned {
+		pinned[id] = true
+	}
+	for _, id := range c.ProductIds {
+		if !pinned[id] {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// OrderByIds arranges products in the order of ids, dropping products that
+// are not listed.
+func OrderByIds(products []model.Product, ids []primitive.ObjectID) []model.Product {
+	byId := make(map[primitive.ObjectID]model.Product, len(products))
+	for _, p := range products {
+		byId[p.Id] = p
+	}
+	ordered := make([]model.Product, 0, len(ids))
+	for _, id := range ids {
+		if p, ok := byId[id]; ok {
+			ordered = append(ordered, p)
+		}
+	}
+	return ordered
+}
@@ -0,0 +1,67 @@
+package helpers
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// Event topics published by the controllers.
+const (
+	EventLowStock   = "inventory.low_stock"
+	EventOutOfStock = "inventory.out_of_stock"
+
+	EventReviewRejected = "review.rejected"
+	EventPriceDropped   = "product.price_dropped"
+)
+
+// Event is something that happened in the store that other parts of the
+// application may react to.
+type Event struct {
+	Topic   string      `json:"topic"`
+	Payload interface{} `json:"payload"`
+	At      time.Time   `json:"at"`
+}
+
+// EventHandler reacts to a published event.
+type EventHandler func(Event)
+
+// EventBus delivers events to the handlers subscribed to their topic. It is
+// safe for concurrent use.
+type EventBus struct {
+	mu       sync.RWMutex
+	handlers map[string][]EventHandler
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{handlers: map[string][]EventHandler{}}
+}
+
+// Subscribe registers handler for topic.
+func (b *EventBus) Subscribe(topic string, handler EventHandler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[topic] = append(b.handlers[topic], handler)
+}
+
+// Publish calls every handler subscribed to topic in the order they were
+// registered. Handlers run synchronously, so anything slow should hand the
+// work off to a goroutine. A panicking handler is logged and does not stop
+// the others.
+func (b *EventBus) Publish(topic string, payload interface{}) {
+	b.mu.RLock()
+	handlers := append([]EventHandler(nil), b.handlers[topic]...)
+	b.mu.RUnlock()
+
+	event := Event{Topic: topic, Payload: payload, At: time.Now()}
+	for _, handler := range handlers {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("event handler for %s panicked: %v", topic, r)
+				}
+			}()
+			handler(event)
+		}()
+	}
+}
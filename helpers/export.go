@@ -0,0 +1,319 @@
+package helpers
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joshua/casify/model"
+)
+
+// ExportColumn describes one column of a catalog export.
+type ExportColumn struct {
+	Name  string
+	Value func(p model.Product) interface{}
+}
+
+// ExportColumns lists every column that can be exported, in default order.
+var ExportColumns = []ExportColumn{
+	{"id", func(p model.Product) interface{} { return p.Id.Hex() }},
+	{"sku", func(p model.Product) interface{} { return p.SKU }},
+	{"title", func(p model.Product) interface{} { return p.Title }},
+	{"slug", func(p model.Product) interface{} { return p.Slug }},
+	{"status", func(p model.Product) interface{} { return p.Status }},
+	{"description", func(p model.Product) interface{} { return p.Description }},
+	{"price", func(p model.Product) interface{} { return p.Price }},
+	{"discount", func(p model.Product) interface{} { return p.Discount }},
+	{"rating", func(p model.Product) interface{} { return p.Rating }},
+	{"color", func(p model.Product) interface{} { return p.Color }},
+	{"category", func(p model.Product) interface{} { return p.Category }},
+	{"category_ids", func(p model.Product) interface{} {
+		ids := make([]string, len(p.CategoryIds))
+		for i, id := range p.CategoryIds {
+			ids[i] = id.Hex()
+		}
+		return ids
+	}},
+	{"images", func(p model.Product) interface{} { return p.Images }},
+	{"details", func(p model.Product) interface{} { return p.Details.Details }},
+	{"features", func(p model.Product) interface{} { return p.Details.Features }},
+	{"created_at", func(p model.Product) interface{} { return p.TimeStamp.CreatedAt }},
+	{"updated_at", func(p model.Product) interface{} { return p.TimeStamp.UpdatedAt }},
+}
+
+// Export formats supported by NewExportWriter.
+const (
+	ExportCSV    = "csv"
+	ExportNDJSON = "ndjson"
+	ExportXLSX   = "xlsx"
+)
+
+// ExportContentTypes maps an export format to its response content type.
+var ExportContentTypes = map[string]string{
+	ExportCSV:    "text/csv; charset=utf-8",
+	ExportNDJSON: "application/x-ndjson",
+	ExportXLSX:   "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// SelectExportColumns returns the columns named in a comma separated list, or
+// every column when the list is empty.
+func SelectExportColumns(fields string) ([]ExportColumn, error) {
+	if strings.TrimSpace(fields) == "" {
+		return ExportColumns, nil
+	}
+
+	byName := make(map[string]ExportColumn, len(ExportColumns))
+	for _, col := range ExportColumns {
+		byName[col.Name] = col
+	}
+
+	var cols []ExportColumn
+	seen := map[string]bool{}
+	for _, name := range strings.Split(fields, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || seen[name] {
+			continue
+		}
+		col, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown export column %q", name)
+		}
+		seen[name] = true
+		cols = append(cols, col)
+	}
+	if len(cols) == 0 {
+		return nil, fmt.Errorf("no export columns selected")
+	}
+	return cols, nil
+}
+
+// ExportWriter writes products one at a time in a specific file format.
+// Close must be called to flush any buffered output.
+type ExportWriter interface {
+	WriteProduct(p model.Product) error
+	Close() error
+}
+
+// NewExportWriter returns a writer for the given format that streams to w.
+func NewExportWriter(format string, w io.Writer, cols []ExportColumn) (ExportWriter, error) {
+	switch format {
+	case ExportCSV:
+		return newCSVExportWriter(w, cols)
+	case ExportNDJSON:
+		return &ndjsonExportWriter{w: bufio.NewWriter(w), cols: cols}, nil
+	case ExportXLSX:
+		return newXLSXExportWriter(w, cols)
+	default:
+		return nil, fmt.Errorf("unsupported export format %q", format)
+	}
+}
+
+// formatExportValue renders a column value as a flat string for tabular formats.
+func formatExportValue(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case []string:
+		return strings.Join(val, "|")
+	case time.Time:
+		if val.IsZero() {
+			return ""
+		}
+		return val.UTC().Format(time.RFC3339)
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+// formulaPrefixes start a cell that spreadsheet apps evaluate as a formula.
+const formulaPrefixes = "=+-@\t\r"
+
+// csvCell renders a column value for a CSV cell. Text that spreadsheet apps
+// would evaluate as a formula is prefixed with a quote so it stays text;
+// numbers are left alone.
+func csvCell(v interface{}) string {
+	text := formatExportValue(v)
+	if _, ok := v.(float64); ok {
+		return text
+	}
+	if text != "" && strings.ContainsRune(formulaPrefixes, rune(text[0])) {
+		return "'" + text
+	}
+	return text
+}
+
+// truncateRunes shortens text to at most n characters without splitting
+// a multi-byte character.
+func truncateRunes(text string, n int) string {
+	if len(text) <= n {
+		return text
+	}
+	count := 0
+	for i := range text {
+		if count == n {
+			return text[:i]
+		}
+		count++
+	}
+	return text
+}
+
+type csvExportWriter struct {
+	w    *csv.Writer
+	cols []ExportColumn
+}
+
+func newCSVExportWriter(w io.Writer, cols []ExportColumn) (*csvExportWriter, error) {
+	cw := csv.NewWriter(w)
+	header := make([]string, len(cols))
+	for i, col := range cols {
+		header[i] = col.Name
+	}
+	if err := cw.Write(header); err != nil {
+		return nil, err
+	}
+	return &csvExportWriter{w: cw, cols: cols}, nil
+}
+
+func (c *csvExportWriter) WriteProduct(p model.Product) error {
+	record := make([]string, len(c.cols))
+	for i, col := range c.cols {
+		record[i] = csvCell(col.Value(p))
+	}
+	return c.w.Write(record)
+}
+
+func (c *csvExportWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type ndjsonExportWriter struct {
+	w    *bufio.Writer
+	cols []ExportColumn
+}
+
+// WriteProduct writes one JSON object per line, keeping the column order.
+func (n *ndjsonExportWriter) WriteProduct(p model.Product) error {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, col := range n.cols {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(col.Name)
+		val, err := json.Marshal(col.Value(p))
+		if err != nil {
+			return err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(val)
+	}
+	buf.WriteString("}\n")
+	_, err := n.w.Write(buf.Bytes())
+	return err
+}
+
+func (n *ndjsonExportWriter) Close() error {
+	return n.w.Flush()
+}
+
+// xlsxMaxCellLength is the longest text Excel accepts in a single cell.
+const xlsxMaxCellLength = 32767
+
+// xlsxStaticParts are the workbook parts written before the sheet data. The
+// sheet uses inline strings so no shared string table is needed and rows can
+// be streamed straight into the zip archive.
+var xlsxStaticParts = []struct{ name, body string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+	{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="Products" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`},
+}
+
+type xlsxExportWriter struct {
+	zip   *zip.Writer
+	sheet *bufio.Writer
+	cols  []ExportColumn
+}
+
+func newXLSXExportWriter(w io.Writer, cols []ExportColumn) (*xlsxExportWriter, error) {
+	zw := zip.NewWriter(w)
+	for _, part := range xlsxStaticParts {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, part.body); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	x := &xlsxExportWriter{zip: zw, sheet: bufio.NewWriter(f), cols: cols}
+	x.sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n")
+	x.sheet.WriteString(`<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+
+	header := make([]interface{}, len(cols))
+	for i, col := range cols {
+		header[i] = col.Name
+	}
+	if err := x.writeRow(header); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+func (x *xlsxExportWriter) WriteProduct(p model.Product) error {
+	values := make([]interface{}, len(x.cols))
+	for i, col := range x.cols {
+		values[i] = col.Value(p)
+	}
+	return x.writeRow(values)
+}
+
+func (x *xlsxExportWriter) writeRow(values []interface{}) error {
+	x.sheet.WriteString("<row>")
+	for _, v := range values {
+		if num, ok := v.(float64); ok {
+			x.sheet.WriteString(`<c t="n"><v>`)
+			x.sheet.WriteString(strconv.FormatFloat(num, 'f', -1, 64))
+			x.sheet.WriteString(`</v></c>`)
+			continue
+		}
+		text := truncateRunes(formatExportValue(v), xlsxMaxCellLength)
+		x.sheet.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+		if err := xml.EscapeText(x.sheet, []byte(text)); err != nil {
+			return err
+		}
+		x.sheet.WriteString(`</t></is></c>`)
+	}
+	_, err := x.sheet.WriteString("</row>")
+	return err
+}
+
+func (x *xlsxExportWriter) Close() error {
+	x.sheet.WriteString("</sheetData></worksheet>")
+	if err := x.sheet.Flush(); err != nil {
+		return err
+	}
+	return x.zip.Close()
+}
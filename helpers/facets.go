@@ -0,0 +1,311 @@
+package helpers
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/joshua/casify/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// maxFacetValues caps how many values are returned for open ended facets
+// such as category and color.
+const maxFacetValues = 50
+
+// PriceBands are the lower bounds of the price facet buckets. The last band
+// is open ended.
+var PriceBands = []float64{0, 10, 20, 50, 100}
+
+// RatingThresholds are the "N stars and up" buckets of the rating facet.
+var RatingThresholds = []float64{4, 3, 2, 1}
+
+// FacetCount is the number of products carrying one facet value.
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// Facets holds the counts shown in the storefront sidebar.
+type Facets struct {
+	Category []FacetCount `json:"category"`
+	Color    []FacetCount `json:"color"`
+	Price    []FacetCount `json:"price"`
+	Rating   []FacetCount `json:"rating"`
+	Discount []FacetCount `json:"discount"`
+}
+
+// WithoutField drops the AND conditions, at any nesting, that only refer to
+// field. Each facet is counted with every active filter except its own, so
+// picking "Black" does not hide the count for "Clear".
+func WithoutField(node FilterNode, field string) FilterNode {
+	if node == nil {
+		return nil
+	}
+	onlyField := func(n FilterNode) bool {
+		for _, f := range n.Fields() {
+			if f != field {
+				return false
+			}
+		}
+		return true
+	}
+
+	and, ok := node.(*AndNode)
+	if !ok {
+		if onlyField(node) {
+			return nil
+		}
+		return node
+	}
+	var kept []FilterNode
+	for _, c := range and.Children {
+		if _, nested := c.(*AndNode); nested {
+			kept = append(kept, WithoutField(c, field))
+		} else if !onlyField(c) {
+			kept = append(kept, c)
+		}
+	}
+	return And(kept...)
+}
+
+func priceBandLabel(i int) string {
+	if i == len(PriceBands)-1 {
+		return fmt.Sprintf("%g+", PriceBands[i])
+	}
+	return fmt.Sprintf("%g-%g", PriceBands[i], PriceBands[i+1])
+}
+
+func priceBandIndex(price float64) int {
+	for i := len(PriceBands) - 1; i >= 0; i-- {
+		if price >= PriceBands[i] {
+			return i
+		}
+	}
+	return -1
+}
+
+func ratingLabel(threshold float64) string {
+	return fmt.Sprintf("%g+", threshold)
+}
+
+const (
+	discountedLabel = "discounted"
+	fullPriceLabel  = "full_price"
+)
+
+// sortedCounts turns a value count map into a list ordered by count, then
+// value, keeping at most limit entries.
+func sortedCounts(counts map[string]int, limit int) []FacetCount {
+	out := make([]FacetCount, 0, len(counts))
+	for value, count := range counts {
+		out = append(out, FacetCount{Value: value, Count: count})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Value < out[j].Value
+	})
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
+
+// ComputeFacets counts facet values over products held in memory. It is the
+// in-memory equivalent of FacetPipeline.
+func ComputeFacets(products []model.Product, filter FilterNode) Facets {
+	categoryFilter := WithoutField(filter, "category")
+	colorFilter := WithoutField(filter, "color")
+	priceFilter := WithoutField(filter, "price")
+	ratingFilter := WithoutField(filter, "rating")
+	discountFilter := WithoutField(filter, "discount")
+
+	categories := map[string]int{}
+	colors := map[string]int{}
+	prices := make([]int, len(PriceBands))
+	ratings := make([]int, len(RatingThresholds))
+	discounted, fullPrice := 0, 0
+
+	for _, p := range products {
+		if EvalFilter(categoryFilter, p) {
+			for _, c := range p.Category {
+				categories[c]++
+			}
+		}
+		if p.Color != "" && EvalFilter(colorFilter, p) {
+			colors[p.Color]++
+		}
+		if EvalFilter(priceFilter, p) {
+			if i := priceBandIndex(p.Price); i >= 0 {
+				prices[i]++
+			}
+		}
+		if EvalFilter(ratingFilter, p) {
+			for i, t := range RatingThresholds {
+				if p.Rating >= t {
+					ratings[i]++
+				}
+			}
+		}
+		if EvalFilter(discountFilter, p) {
+			if p.Discount > 0 {
+				discounted++
+			} else {
+				fullPrice++
+			}
+		}
+	}
+
+	facets := Facets{
+		Category: sortedCounts(categories, maxFacetValues),
+		Color:    sortedCounts(colors, maxFacetValues),
+		Discount: []FacetCount{{discountedLabel, discounted}, {fullPriceLabel, fullPrice}},
+	}
+	for i, count := range prices {
+		facets.Price = append(facets.Price, FacetCount{priceBandLabel(i), count})
+	}
+	for i, t := range RatingThresholds {
+		facets.Rating = append(facets.Rating, FacetCount{ratingLabel(t), ratings[i]})
+	}
+	return facets
+}
+
+// FacetPipeline builds a $facet aggregation computing the same counts as
+// ComputeFacets inside MongoDB.
+func FacetPipeline(filter FilterNode) mongo.Pipeline {
+	boundaries := bson.A{}
+	for _, b := range PriceBands {
+		boundaries = append(boundaries, b)
+	}
+	boundaries = append(boundaries, math.MaxFloat64)
+
+	ratingGroup := bson.M{"_id": nil}
+	for i, t := range RatingThresholds {
+		ratingGroup[fmt.Sprintf("r%d", i)] = bson.M{"$sum": bson.M{
+			"$cond": bson.A{bson.M{"$gte": bson.A{bson.M{"$ifNull": bson.A{"$rating", 0}}, t}}, 1, 0},
+		}}
+	}
+
+	match := func(field string) bson.M {
+		return bson.M{"$match": CompileFilter(WithoutField(filter, field))}
+	}
+
+	return mongo.Pipeline{{{Key: "$facet", Value: bson.M{
+		"category": bson.A{
+			match("category"),
+			bson.M{"$unwind": "$category"},
+			bson.M{"$group": bson.M{"_id": "$category", "count": bson.M{"$sum": 1}}},
+			bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+			bson.M{"$limit": maxFacetValues},
+		},
+		"color": bson.A{
+			match("color"),
+			bson.M{"$match": bson.M{"color": bson.M{"$nin": bson.A{nil, ""}}}},
+			bson.M{"$group": bson.M{"_id": "$color", "count": bson.M{"$sum": 1}}},
+			bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "_id", Value: 1}}},
+			bson.M{"$limit": maxFacetValues},
+		},
+		"price": bson.A{
+			match("price"),
+			bson.M{"$bucket": bson.M{
+				"groupBy":    "$price",
+				"boundaries": boundaries,
+				"default":    "other",
+				"output":     bson.M{"count": bson.M{"$sum": 1}},
+			}},
+		},
+		"rating": bson.A{
+			match("rating"),
+			bson.M{"$group": ratingGroup},
+		},
+		"discount": bson.A{
+			match("discount"),
+			bson.M{"$group": bson.M{
+				"_id":   bson.M{"$gt": bson.A{bson.M{"$ifNull": bson.A{"$discount", 0}}, 0}},
+				"count": bson.M{"$sum": 1},
+			}},
+		},
+	}}}}
+}
+
+// FacetResult is the raw document returned by FacetPipeline.
+type FacetResult struct {
+	Category []struct {
+		Id    string `bson:"_id"`
+		Count int    `bson:"count"`
+	} `bson:"category"`
+	Color []struct {
+		Id    string `bson:"_id"`
+		Count int    `bson:"count"`
+	} `bson:"color"`
+	Price []struct {
+		Id    interface{} `bson:"_id"`
+		Count int         `bson:"count"`
+	} `bson:"price"`
+	Rating   []bson.M `bson:"rating"`
+	Discount []struct {
+		Id    bool `bson:"_id"`
+		Count int  `bson:"count"`
+	} `bson:"discount"`
+}
+
+// Facets converts the aggregation output into the response shape, filling
+// in empty buckets so every band and threshold is always listed.
+func (r FacetResult) Facets() Facets {
+	facets := Facets{
+		Category: []FacetCount{},
+		Color:    []FacetCount{},
+	}
+	for _, c := range r.Category {
+		facets.Category = append(facets.Category, FacetCount{c.Id, c.Count})
+	}
+	for _, c := range r.Color {
+		facets.Color = append(facets.Color, FacetCount{c.Id, c.Count})
+	}
+
+	prices := make([]int, len(PriceBands))
+	for _, b := range r.Price {
+		if lower, ok := b.Id.(float64); ok {
+			if i := priceBandIndex(lower); i >= 0 {
+				prices[i] += b.Count
+			}
+		}
+	}
+	for i, count := range prices {
+		facets.Price = append(facets.Price, FacetCount{priceBandLabel(i), count})
+	}
+
+	for i, t := range RatingThresholds {
+		count := 0
+		if len(r.Rating) > 0 {
+			count = toInt(r.Rating[0][fmt.Sprintf("r%d", i)])
+		}
+		facets.Rating = append(facets.Rating, FacetCount{ratingLabel(t), count})
+	}
+
+	discounted, fullPrice := 0, 0
+	for _, d := range r.Discount {
+		if d.Id {
+			discounted += d.Count
+		} else {
+			fullPrice += d.Count
+		}
+	}
+	facets.Discount = []FacetCount{{discountedLabel, discounted}, {fullPriceLabel, fullPrice}}
+	return facets
+}
+
+func toInt(v interface{}) int {
+	switch n := v.(type) {
+	case int32:
+		return int(n)
+	case int64:
+		return int(n)
+	case float64:
+		return int(n)
+	}
+	return 0
+}
@@ -0,0 +1,154 @@
+package helpers
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/joshua/casify/model"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func facetProducts() []model.Product {
+	return []model.Product{
+		{Title: "Black leather", Color: "Black", Category: []string{"Leather"}, Price: 45, Rating: 4.5, Discount: 10, Status: model.ProductPublished},
+		{Title: "Brown leather", Color: "Brown", Category: []string{"Leather"}, Price: 55, Rating: 4, Status: model.ProductPublished},
+		{Title: "Black silicone", Color: "Black", Category: []string{"Silicone"}, Price: 15, Rating: 3, Status: model.ProductPublished},
+		{Title: "Clear silicone", Color: "Clear", Category: []string{"Silicone"}, Price: 12, Status: model.ProductPublished},
+		{Title: "Black leather draft", Color: "Black", Category: []string{"Leather"}, Price: 40, Status: model.ProductDraft},
+	}
+}
+
+// listingFilter builds the filter the listing endpoints use: the parsed
+// expression joined with the live product filter.
+func listingFilter(t *testing.T, expr string) FilterNode {
+	t.Helper()
+	node, err := ParseFilter(expr)
+	if err != nil {
+		t.Fatalf("ParseFilter(%q): %v", expr, err)
+	}
+	return And(node, LiveProductFilter())
+}
+
+func TestAndFlattensNestedConditions(t *testing.T) {
+	color, _ := NewCompare("color", OpEq, "black")
+	price, _ := NewCompare("price", OpGt, 10.0)
+	rating, _ := NewCompare("rating", OpGte, 4.0)
+
+	node := And(And(color, price), nil, rating)
+	and, ok := node.(*AndNode)
+	if !ok || !reflect.DeepEqual(and.Children, []FilterNode{color, price, rating}) {
+		t.Fatalf("And = %#v, want one AND of the three conditions", node)
+	}
+	if And(nil, nil) != nil {
+		t.Fatal("And of nils is not nil")
+	}
+	if And(color) != color {
+		t.Fatal("And of one node does not return it")
+	}
+}
+
+func TestWithoutField(t *testing.T) {
+	tests := []struct {
+		name   string
+		expr   string
+		field  string
+		fields []string
+	}{
+		{"single filter", "color = black", "color", []string{"status"}},
+		{"two filters", "color = black AND category = leather", "color", []string{"category", "status"}},
+		{"three filters", "color = black AND category = leather AND price > 20", "category", []string{"color", "price", "status"}},
+		{"nested group", "(color = black AND price > 20) AND rating >= 4", "color", []string{"price", "rating", "status"}},
+		{"OR across fields is kept", "color = black OR price > 20", "color", []string{"color", "price", "status"}},
+		{"OR within the field is dropped", "(color = black OR color = clear) AND price > 20", "color", []string{"price", "status"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := WithoutField(listingFilter(t, tt.expr), tt.field).Fields()
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.fields) {
+				t.Fatalf("fields = %v, want %v", got, tt.fields)
+			}
+		})
+	}
+	if WithoutField(nil, "color") != nil {
+		t.Fatal("WithoutField(nil) is not nil")
+	}
+}
+
+func TestComputeFacetsWithSeveralFilters(t *testing.T) {
+	facets := ComputeFacets(facetProducts(), listingFilter(t, "color = black AND category = leather"))
+
+	// Every color of live leather products, ignoring the color filter
+	wantColor := []FacetCount{{"Black", 1}, {"Brown", 1}}
+	if !reflect.DeepEqual(facets.Color, wantColor) {
+		t.Errorf("color = %v, want %v", facets.Color, wantColor)
+	}
+	// Every category of live black products, ignoring the category filter
+	wantCategory := []FacetCount{{"Leather", 1}, {"Silicone", 1}}
+	if !reflect.DeepEqual(facets.Category, wantCategory) {
+		t.Errorf("category = %v, want %v", facets.Category, wantCategory)
+	}
+	// Both filters apply to the other facets
+	wantDiscount := []FacetCount{{discountedLabel, 1}, {fullPriceLabel, 0}}
+	if !reflect.DeepEqual(facets.Discount, wantDiscount) {
+		t.Errorf("discount = %v, want %v", facets.Discount, wantDiscount)
+	}
+}
+
+func TestComputeFacetsWithThreeFilters(t *testing.T) {
+	facets := ComputeFacets(facetProducts(), listingFilter(t, "color = black AND category = silicone AND price < 20"))
+
+	wantColor := []FacetCount{{"Black", 1}, {"Clear", 1}}
+	if !reflect.DeepEqual(facets.Color, wantColor) {
+		t.Errorf("color = %v, want %v", facets.Color, wantColor)
+	}
+	wantCategory := []FacetCount{{"Silicone", 1}}
+	if !reflect.DeepEqual(facets.Category, wantCategory) {
+		t.Errorf("category = %v, want %v", facets.Category, wantCategory)
+	}
+	// Black silicone at 15 falls in 10-20; the price filter itself is ignored
+	wantPrice := []FacetCount{{"0-10", 0}, {"10-20", 1}, {"20-50", 0}, {"50-100", 0}, {"100+", 0}}
+	if !reflect.DeepEqual(facets.Price, wantPrice) {
+		t.Errorf("price = %v, want %v", facets.Price, wantPrice)
+	}
+}
+
+func TestFacetPipelineDropsOwnFilter(t *testing.T) {
+	filter := listingFilter(t, "color = black AND category = leather")
+	pipeline := FacetPipeline(filter)
+	stages := pipeline[0][0].Value.(bson.M)
+
+	for facet, want := range map[string][]string{
+		"color":    {"category", "status"},
+		"category": {"color", "status"},
+		"price":    {"category", "color", "status"},
+	} {
+		match := stages[facet].(bson.A)[0].(bson.M)["$match"]
+		got := compiledFields(match)
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s facet matches on %v, want %v", facet, got, want)
+		}
+	}
+}
+
+// compiledFields lists the document fields a compiled filter refers to.
+func compiledFields(filter interface{}) []string {
+	var fields []string
+	switch f := filter.(type) {
+	case bson.M:
+		for key, value := range f {
+			if key[0] == '$' {
+				fields = append(fields, compiledFields(value)...)
+			} else {
+				fields = append(fields, key)
+			}
+		}
+	case []bson.M:
+		for _, part := range f {
+			fields = append(fields, compiledFields(part)...)
+		}
+	}
+	return fields
+}
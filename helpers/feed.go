@@ -0,0 +1,208 @@
+package helpers
+
+import (
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/joshua/casify/model"
+)
+
+// FeedConfig holds the store wide values every feed item needs.
+type FeedConfig struct {
+	BaseURL  string
+	Currency string
+	Brand    string
+	Title    string
+}
+
+// FeedConfigFromEnv reads the feed settings from the environment, falling
+// back to defaults suitable for local development.
+func FeedConfigFromEnv() FeedConfig {
+	cfg := FeedConfig{
+		BaseURL:  strings.TrimRight(os.Getenv("STORE_URL"), "/"),
+		Currency: os.Getenv("FEED_CURRENCY"),
+		Brand:    os.Getenv("FEED_BRAND"),
+		Title:    os.Getenv("FEED_TITLE"),
+	}
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = "http://localhost:3000"
+	}
+	if cfg.Currency == "" {
+		cfg.Currency = "USD"
+	}
+	if cfg.Brand == "" {
+		cfg.Brand = "Casify"
+	}
+	if cfg.Title == "" {
+		cfg.Title = cfg.Brand + " products"
+	}
+	return cfg
+}
+
+// DiscountedPrice applies a product discount, which is stored as a
+// percentage of the price.
+func DiscountedPrice(price, discount float64) float64 {
+	if discount <= 0 || discount >= 100 {
+		return price
+	}
+	return price * (100 - discount) / 100
+}
+
+// FeedItem is a product flattened into the attributes shared by the Google
+// Merchant and Meta catalog feeds.
+type FeedItem struct {
+	ID           string
+	Title        string
+	Description  string
+	Link         string
+	ImageLink    string
+	Price        string
+	SalePrice    string
+	Availability string
+	Brand        string
+	ProductType  string
+	Condition    string
+}
+
+// BuildFeedItem maps a product onto feed attributes. Products whose SKUs
+// are tracked in inventory are out of stock when none is available;
+// availability is nil for products without SKUs.
+func BuildFeedItem(p model.Product, availability *ProductAvailability, cfg FeedConfig) FeedItem {
+	item := FeedItem{
+		ID:           p.Id.Hex(),
+		Title:        strings.TrimSpace(p.Title),
+		Description:  strings.TrimSpace(p.Description),
+		Link:         cfg.BaseURL + ProductPath(p),
+		Availability: "in stock",
+		Brand:        cfg.Brand,
+		ProductType:  strings.Join(p.Category, " > "),
+		Condition:    "new",
+	}
+	if availability != nil && !availability.InStock {
+		item.Availability = "out of stock"
+	}
+	if len(p.Images) > 0 {
+		item.ImageLink = p.Images[0]
+	}
+	if p.Price > 0 {
+		item.Price = formatFeedPrice(p.Price, cfg.Currency)
+		if p.Discount > 0 && p.Discount < 100 {
+			item.SalePrice = formatFeedPrice(DiscountedPrice(p.Price, p.Discount), cfg.Currency)
+		}
+	}
+	return item
+}
+
+func formatFeedPrice(amount float64, currency string) string {
+	return fmt.Sprintf("%.2f %s", amount, currency)
+}
+
+// MissingFeedAttributes lists the required feed attributes an item lacks.
+// Items with missing attributes are rejected by both Google and Meta.
+func MissingFeedAttributes(item FeedItem) []string {
+	required := []struct {
+		name  string
+		value string
+	}{
+		{"id", item.ID},
+		{"title", item.Title},
+		{"description", item.Description},
+		{"link", item.Link},
+		{"image_link", item.ImageLink},
+		{"price", item.Price},
+		{"availability", item.Availability},
+		{"brand", item.Brand},
+	}
+
+	var missing []string
+	for _, attr := range required {
+		if attr.value == "" {
+			missing = append(missing, attr.name)
+		}
+	}
+	return missing
+}
+
+type googleFeed struct {
+	XMLName xml.Name      `xml:"rss"`
+	Version string        `xml:"version,attr"`
+	NS      string        `xml:"xmlns:g,attr"`
+	Channel googleChannel `xml:"channel"`
+}
+
+type googleChannel struct {
+	Title       string       `xml:"title"`
+	Link        string       `xml:"link"`
+	Description string       `xml:"description"`
+	Items       []googleItem `xml:"item"`
+}
+
+type googleItem struct {
+	ID           string `xml:"g:id"`
+	Title        string `xml:"title"`
+	Description  string `xml:"description"`
+	Link         string `xml:"link"`
+	ImageLink    string `xml:"g:image_link"`
+	Price        string `xml:"g:price"`
+	SalePrice    string `xml:"g:sale_price,omitempty"`
+	Availability string `xml:"g:availability"`
+	Brand        string `xml:"g:brand"`
+	ProductType  string `xml:"g:product_type,omitempty"`
+	Condition    string `xml:"g:condition"`
+}
+
+// WriteGoogleFeed renders items as a Google Merchant Center RSS 2.0 feed.
+func WriteGoogleFeed(w io.Writer, items []FeedItem, cfg FeedConfig) error {
+	feed := googleFeed{
+		Version: "2.0",
+		NS:      "http://base.google.com/ns/1.0",
+		Channel: googleChannel{
+			Title:       cfg.Title,
+			Link:        cfg.BaseURL,
+			Description: cfg.Title,
+			Items:       make([]googleItem, len(items)),
+		},
+	}
+	for i, item := range items {
+		feed.Channel.Items[i] = googleItem(item)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		return err
+	}
+	return enc.Flush()
+}
+
+// metaFeedHeader is the column order of the Meta catalog CSV feed.
+var metaFeedHeader = []string{
+	"id", "title", "description", "availability", "condition", "price",
+	"sale_price", "link", "image_link", "brand", "product_type",
+}
+
+// WriteMetaFeed renders items as a Meta (Facebook/Instagram) catalog CSV.
+func WriteMetaFeed(w io.Writer, items []FeedItem) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(metaFeedHeader); err != nil {
+		return err
+	}
+	for _, item := range items {
+		record := []string{
+			item.ID, item.Title, item.Description, item.Availability, item.Condition, item.Price,
+			item.SalePrice, item.Link, item.ImageLink, item.Brand, item.ProductType,
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
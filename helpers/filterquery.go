@@ -0,0 +1,718 @@
+package helpers
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/joshua/casify/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Limits keeping filter expressions cheap to parse and to run.
+const (
+	maxFilterLength     = 1000
+	maxFilterConditions = 50
+	maxFilterDepth      = 10
+)
+
+// FilterError reports a problem with a filter expression and where it is.
+type FilterError struct {
+	Pos     int
+	Token   string
+	Message string
+}
+
+func (e *FilterError) Error() string {
+	if e.Token == "" {
+		return fmt.Sprintf("at position %d: %s", e.Pos, e.Message)
+	}
+	return fmt.Sprintf("at position %d near %q: %s", e.Pos, e.Token, e.Message)
+}
+
+type filterKind int
+
+const (
+	numberField filterKind = iota
+	stringField
+	stringListField
+	idListField
+)
+
+// filterField describes a product field that can appear in a filter.
+type filterField struct {
+	path string
+	kind filterKind
+	// omitZero marks fields stored with omitempty, where a zero value means
+	// the field is missing from the document.
+	omitZero bool
+	// exact marks enum-like text fields, matched case-sensitively with plain
+	// equality so their indexes can be used. Other text fields are free
+	// text and matched case-insensitively.
+	exact   bool
+	number  func(p model.Product) float64
+	strings func(p model.Product) []string
+	ids     func(p model.Product) []primitive.ObjectID
+}
+
+var filterFields = map[string]filterField{
+	"title":       {path: "title", kind: stringField, strings: func(p model.Product) []string { return []string{p.Title} }},
+	"description": {path: "description", kind: stringField, strings: func(p model.Product) []string { return []string{p.Description} }},
+	"color":       {path: "color", kind: stringField, omitZero: true, strings: func(p model.Product) []string { return []string{p.Color} }},
+	"category":    {path: "category", kind: stringListField, strings: func(p model.Product) []string { return p.Category }},
+	"category_id": {path: "category_ids", kind: idListField, ids: func(p model.Product) []primitive.ObjectID { return p.CategoryIds }},
+	"price":       {path: "price", kind: numberField, number: func(p model.Product) float64 { return p.Price }},
+	"discount":    {path: "discount", kind: numberField, omitZero: true, number: func(p model.Product) float64 { return p.Discount }},
+	"rating":      {path: "rating", kind: numberField, omitZero: true, number: func(p model.Product) float64 { return p.Rating }},
+	"device":      {path: "compatible_devices", kind: idListField, ids: func(p model.Product) []primitive.ObjectID { return p.CompatibleDevices }},
+	"status":      {path: "status", kind: stringField, omitZero: true, exact: true, strings: func(p model.Product) []string { return []string{p.Status} }},
+}
+
+// Filter operators. "~" is a case-insensitive substring match.
+const (
+	OpEq       = "="
+	OpNe       = "!="
+	OpGt       = ">"
+	OpGte      = ">="
+	OpLt       = "<"
+	OpLte      = "<="
+	OpContains = "~"
+)
+
+// FilterNode is a node of a parsed filter expression. It can be compiled to
+// a MongoDB filter or evaluated directly against a product.
+type FilterNode interface {
+	Compile() bson.M
+	Eval(p model.Product) bool
+	// Fields lists the product fields the node refers to.
+	Fields() []string
+}
+
+type AndNode struct{ Children []FilterNode }
+type OrNode struct{ Children []FilterNode }
+type NotNode struct{ Child FilterNode }
+
+// CompareNode compares a field against a single value.
+type CompareNode struct {
+	Field string
+	Op    string
+	// Value is a float64 for number fields and a string otherwise.
+	Value interface{}
+}
+
+// InNode matches fields equal to any of the values, or none with Negate.
+type InNode struct {
+	Field  string
+	Values []interface{}
+	Negate bool
+}
+
+// And joins nodes, skipping nils and flattening nested AND nodes, so every
+// condition is a direct child. It returns nil when nothing is left.
+func And(nodes ...FilterNode) FilterNode {
+	var children []FilterNode
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case nil:
+		case *AndNode:
+			children = append(children, n.Children...)
+		default:
+			children = append(children, n)
+		}
+	}
+	switch len(children) {
+	case 0:
+		return nil
+	case 1:
+		return children[0]
+	}
+	return &AndNode{Children: children}
+}
+
+// CompileFilter compiles a possibly nil node into a MongoDB filter.
+func CompileFilter(n FilterNode) bson.M {
+	if n == nil {
+		return bson.M{}
+	}
+	return n.Compile()
+}
+
+// EvalFilter evaluates a possibly nil node; nil matches everything.
+func EvalFilter(n FilterNode, p model.Product) bool {
+	return n == nil || n.Eval(p)
+}
+
+func (n *AndNode) Compile() bson.M {
+	parts := make([]bson.M, len(n.Children))
+	for i, c := range n.Children {
+		parts[i] = c.Compile()
+	}
+	return bson.M{"$and": parts}
+}
+
+func (n *AndNode) Eval(p model.Product) bool {
+	for _, c := range n.Children {
+		if !c.Eval(p) {
+			return false
+		}
+	}
+	return true
+}
+
+func (n *AndNode) Fields() []string { return childFields(n.Children) }
+
+func (n *OrNode) Compile() bson.M {
+	parts := make([]bson.M, len(n.Children))
+	for i, c := range n.Children {
+		parts[i] = c.Compile()
+	}
+	return bson.M{"$or": parts}
+}
+
+func (n *OrNode) Eval(p model.Product) bool {
+	for _, c := range n.Children {
+		if c.Eval(p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *OrNode) Fields() []string { return childFields(n.Children) }
+
+func (n *NotNode) Compile() bson.M {
+	return bson.M{"$nor": []bson.M{n.Child.Compile()}}
+}
+
+func (n *NotNode) Eval(p model.Product) bool { return !n.Child.Eval(p) }
+
+func (n *NotNode) Fields() []string { return n.Child.Fields() }
+
+func childFields(children []FilterNode) []string {
+	var fields []string
+	for _, c := range children {
+		fields = append(fields, c.Fields()...)
+	}
+	return fields
+}
+
+// stringPattern builds the escaped, case-insensitive regex used for string
+// matching. User input never reaches the regex engine unescaped.
+func stringPattern(op string, value string) string {
+	quoted := regexp.QuoteMeta(value)
+	if op == OpContains {
+		return quoted
+	}
+	return "^" + quoted + "$"
+}
+
+func (n *CompareNode) Compile() bson.M {
+	field := filterFields[n.Field]
+	if field.kind == numberField {
+		switch n.Op {
+		case OpEq:
+			return bson.M{field.path: n.Value}
+		case OpNe:
+			return bson.M{field.path: bson.M{"$ne": n.Value}}
+		case OpGt:
+			return bson.M{field.path: bson.M{"$gt": n.Value}}
+		case OpGte:
+			return bson.M{field.path: bson.M{"$gte": n.Value}}
+		case OpLt:
+			return bson.M{field.path: bson.M{"$lt": n.Value}}
+		default:
+			return bson.M{field.path: bson.M{"$lte": n.Value}}
+		}
+	}
+
+	if field.kind == idListField {
+		if n.Op == OpNe {
+			return bson.M{field.path: bson.M{"$ne": n.Value}}
+		}
+		return bson.M{field.path: n.Value}
+	}
+
+	if field.exact {
+		if n.Op == OpNe {
+			return bson.M{field.path: bson.M{"$ne": n.Value}}
+		}
+		return bson.M{field.path: n.Value}
+	}
+
+	re := primitive.Regex{Pattern: stringPattern(n.Op, n.Value.(string)), Options: "i"}
+	if n.Op == OpNe {
+		return bson.M{field.path: bson.M{"$not": re}}
+	}
+	return bson.M{field.path: re}
+}
+
+func (n *CompareNode) Eval(p model.Product) bool {
+	field := filterFields[n.Field]
+	if field.kind == numberField {
+		v := field.number(p)
+		if field.omitZero && v == 0 {
+			// A missing field only satisfies "not equal".
+			return n.Op == OpNe
+		}
+		want := n.Value.(float64)
+		switch n.Op {
+		case OpEq:
+			return v == want
+		case OpNe:
+			return v != want
+		case OpGt:
+			return v > want
+		case OpGte:
+			return v >= want
+		case OpLt:
+			return v < want
+		default:
+			return v <= want
+		}
+	}
+
+	var matched bool
+	if field.kind == idListField {
+		matched = hasID(field.ids(p), n.Value.(primitive.ObjectID))
+	} else {
+		matched = anyStringMatches(field, p, n.Op, n.Value.(string))
+	}
+	if n.Op == OpNe {
+		return !matched
+	}
+	return matched
+}
+
+func (n *CompareNode) Fields() []string { return []string{n.Field} }
+
+func hasID(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// anyStringMatches reports whether any value of a string field matches.
+func anyStringMatches(field filterField, p model.Product, op string, want string) bool {
+	if !field.exact {
+		want = strings.ToLower(want)
+	}
+	for _, v := range field.strings(p) {
+		if field.omitZero && v == "" {
+			continue
+		}
+		if !field.exact {
+			v = strings.ToLower(v)
+		}
+		if op == OpContains && strings.Contains(v, want) {
+			return true
+		}
+		if op != OpContains && v == want {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *InNode) Compile() bson.M {
+	field := filterFields[n.Field]
+	values := make(bson.A, len(n.Values))
+	for i, v := range n.Values {
+		if field.kind == numberField || field.kind == idListField || field.exact {
+			values[i] = v
+		} else {
+			values[i] = primitive.Regex{Pattern: stringPattern(OpEq, v.(string)), Options: "i"}
+		}
+	}
+	if n.Negate {
+		return bson.M{field.path: bson.M{"$nin": values}}
+	}
+	return bson.M{field.path: bson.M{"$in": values}}
+}
+
+func (n *InNode) Eval(p model.Product) bool {
+	field := filterFields[n.Field]
+	matched := false
+	for _, v := range n.Values {
+		if field.kind == numberField {
+			num := field.number(p)
+			if !(field.omitZero && num == 0) && num == v.(float64) {
+				matched = true
+			}
+		} else if field.kind == idListField {
+			matched = hasID(field.ids(p), v.(primitive.ObjectID))
+		} else if anyStringMatches(field, p, OpEq, v.(string)) {
+			matched = true
+		}
+		if matched {
+			break
+		}
+	}
+	return matched != n.Negate
+}
+
+func (n *InNode) Fields() []string { return []string{n.Field} }
+
+// NewCompare builds a validated comparison node, for callers turning query
+// parameters into filter nodes.
+func NewCompare(field, op string, value interface{}) (FilterNode, error) {
+	f, ok := filterFields[field]
+	if !ok {
+		return nil, fmt.Errorf("unknown filter field %q", field)
+	}
+	if err := checkOperator(f, op); err != nil {
+		return nil, err
+	}
+	if !validFilterValue(f, value) {
+		return nil, fmt.Errorf("invalid value for %s", field)
+	}
+	return &CompareNode{Field: field, Op: op, Value: value}, nil
+}
+
+// NewIn builds a validated IN node.
+func NewIn(field string, values []interface{}) (FilterNode, error) {
+	f, ok := filterFields[field]
+	if !ok {
+		return nil, fmt.Errorf("unknown filter field %q", field)
+	}
+	for _, v := range values {
+		if !validFilterValue(f, v) {
+			return nil, fmt.Errorf("invalid value for %s", field)
+		}
+	}
+	return &InNode{Field: field, Values: values}, nil
+}
+
+func validFilterValue(f filterField, value interface{}) bool {
+	switch value.(type) {
+	case float64:
+		return f.kind == numberField
+	case primitive.ObjectID:
+		return f.kind == idListField
+	case string:
+		return f.kind == stringField || f.kind == stringListField
+	}
+	return false
+}
+
+func checkOperator(f filterField, op string) error {
+	switch op {
+	case OpEq, OpNe:
+		return nil
+	case OpGt, OpGte, OpLt, OpLte:
+		if f.kind != numberField {
+			return fmt.Errorf("operator %s only applies to numeric fields", op)
+		}
+		return nil
+	case OpContains:
+		if f.kind == numberField || f.kind == idListField || f.exact {
+			return fmt.Errorf("operator ~ only applies to free text fields")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown operator %s", op)
+}
+
+// ParseFilter parses a filter expression such as
+//
+//	price>=10 AND category IN (magsafe, leather) AND rating>4
+//
+// Supported operators are =, !=, >, >=, <, <=, ~ (contains), IN and NOT IN,
+// combined with AND, OR, NOT and parentheses. String values may be bare
+// words or quoted with single or double quotes.
+func ParseFilter(input string) (FilterNode, error) {
+	if len(input) > maxFilterLength {
+		return nil, &FilterError{Pos: maxFilterLength, Message: fmt.Sprintf("filter is longer than %d characters", maxFilterLength)}
+	}
+	tokens, err := lexFilter(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	if p.peek().kind == tokEOF {
+		return nil, nil
+	}
+	node, err := p.parseOr(0)
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, &FilterError{Pos: tok.pos, Token: tok.text, Message: "unexpected token"}
+	}
+	return node, nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+type filterToken struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// filterNumber matches the numbers filters accept: plain decimals, so
+// ParseFloat's NaN, Inf, exponents and hex floats stay words.
+var filterNumber = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '.'
+}
+
+func lexFilter(input string) ([]filterToken, error) {
+	var tokens []filterToken
+	runes := []rune(input)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, filterToken{tokLParen, "(", i})
+			i++
+		case r == ')':
+			tokens = append(tokens, filterToken{tokRParen, ")", i})
+			i++
+		case r == ',':
+			tokens = append(tokens, filterToken{tokComma, ",", i})
+			i++
+		case r == '"' || r == '\'':
+			start := i
+			i++
+			var sb strings.Builder
+			for i < len(runes) && runes[i] != r {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				sb.WriteRune(runes[i])
+				i++
+			}
+			if i >= len(runes) {
+				return nil, &FilterError{Pos: start, Token: string(runes[start:]), Message: "unterminated string"}
+			}
+			i++
+			tokens = append(tokens, filterToken{tokString, sb.String(), start})
+		case strings.ContainsRune("=!<>~", r):
+			start := i
+			op := string(r)
+			if i+1 < len(runes) && runes[i+1] == '=' && r != '=' && r != '~' {
+				op += "="
+			}
+			if op == "!" {
+				return nil, &FilterError{Pos: start, Token: op, Message: "expected !="}
+			}
+			i += len(op)
+			tokens = append(tokens, filterToken{tokOp, op, start})
+		case isWordRune(r):
+			start := i
+			for i < len(runes) && isWordRune(runes[i]) {
+				i++
+			}
+			text := string(runes[start:i])
+			kind := tokIdent
+			if filterNumber.MatchString(text) {
+				kind = tokNumber
+			}
+			tokens = append(tokens, filterToken{kind, text, start})
+		default:
+			return nil, &FilterError{Pos: i, Token: string(r), Message: "unexpected character"}
+		}
+	}
+	return append(tokens, filterToken{tokEOF, "", len(runes)}), nil
+}
+
+type filterParser struct {
+	tokens     []filterToken
+	pos        int
+	conditions int
+}
+
+func (p *filterParser) peek() filterToken { return p.tokens[p.pos] }
+
+func (p *filterParser) next() filterToken {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *filterParser) keyword(word string) bool {
+	tok := p.peek()
+	return tok.kind == tokIdent && strings.EqualFold(tok.text, word)
+}
+
+func (p *filterParser) parseOr(depth int) (FilterNode, error) {
+	left, err := p.parseAnd(depth)
+	if err != nil {
+		return nil, err
+	}
+	children := []FilterNode{left}
+	for p.keyword("OR") {
+		p.next()
+		right, err := p.parseAnd(depth)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, right)
+	}
+	if len(children) == 1 {
+		return left, nil
+	}
+	return &OrNode{Children: children}, nil
+}
+
+func (p *filterParser) parseAnd(depth int) (FilterNode, error) {
+	left, err := p.parseUnary(depth)
+	if err != nil {
+		return nil, err
+	}
+	children := []FilterNode{left}
+	for p.keyword("AND") {
+		p.next()
+		right, err := p.parseUnary(depth)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, right)
+	}
+	if len(children) == 1 {
+		return left, nil
+	}
+	return &AndNode{Children: children}, nil
+}
+
+func (p *filterParser) parseUnary(depth int) (FilterNode, error) {
+	if depth > maxFilterDepth {
+		tok := p.peek()
+		return nil, &FilterError{Pos: tok.pos, Token: tok.text, Message: "filter is nested too deeply"}
+	}
+	if p.keyword("NOT") {
+		p.next()
+		child, err := p.parseUnary(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return &NotNode{Child: child}, nil
+	}
+	if p.peek().kind == tokLParen {
+		p.next()
+		node, err := p.parseOr(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if tok := p.next(); tok.kind != tokRParen {
+			return nil, &FilterError{Pos: tok.pos, Token: tok.text, Message: "expected )"}
+		}
+		return node, nil
+	}
+	return p.parseCondition()
+}
+
+func (p *filterParser) parseCondition() (FilterNode, error) {
+	fieldTok := p.next()
+	if fieldTok.kind != tokIdent {
+		return nil, &FilterError{Pos: fieldTok.pos, Token: fieldTok.text, Message: "expected a field name"}
+	}
+	name := strings.ToLower(fieldTok.text)
+	field, ok := filterFields[name]
+	if !ok {
+		return nil, &FilterError{Pos: fieldTok.pos, Token: fieldTok.text, Message: "unknown field"}
+	}
+
+	p.conditions++
+	if p.conditions > maxFilterConditions {
+		return nil, &FilterError{Pos: fieldTok.pos, Token: fieldTok.text, Message: fmt.Sprintf("filter has more than %d conditions", maxFilterConditions)}
+	}
+
+	negate := false
+	if p.keyword("NOT") {
+		p.next()
+		negate = true
+		if !p.keyword("IN") {
+			tok := p.peek()
+			return nil, &FilterError{Pos: tok.pos, Token: tok.text, Message: "expected IN after NOT"}
+		}
+	}
+	if p.keyword("IN") {
+		p.next()
+		values, err := p.parseValueList(field)
+		if err != nil {
+			return nil, err
+		}
+		return &InNode{Field: name, Values: values, Negate: negate}, nil
+	}
+
+	opTok := p.next()
+	if opTok.kind != tokOp {
+		return nil, &FilterError{Pos: opTok.pos, Token: opTok.text, Message: "expected an operator"}
+	}
+	if err := checkOperator(field, opTok.text); err != nil {
+		return nil, &FilterError{Pos: opTok.pos, Token: opTok.text, Message: err.Error()}
+	}
+	value, err := p.parseValue(field)
+	if err != nil {
+		return nil, err
+	}
+	return &CompareNode{Field: name, Op: opTok.text, Value: value}, nil
+}
+
+func (p *filterParser) parseValueList(field filterField) ([]interface{}, error) {
+	if tok := p.next(); tok.kind != tokLParen {
+		return nil, &FilterError{Pos: tok.pos, Token: tok.text, Message: "expected ( after IN"}
+	}
+	var values []interface{}
+	for {
+		value, err := p.parseValue(field)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+
+		tok := p.next()
+		if tok.kind == tokRParen {
+			return values, nil
+		}
+		if tok.kind != tokComma {
+			return nil, &FilterError{Pos: tok.pos, Token: tok.text, Message: "expected , or )"}
+		}
+	}
+}
+
+func (p *filterParser) parseValue(field filterField) (interface{}, error) {
+	tok := p.next()
+	if field.kind == numberField {
+		if tok.kind != tokNumber {
+			return nil, &FilterError{Pos: tok.pos, Token: tok.text, Message: "expected a number"}
+		}
+		num, _ := strconv.ParseFloat(tok.text, 64)
+		return num, nil
+	}
+	if field.kind == idListField {
+		id, err := primitive.ObjectIDFromHex(tok.text)
+		if (tok.kind != tokIdent && tok.kind != tokString && tok.kind != tokNumber) || err != nil {
+			return nil, &FilterError{Pos: tok.pos, Token: tok.text, Message: "expected an id"}
+		}
+		return id, nil
+	}
+	switch tok.kind {
+	case tokIdent, tokString, tokNumber:
+		return tok.text, nil
+	}
+	return nil, &FilterError{Pos: tok.pos, Token: tok.text, Message: "expected a value"}
+}
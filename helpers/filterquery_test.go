@@ -0,0 +1,383 @@
+package helpers
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/joshua/casify/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestParseFilterErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{"unknown field", "weight > 3"},
+		{"missing operator", "price 10"},
+		{"missing value", "price >"},
+		{"text value for a number", "price > cheap"},
+		{"NaN", "price > NaN"},
+		{"Inf", "price < Inf"},
+		{"negative Inf", "price > -Inf"},
+		{"hex float", "price > 0x1p4"},
+		{"exponent", "price > 1e3"},
+		{"underscores", "price > 1_000"},
+		{"trailing dot", "price > 10."},
+		{"range operator on text", "title > abc"},
+		{"contains on a number", "price ~ 10"},
+		{"contains on an id", "device ~ abc"},
+		{"contains on an enum", "status ~ pub"},
+		{"invalid id", "device = nope"},
+		{"bare bang", "price ! 10"},
+		{"unterminated string", `title = "leather`},
+		{"unexpected character", "price > 10 & rating > 4"},
+		{"unclosed parenthesis", "(price > 10"},
+		{"trailing token", "price > 10 rating"},
+		{"dangling AND", "price > 10 AND"},
+		{"NOT without IN", "color NOT red"},
+		{"IN without list", "color IN red"},
+		{"unclosed IN list", "color IN (red, blue"},
+		{"empty IN list", "color IN ()"},
+		{"number in text IN list position", "price IN (1, cheap)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node, err := ParseFilter(tt.input)
+			var filterErr *FilterError
+			if !errors.As(err, &filterErr) {
+				t.Fatalf("ParseFilter(%q) = %v, %v, want a FilterError", tt.input, node, err)
+			}
+		})
+	}
+}
+
+func TestParseFilterNumbers(t *testing.T) {
+	tests := []struct {
+		input string
+		want  float64
+	}{
+		{"price > 10", 10},
+		{"price >= 10.5", 10.5},
+		{"price < 0.99", 0.99},
+		{"discount != -5", -5},
+		{"price=007", 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			node, err := ParseFilter(tt.input)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			compare, ok := node.(*CompareNode)
+			if !ok || compare.Value != tt.want {
+				t.Fatalf("node = %#v, want value %v", node, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFilterLimits(t *testing.T) {
+	conditions := func(n int) string {
+		parts := make([]string, n)
+		for i := range parts {
+			parts[i] = fmt.Sprintf("price > %d", i)
+		}
+		return strings.Join(parts, " OR ")
+	}
+	nested := func(depth int) string {
+		return strings.Repeat("(", depth) + "price > 1" + strings.Repeat(")", depth)
+	}
+	tests := []struct {
+		name    string
+		input   string
+		wantErr bool
+	}{
+		{"empty", "", false},
+		{"only spaces", "   ", false},
+		{"longest allowed", "title ~ " + strings.Repeat("a", maxFilterLength-len("title ~ ")), false},
+		{"too long", "title ~ " + strings.Repeat("a", maxFilterLength), true},
+		{"most conditions allowed", conditions(maxFilterConditions), false},
+		{"too many conditions", conditions(maxFilterConditions + 1), true},
+		{"too many IN conditions", strings.Repeat("color IN (a) AND ", maxFilterConditions) + "color IN (b)", true},
+		{"deepest nesting allowed", nested(maxFilterDepth), false},
+		{"nested too deeply", nested(maxFilterDepth + 1), true},
+		{"NOT nested too deeply", strings.Repeat("NOT ", maxFilterDepth+1) + "price > 1", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseFilter(tt.input)
+			if tt.wantErr {
+				var filterErr *FilterError
+				if !errors.As(err, &filterErr) {
+					t.Fatalf("error = %v, want a FilterError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestParseFilterEscapesRegex(t *testing.T) {
+	tests := []struct {
+		input   string
+		pattern string
+	}{
+		{`title ~ "a.b*"`, `a\.b\*`},
+		{`title = "(x|y)"`, `^\(x\|y\)$`},
+		{`color != "^red$"`, `^\^red\$$`},
+		{`description ~ '[a-z]+'`, `\[a-z\]\+`},
+		{`category IN ("a+b")`, `^a\+b$`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			node, err := ParseFilter(tt.input)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var patterns []string
+			collectRegexes(node.Compile(), &patterns)
+			if len(patterns) != 1 || patterns[0] != tt.pattern {
+				t.Fatalf("patterns = %q, want %q", patterns, tt.pattern)
+			}
+		})
+	}
+}
+
+func TestParseFilterExactFields(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bson.M
+	}{
+		{"status = published", bson.M{"status": "published"}},
+		{"status != draft", bson.M{"status": bson.M{"$ne": "draft"}}},
+		{"status IN (draft, scheduled)", bson.M{"status": bson.M{"$in": bson.A{"draft", "scheduled"}}}},
+		{"status NOT IN (archived)", bson.M{"status": bson.M{"$nin": bson.A{"archived"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			node, err := ParseFilter(tt.input)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := node.Compile(); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Compile = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if got, want := CompileFilter(LiveProductFilter()), (bson.M{"status": model.ProductPublished}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("LiveProductFilter compiles to %v, want %v", got, want)
+	}
+	node, _ := ParseFilter("status = PUBLISHED")
+	if node.Eval(model.Product{Status: model.ProductPublished}) {
+		t.Fatal("status matched case-insensitively")
+	}
+}
+
+// collectRegexes gathers the patterns of every regex in a compiled filter.
+func collectRegexes(value interface{}, patterns *[]string) {
+	switch v := value.(type) {
+	case primitive.Regex:
+		*patterns = append(*patterns, v.Pattern)
+	case bson.M:
+		for _, child := range v {
+			collectRegexes(child, patterns)
+		}
+	case []bson.M:
+		for _, child := range v {
+			collectRegexes(child, patterns)
+		}
+	case bson.A:
+		for _, child := range v {
+			collectRegexes(child, patterns)
+		}
+	}
+}
+
+func TestFilterEvalMatchesCompile(t *testing.T) {
+	magsafe, leather := primitive.NewObjectID(), primitive.NewObjectID()
+	iphone, pixel := primitive.NewObjectID(), primitive.NewObjectID()
+	products := []model.Product{
+		{
+			Id: primitive.NewObjectID(), Title: "Leather MagSafe Case", Description: "Full grain (brown) leather",
+			Price: 49.99, Discount: 10, Rating: 4.5, Color: "Brown", Status: model.ProductPublished,
+			Category: []string{"MagSafe", "Leather"}, CategoryIds: []primitive.ObjectID{magsafe, leather},
+			CompatibleDevices: []primitive.ObjectID{iphone},
+		},
+		{
+			Id: primitive.NewObjectID(), Title: "Clear Case", Description: "Slim a.b* case",
+			Price: 19, Rating: 3, Color: "clear", Status: model.ProductDraft,
+			Category: []string{"Clear"}, CompatibleDevices: []primitive.ObjectID{iphone, pixel},
+		},
+		{
+			Id: primitive.NewObjectID(), Title: "Bare", Description: "No optional fields", Price: 5,
+		},
+	}
+	inputs := []string{
+		"price > 20",
+		"price >= 19 AND price <= 19",
+		"price != 19",
+		"price IN (5, 19)",
+		"price NOT IN (5)",
+		"discount = 0",
+		"discount != 0",
+		"discount < 20",
+		"discount NOT IN (10)",
+		"rating >= 3",
+		"rating != 4.5",
+		"title ~ case",
+		"title = 'clear case'",
+		"title != 'Clear Case'",
+		`description ~ "a.b*"`,
+		`description ~ "(brown)"`,
+		"color = BROWN",
+		"color != brown",
+		"color IN (brown, clear)",
+		"color NOT IN (brown)",
+		"category = magsafe",
+		"category != leather",
+		"category ~ safe",
+		"category IN (clear, leather)",
+		"category NOT IN (clear)",
+		"category_id = " + magsafe.Hex(),
+		"category_id != " + leather.Hex(),
+		"device = " + pixel.Hex(),
+		"device NOT IN (" + pixel.Hex() + ")",
+		"device IN (" + pixel.Hex() + ", " + iphone.Hex() + ")",
+		"status = published",
+		"status = PUBLISHED",
+		"status != draft",
+		"status IN (draft, published)",
+		"NOT price > 20",
+		"NOT (color = brown OR rating < 4)",
+		"price < 10 OR (category = clear AND NOT color = brown)",
+	}
+	for _, input := range inputs {
+		t.Run(input, func(t *testing.T) {
+			node, err := ParseFilter(input)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			compiled := node.Compile()
+			for _, p := range products {
+				want := node.Eval(p)
+				if got := matchDocument(t, compiled, productDocument(t, p)); got != want {
+					t.Errorf("%s: compiled filter matched %v, Eval %v", p.Title, got, want)
+				}
+			}
+		})
+	}
+}
+
+// productDocument encodes a product the way it is stored.
+func productDocument(t *testing.T, p model.Product) bson.M {
+	raw, err := bson.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+// matchDocument evaluates the subset of MongoDB query operators Compile
+// emits against a document.
+func matchDocument(t *testing.T, filter bson.M, doc bson.M) bool {
+	for key, value := range filter {
+		switch key {
+		case "$and", "$or", "$nor":
+			parts := value.([]bson.M)
+			matched := 0
+			for _, part := range parts {
+				if matchDocument(t, part, doc) {
+					matched++
+				}
+			}
+			if (key == "$and" && matched != len(parts)) || (key == "$or" && matched == 0) || (key == "$nor" && matched > 0) {
+				return false
+			}
+		default:
+			if !matchField(t, doc[key], value) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func matchField(t *testing.T, field, condition interface{}) bool {
+	operators, ok := condition.(bson.M)
+	if !ok {
+		return anyValue(field, func(v interface{}) bool { return equalValue(v, condition) })
+	}
+	for op, arg := range operators {
+		var matched bool
+		switch op {
+		case "$ne":
+			matched = !anyValue(field, func(v interface{}) bool { return equalValue(v, arg) })
+		case "$gt", "$gte", "$lt", "$lte":
+			matched = anyValue(field, func(v interface{}) bool {
+				num, ok := v.(float64)
+				want := arg.(float64)
+				return ok && map[string]bool{"$gt": num > want, "$gte": num >= want, "$lt": num < want, "$lte": num <= want}[op]
+			})
+		case "$in", "$nin":
+			matched = anyValue(field, func(v interface{}) bool {
+				for _, want := range arg.(bson.A) {
+					if equalValue(v, want) {
+						return true
+					}
+				}
+				return false
+			})
+			if op == "$nin" {
+				matched = !matched
+			}
+		case "$not":
+			matched = !anyValue(field, func(v interface{}) bool { return equalValue(v, arg) })
+		default:
+			t.Fatalf("unsupported operator %s", op)
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// anyValue applies match to a field, or to each element of an array field.
+// Missing fields match nothing.
+func anyValue(field interface{}, match func(v interface{}) bool) bool {
+	if field == nil {
+		return false
+	}
+	if values, ok := field.(bson.A); ok {
+		for _, v := range values {
+			if match(v) {
+				return true
+			}
+		}
+		return false
+	}
+	return match(field)
+}
+
+// equalValue compares a stored value with a query value, matching strings
+// against regexes.
+func equalValue(v, want interface{}) bool {
+	if re, ok := want.(primitive.Regex); ok {
+		s, isString := v.(string)
+		return isString && regexp.MustCompile("(?"+re.Options+")"+re.Pattern).MatchString(s)
+	}
+	return v == want
+}
@@ -68,7 +68,12 @@ func ComparePassword(hashedPassword, password string) bool {
 func ValidateProductInput(p model.Product) error {
 	// Check required fields
 	if p.Title == "" || p.Description == "" || p.Price == 0 ||
-		p.Images == nil || p.Details.Details == nil || p.Color == "" || p.Category == nil {
+		p.Images == nil || p.Details.Details == nil || (p.Category == nil && p.CategoryIds == nil) {
+		return errors.New("all fields are required")
+	}
+
+	// Products with variants carry their color as an option instead
+	if p.Color == "" && len(p.Variants) == 0 {
 		return errors.New("all fields are required")
 	}
 
@@ -77,18 +82,13 @@ func ValidateProductInput(p model.Product) error {
 		return errors.New("price and discount must be non-negative")
 	}
 
-	// Validate ratings
-	if p.Rating < 0 || p.Rating > 5 {
-		return errors.New("ratings must be between 0 and 5")
-	}
-
-	// Validate comments
-	if err := validateStringSlice(p.Comments, "comments"); err != nil {
+	// Validate images
+	if err := validateStringSlice(p.Images, "images"); err != nil {
 		return err
 	}
 
-	// Validate images
-	if err := validateStringSlice(p.Images, "images"); err != nil {
+	// Validate variants
+	if err := ValidateVariants(p); err != nil {
 		return err
 	}
 
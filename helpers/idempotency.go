@@ -0,0 +1,54 @@
+package helpers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"time"
+)
+
+// maxIdempotencyKey bounds the length of an Idempotency-Key header.
+const maxIdempotencyKey = 255
+
+// IdempotencyTTLFromEnv reads IDEMPOTENCY_TTL, a Go duration such as "24h",
+// how long responses are kept for replay. It defaults to 24 hours.
+func IdempotencyTTLFromEnv() time.Duration {
+	if ttl, err := time.ParseDuration(os.Getenv("IDEMPOTENCY_TTL")); err == nil && ttl > 0 {
+		return ttl
+	}
+	return 24 * time.Hour
+}
+
+// IdempotencyLeaseFromEnv reads IDEMPOTENCY_LEASE, a Go duration, how long
+// a request holds its key before a retry may take over, e.g. after the
+// server crashed. Running requests keep renewing it. It defaults to one
+// minute.
+func IdempotencyLeaseFromEnv() time.Duration {
+	if lease, err := time.ParseDuration(os.Getenv("IDEMPOTENCY_LEASE")); err == nil && lease > 0 {
+		return lease
+	}
+	return time.Minute
+}
+
+// ValidateIdempotencyKey checks a client supplied key.
+func ValidateIdempotencyKey(key string) error {
+	if len(key) > maxIdempotencyKey {
+		return errors.New("Idempotency-Key is limited to 255 characters")
+	}
+	for _, r := range key {
+		if r < 0x21 || r > 0x7e {
+			return errors.New("Idempotency-Key must be printable ASCII without spaces")
+		}
+	}
+	return nil
+}
+
+// RequestHash identifies a request by method, path with query and body, to
+// tell a retry from a different request reusing the key.
+func RequestHash(method, uri string, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(method + " " + uri + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
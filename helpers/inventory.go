@@ -0,0 +1,143 @@
+package helpers
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/joshua/casify/model"
+)
+
+// defaultReservationTTL is how long a reservation holds stock when
+// STOCK_RESERVATION_TTL is not set.
+const defaultReservationTTL = 15 * time.Minute
+
+// ReservationTTLFromEnv reads STOCK_RESERVATION_TTL, a Go duration such as
+// "30m".
+func ReservationTTLFromEnv() time.Duration {
+	if ttl, err := time.ParseDuration(os.Getenv("STOCK_RESERVATION_TTL")); err == nil && ttl > 0 {
+		return ttl
+	}
+	return defaultReservationTTL
+}
+
+// StockRequest asks for a quantity of one SKU.
+type StockRequest struct {
+	SKU      string `json:"sku" binding:"required"`
+	Quantity int    `json:"quantity" binding:"required"`
+}
+
+// NormalizeStockRequests validates requested quantities and merges lines
+// asking for the same SKU. The result is sorted by SKU so concurrent
+// checkouts always touch stock in the same order.
+func NormalizeStockRequests(items []StockRequest) ([]StockRequest, error) {
+	if len(items) == 0 {
+		return nil, fmt.Errorf("at least one item is required")
+	}
+	quantities := map[string]int{}
+	for _, item := range items {
+		sku := strings.TrimSpace(item.SKU)
+		if sku == "" {
+			return nil, fmt.Errorf("every item needs a sku")
+		}
+		if item.Quantity <= 0 {
+			return nil, fmt.Errorf("quantity for %s must be positive", sku)
+		}
+		quantities[sku] += item.Quantity
+	}
+
+	out := make([]StockRequest, 0, len(quantities))
+	for sku, qty := range quantities {
+		out = append(out, StockRequest{SKU: sku, Quantity: qty})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].SKU < out[j].SKU })
+	return out, nil
+}
+
+// adjustReasons are the reasons accepted for a manual stock adjustment.
+// Sales are recorded by committing reservations.
+var adjustReasons = map[string]bool{
+	model.AdjustRestock:    true,
+	model.AdjustReturn:     true,
+	model.AdjustDamage:     true,
+	model.AdjustCorrection: true,
+}
+
+// ValidateAdjustment checks a manual stock adjustment.
+func ValidateAdjustment(delta int, reason string) error {
+	if delta == 0 {
+		return fmt.Errorf("delta cannot be zero")
+	}
+	if !adjustReasons[reason] {
+		return fmt.Errorf("unknown adjustment reason %q", reason)
+	}
+	return nil
+}
+
+// StockAlert is the payload of low and out of stock events.
+type StockAlert struct {
+	SKU       string `json:"sku"`
+	Available int    `json:"available"`
+	Threshold int    `json:"threshold"`
+}
+
+// StockAlertTopic returns the event to publish when a SKU's available stock
+// moves from before to after, or "" when no threshold was crossed. Alerts
+// fire once on the way down, not on every change below the threshold.
+func StockAlertTopic(before, after int, threshold int) string {
+	switch {
+	case after <= 0 && before > 0:
+		return EventOutOfStock
+	case threshold > 0 && after <= threshold && before > threshold:
+		return EventLowStock
+	default:
+		return ""
+	}
+}
+
+// SKUAvailability is the stock of one SKU as shown to shoppers.
+type SKUAvailability struct {
+	Available int  `json:"available"`
+	InStock   bool `json:"in_stock"`
+	LowStock  bool `json:"low_stock"`
+}
+
+// ProductAvailability sums up the stock of a product across its SKUs.
+type ProductAvailability struct {
+	InStock   bool                       `json:"in_stock"`
+	Available int                        `json:"available"`
+	SKUs      map[string]SKUAvailability `json:"skus"`
+}
+
+// BuildAvailability summarizes the stock levels of a product's SKUs. SKUs
+// without a stock level count as out of stock.
+func BuildAvailability(p model.Product, levels []model.StockLevel) *ProductAvailability {
+	skus := SellableSKUs(p)
+	if len(skus) == 0 {
+		return nil
+	}
+
+	bySKU := make(map[string]model.StockLevel, len(levels))
+	for _, level := range levels {
+		bySKU[level.SKU] = level
+	}
+
+	out := &ProductAvailability{SKUs: make(map[string]SKUAvailability, len(skus))}
+	for _, sku := range skus {
+		level := bySKU[sku]
+		available := level.Available()
+		if available < 0 {
+			available = 0
+		}
+		out.SKUs[sku] = SKUAvailability{
+			Available: available,
+			InStock:   available > 0,
+			LowStock:  available > 0 && available <= level.LowStockThreshold,
+		}
+		out.Available += available
+	}
+	out.InStock = out.Available > 0
+	return out
+}
@@ -0,0 +1,176 @@
+package helpers
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/joshua/casify/model"
+)
+
+const defaultSchedulerInterval = time.Minute
+
+// SchedulerIntervalFromEnv reads PRODUCT_SCHEDULER_INTERVAL, how often
+// scheduled publishing and unpublishing is checked, as a Go duration.
+func SchedulerIntervalFromEnv() time.Duration {
+	if interval, err := time.ParseDuration(os.Getenv("PRODUCT_SCHEDULER_INTERVAL")); err == nil && interval > 0 {
+		return interval
+	}
+	return defaultSchedulerInterval
+}
+
+// ProductStatuses lists the lifecycle states in their natural order.
+var ProductStatuses = []string{model.ProductDraft, model.ProductScheduled, model.ProductPublished, model.ProductArchived}
+
+// ValidProductStatus reports whether status is a known lifecycle state.
+func ValidProductStatus(status string) bool {
+	for _, s := range ProductStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// ProductLive reports whether shoppers can see a product.
+func ProductLive(p model.Product) bool {
+	return p.Status == model.ProductPublished
+}
+
+// LiveProductFilter matches the products shoppers can see. It compiles to a
+// plain equality on status, so listings can use the status index.
+func LiveProductFilter() FilterNode {
+	node, _ := NewCompare("status", OpEq, model.ProductPublished)
+	return node
+}
+
+// PublishError lists what a product is missing before it can go live.
+type PublishError struct {
+	Missing []string
+}
+
+func (e *PublishError) Error() string {
+	return "product is incomplete, missing " + strings.Join(e.Missing, ", ")
+}
+
+// CheckPublishable returns a *PublishError when a product lacks something
+// shoppers need to see or buy it.
+func CheckPublishable(p model.Product) error {
+	var missing []string
+	if strings.TrimSpace(p.Title) == "" {
+		missing = append(missing, "title")
+	}
+	if p.Slug == "" {
+		missing = append(missing, "slug")
+	}
+	if strings.TrimSpace(p.Description) == "" {
+		missing = append(missing, "description")
+	}
+	if p.Price <= 0 {
+		missing = append(missing, "price")
+	}
+	if len(p.Images) == 0 || strings.TrimSpace(p.Images[0]) == "" {
+		missing = append(missing, "images")
+	}
+	if len(p.Category) == 0 && len(p.CategoryIds) == 0 {
+		missing = append(missing, "category")
+	}
+	if len(SellableSKUs(p)) == 0 {
+		missing = append(missing, "sku")
+	}
+	if len(missing) > 0 {
+		return &PublishError{Missing: missing}
+	}
+	return nil
+}
+
+// CheckStillPublishable is CheckPublishable for an update of a live or
+// scheduled product. Only what the update takes away is reported, so
+// products that went live before a field was required can still be edited.
+func CheckStillPublishable(before, after model.Product) error {
+	var missingAfter *PublishError
+	if !errors.As(CheckPublishable(after), &missingAfter) {
+		return nil
+	}
+	lacked := map[string]bool{}
+	var missingBefore *PublishError
+	if errors.As(CheckPublishable(before), &missingBefore) {
+		for _, field := range missingBefore.Missing {
+			lacked[field] = true
+		}
+	}
+	var missing []string
+	for _, field := range missingAfter.Missing {
+		if !lacked[field] {
+			missing = append(missing, field)
+		}
+	}
+	if len(missing) > 0 {
+		return &PublishError{Missing: missing}
+	}
+	return nil
+}
+
+// StatusChange asks to move a product to another lifecycle state.
+type StatusChange struct {
+	Status      string     `json:"status" binding:"required"`
+	PublishAt   *time.Time `json:"publish_at"`
+	UnpublishAt *time.Time `json:"unpublish_at"`
+}
+
+// ApplyStatusChange returns p moved to the requested state at now.
+// Publishing and scheduling require a complete product; a scheduled
+// product needs a publish time in the future. Drafts drop their schedule,
+// archived products keep the time they were published.
+func ApplyStatusChange(p model.Product, change StatusChange, now time.Time) (model.Product, error) {
+	if !ValidProductStatus(change.Status) {
+		return p, fmt.Errorf("status must be one of %s", strings.Join(ProductStatuses, ", "))
+	}
+
+	switch change.Status {
+	case model.ProductDraft:
+		p.PublishAt, p.UnpublishAt = nil, nil
+	case model.ProductArchived:
+		p.UnpublishAt = nil
+	case model.ProductScheduled:
+		if change.PublishAt == nil || !change.PublishAt.After(now) {
+			return p, errors.New("scheduled products need a publish_at in the future")
+		}
+		p.PublishAt, p.UnpublishAt = change.PublishAt, change.UnpublishAt
+	case model.ProductPublished:
+		if change.PublishAt != nil && change.PublishAt.After(now) {
+			return p, errors.New("publish_at is in the future, schedule the product instead")
+		}
+		publishedAt := now
+		p.PublishAt, p.UnpublishAt = &publishedAt, change.UnpublishAt
+	}
+
+	if change.Status == model.ProductScheduled || change.Status == model.ProductPublished {
+		if p.UnpublishAt != nil && !p.UnpublishAt.After(*p.PublishAt) {
+			return p, errors.New("unpublish_at must be after publish_at")
+		}
+		if p.UnpublishAt != nil && !p.UnpublishAt.After(now) {
+			return p, errors.New("unpublish_at must be in the future")
+		}
+		if err := CheckPublishable(p); err != nil {
+			return p, err
+		}
+	}
+	p.Status = change.Status
+	return p, nil
+}
+
+// DueStatus returns the state a product should be in at now according to
+// its schedule, and whether that differs from its current state.
+func DueStatus(p model.Product, now time.Time) (string, bool) {
+	status := p.Status
+	if status == model.ProductScheduled && p.PublishAt != nil && !p.PublishAt.After(now) {
+		status = model.ProductPublished
+	}
+	if status == model.ProductPublished && p.UnpublishAt != nil && !p.UnpublishAt.After(now) {
+		status = model.ProductArchived
+	}
+	return status, status != p.Status
+}
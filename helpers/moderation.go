@@ -0,0 +1,149 @@
+package helpers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/joshua/casify/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Reasons the automated screen holds a review for a moderator.
+const (
+	FlagProfanity = "profanity"
+	FlagLinks     = "links"
+	FlagDuplicate = "duplicate"
+	FlagRateLimit = "rate_limit"
+)
+
+// defaultBlockedWords is the built in profanity list. REVIEW_BLOCKED_WORDS
+// adds to it.
+var defaultBlockedWords = []string{
+	"arsehole", "asshole", "bastard", "bitch", "bollocks", "bullshit",
+	"cunt", "dick", "fuck", "fucker", "fucking", "motherfucker",
+	"piss", "prick", "shit", "slut", "twat", "wanker", "whore",
+}
+
+var linkPattern = regexp.MustCompile(`(?i)(https?://|www\.)\S+|\b[a-z0-9-]+\.(com|net|org|info|biz|io|ru|xyz|top|shop)\b`)
+
+// ScreenConfig holds the limits of the automated review screen.
+type ScreenConfig struct {
+	BlockedWords map[string]bool
+	// MaxPerHour is how many reviews a user may post in an hour before
+	// further ones are held.
+	MaxPerHour int
+}
+
+// ScreenConfigFromEnv reads REVIEW_BLOCKED_WORDS, a comma separated list
+// added to the built in one, and REVIEW_RATE_LIMIT, reviews per user and
+// hour (default 5).
+func ScreenConfigFromEnv() ScreenConfig {
+	config := ScreenConfig{BlockedWords: map[string]bool{}, MaxPerHour: 5}
+	for _, word := range defaultBlockedWords {
+		config.BlockedWords[word] = true
+	}
+	for _, word := range strings.Split(os.Getenv("REVIEW_BLOCKED_WORDS"), ",") {
+		if word = strings.ToLower(strings.TrimSpace(word)); word != "" {
+			config.BlockedWords[word] = true
+		}
+	}
+	if limit, err := strconv.Atoi(os.Getenv("REVIEW_RATE_LIMIT")); err == nil && limit > 0 {
+		config.MaxPerHour = limit
+	}
+	return config
+}
+
+// reviewWords splits text into lower case words.
+func reviewWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// ContainsBlockedWord reports whether text uses a blocked word. Whole words
+// are matched, so "Scunthorpe" or "dickens" pass.
+func ContainsBlockedWord(text string, blocked map[string]bool) bool {
+	for _, word := range reviewWords(text) {
+		if blocked[word] {
+			return true
+		}
+	}
+	return false
+}
+
+// ContainsLink reports whether text holds a URL or a bare domain.
+func ContainsLink(text string) bool {
+	return linkPattern.MatchString(text)
+}
+
+// ReviewFingerprint identifies the text of a review regardless of case,
+// spacing and punctuation. Reviews without text have no fingerprint.
+func ReviewFingerprint(r ReviewInput) string {
+	words := reviewWords(r.Title + " " + r.Body)
+	if len(words) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(strings.Join(words, " ")))
+	return hex.EncodeToString(sum[:])
+}
+
+// ScreenReview runs the automated checks on a review. recent is how many
+// reviews the author posted in the last hour and duplicate whether the same
+// text was already posted. It returns the flags raised, none for clean
+// content.
+func ScreenReview(r ReviewInput, recent int, duplicate bool, config ScreenConfig) []string {
+	return ScreenText(r.Title+" "+r.Body, recent, duplicate, config)
+}
+
+// ScreenText runs the automated checks on any text customers publish, such
+// as reviews, questions and answers.
+func ScreenText(text string, recent int, duplicate bool, config ScreenConfig) []string {
+	flags := []string{}
+	if ContainsBlockedWord(text, config.BlockedWords) {
+		flags = append(flags, FlagProfanity)
+	}
+	if ContainsLink(text) {
+		flags = append(flags, FlagLinks)
+	}
+	if duplicate {
+		flags = append(flags, FlagDuplicate)
+	}
+	if recent >= config.MaxPerHour {
+		flags = append(flags, FlagRateLimit)
+	}
+	return flags
+}
+
+// ScreenedStatus is the status of a review, question or answer after
+// screening: clean content is approved straight away, flagged content waits
+// for a moderator.
+func ScreenedStatus(flags []string) string {
+	if len(flags) == 0 {
+		return model.ReviewApproved
+	}
+	return model.ReviewPending
+}
+
+// ValidReviewStatus reports whether status is a known review status.
+// Questions and answers share the review statuses.
+func ValidReviewStatus(status string) bool {
+	switch status {
+	case model.ReviewPending, model.ReviewApproved, model.ReviewRejected:
+		return true
+	}
+	return false
+}
+
+// ReviewRejection is the payload of review rejected events.
+type ReviewRejection struct {
+	ReviewId  primitive.ObjectID `json:"review_id"`
+	ProductId primitive.ObjectID `json:"product_id"`
+	UserId    primitive.ObjectID `json:"user_id"`
+	Title     string             `json:"title"`
+	Reason    string             `json:"reason"`
+}
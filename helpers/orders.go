@@ -0,0 +1,188 @@
+package helpers
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/joshua/casify/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// OrderTransitions lists the states each order state may move to.
+// Cancelled and refunded orders are final.
+var OrderTransitions = map[string][]string{
+	model.OrderPending:    {model.OrderPaid, model.OrderCancelled},
+	model.OrderPaid:       {model.OrderFulfilling, model.OrderRefunded},
+	model.OrderFulfilling: {model.OrderShipped, model.OrderRefunded},
+	model.OrderShipped:    {model.OrderDelivered},
+	model.OrderDelivered:  {model.OrderRefunded},
+	model.OrderCancelled:  {},
+	model.OrderRefunded:   {},
+}
+
+// PurchasedOrderStatuses are the states of orders that were paid for and
+// not refunded.
+var PurchasedOrderStatuses = []string{
+	model.OrderPaid, model.OrderFulfilling, model.OrderShipped, model.OrderDelivered,
+}
+
+// TransitionError is returned for a move the state machine does not allow.
+type TransitionError struct {
+	From, To string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("an order cannot go from %s to %s", e.From, e.To)
+}
+
+// ValidOrderStatus reports whether status is a known order state.
+func ValidOrderStatus(status string) bool {
+	_, ok := OrderTransitions[status]
+	return ok
+}
+
+// CheckOrderTransition returns a *TransitionError unless an order may move
+// from one state to the other.
+func CheckOrderTransition(from, to string) error {
+	for _, next := range OrderTransitions[from] {
+		if next == to {
+			return nil
+		}
+	}
+	return &TransitionError{From: from, To: to}
+}
+
+// ShippingMethod is a way an order can be delivered.
+type ShippingMethod struct {
+	Code  string  `json:"code"`
+	Name  string  `json:"name"`
+	Price float64 `json:"price"`
+	// FreeOver waives the price for subtotals at or above it. Zero never
+	// waives it.
+	FreeOver float64 `json:"free_over,omitempty"`
+	Days     string  `json:"days"`
+}
+
+// ShippingMethods are the delivery options offered at checkout.
+var ShippingMethods = []ShippingMethod{
+	{Code: "standard", Name: "Standard", Price: 4.99, FreeOver: 50, Days: "3-5"},
+	{Code: "express", Name: "Express", Price: 12.99, Days: "1-2"},
+}
+
+// FindShippingMethod looks up a shipping method by code.
+func FindShippingMethod(code string) (ShippingMethod, bool) {
+	for _, m := range ShippingMethods {
+		if m.Code == code {
+			return m, true
+		}
+	}
+	return ShippingMethod{}, false
+}
+
+// Cost is what the method costs for an order of subtotal.
+func (m ShippingMethod) Cost(subtotal float64) float64 {
+	if m.FreeOver > 0 && subtotal >= m.FreeOver {
+		return 0
+	}
+	return m.Price
+}
+
+// StoreCurrencyFromEnv reads STORE_CURRENCY, falling back to the feed
+// currency and then USD.
+func StoreCurrencyFromEnv() string {
+	if currency := os.Getenv("STORE_CURRENCY"); currency != "" {
+		return currency
+	}
+	return FeedConfigFromEnv().Currency
+}
+
+// ValidateAddress checks a shipping address.
+func ValidateAddress(a model.Address) error {
+	required := []struct{ name, value string }{
+		{"name", a.Name}, {"line1", a.Line1}, {"city", a.City},
+		{"postal_code", a.PostalCode}, {"country", a.Country},
+	}
+	for _, field := range required {
+		if strings.TrimSpace(field.value) == "" {
+			return fmt.Errorf("%s is required", field.name)
+		}
+	}
+	if len(strings.TrimSpace(a.Country)) != 2 {
+		return errors.New("country must be a two letter ISO code")
+	}
+	return nil
+}
+
+// ErrEmptyCart is returned when checking out a cart without items.
+var ErrEmptyCart = errors.New("the cart is empty")
+
+// OrderLinesFromCart turns a priced cart into order lines at the current
+// prices. Items that can no longer be bought fail the checkout rather than
+// being dropped silently.
+func OrderLinesFromCart(view CartView) ([]model.OrderLine, error) {
+	if len(view.Items) == 0 {
+		return nil, ErrEmptyCart
+	}
+	lines := make([]model.OrderLine, 0, len(view.Items))
+	for _, item := range view.Items {
+		if !item.Purchasable {
+			return nil, fmt.Errorf("%w: %s", ErrSKUNotSold, item.SKU)
+		}
+		lines = append(lines, model.OrderLine{
+			SKU:       item.SKU,
+			ProductId: item.ProductId,
+			Title:     item.Title,
+			Options:   item.Options,
+			Quantity:  item.Quantity,
+			UnitPrice: item.Price,
+			LineTotal: item.LineTotal,
+		})
+	}
+	return lines, nil
+}
+
+// NewOrder builds a pending order from its lines.
+func NewOrder(userId primitive.ObjectID, lines []model.OrderLine, method ShippingMethod, address model.Address, currency string, now time.Time) model.Order {
+	order := model.Order{
+		Id:              primitive.NewObjectID(),
+		UserId:          userId,
+		Status:          model.OrderPending,
+		Lines:           lines,
+		ShippingMethod:  method.Code,
+		ShippingAddress: address,
+		Currency:        currency,
+		History:         []model.OrderTransition{{To: model.OrderPending, Actor: userId, At: now}},
+		TimeStamp:       model.TimeStamp{CreatedAt: now, UpdatedAt: now},
+	}
+	order.ShippingAddress.Country = strings.ToUpper(strings.TrimSpace(address.Country))
+	seen := map[primitive.ObjectID]bool{}
+	for _, line := range lines {
+		order.Subtotal += line.LineTotal
+		if !seen[line.ProductId] {
+			seen[line.ProductId] = true
+			order.ProductIds = append(order.ProductIds, line.ProductId)
+		}
+	}
+	order.Subtotal = roundCents(order.Subtotal)
+	order.ShippingCost = method.Cost(order.Subtotal)
+	order.Total = roundCents(order.Subtotal + order.ShippingCost)
+	return order
+}
+
+// OrderNumber makes the number shown to customers, such as
+// CS-261019-4F2A9C, from the order date and a random suffix.
+func OrderNumber(now time.Time, suffix string) string {
+	return "CS-" + now.Format("060102") + "-" + strings.ToUpper(suffix)
+}
+
+// StockRequestsForOrder lists the stock an order needs.
+func StockRequestsForOrder(lines []model.OrderLine) []StockRequest {
+	items := make([]StockRequest, len(lines))
+	for i, line := range lines {
+		items[i] = StockRequest{SKU: line.SKU, Quantity: line.Quantity}
+	}
+	return items
+}
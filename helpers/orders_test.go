@@ -0,0 +1,162 @@
+package helpers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/joshua/casify/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCheckOrderTransition(t *testing.T) {
+	tests := []struct {
+		from, to string
+		allowed  bool
+	}{
+		{model.OrderPending, model.OrderPaid, true},
+		{model.OrderPending, model.OrderCancelled, true},
+		{model.OrderPaid, model.OrderFulfilling, true},
+		{model.OrderPaid, model.OrderRefunded, true},
+		{model.OrderFulfilling, model.OrderShipped, true},
+		{model.OrderFulfilling, model.OrderRefunded, true},
+		{model.OrderShipped, model.OrderDelivered, true},
+		{model.OrderDelivered, model.OrderRefunded, true},
+
+		{model.OrderPending, model.OrderShipped, false},
+		{model.OrderPending, model.OrderRefunded, false},
+		{model.OrderPaid, model.OrderCancelled, false},
+		{model.OrderPaid, model.OrderPending, false},
+		{model.OrderShipped, model.OrderRefunded, false},
+		{model.OrderShipped, model.OrderCancelled, false},
+		{model.OrderCancelled, model.OrderPaid, false},
+		{model.OrderRefunded, model.OrderPaid, false},
+		{model.OrderPaid, model.OrderPaid, false},
+		{"lost", model.OrderPaid, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.from+"->"+tt.to, func(t *testing.T) {
+			err := CheckOrderTransition(tt.from, tt.to)
+			if tt.allowed {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var transition *TransitionError
+			if !errors.As(err, &transition) || transition.From != tt.from || transition.To != tt.to {
+				t.Fatalf("error = %v, want a TransitionError", err)
+			}
+		})
+	}
+}
+
+func TestValidOrderStatus(t *testing.T) {
+	for status := range OrderTransitions {
+		if !ValidOrderStatus(status) {
+			t.Errorf("%s is not valid", status)
+		}
+	}
+	if ValidOrderStatus("lost") {
+		t.Error("unknown status is valid")
+	}
+}
+
+func TestNewOrderTotals(t *testing.T) {
+	standard, _ := FindShippingMethod("standard")
+	express, _ := FindShippingMethod("express")
+	productA, productB := primitive.NewObjectID(), primitive.NewObjectID()
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		lines        []model.OrderLine
+		method       ShippingMethod
+		subtotal     float64
+		shippingCost float64
+		total        float64
+		productIds   int
+	}{
+		{
+			name:         "standard below the free threshold",
+			lines:        []model.OrderLine{{SKU: "a", ProductId: productA, Quantity: 2, UnitPrice: 19.99, LineTotal: 39.98}},
+			method:       standard,
+			subtotal:     39.98,
+			shippingCost: 4.99,
+			total:        44.97,
+			productIds:   1,
+		},
+		{
+			name:         "standard free at the threshold",
+			lines:        []model.OrderLine{{SKU: "a", ProductId: productA, Quantity: 2, UnitPrice: 25, LineTotal: 50}},
+			method:       standard,
+			subtotal:     50,
+			shippingCost: 0,
+			total:        50,
+			productIds:   1,
+		},
+		{
+			name:         "express never free",
+			lines:        []model.OrderLine{{SKU: "a", ProductId: productA, Quantity: 4, UnitPrice: 25, LineTotal: 100}},
+			method:       express,
+			subtotal:     100,
+			shippingCost: 12.99,
+			total:        112.99,
+			productIds:   1,
+		},
+		{
+			name: "subtotal rounded to cents",
+			lines: []model.OrderLine{
+				{SKU: "a", ProductId: productA, Quantity: 1, UnitPrice: 0.1, LineTotal: 0.1},
+				{SKU: "b", ProductId: productB, Quantity: 1, UnitPrice: 0.2, LineTotal: 0.2},
+				{SKU: "c", ProductId: productA, Quantity: 1, UnitPrice: 9.99, LineTotal: 9.99},
+			},
+			method:       standard,
+			subtotal:     10.29,
+			shippingCost: 4.99,
+			total:        15.28,
+			productIds:   2,
+		},
+		{
+			name: "rounding decides the threshold",
+			lines: []model.OrderLine{
+				{SKU: "a", ProductId: productA, Quantity: 1, UnitPrice: 49.9, LineTotal: 49.9},
+				{SKU: "b", ProductId: productB, Quantity: 1, UnitPrice: 0.1, LineTotal: 0.1},
+			},
+			method:       standard,
+			subtotal:     50,
+			shippingCost: 0,
+			total:        50,
+			productIds:   2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userId := primitive.NewObjectID()
+			order := NewOrder(userId, tt.lines, tt.method, model.Address{Country: " us "}, "USD", now)
+			if order.Subtotal != tt.subtotal || order.ShippingCost != tt.shippingCost || order.Total != tt.total {
+				t.Fatalf("totals = %v + %v = %v, want %v + %v = %v",
+					order.Subtotal, order.ShippingCost, order.Total, tt.subtotal, tt.shippingCost, tt.total)
+			}
+			if len(order.ProductIds) != tt.productIds {
+				t.Errorf("product ids = %v, want %d", order.ProductIds, tt.productIds)
+			}
+			if order.Status != model.OrderPending || order.ShippingMethod != tt.method.Code {
+				t.Errorf("order is %s by %s", order.Status, order.ShippingMethod)
+			}
+			if order.ShippingAddress.Country != "US" {
+				t.Errorf("country = %q, want US", order.ShippingAddress.Country)
+			}
+			if len(order.History) != 1 || order.History[0].To != model.OrderPending || order.History[0].Actor != userId {
+				t.Errorf("history = %+v", order.History)
+			}
+		})
+	}
+}
+
+func TestOrderNumber(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	if got := OrderNumber(now, "4f2a9c"); got != "CS-261019-4F2A9C" {
+		t.Errorf("OrderNumber = %s", got)
+	}
+}
@@ -0,0 +1,277 @@
+package helpers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+const (
+	DefaultPageLimit = 20
+	MaxPageLimit     = 100
+)
+
+// ProductSortFields maps the sortable product fields to their document paths.
+// Every entry is backed by an index created at startup.
+var ProductSortFields = map[string]string{
+	"price":      "price",
+	"rating":     "rating",
+	"title":      "title",
+	"created_at": "time_stamp.created_at",
+}
+
+// SortField is one key of a multi-key sort.
+type SortField struct {
+	Name string
+	Path string
+	Desc bool
+}
+
+// ListParams are the pagination, sorting and projection options shared by
+// the listing endpoints.
+type ListParams struct {
+	Limit  int
+	Offset int
+	// UseOffset is set when the client asked for offset pagination instead
+	// of cursors.
+	UseOffset bool
+	// After holds the sort values of the last item of the previous page,
+	// followed by its _id.
+	After  []interface{}
+	Sort   []SortField
+	Fields []string
+	Count  bool
+}
+
+// ParseSort parses a comma separated sort list such as "-price,title". A
+// leading "-" sorts descending. The legacy values "asc" and "desc" sort by
+// price.
+func ParseSort(raw string) ([]SortField, error) {
+	raw = strings.TrimSpace(raw)
+	switch raw {
+	case "":
+		return nil, nil
+	case "asc":
+		return []SortField{{Name: "price", Path: "price"}}, nil
+	case "desc":
+		return []SortField{{Name: "price", Path: "price", Desc: true}}, nil
+	}
+
+	var fields []SortField
+	seen := map[string]bool{}
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		desc := strings.HasPrefix(part, "-")
+		name := strings.TrimPrefix(strings.TrimPrefix(part, "-"), "+")
+		path, ok := ProductSortFields[name]
+		if !ok {
+			return nil, fmt.Errorf("cannot sort by %q", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("sort field %q given twice", name)
+		}
+		seen[name] = true
+		fields = append(fields, SortField{Name: name, Path: path, Desc: desc})
+	}
+	return fields, nil
+}
+
+// FixedSort builds the order of a listing whose clients cannot choose one,
+// from document paths in the "-time_stamp.created_at,rating" form. The
+// paths double as the field names cursors are checked against.
+func FixedSort(spec string) []SortField {
+	var fields []SortField
+	for _, part := range strings.Split(spec, ",") {
+		path := strings.TrimPrefix(part, "-")
+		fields = append(fields, SortField{Name: path, Path: path, Desc: strings.HasPrefix(part, "-")})
+	}
+	return fields
+}
+
+// SortSpec renders sort fields back into the "-price,title" form.
+func SortSpec(sort []SortField) string {
+	parts := make([]string, len(sort))
+	for i, f := range sort {
+		if f.Desc {
+			parts[i] = "-" + f.Name
+		} else {
+			parts[i] = f.Name
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// SortDocument builds the MongoDB sort, always ending with _id so the order
+// is total and cursors are stable.
+func SortDocument(sort []SortField) bson.D {
+	doc := bson.D{}
+	for _, f := range sort {
+		dir := 1
+		if f.Desc {
+			dir = -1
+		}
+		doc = append(doc, bson.E{Key: f.Path, Value: dir})
+	}
+	return append(doc, bson.E{Key: "_id", Value: 1})
+}
+
+// ParseLimit reads a page size, applying the default and upper bound.
+func ParseLimit(raw string) (int, error) {
+	if raw == "" {
+		return DefaultPageLimit, nil
+	}
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit < 1 {
+		return 0, errors.New("limit must be a positive integer")
+	}
+	if limit > MaxPageLimit {
+		limit = MaxPageLimit
+	}
+	return limit, nil
+}
+
+type pageCursor struct {
+	Sort   string        `bson:"s"`
+	Values []interface{} `bson:"v"`
+}
+
+// EncodeCursor builds an opaque cursor from the raw document of the last item
+// on a page.
+func EncodeCursor(sort []SortField, doc bson.Raw) (string, error) {
+	values := make(bson.A, 0, len(sort)+1)
+	for _, f := range sort {
+		val, err := doc.LookupErr(strings.Split(f.Path, ".")...)
+		if err != nil {
+			// Missing fields sort like null.
+			val = bson.RawValue{Type: bsontype.Null}
+		}
+		values = append(values, val)
+	}
+	id, err := doc.LookupErr("_id")
+	if err != nil {
+		return "", errors.New("document has no _id")
+	}
+	values = append(values, id)
+
+	data, err := bson.Marshal(bson.D{{Key: "s", Value: SortSpec(sort)}, {Key: "v", Value: values}})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// DecodeCursor reverses EncodeCursor. The cursor must have been produced for
+// the same sort order.
+func DecodeCursor(raw string, sort []SortField) ([]interface{}, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, errors.New("malformed cursor")
+	}
+	var c pageCursor
+	if err := bson.Unmarshal(data, &c); err != nil {
+		return nil, errors.New("malformed cursor")
+	}
+	if c.Sort != SortSpec(sort) || len(c.Values) != len(sort)+1 {
+		return nil, errors.New("cursor does not match the requested sort")
+	}
+	return c.Values, nil
+}
+
+// CursorFilter returns the condition selecting documents that come after the
+// given cursor values in the sort order. MongoDB sorts null and missing
+// values before everything else, which the comparisons account for.
+func CursorFilter(sort []SortField, after []interface{}) bson.M {
+	keys := append(append([]SortField{}, sort...), SortField{Path: "_id"})
+
+	var branches []bson.M
+	for i, f := range keys {
+		branch := bson.M{}
+		for j := 0; j < i; j++ {
+			branch[keys[j].Path] = after[j]
+		}
+
+		val := after[i]
+		switch {
+		case val == nil && f.Desc:
+			// Nothing sorts after null in descending order.
+			continue
+		case val == nil:
+			branch[f.Path] = bson.M{"$ne": nil}
+		case f.Desc:
+			branches = append(branches, bson.M{"$and": []bson.M{branch, {"$or": []bson.M{
+				{f.Path: bson.M{"$lt": val}},
+				{f.Path: nil},
+			}}}})
+			continue
+		default:
+			branch[f.Path] = bson.M{"$gt": val}
+		}
+		branches = append(branches, branch)
+	}
+	return bson.M{"$or": branches}
+}
+
+// ParseFields validates a comma separated list of product JSON field names.
+func ParseFields(raw string) ([]string, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	var fields []string
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, ok := productFieldPaths[name]; !ok {
+			return nil, fmt.Errorf("unknown field %q", name)
+		}
+		fields = append(fields, name)
+	}
+	return fields, nil
+}
+
+// ProjectionDocument builds a MongoDB projection for the requested fields,
+// also keeping the sort fields that cursors are built from.
+func ProjectionDocument(fields []string, sort []SortField) bson.M {
+	if len(fields) == 0 {
+		return nil
+	}
+	proj := bson.M{"_id": 1}
+	for _, name := range fields {
+		proj[productFieldPaths[name]] = 1
+	}
+	for _, f := range sort {
+		// Projecting both a document and one of its fields is a path
+		// collision, so skip sort paths already covered by a parent.
+		if _, ok := proj[strings.Split(f.Path, ".")[0]]; !ok {
+			proj[f.Path] = 1
+		}
+	}
+	return proj
+}
+
+// ProjectProduct renders only the requested fields of a product. The id is
+// always included.
+func ProjectProduct(p interface{}, fields []string) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+	out := map[string]json.RawMessage{"id": all["id"]}
+	for _, name := range fields {
+		if val, ok := all[name]; ok {
+			out[name] = val
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,48 @@
+package helpers
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestFixedSort(t *testing.T) {
+	sort := FixedSort("-rating,time_stamp.created_at")
+	want := []SortField{
+		{Name: "rating", Path: "rating", Desc: true},
+		{Name: "time_stamp.created_at", Path: "time_stamp.created_at"},
+	}
+	if !reflect.DeepEqual(sort, want) {
+		t.Fatalf("FixedSort = %v, want %v", sort, want)
+	}
+	wantDoc := bson.D{{Key: "rating", Value: -1}, {Key: "time_stamp.created_at", Value: 1}, {Key: "_id", Value: 1}}
+	if doc := SortDocument(sort); !reflect.DeepEqual(doc, wantDoc) {
+		t.Fatalf("SortDocument = %v, want %v", doc, wantDoc)
+	}
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	id := primitive.NewObjectID()
+	sort := FixedSort("-score")
+	doc, _ := bson.Marshal(bson.M{"score": 2.0, "_id": id})
+
+	cursor, err := EncodeCursor(sort, doc)
+	if err != nil {
+		t.Fatalf("EncodeCursor: %v", err)
+	}
+	after, err := DecodeCursor(cursor, sort)
+	if err != nil {
+		t.Fatalf("DecodeCursor: %v", err)
+	}
+	if !reflect.DeepEqual(after, []interface{}{2.0, id}) {
+		t.Fatalf("cursor values = %#v", after)
+	}
+	if _, err := DecodeCursor(cursor, FixedSort("score")); err == nil {
+		t.Fatal("cursor accepted for another sort")
+	}
+	if _, err := DecodeCursor(cursor, nil); err == nil {
+		t.Fatal("cursor accepted without a sort")
+	}
+}
@@ -0,0 +1,294 @@
+package helpers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+)
+
+// Normalized payment intent states, whatever the provider calls them.
+const (
+	IntentRequiresPayment = "requires_payment"
+	IntentRequiresAction  = "requires_action"
+	IntentRequiresCapture = "requires_capture"
+	IntentSucceeded       = "succeeded"
+	IntentFailed          = "failed"
+	IntentCanceled        = "canceled"
+)
+
+// Normalized webhook event types. Providers map their own events onto
+// these; anything else is reported with its original type and ignored.
+const (
+	// PaymentEventAuthorized means the funds are held and must be captured.
+	PaymentEventAuthorized = "payment.authorized"
+	PaymentEventSucceeded  = "payment.succeeded"
+	PaymentEventFailed     = "payment.failed"
+	PaymentEventRefunded   = "payment.refunded"
+)
+
+var (
+	// ErrInvalidSignature is returned for webhooks that fail verification.
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	// ErrPaymentDeclined is returned when the provider declines a payment.
+	ErrPaymentDeclined = errors.New("payment declined")
+	// ErrNoPaymentProvider is returned when PAYMENT_PROVIDER is not set.
+	ErrNoPaymentProvider = errors.New("PAYMENT_PROVIDER is not set")
+)
+
+// IntentRequest opens a payment. Amount is in minor units, e.g. cents.
+type IntentRequest struct {
+	Amount    int64
+	Currency  string
+	Reference string
+	// PaymentMethod is a provider payment method id, when the client
+	// already collected one.
+	PaymentMethod string
+}
+
+// PaymentIntent is a provider's record of one payment.
+type PaymentIntent struct {
+	Id           string `json:"id"`
+	Status       string `json:"status"`
+	ClientSecret string `json:"client_secret,omitempty"`
+	Amount       int64  `json:"amount"`
+	Currency     string `json:"currency"`
+}
+
+// PaymentEvent is a verified webhook event.
+type PaymentEvent struct {
+	Id       string `json:"id"`
+	Type     string `json:"type"`
+	IntentId string `json:"intent_id"`
+	// Amount is what was authorized, paid or, for refunds, returned in
+	// total so far, in minor units.
+	Amount int64 `json:"amount"`
+}
+
+// PaymentProvider takes payments through an external gateway.
+type PaymentProvider interface {
+	Name() string
+	CreateIntent(ctx context.Context, req IntentRequest) (PaymentIntent, error)
+	// ConfirmIntent attempts the payment, with paymentMethod when set.
+	ConfirmIntent(ctx context.Context, intentId, paymentMethod string) (PaymentIntent, error)
+	// CaptureIntent takes the funds of an authorized payment.
+	CaptureIntent(ctx context.Context, intentId string) (PaymentIntent, error)
+	// CancelIntent stops a payment that was not taken yet, releasing any
+	// authorization, so it can no longer be paid.
+	CancelIntent(ctx context.Context, intentId string) error
+	// Refund returns amount minor units of a payment, all of it when 0.
+	Refund(ctx context.Context, intentId string, amount int64) error
+	// SignatureHeader is the request header webhooks are signed in.
+	SignatureHeader() string
+	VerifyWebhook(payload []byte, signature string) (PaymentEvent, error)
+}
+
+// ToMinorUnits converts a price to the smallest currency unit. All store
+// currencies have two decimals.
+func ToMinorUnits(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+// fakeWebhookSecret signs fake gateway webhooks in development when no
+// secret is configured.
+const fakeWebhookSecret = "fake_webhook_secret"
+
+// DevEnvironment reports whether APP_ENV is "development".
+func DevEnvironment() bool {
+	return os.Getenv("APP_ENV") == "development"
+}
+
+// PaymentProviderFromEnv builds the provider named by PAYMENT_PROVIDER,
+// "stripe" or "fake", and returns ErrNoPaymentProvider when none is set, so
+// a deploy never takes orders without charging for them. Stripe needs
+// STRIPE_SECRET_KEY and STRIPE_WEBHOOK_SECRET. The fake gateway signs
+// webhooks with FAKE_PAYMENT_WEBHOOK_SECRET, which may only be left out in
+// development.
+func PaymentProviderFromEnv() (PaymentProvider, error) {
+	switch provider := os.Getenv("PAYMENT_PROVIDER"); provider {
+	case "":
+		return nil, ErrNoPaymentProvider
+	case "fake":
+		secret := os.Getenv("FAKE_PAYMENT_WEBHOOK_SECRET")
+		if secret == "" || secret == fakeWebhookSecret {
+			if !DevEnvironment() {
+				return nil, errors.New("FAKE_PAYMENT_WEBHOOK_SECRET is required outside development")
+			}
+			secret = fakeWebhookSecret
+		}
+		return NewFakeProvider(secret), nil
+	case "stripe":
+		key, secret := os.Getenv("STRIPE_SECRET_KEY"), os.Getenv("STRIPE_WEBHOOK_SECRET")
+		if key == "" || secret == "" {
+			return nil, errors.New("STRIPE_SECRET_KEY and STRIPE_WEBHOOK_SECRET are required")
+		}
+		return NewStripeProvider(key, secret), nil
+	default:
+		return nil, fmt.Errorf("unknown payment provider %q", provider)
+	}
+}
+
+// FakeDeclinedCents makes the fake gateway decline any amount ending in
+// that many cents, e.g. 10.13, so failures can be tried out.
+const FakeDeclinedCents = 13
+
+// FakeProvider is an in-process gateway for development and tests. It
+// keeps intents in memory, numbers them in order and never calls out.
+type FakeProvider struct {
+	secret  string
+	mu      sync.Mutex
+	next    int
+	intents map[string]*fakeIntent
+}
+
+// fakeIntent is a payment intent of the fake gateway with the amount
+// refunded from it so far.
+type fakeIntent struct {
+	PaymentIntent
+	refunded int64
+}
+
+func NewFakeProvider(webhookSecret string) *FakeProvider {
+	return &FakeProvider{secret: webhookSecret, intents: map[string]*fakeIntent{}}
+}
+
+func (f *FakeProvider) Name() string { return "fake" }
+
+func (f *FakeProvider) CreateIntent(ctx context.Context, req IntentRequest) (PaymentIntent, error) {
+	if req.Amount <= 0 {
+		return PaymentIntent{}, errors.New("amount must be positive")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.next++
+	id := fmt.Sprintf("fake_pi_%d", f.next)
+	intent := &fakeIntent{PaymentIntent: PaymentIntent{
+		Id:           id,
+		Status:       IntentRequiresPayment,
+		ClientSecret: id + "_secret",
+		Amount:       req.Amount,
+		Currency:     req.Currency,
+	}}
+	f.intents[id] = intent
+	return intent.PaymentIntent, nil
+}
+
+func (f *FakeProvider) intent(id string) (*fakeIntent, error) {
+	intent, ok := f.intents[id]
+	if !ok {
+		return nil, fmt.Errorf("no such payment intent %q", id)
+	}
+	return intent, nil
+}
+
+// ConfirmIntent authorizes the payment; it is declined for amounts ending
+// in FakeDeclinedCents.
+func (f *FakeProvider) ConfirmIntent(ctx context.Context, intentId, paymentMethod string) (PaymentIntent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	intent, err := f.intent(intentId)
+	if err != nil {
+		return PaymentIntent{}, err
+	}
+	if intent.Status != IntentRequiresPayment && intent.Status != IntentFailed {
+		return intent.PaymentIntent, fmt.Errorf("payment intent %s is %s", intentId, intent.Status)
+	}
+	if intent.Amount%100 == FakeDeclinedCents {
+		intent.Status = IntentFailed
+		return intent.PaymentIntent, ErrPaymentDeclined
+	}
+	intent.Status = IntentRequiresCapture
+	return intent.PaymentIntent, nil
+}
+
+func (f *FakeProvider) CaptureIntent(ctx context.Context, intentId string) (PaymentIntent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	intent, err := f.intent(intentId)
+	if err != nil {
+		return PaymentIntent{}, err
+	}
+	if intent.Status != IntentRequiresCapture {
+		return intent.PaymentIntent, fmt.Errorf("payment intent %s is %s", intentId, intent.Status)
+	}
+	intent.Status = IntentSucceeded
+	return intent.PaymentIntent, nil
+}
+
+func (f *FakeProvider) CancelIntent(ctx context.Context, intentId string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	intent, err := f.intent(intentId)
+	if err != nil {
+		return err
+	}
+	if intent.Status == IntentSucceeded {
+		return fmt.Errorf("payment intent %s is %s", intentId, intent.Status)
+	}
+	intent.Status = IntentCanceled
+	return nil
+}
+
+// Refund returns part or, when amount is 0, the rest of a captured
+// payment. Like Stripe, it never refunds more than was paid in total.
+func (f *FakeProvider) Refund(ctx context.Context, intentId string, amount int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	intent, err := f.intent(intentId)
+	if err != nil {
+		return err
+	}
+	if intent.Status != IntentSucceeded {
+		return fmt.Errorf("payment intent %s is %s", intentId, intent.Status)
+	}
+	remaining := intent.Amount - intent.refunded
+	if remaining == 0 {
+		return fmt.Errorf("payment intent %s is already refunded", intentId)
+	}
+	if amount == 0 {
+		amount = remaining
+	}
+	if amount < 0 || amount > remaining {
+		return errors.New("refund exceeds the payment")
+	}
+	intent.refunded += amount
+	return nil
+}
+
+func (f *FakeProvider) SignatureHeader() string { return "X-Fake-Signature" }
+
+// SignEvent encodes an event as the fake gateway would post it and returns
+// the payload with its signature.
+func (f *FakeProvider) SignEvent(event PaymentEvent) ([]byte, string, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, "", err
+	}
+	return payload, f.sign(payload), nil
+}
+
+func (f *FakeProvider) sign(payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(f.secret))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (f *FakeProvider) VerifyWebhook(payload []byte, signature string) (PaymentEvent, error) {
+	if !hmac.Equal([]byte(signature), []byte(f.sign(payload))) {
+		return PaymentEvent{}, ErrInvalidSignature
+	}
+	var event PaymentEvent
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return PaymentEvent{}, err
+	}
+	if event.Id == "" {
+		return PaymentEvent{}, errors.New("event id is missing")
+	}
+	return event, nil
+}
@@ -0,0 +1,280 @@
+package helpers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestFakeProviderPaymentFlow(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFakeProvider("secret")
+
+	intent, err := fake.CreateIntent(ctx, IntentRequest{Amount: 2599, Currency: "USD", Reference: "CS-1"})
+	if err != nil {
+		t.Fatalf("CreateIntent: %v", err)
+	}
+	if intent.Id != "fake_pi_1" || intent.Status != IntentRequiresPayment || intent.ClientSecret == "" {
+		t.Fatalf("unexpected intent %+v", intent)
+	}
+	if second, _ := fake.CreateIntent(ctx, IntentRequest{Amount: 100, Currency: "USD"}); second.Id != "fake_pi_2" {
+		t.Fatalf("intents are not numbered in order: %s", second.Id)
+	}
+
+	if intent, err = fake.ConfirmIntent(ctx, intent.Id, ""); err != nil || intent.Status != IntentRequiresCapture {
+		t.Fatalf("ConfirmIntent = %+v, %v", intent, err)
+	}
+	if err := fake.Refund(ctx, intent.Id, 0); err == nil {
+		t.Fatal("refunded a payment that was not captured")
+	}
+	if intent, err = fake.CaptureIntent(ctx, intent.Id); err != nil || intent.Status != IntentSucceeded {
+		t.Fatalf("CaptureIntent = %+v, %v", intent, err)
+	}
+	if err := fake.CancelIntent(ctx, intent.Id); err == nil {
+		t.Fatal("cancelled a captured payment")
+	}
+	if err := fake.Refund(ctx, intent.Id, 3000); err == nil {
+		t.Fatal("refunded more than was paid")
+	}
+	if err := fake.Refund(ctx, intent.Id, 0); err != nil {
+		t.Fatalf("Refund: %v", err)
+	}
+}
+
+func TestFakeProviderRefundsAtMostThePayment(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFakeProvider("secret")
+
+	intent, _ := fake.CreateIntent(ctx, IntentRequest{Amount: 2500, Currency: "USD"})
+	fake.ConfirmIntent(ctx, intent.Id, "")
+	fake.CaptureIntent(ctx, intent.Id)
+
+	if err := fake.Refund(ctx, intent.Id, 0); err != nil {
+		t.Fatalf("Refund: %v", err)
+	}
+	if err := fake.Refund(ctx, intent.Id, 0); err == nil {
+		t.Fatal("refunded the same payment twice")
+	}
+	if err := fake.Refund(ctx, intent.Id, 1); err == nil {
+		t.Fatal("refunded part of a fully refunded payment")
+	}
+
+	partial, _ := fake.CreateIntent(ctx, IntentRequest{Amount: 2500, Currency: "USD"})
+	fake.ConfirmIntent(ctx, partial.Id, "")
+	fake.CaptureIntent(ctx, partial.Id)
+
+	if err := fake.Refund(ctx, partial.Id, 1000); err != nil {
+		t.Fatalf("partial Refund: %v", err)
+	}
+	if err := fake.Refund(ctx, partial.Id, 2000); err == nil {
+		t.Fatal("refunds add up to more than was paid")
+	}
+	if err := fake.Refund(ctx, partial.Id, 0); err != nil {
+		t.Fatalf("refund of the rest: %v", err)
+	}
+	if err := fake.Refund(ctx, partial.Id, 0); err == nil {
+		t.Fatal("refunded a payment after its rest was refunded")
+	}
+}
+
+func TestFakeProviderDeclineAndCancel(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFakeProvider("secret")
+
+	declined, _ := fake.CreateIntent(ctx, IntentRequest{Amount: 1013, Currency: "USD"})
+	if _, err := fake.ConfirmIntent(ctx, declined.Id, ""); !errors.Is(err, ErrPaymentDeclined) {
+		t.Fatalf("ConfirmIntent error = %v, want ErrPaymentDeclined", err)
+	}
+
+	open, _ := fake.CreateIntent(ctx, IntentRequest{Amount: 1000, Currency: "USD"})
+	if err := fake.CancelIntent(ctx, open.Id); err != nil {
+		t.Fatalf("CancelIntent: %v", err)
+	}
+	if _, err := fake.ConfirmIntent(ctx, open.Id, ""); err == nil {
+		t.Fatal("confirmed a cancelled payment")
+	}
+	if _, err := fake.CaptureIntent(ctx, "fake_pi_99"); err == nil {
+		t.Fatal("captured an unknown payment")
+	}
+}
+
+func TestFakeProviderWebhooks(t *testing.T) {
+	fake := NewFakeProvider("secret")
+	event := PaymentEvent{Id: "evt_1", Type: PaymentEventSucceeded, IntentId: "fake_pi_1", Amount: 2599}
+	payload, signature, err := fake.SignEvent(event)
+	if err != nil {
+		t.Fatalf("SignEvent: %v", err)
+	}
+
+	got, err := fake.VerifyWebhook(payload, signature)
+	if err != nil || got != event {
+		t.Fatalf("VerifyWebhook = %+v, %v", got, err)
+	}
+	if _, err := NewFakeProvider("other").VerifyWebhook(payload, signature); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("wrong secret error = %v", err)
+	}
+	tampered := bytes.Replace(payload, []byte("2599"), []byte("1"), 1)
+	if _, err := fake.VerifyWebhook(tampered, signature); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("tampered payload error = %v", err)
+	}
+	noId, signature, _ := fake.SignEvent(PaymentEvent{Type: PaymentEventSucceeded})
+	if _, err := fake.VerifyWebhook(noId, signature); err == nil {
+		t.Fatal("accepted an event without id")
+	}
+}
+
+func stripeSignature(secret string, at time.Time, payload []byte) string {
+	timestamp := fmt.Sprint(at.Unix())
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestStripeVerifyWebhook(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	stripe := NewStripeProvider("sk_test", "whsec_test")
+	stripe.now = func() time.Time { return now }
+
+	succeeded := []byte(`{"id":"evt_1","type":"payment_intent.succeeded","data":{"object":{"id":"pi_1","amount":2599}}}`)
+	authorized := []byte(`{"id":"evt_2","type":"payment_intent.amount_capturable_updated","data":{"object":{"id":"pi_1","amount":2599,"amount_capturable":2599}}}`)
+	refunded := []byte(`{"id":"evt_3","type":"charge.refunded","data":{"object":{"id":"ch_1","amount":2599,"amount_refunded":1000,"payment_intent":"pi_1"}}}`)
+	other := []byte(`{"id":"evt_4","type":"customer.created","data":{"object":{"id":"cus_1"}}}`)
+
+	tests := []struct {
+		name      string
+		payload   []byte
+		signature string
+		want      PaymentEvent
+		wantErr   error
+	}{
+		{
+			name:      "succeeded",
+			payload:   succeeded,
+			signature: stripeSignature("whsec_test", now, succeeded),
+			want:      PaymentEvent{Id: "evt_1", Type: PaymentEventSucceeded, IntentId: "pi_1", Amount: 2599},
+		},
+		{
+			name:      "authorized",
+			payload:   authorized,
+			signature: stripeSignature("whsec_test", now, authorized),
+			want:      PaymentEvent{Id: "evt_2", Type: PaymentEventAuthorized, IntentId: "pi_1", Amount: 2599},
+		},
+		{
+			name:      "refund reports the total refunded",
+			payload:   refunded,
+			signature: stripeSignature("whsec_test", now, refunded),
+			want:      PaymentEvent{Id: "evt_3", Type: PaymentEventRefunded, IntentId: "pi_1", Amount: 1000},
+		},
+		{
+			name:      "unhandled type keeps its name",
+			payload:   other,
+			signature: stripeSignature("whsec_test", now, other),
+			want:      PaymentEvent{Id: "evt_4", Type: "customer.created", IntentId: "cus_1"},
+		},
+		{
+			name:      "signed within the tolerance",
+			payload:   succeeded,
+			signature: stripeSignature("whsec_test", now.Add(-4*time.Minute), succeeded),
+			want:      PaymentEvent{Id: "evt_1", Type: PaymentEventSucceeded, IntentId: "pi_1", Amount: 2599},
+		},
+		{
+			name:      "replayed after the tolerance",
+			payload:   succeeded,
+			signature: stripeSignature("whsec_test", now.Add(-6*time.Minute), succeeded),
+			wantErr:   ErrInvalidSignature,
+		},
+		{
+			name:      "timestamp in the future",
+			payload:   succeeded,
+			signature: stripeSignature("whsec_test", now.Add(6*time.Minute), succeeded),
+			wantErr:   ErrInvalidSignature,
+		},
+		{
+			name:      "wrong secret",
+			payload:   succeeded,
+			signature: stripeSignature("whsec_other", now, succeeded),
+			wantErr:   ErrInvalidSignature,
+		},
+		{
+			name:      "payload changed after signing",
+			payload:   refunded,
+			signature: stripeSignature("whsec_test", now, succeeded),
+			wantErr:   ErrInvalidSignature,
+		},
+		{
+			name:      "one of several signatures matches",
+			payload:   succeeded,
+			signature: stripeSignature("whsec_test", now, succeeded) + ",v1=deadbeef",
+			want:      PaymentEvent{Id: "evt_1", Type: PaymentEventSucceeded, IntentId: "pi_1", Amount: 2599},
+		},
+		{
+			name:      "malformed header",
+			payload:   succeeded,
+			signature: "v1=deadbeef",
+			wantErr:   ErrInvalidSignature,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := stripe.VerifyWebhook(tt.payload, tt.signature)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("event = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPaymentProviderFromEnv(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		provider string
+		wantErr  bool
+	}{
+		{name: "unset", wantErr: true},
+		{name: "unknown", env: map[string]string{"PAYMENT_PROVIDER": "paypal"}, wantErr: true},
+		{name: "fake without secret", env: map[string]string{"PAYMENT_PROVIDER": "fake"}, wantErr: true},
+		{name: "fake with built-in secret", env: map[string]string{"PAYMENT_PROVIDER": "fake", "FAKE_PAYMENT_WEBHOOK_SECRET": fakeWebhookSecret}, wantErr: true},
+		{name: "fake in development", env: map[string]string{"PAYMENT_PROVIDER": "fake", "APP_ENV": "development"}, provider: "fake"},
+		{name: "fake with secret", env: map[string]string{"PAYMENT_PROVIDER": "fake", "FAKE_PAYMENT_WEBHOOK_SECRET": "s3cret"}, provider: "fake"},
+		{name: "stripe without keys", env: map[string]string{"PAYMENT_PROVIDER": "stripe"}, wantErr: true},
+		{name: "stripe", env: map[string]string{"PAYMENT_PROVIDER": "stripe", "STRIPE_SECRET_KEY": "sk", "STRIPE_WEBHOOK_SECRET": "wh"}, provider: "stripe"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"PAYMENT_PROVIDER", "APP_ENV", "FAKE_PAYMENT_WEBHOOK_SECRET", "STRIPE_SECRET_KEY", "STRIPE_WEBHOOK_SECRET"} {
+				t.Setenv(name, tt.env[name])
+			}
+			provider, err := PaymentProviderFromEnv()
+			if _, set := tt.env["PAYMENT_PROVIDER"]; !set && !errors.Is(err, ErrNoPaymentProvider) {
+				t.Fatalf("error = %v, want ErrNoPaymentProvider", err)
+			}
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got provider %s, want an error", provider.Name())
+				}
+				return
+			}
+			if err != nil || provider.Name() != tt.provider {
+				t.Fatalf("PaymentProviderFromEnv = %v, %v, want %s", provider, err, tt.provider)
+			}
+		})
+	}
+}
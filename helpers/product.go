@@ -0,0 +1,75 @@
+package helpers
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/joshua/casify/model"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// productFieldPaths maps product JSON field names to their document paths.
+var productFieldPaths = func() map[string]string {
+	paths := map[string]string{}
+	t := reflect.TypeOf(model.Product{})
+	for i := 0; i < t.NumField(); i++ {
+		jsonName := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		bsonName := strings.Split(t.Field(i).Tag.Get("bson"), ",")[0]
+		if jsonName != "" && jsonName != "-" && bsonName != "" && bsonName != "-" {
+			paths[jsonName] = bsonName
+		}
+	}
+	return paths
+}()
+
+// productFieldIndex maps product JSON field names to their struct field index.
+var productFieldIndex = func() map[string]int {
+	index := map[string]int{}
+	t := reflect.TypeOf(model.Product{})
+	for i := 0; i < t.NumField(); i++ {
+		jsonName := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if jsonName != "" && jsonName != "-" {
+			index[jsonName] = i
+		}
+	}
+	return index
+}()
+
+// ProductUpdateDocument turns a partial product JSON body into a $set
+// document. Values are decoded through model.Product so they are stored with
+// the same types as on insert, e.g. ids as ObjectIDs rather than strings.
+// Fields listed in readOnly are rejected.
+func ProductUpdateDocument(body []byte, readOnly ...string) (bson.M, error) {
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(body, &keys); err != nil {
+		return nil, err
+	}
+	var product model.Product
+	if err := json.Unmarshal(body, &product); err != nil {
+		return nil, err
+	}
+
+	blocked := map[string]bool{}
+	for _, name := range readOnly {
+		blocked[name] = true
+	}
+
+	set := bson.M{}
+	value := reflect.ValueOf(product)
+	for key := range keys {
+		i, ok := productFieldIndex[key]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q", key)
+		}
+		if blocked[key] {
+			return nil, fmt.Errorf("field %q cannot be updated", key)
+		}
+		set[productFieldPaths[key]] = value.Field(i).Interface()
+	}
+	if len(set) == 0 {
+		return nil, fmt.Errorf("no fields to update")
+	}
+	return set, nil
+}
@@ -0,0 +1,37 @@
+package helpers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	maxQuestionBody = 1000
+	maxAnswerBody   = 2000
+)
+
+// PostInput is the text of a question or answer.
+type PostInput struct {
+	Body string `json:"body" binding:"required"`
+}
+
+// ValidateQuestion checks a question before it is saved.
+func ValidateQuestion(q PostInput) error {
+	return validatePost(q, maxQuestionBody)
+}
+
+// ValidateAnswer checks an answer before it is saved.
+func ValidateAnswer(a PostInput) error {
+	return validatePost(a, maxAnswerBody)
+}
+
+func validatePost(p PostInput, max int) error {
+	if strings.TrimSpace(p.Body) == "" {
+		return errors.New("body is required")
+	}
+	if len(p.Body) > max {
+		return fmt.Errorf("body is limited to %d characters", max)
+	}
+	return nil
+}
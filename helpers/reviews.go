@@ -0,0 +1,83 @@
+package helpers
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/joshua/casify/model"
+)
+
+const (
+	maxReviewTitle  = 120
+	maxReviewBody   = 5000
+	maxReviewPhotos = 6
+)
+
+// ReviewInput is the part of a review its author writes.
+type ReviewInput struct {
+	Rating int      `json:"rating" binding:"required"`
+	Title  string   `json:"title"`
+	Body   string   `json:"body"`
+	Photos []string `json:"photos"`
+}
+
+// ValidateReview checks a review before it is saved.
+func ValidateReview(r ReviewInput) error {
+	if r.Rating < 1 || r.Rating > 5 {
+		return errors.New("rating must be between 1 and 5 stars")
+	}
+	if len(r.Title) > maxReviewTitle {
+		return fmt.Errorf("title is limited to %d characters", maxReviewTitle)
+	}
+	if len(r.Body) > maxReviewBody {
+		return fmt.Errorf("body is limited to %d characters", maxReviewBody)
+	}
+	if len(r.Photos) > maxReviewPhotos {
+		return fmt.Errorf("a review has at most %d photos", maxReviewPhotos)
+	}
+	return validateStringSlice(r.Photos, "photos")
+}
+
+// Trimmed returns the input with surrounding spaces removed.
+func (r ReviewInput) Trimmed() ReviewInput {
+	r.Title = strings.TrimSpace(r.Title)
+	r.Body = strings.TrimSpace(r.Body)
+	return r
+}
+
+// RatingSummary is the aggregate of a product's reviews.
+type RatingSummary struct {
+	Average      float64        `json:"average"`
+	Count        int            `json:"count"`
+	Distribution map[string]int `json:"distribution"`
+}
+
+// SummarizeRatings builds the aggregate from the number of reviews per star
+// rating. The average is rounded to one decimal.
+func SummarizeRatings(stars map[int]int) RatingSummary {
+	summary := RatingSummary{Distribution: map[string]int{}}
+	total := 0
+	for star := 1; star <= 5; star++ {
+		n := stars[star]
+		summary.Distribution[strconv.Itoa(star)] = n
+		summary.Count += n
+		total += star * n
+	}
+	if summary.Count > 0 {
+		summary.Average = math.Round(float64(total)/float64(summary.Count)*10) / 10
+	}
+	return summary
+}
+
+// ProductRatingSummary reads the aggregate stored on a product.
+func ProductRatingSummary(p model.Product) RatingSummary {
+	summary := RatingSummary{Average: p.Rating, Count: p.ReviewCount, Distribution: map[string]int{}}
+	for star := 1; star <= 5; star++ {
+		key := strconv.Itoa(star)
+		summary.Distribution[key] = p.RatingDistribution[key]
+	}
+	return summary
+}
@@ -0,0 +1,61 @@
+package helpers
+
+import (
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/joshua/casify/model"
+)
+
+// revisionIgnoredFields change on every write, or are computed from other
+// data, and say nothing about what was edited.
+var revisionIgnoredFields = map[string]bool{
+	"id":                  true,
+	"time_stamp":          true,
+	"rating":              true,
+	"review_count":        true,
+	"rating_distribution": true,
+}
+
+// DiffProducts lists the fields that differ between two versions of a
+// product, by JSON name and in declaration order. Missing and empty lists
+// count as equal.
+func DiffProducts(before, after model.Product) []model.FieldChange {
+	changes := []model.FieldChange{}
+	t := reflect.TypeOf(before)
+	b, a := reflect.ValueOf(before), reflect.ValueOf(after)
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" || revisionIgnoredFields[name] {
+			continue
+		}
+		if !sameFieldValue(b.Field(i), a.Field(i)) {
+			changes = append(changes, model.FieldChange{
+				Field:  name,
+				Before: b.Field(i).Interface(),
+				After:  a.Field(i).Interface(),
+			})
+		}
+	}
+	return changes
+}
+
+func sameFieldValue(a, b reflect.Value) bool {
+	switch a.Kind() {
+	case reflect.Slice, reflect.Map:
+		if a.Len() == 0 && b.Len() == 0 {
+			return true
+		}
+	case reflect.Ptr:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		return sameFieldValue(a.Elem(), b.Elem())
+	}
+	// Stored times lose their location and sub-millisecond precision
+	if at, ok := a.Interface().(time.Time); ok {
+		return at.Truncate(time.Millisecond).Equal(b.Interface().(time.Time).Truncate(time.Millisecond))
+	}
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
@@ -0,0 +1,374 @@
+package helpers
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/joshua/casify/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Field weights used when indexing a product. A query term found in the
+// title counts three times as much as one found in the description.
+const (
+	titleWeight       = 3.0
+	categoryWeight    = 2.0
+	featureWeight     = 1.5
+	descriptionWeight = 1.0
+)
+
+// Weights applied to terms that only match a query token indirectly.
+const (
+	synonymWeight = 0.8
+	typoWeight    = 0.6
+)
+
+// BM25 tuning parameters.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "the": true, "for": true, "with": true,
+	"of": true, "in": true, "on": true, "to": true, "by": true, "or": true,
+}
+
+// synonymGroups lists words customers use interchangeably. Each group is
+// stemmed when the package loads.
+var synonymGroups = [][]string{
+	{"magsafe", "magnetic", "magnet"},
+	{"clear", "transparent", "crystal"},
+	{"case", "cover"},
+	{"phone", "smartphone", "mobile"},
+	{"rugged", "tough", "durable"},
+	{"slim", "thin"},
+	{"wallet", "card"},
+}
+
+var synonyms = func() map[string][]string {
+	out := map[string][]string{}
+	for _, group := range synonymGroups {
+		for _, word := range group {
+			stem := Stem(word)
+			for _, other := range group {
+				if other != word {
+					out[stem] = append(out[stem], Stem(other))
+				}
+			}
+		}
+	}
+	return out
+}()
+
+// Stem reduces an English word to a rough stem so that "cases" matches
+// "case" and "charging" matches "charge". It only strips common suffixes.
+func Stem(word string) string {
+	switch {
+	case len(word) > 4 && strings.HasSuffix(word, "ies"):
+		return word[:len(word)-3] + "y"
+	case len(word) > 4 && (strings.HasSuffix(word, "ches") || strings.HasSuffix(word, "shes") ||
+		strings.HasSuffix(word, "sses") || strings.HasSuffix(word, "xes")):
+		return word[:len(word)-2]
+	case len(word) > 3 && strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss") && !strings.HasSuffix(word, "us"):
+		return word[:len(word)-1]
+	case len(word) > 5 && strings.HasSuffix(word, "ing"):
+		return word[:len(word)-3]
+	case len(word) > 4 && strings.HasSuffix(word, "ed"):
+		return word[:len(word)-2]
+	}
+	return word
+}
+
+// Tokenize lowercases text, splits it into words, drops stop words and stems
+// what is left.
+func Tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	tokens := make([]string, 0, len(words))
+	for _, w := range words {
+		if stopWords[w] {
+			continue
+		}
+		tokens = append(tokens, Stem(w))
+	}
+	return tokens
+}
+
+// SearchHit is a product matched by a query with its relevance score.
+type SearchHit struct {
+	Product model.Product `json:"product"`
+	Score   float64       `json:"score"`
+}
+
+// SearchIndex is an in-memory inverted index over the product catalog. It
+// also keeps the indexed products so results can be filtered without a
+// database round trip. It is safe for concurrent use.
+type SearchIndex struct {
+	mu       sync.RWMutex
+	products map[primitive.ObjectID]model.Product
+	// postings maps a term to the weighted term frequency in each product.
+	postings map[string]map[primitive.ObjectID]float64
+	docLen   map[primitive.ObjectID]float64
+	totalLen float64
+}
+
+func NewSearchIndex() *SearchIndex {
+	return &SearchIndex{
+		products: map[primitive.ObjectID]model.Product{},
+		postings: map[string]map[primitive.ObjectID]float64{},
+		docLen:   map[primitive.ObjectID]float64{},
+	}
+}
+
+// productTerms returns the weighted term frequencies of a product.
+func productTerms(p model.Product) map[string]float64 {
+	terms := map[string]float64{}
+	add := func(text string, weight float64) {
+		for _, t := range Tokenize(text) {
+			terms[t] += weight
+		}
+	}
+	add(p.Title, titleWeight)
+	add(p.Description, descriptionWeight)
+	for _, c := range p.Category {
+		add(c, categoryWeight)
+	}
+	for _, f := range p.Details.Features {
+		add(f, featureWeight)
+	}
+	return terms
+}
+
+// Replace rebuilds the index from a full list of products.
+func (ix *SearchIndex) Replace(products []model.Product) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.products = map[primitive.ObjectID]model.Product{}
+	ix.postings = map[string]map[primitive.ObjectID]float64{}
+	ix.docLen = map[primitive.ObjectID]float64{}
+	ix.totalLen = 0
+	for _, p := range products {
+		ix.add(p)
+	}
+}
+
+// Upsert adds a product or replaces its previous version.
+func (ix *SearchIndex) Upsert(p model.Product) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.remove(p.Id)
+	ix.add(p)
+}
+
+// Remove drops a product from the index.
+func (ix *SearchIndex) Remove(id primitive.ObjectID) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.remove(id)
+}
+
+// Clear empties the index.
+func (ix *SearchIndex) Clear() {
+	ix.Replace(nil)
+}
+
+// Get returns an indexed product by id.
+func (ix *SearchIndex) Get(id primitive.ObjectID) (model.Product, bool) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	p, ok := ix.products[id]
+	return p, ok
+}
+
+// Products returns a snapshot of every indexed product.
+func (ix *SearchIndex) Products() []model.Product {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	out := make([]model.Product, 0, len(ix.products))
+	for _, p := range ix.products {
+		out = append(out, p)
+	}
+	return out
+}
+
+func (ix *SearchIndex) add(p model.Product) {
+	terms := productTerms(p)
+	length := 0.0
+	for term, tf := range terms {
+		docs, ok := ix.postings[term]
+		if !ok {
+			docs = map[primitive.ObjectID]float64{}
+			ix.postings[term] = docs
+		}
+		docs[p.Id] = tf
+		length += tf
+	}
+	ix.products[p.Id] = p
+	ix.docLen[p.Id] = length
+	ix.totalLen += length
+}
+
+func (ix *SearchIndex) remove(id primitive.ObjectID) {
+	p, ok := ix.products[id]
+	if !ok {
+		return
+	}
+	for term := range productTerms(p) {
+		if docs, ok := ix.postings[term]; ok {
+			delete(docs, id)
+			if len(docs) == 0 {
+				delete(ix.postings, term)
+			}
+		}
+	}
+	ix.totalLen -= ix.docLen[id]
+	delete(ix.docLen, id)
+	delete(ix.products, id)
+}
+
+// candidate is an indexed term a query token may stand for.
+type candidate struct {
+	term   string
+	weight float64
+}
+
+// expandToken finds the indexed terms matching a query token: the token
+// itself, its synonyms and terms within a small edit distance.
+func (ix *SearchIndex) expandToken(token string) []candidate {
+	var out []candidate
+	seen := map[string]bool{}
+	addTerm := func(term string, weight float64) {
+		if seen[term] {
+			return
+		}
+		if _, ok := ix.postings[term]; ok {
+			seen[term] = true
+			out = append(out, candidate{term, weight})
+		}
+	}
+
+	addTerm(token, 1)
+	for _, syn := range synonyms[token] {
+		addTerm(syn, synonymWeight)
+	}
+
+	maxDist := typoTolerance(token)
+	if maxDist == 0 {
+		return out
+	}
+	for term := range ix.postings {
+		if seen[term] {
+			continue
+		}
+		if d := editDistance(token, term, maxDist); d <= maxDist {
+			addTerm(term, typoWeight/float64(d))
+		}
+	}
+	return out
+}
+
+// typoTolerance is how many edits a token may be off by. Short tokens such as
+// model numbers must match exactly.
+func typoTolerance(token string) int {
+	switch n := len([]rune(token)); {
+	case n >= 8:
+		return 2
+	case n >= 4:
+		return 1
+	}
+	return 0
+}
+
+// editDistance computes the Levenshtein distance between a and b, giving up
+// early with max+1 once the distance is known to exceed max.
+func editDistance(a, b string, max int) int {
+	ra, rb := []rune(a), []rune(b)
+	if d := len(ra) - len(rb); d > max || -d > max {
+		return max + 1
+	}
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		rowMin := cur[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			rowMin = min(rowMin, cur[j])
+		}
+		if rowMin > max {
+			return max + 1
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// Search scores products against a free text query with BM25, counting
+// synonyms and near misses at a reduced weight. Products matching more of
+// the query tokens rank higher. Only products accepted by filter are
+// returned; a nil filter accepts everything.
+func (ix *SearchIndex) Search(query string, filter FilterNode) []SearchHit {
+	tokens := Tokenize(query)
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	n := float64(len(ix.products))
+	if n == 0 {
+		return nil
+	}
+	avgLen := ix.totalLen / n
+
+	scores := map[primitive.ObjectID]float64{}
+	matchedTokens := map[primitive.ObjectID]int{}
+	for _, token := range tokens {
+		best := map[primitive.ObjectID]float64{}
+		for _, c := range ix.expandToken(token) {
+			docs := ix.postings[c.term]
+			df := float64(len(docs))
+			idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+			for id, tf := range docs {
+				norm := tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*ix.docLen[id]/avgLen))
+				if s := c.weight * idf * norm; s > best[id] {
+					best[id] = s
+				}
+			}
+		}
+		for id, s := range best {
+			scores[id] += s
+			matchedTokens[id]++
+		}
+	}
+
+	hits := make([]SearchHit, 0, len(scores))
+	for id, score := range scores {
+		p := ix.products[id]
+		if !EvalFilter(filter, p) {
+			continue
+		}
+		coverage := float64(matchedTokens[id]) / float64(len(tokens))
+		hits = append(hits, SearchHit{Product: p, Score: math.Round(score*coverage*1000) / 1000})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Product.Id.Hex() < hits[j].Product.Id.Hex()
+	})
+	return hits
+}
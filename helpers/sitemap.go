@@ -0,0 +1,128 @@
+package helpers
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joshua/casify/model"
+)
+
+// SitemapMaxURLs is the most URLs the sitemap protocol allows in one file.
+const SitemapMaxURLs = 50000
+
+// SitemapPageSizeFromEnv reads SITEMAP_PAGE_SIZE, capped at SitemapMaxURLs.
+func SitemapPageSizeFromEnv() int {
+	size, err := strconv.Atoi(os.Getenv("SITEMAP_PAGE_SIZE"))
+	if err != nil || size <= 0 || size > SitemapMaxURLs {
+		return SitemapMaxURLs
+	}
+	return size
+}
+
+// APIBaseURLFromEnv reads API_URL, the public address the API is served
+// from, falling back to the storefront URL when both share a host.
+func APIBaseURLFromEnv() string {
+	if base := strings.TrimRight(os.Getenv("API_URL"), "/"); base != "" {
+		return base
+	}
+	return FeedConfigFromEnv().BaseURL
+}
+
+// ProductPath is the storefront path of a product, by slug when it has one.
+func ProductPath(p model.Product) string {
+	if p.Slug != "" {
+		return "/products/" + p.Slug
+	}
+	return "/products/" + p.Id.Hex()
+}
+
+// CategoryPath is the storefront path of a category.
+func CategoryPath(c model.Category) string {
+	return "/categories/" + c.Slug
+}
+
+// CollectionPath is the storefront path of a collection.
+func CollectionPath(c model.Collection) string {
+	return "/collections/" + c.Slug
+}
+
+// SitemapURL is one location listed in a sitemap or sitemap index.
+type SitemapURL struct {
+	Loc     string
+	LastMod time.Time
+}
+
+type sitemapEntry struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+func toSitemapEntries(urls []SitemapURL) []sitemapEntry {
+	entries := make([]sitemapEntry, len(urls))
+	for i, u := range urls {
+		entries[i].Loc = u.Loc
+		if !u.LastMod.IsZero() {
+			entries[i].LastMod = u.LastMod.UTC().Format(time.RFC3339)
+		}
+	}
+	return entries
+}
+
+func writeSitemapXML(w io.Writer, doc interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	return enc.Flush()
+}
+
+// WriteURLSet writes a sitemap listing urls.
+func WriteURLSet(w io.Writer, urls []SitemapURL) error {
+	return writeSitemapXML(w, struct {
+		XMLName xml.Name       `xml:"urlset"`
+		Xmlns   string         `xml:"xmlns,attr"`
+		URLs    []sitemapEntry `xml:"url"`
+	}{Xmlns: "http://www.sitemaps.org/schemas/sitemap/0.9", URLs: toSitemapEntries(urls)})
+}
+
+// WriteSitemapIndex writes a sitemap index pointing at other sitemaps.
+func WriteSitemapIndex(w io.Writer, sitemaps []SitemapURL) error {
+	return writeSitemapXML(w, struct {
+		XMLName  xml.Name       `xml:"sitemapindex"`
+		Xmlns    string         `xml:"xmlns,attr"`
+		Sitemaps []sitemapEntry `xml:"sitemap"`
+	}{Xmlns: "http://www.sitemaps.org/schemas/sitemap/0.9", Sitemaps: toSitemapEntries(sitemaps)})
+}
+
+// SitemapPages is the number of files needed for count URLs.
+func SitemapPages(count, size int) int {
+	return (count + size - 1) / size
+}
+
+// SitemapFileName names page n, counted from 1, of a sitemap section.
+func SitemapFileName(section string, page int) string {
+	return fmt.Sprintf("%s-%d.xml", section, page)
+}
+
+// ParseSitemapFileName reverses SitemapFileName.
+func ParseSitemapFileName(name string) (string, int, error) {
+	base := strings.TrimSuffix(name, ".xml")
+	i := strings.LastIndex(base, "-")
+	if base == name || i <= 0 {
+		return "", 0, fmt.Errorf("invalid sitemap name %q", name)
+	}
+	page, err := strconv.Atoi(base[i+1:])
+	if err != nil || page < 1 {
+		return "", 0, fmt.Errorf("invalid sitemap name %q", name)
+	}
+	return base[:i], page, nil
+}
@@ -0,0 +1,237 @@
+package helpers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stripeSignatureTolerance is how old a signed webhook may be, to stop
+// captured requests from being replayed later.
+const stripeSignatureTolerance = 5 * time.Minute
+
+// StripeProvider takes payments through the Stripe API.
+type StripeProvider struct {
+	secretKey     string
+	webhookSecret string
+	BaseURL       string
+	Client        *http.Client
+	// now is the clock the signature window is checked against.
+	now func() time.Time
+}
+
+func NewStripeProvider(secretKey, webhookSecret string) *StripeProvider {
+	return &StripeProvider{
+		secretKey:     secretKey,
+		webhookSecret: webhookSecret,
+		BaseURL:       "https://api.stripe.com",
+		Client:        &http.Client{Timeout: 15 * time.Second},
+		now:           time.Now,
+	}
+}
+
+func (s *StripeProvider) Name() string { return "stripe" }
+
+// stripeIntent is the part of a Stripe PaymentIntent the store uses.
+type stripeIntent struct {
+	Id           string `json:"id"`
+	Status       string `json:"status"`
+	ClientSecret string `json:"client_secret"`
+	Amount       int64  `json:"amount"`
+	Currency     string `json:"currency"`
+}
+
+// stripeIntentStatuses maps Stripe intent states onto ours.
+var stripeIntentStatuses = map[string]string{
+	"requires_payment_method": IntentRequiresPayment,
+	"requires_confirmation":   IntentRequiresPayment,
+	"requires_action":         IntentRequiresAction,
+	"processing":              IntentRequiresAction,
+	"requires_capture":        IntentRequiresCapture,
+	"succeeded":               IntentSucceeded,
+	"canceled":                IntentCanceled,
+}
+
+func (i stripeIntent) normalize() PaymentIntent {
+	status, ok := stripeIntentStatuses[i.Status]
+	if !ok {
+		status = i.Status
+	}
+	return PaymentIntent{
+		Id:           i.Id,
+		Status:       status,
+		ClientSecret: i.ClientSecret,
+		Amount:       i.Amount,
+		Currency:     strings.ToUpper(i.Currency),
+	}
+}
+
+// post calls a Stripe endpoint with form values and decodes the reply
+// into out.
+func (s *StripeProvider) post(ctx context.Context, path string, form url.Values, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.BaseURL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(s.secretKey, "")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var failure struct {
+			Error struct {
+				Type    string `json:"type"`
+				Code    string `json:"code"`
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&failure)
+		if failure.Error.Type == "card_error" {
+			return fmt.Errorf("%w: %s", ErrPaymentDeclined, failure.Error.Message)
+		}
+		return fmt.Errorf("stripe %s: %d %s", path, resp.StatusCode, failure.Error.Message)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (s *StripeProvider) CreateIntent(ctx context.Context, req IntentRequest) (PaymentIntent, error) {
+	form := url.Values{
+		"amount":                             {strconv.FormatInt(req.Amount, 10)},
+		"currency":                           {strings.ToLower(req.Currency)},
+		"capture_method":                     {"manual"},
+		"metadata[reference]":                {req.Reference},
+		"automatic_payment_methods[enabled]": {"true"},
+	}
+	if req.PaymentMethod != "" {
+		form.Set("payment_method", req.PaymentMethod)
+	}
+	var intent stripeIntent
+	if err := s.post(ctx, "/v1/payment_intents", form, &intent); err != nil {
+		return PaymentIntent{}, err
+	}
+	return intent.normalize(), nil
+}
+
+func (s *StripeProvider) ConfirmIntent(ctx context.Context, intentId, paymentMethod string) (PaymentIntent, error) {
+	form := url.Values{}
+	if paymentMethod != "" {
+		form.Set("payment_method", paymentMethod)
+	}
+	var intent stripeIntent
+	if err := s.post(ctx, "/v1/payment_intents/"+url.PathEscape(intentId)+"/confirm", form, &intent); err != nil {
+		return PaymentIntent{}, err
+	}
+	return intent.normalize(), nil
+}
+
+func (s *StripeProvider) CaptureIntent(ctx context.Context, intentId string) (PaymentIntent, error) {
+	var intent stripeIntent
+	if err := s.post(ctx, "/v1/payment_intents/"+url.PathEscape(intentId)+"/capture", url.Values{}, &intent); err != nil {
+		return PaymentIntent{}, err
+	}
+	return intent.normalize(), nil
+}
+
+func (s *StripeProvider) CancelIntent(ctx context.Context, intentId string) error {
+	return s.post(ctx, "/v1/payment_intents/"+url.PathEscape(intentId)+"/cancel", url.Values{}, nil)
+}
+
+func (s *StripeProvider) Refund(ctx context.Context, intentId string, amount int64) error {
+	form := url.Values{"payment_intent": {intentId}}
+	if amount > 0 {
+		form.Set("amount", strconv.FormatInt(amount, 10))
+	}
+	return s.post(ctx, "/v1/refunds", form, nil)
+}
+
+func (s *StripeProvider) SignatureHeader() string { return "Stripe-Signature" }
+
+// VerifyWebhook checks the Stripe-Signature header, "t=<unix>,v1=<hex>",
+// an HMAC-SHA256 of "<t>.<payload>", and maps the event onto ours.
+func (s *StripeProvider) VerifyWebhook(payload []byte, signature string) (PaymentEvent, error) {
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(signature, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return PaymentEvent{}, ErrInvalidSignature
+	}
+	if age := s.now().Sub(time.Unix(unix, 0)); age > stripeSignatureTolerance || age < -stripeSignatureTolerance {
+		return PaymentEvent{}, fmt.Errorf("%w: timestamp outside the tolerance", ErrInvalidSignature)
+	}
+	mac := hmac.New(sha256.New, []byte(s.webhookSecret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	expected := hex.EncodeToString(mac.Sum(nil))
+	valid := false
+	for _, sig := range signatures {
+		if hmac.Equal([]byte(sig), []byte(expected)) {
+			valid = true
+		}
+	}
+	if !valid {
+		return PaymentEvent{}, ErrInvalidSignature
+	}
+
+	var raw struct {
+		Id   string `json:"id"`
+		Type string `json:"type"`
+		Data struct {
+			Object struct {
+				Id               string `json:"id"`
+				Object           string `json:"object"`
+				Amount           int64  `json:"amount"`
+				AmountCapturable int64  `json:"amount_capturable"`
+				AmountRefunded   int64  `json:"amount_refunded"`
+				PaymentIntent    string `json:"payment_intent"`
+			} `json:"object"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		return PaymentEvent{}, err
+	}
+	if raw.Id == "" {
+		return PaymentEvent{}, errors.New("event id is missing")
+	}
+	object := raw.Data.Object
+	event := PaymentEvent{Id: raw.Id, Type: raw.Type, IntentId: object.Id, Amount: object.Amount}
+	switch raw.Type {
+	case "payment_intent.amount_capturable_updated":
+		// Intents are captured manually, so payments the customer
+		// completed in the browser stop here until the store captures them
+		event.Type = PaymentEventAuthorized
+		event.Amount = object.AmountCapturable
+	case "payment_intent.succeeded":
+		event.Type = PaymentEventSucceeded
+	case "payment_intent.payment_failed":
+		event.Type = PaymentEventFailed
+	case "charge.refunded":
+		event.Type = PaymentEventRefunded
+		event.IntentId, event.Amount = object.PaymentIntent, object.AmountRefunded
+	}
+	return event, nil
+}
@@ -0,0 +1,233 @@
+package helpers
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Kinds of search suggestions.
+const (
+	SuggestTitle    = "title"
+	SuggestCategory = "category"
+	SuggestDevice   = "device"
+)
+
+// Suggestion is one autocomplete entry.
+type Suggestion struct {
+	Text  string  `json:"text"`
+	Kind  string  `json:"kind"`
+	Score float64 `json:"score"`
+}
+
+// SuggestItem is a term a source contributes to the suggestion index. The
+// same text and kind contributed by several sources is merged into one
+// suggestion whose score is the sum of the weights.
+type SuggestItem struct {
+	Text   string
+	Kind   string
+	Weight float64
+}
+
+type suggestEntry struct {
+	text   string
+	kind   string
+	weight float64
+	refs   int
+	keys   []string
+}
+
+type trieNode struct {
+	children map[rune]*trieNode
+	// entries holds every entry with a key passing through this node, so a
+	// prefix lookup never walks the subtree.
+	entries map[*suggestEntry]bool
+}
+
+func newTrieNode() *trieNode {
+	return &trieNode{children: map[rune]*trieNode{}, entries: map[*suggestEntry]bool{}}
+}
+
+// SuggestIndex is a prefix trie of product titles, categories and other
+// catalog terms used for search-as-you-type. Entries are keyed by their full
+// normalized text and by every word boundary inside it, so "clear ca" finds
+// "iPhone 15 Pro Clear Case". It is safe for concurrent use.
+type SuggestIndex struct {
+	mu      sync.RWMutex
+	root    *trieNode
+	entries map[string]*suggestEntry
+	sources map[string][]SuggestItem
+}
+
+func NewSuggestIndex() *SuggestIndex {
+	return &SuggestIndex{
+		root:    newTrieNode(),
+		entries: map[string]*suggestEntry{},
+		sources: map[string][]SuggestItem{},
+	}
+}
+
+// normalizeSuggestText lowercases text and reduces it to words separated by
+// single spaces.
+func normalizeSuggestText(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, " ")
+}
+
+// suggestKeys returns the normalized text and every suffix starting at a
+// word boundary.
+func suggestKeys(normalized string) []string {
+	keys := []string{normalized}
+	for i, r := range normalized {
+		if r == ' ' {
+			keys = append(keys, normalized[i+1:])
+		}
+	}
+	return keys
+}
+
+// SetSource replaces everything a source contributed with items. Sources are
+// identified by a caller chosen id such as "product:<id>".
+func (s *SuggestIndex) SetSource(source string, items []SuggestItem) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeSource(source)
+	for _, item := range items {
+		s.addItem(item)
+	}
+	if len(items) > 0 {
+		s.sources[source] = items
+	}
+}
+
+// RemoveSource drops everything a source contributed.
+func (s *SuggestIndex) RemoveSource(source string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeSource(source)
+}
+
+// RemoveSourcesWithPrefix drops every source whose id starts with prefix.
+func (s *SuggestIndex) RemoveSourcesWithPrefix(prefix string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for source := range s.sources {
+		if strings.HasPrefix(source, prefix) {
+			s.removeSource(source)
+		}
+	}
+}
+
+func (s *SuggestIndex) removeSource(source string) {
+	for _, item := range s.sources[source] {
+		s.removeItem(item)
+	}
+	delete(s.sources, source)
+}
+
+func entryID(kind, normalized string) string {
+	return kind + "\x00" + normalized
+}
+
+func (s *SuggestIndex) addItem(item SuggestItem) {
+	normalized := normalizeSuggestText(item.Text)
+	if normalized == "" {
+		return
+	}
+	id := entryID(item.Kind, normalized)
+	entry, ok := s.entries[id]
+	if !ok {
+		entry = &suggestEntry{text: strings.TrimSpace(item.Text), kind: item.Kind, keys: suggestKeys(normalized)}
+		s.entries[id] = entry
+		for _, key := range entry.keys {
+			node := s.root
+			for _, r := range key {
+				child, ok := node.children[r]
+				if !ok {
+					child = newTrieNode()
+					node.children[r] = child
+				}
+				child.entries[entry] = true
+				node = child
+			}
+		}
+	}
+	entry.weight += item.Weight
+	entry.refs++
+}
+
+func (s *SuggestIndex) removeItem(item SuggestItem) {
+	normalized := normalizeSuggestText(item.Text)
+	id := entryID(item.Kind, normalized)
+	entry, ok := s.entries[id]
+	if !ok {
+		return
+	}
+	entry.weight -= item.Weight
+	entry.refs--
+	if entry.refs > 0 {
+		return
+	}
+
+	delete(s.entries, id)
+	for _, key := range entry.keys {
+		node := s.root
+		for _, r := range key {
+			child, ok := node.children[r]
+			if !ok {
+				break
+			}
+			delete(child.entries, entry)
+			if len(child.entries) == 0 {
+				// Nothing else passes through here, prune the branch.
+				delete(node.children, r)
+				break
+			}
+			node = child
+		}
+	}
+}
+
+// Suggest returns up to limit entries starting with prefix, or containing a
+// word starting with it, ranked by popularity. Entries whose text starts
+// with the prefix rank above those matching at a later word.
+func (s *SuggestIndex) Suggest(prefix string, limit int) []Suggestion {
+	prefix = normalizeSuggestText(prefix)
+	if prefix == "" {
+		return []Suggestion{}
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	node := s.root
+	for _, r := range prefix {
+		child, ok := node.children[r]
+		if !ok {
+			return []Suggestion{}
+		}
+		node = child
+	}
+
+	out := make([]Suggestion, 0, len(node.entries))
+	for entry := range node.entries {
+		score := entry.weight
+		if strings.HasPrefix(entry.keys[0], prefix) {
+			score *= 2
+		}
+		out = append(out, Suggestion{Text: entry.text, Kind: entry.kind, Score: score})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Score != out[j].Score {
+			return out[i].Score > out[j].Score
+		}
+		return out[i].Text < out[j].Text
+	})
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out
+}
@@ -0,0 +1,78 @@
+package helpers
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/joshua/casify/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ValidateTemplate checks a product template before it is saved.
+func ValidateTemplate(t model.ProductTemplate) error {
+	if strings.TrimSpace(t.Name) == "" {
+		return errors.New("name is required")
+	}
+	if err := validateStringSlice(t.Details.Details, "details"); err != nil {
+		return err
+	}
+	if err := validateStringSlice(t.Details.Features, "features"); err != nil {
+		return err
+	}
+	return checkUniqueIds(t.CategoryIds, "category_ids")
+}
+
+// ProductFromTemplate returns a product prefilled with a template's
+// defaults, ready for the request body to be decoded on top.
+func ProductFromTemplate(t model.ProductTemplate) model.Product {
+	return model.Product{
+		Description: t.Description,
+		Details: model.ProductDetails{
+			Details:  append([]string(nil), t.Details.Details...),
+			Features: append([]string(nil), t.Details.Features...),
+		},
+		Category:    append([]string(nil), t.Category...),
+		CategoryIds: append([]primitive.ObjectID(nil), t.CategoryIds...),
+	}
+}
+
+// CloneProduct copies a product into a new draft. The copy gets a fresh id
+// and timestamps, and drops what belongs to the original alone: its slug,
+// schedule, reviews and stock. SKUs get skuSuffix appended; without one the
+// product SKU is cleared and variant SKUs must be overridden.
+func CloneProduct(p model.Product, skuSuffix string, now time.Time) model.Product {
+	clone := p
+	clone.Id = primitive.NewObjectID()
+	clone.Slug = ""
+	clone.PreviousSlugs = nil
+	clone.Status = ""
+	clone.PublishAt, clone.UnpublishAt = nil, nil
+	clone.Rating, clone.ReviewCount, clone.RatingDistribution = 0, 0, nil
+	clone.TimeStamp = model.TimeStamp{CreatedAt: now, UpdatedAt: now}
+
+	// Copy the slices so the clone can be changed without touching p
+	clone.Images = append([]string(nil), p.Images...)
+	clone.Category = append([]string(nil), p.Category...)
+	clone.CategoryIds = append([]primitive.ObjectID(nil), p.CategoryIds...)
+	clone.CompatibleDevices = append([]primitive.ObjectID(nil), p.CompatibleDevices...)
+	clone.Options = append([]model.ProductOption(nil), p.Options...)
+	clone.Variants = make([]model.ProductVariant, len(p.Variants))
+	for i, v := range p.Variants {
+		v.Stock = 0
+		if skuSuffix != "" {
+			v.SKU += skuSuffix
+		}
+		clone.Variants[i] = v
+	}
+	if len(clone.Variants) == 0 {
+		clone.Variants = nil
+	}
+
+	if skuSuffix != "" && clone.SKU != "" {
+		clone.SKU += skuSuffix
+	} else {
+		clone.SKU = ""
+	}
+	return clone
+}
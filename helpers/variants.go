@@ -0,0 +1,219 @@
+package helpers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/joshua/casify/model"
+)
+
+// maxVariantCombinations caps the size of a product's option matrix.
+const maxVariantCombinations = 500
+
+// ValidateVariants checks a product's option axes and variants: every
+// variant needs a SKU, exactly one declared value per axis, and a
+// combination of values no other variant uses.
+func ValidateVariants(p model.Product) error {
+	if len(p.Variants) == 0 {
+		return nil
+	}
+	if len(p.Options) == 0 {
+		return errors.New("variants require at least one option")
+	}
+
+	combinations := 1
+	axes := map[string]map[string]bool{}
+	for _, opt := range p.Options {
+		name := strings.TrimSpace(opt.Name)
+		if name == "" {
+			return errors.New("option names cannot be empty")
+		}
+		if _, ok := axes[name]; ok {
+			return fmt.Errorf("option %q is declared twice", name)
+		}
+		if len(opt.Values) == 0 {
+			return fmt.Errorf("option %q has no values", name)
+		}
+		values := map[string]bool{}
+		for _, v := range opt.Values {
+			if strings.TrimSpace(v) == "" {
+				return fmt.Errorf("option %q cannot contain empty values", name)
+			}
+			if values[v] {
+				return fmt.Errorf("option %q lists %q twice", name, v)
+			}
+			values[v] = true
+		}
+		axes[name] = values
+		combinations *= len(opt.Values)
+		if combinations > maxVariantCombinations {
+			return fmt.Errorf("options allow more than %d combinations", maxVariantCombinations)
+		}
+	}
+
+	skus := map[string]bool{}
+	if p.SKU != "" {
+		skus[p.SKU] = true
+	}
+	seen := map[string]string{}
+	for i, v := range p.Variants {
+		if strings.TrimSpace(v.SKU) == "" {
+			return fmt.Errorf("variant %d has no sku", i)
+		}
+		if skus[v.SKU] {
+			return fmt.Errorf("sku %q is used twice", v.SKU)
+		}
+		skus[v.SKU] = true
+
+		if len(v.Options) != len(p.Options) {
+			return fmt.Errorf("variant %s must set exactly one value for each option", v.SKU)
+		}
+		for name, value := range v.Options {
+			values, ok := axes[name]
+			if !ok {
+				return fmt.Errorf("variant %s uses unknown option %q", v.SKU, name)
+			}
+			if !values[value] {
+				return fmt.Errorf("variant %s uses unknown %s %q", v.SKU, name, value)
+			}
+		}
+
+		key := variantKey(p.Options, v.Options)
+		if other, ok := seen[key]; ok {
+			return fmt.Errorf("variants %s and %s have the same options", other, v.SKU)
+		}
+		seen[key] = v.SKU
+
+		if v.Price != nil && *v.Price < 0 {
+			return fmt.Errorf("variant %s price must be non-negative", v.SKU)
+		}
+		if v.Stock < 0 {
+			return fmt.Errorf("variant %s stock must be non-negative", v.SKU)
+		}
+		if err := validateStringSlice(v.Images, "variant images"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// variantKey identifies a combination of option values in axis order.
+func variantKey(axes []model.ProductOption, values map[string]string) string {
+	parts := make([]string, len(axes))
+	for i, axis := range axes {
+		parts[i] = values[axis.Name]
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// ProductSKUs lists the product SKU and every variant SKU.
+func ProductSKUs(p model.Product) []string {
+	var skus []string
+	if p.SKU != "" {
+		skus = append(skus, p.SKU)
+	}
+	for _, v := range p.Variants {
+		skus = append(skus, v.SKU)
+	}
+	return skus
+}
+
+// SellableSKUs lists the SKUs stock is kept for: the variant SKUs, or the
+// product SKU when the product has no variants.
+func SellableSKUs(p model.Product) []string {
+	if len(p.Variants) == 0 {
+		if p.SKU == "" {
+			return nil
+		}
+		return []string{p.SKU}
+	}
+	skus := make([]string, len(p.Variants))
+	for i, v := range p.Variants {
+		skus[i] = v.SKU
+	}
+	return skus
+}
+
+// VariantPrice is the price a variant sells for before discount.
+func VariantPrice(p model.Product, v model.ProductVariant) float64 {
+	if v.Price != nil {
+		return *v.Price
+	}
+	return p.Price
+}
+
+// VariantView is a variant with its prices resolved.
+type VariantView struct {
+	model.ProductVariant
+	EffectivePrice float64 `json:"effective_price"`
+	SalePrice      float64 `json:"sale_price"`
+}
+
+// VariantCell is one combination of option values. Variant is nil when the
+// combination is not sold.
+type VariantCell struct {
+	Options map[string]string `json:"options"`
+	Variant *VariantView      `json:"variant"`
+}
+
+// PriceRange is the cheapest and dearest price across variants.
+type PriceRange struct {
+	Min float64 `json:"min"`
+	Max float64 `json:"max"`
+}
+
+// VariantMatrix lays out every combination of a product's options.
+type VariantMatrix struct {
+	Axes       []model.ProductOption `json:"axes"`
+	Cells      []VariantCell         `json:"cells"`
+	PriceRange PriceRange            `json:"price_range"`
+}
+
+// BuildVariantMatrix returns the full option matrix of a product, or nil
+// when it has no variants.
+func BuildVariantMatrix(p model.Product) *VariantMatrix {
+	if len(p.Variants) == 0 {
+		return nil
+	}
+
+	byKey := map[string]*VariantView{}
+	matrix := &VariantMatrix{Axes: p.Options}
+	for i, v := range p.Variants {
+		price := VariantPrice(p, v)
+		view := &VariantView{
+			ProductVariant: v,
+			EffectivePrice: price,
+			SalePrice:      DiscountedPrice(price, p.Discount),
+		}
+		byKey[variantKey(p.Options, v.Options)] = view
+		if i == 0 || price < matrix.PriceRange.Min {
+			matrix.PriceRange.Min = price
+		}
+		if price > matrix.PriceRange.Max {
+			matrix.PriceRange.Max = price
+		}
+	}
+
+	// Walk the cartesian product of the axes in declaration order.
+	var walk func(axis int, current map[string]string)
+	walk = func(axis int, current map[string]string) {
+		if axis == len(p.Options) {
+			options := make(map[string]string, len(current))
+			for k, v := range current {
+				options[k] = v
+			}
+			matrix.Cells = append(matrix.Cells, VariantCell{
+				Options: options,
+				Variant: byKey[variantKey(p.Options, options)],
+			})
+			return
+		}
+		for _, value := range p.Options[axis].Values {
+			current[p.Options[axis].Name] = value
+			walk(axis+1, current)
+		}
+	}
+	walk(0, map[string]string{})
+	return matrix
+}
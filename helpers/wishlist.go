@@ -0,0 +1,165 @@
+package helpers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/joshua/casify/model"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	maxWishlistName = 60
+	// MaxWishlists is how many lists a user may keep.
+	MaxWishlists     = 20
+	maxWishlistItems = 100
+)
+
+var (
+	// ErrAlreadyWishlisted is returned when an item is already on the list.
+	ErrAlreadyWishlisted = errors.New("item is already on this list")
+	// ErrWishlistLimit is returned when a user has too many lists or a
+	// list too many items.
+	ErrWishlistLimit = errors.New("wishlist limit exceeded")
+)
+
+// RandomToken returns n random bytes, hex encoded, for ids that must not be
+// guessed such as guest carts and share links.
+func RandomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// ValidateWishlistName checks the name of a list.
+func ValidateWishlistName(name string) error {
+	if strings.TrimSpace(name) == "" {
+		return errors.New("name is required")
+	}
+	if len(name) > maxWishlistName {
+		return fmt.Errorf("name is limited to %d characters", maxWishlistName)
+	}
+	return nil
+}
+
+// ItemPrice is the sale price of a wishlisted product, or of one of its
+// SKUs when sku is set. ok is false when p does not sell the SKU.
+func ItemPrice(p model.Product, sku string) (price float64, ok bool) {
+	if sku == "" {
+		return roundCents(DiscountedPrice(p.Price, p.Discount)), true
+	}
+	price, _, ok = SKUPrice(p, sku)
+	return price, ok
+}
+
+// NewWishlistItem builds the list item for a live product, or one of its
+// SKUs.
+func NewWishlistItem(p model.Product, sku string, now time.Time) (model.WishlistItem, error) {
+	price, ok := ItemPrice(p, sku)
+	if !ok || !ProductLive(p) {
+		return model.WishlistItem{}, fmt.Errorf("%w: %s", ErrSKUNotSold, sku)
+	}
+	return model.WishlistItem{
+		Id:             primitive.NewObjectID(),
+		ProductId:      p.Id,
+		SKU:            sku,
+		PriceWhenAdded: price,
+		AddedAt:        now,
+	}, nil
+}
+
+// AddWishlistItem adds item to the list unless the same product and SKU is
+// already on it.
+func AddWishlistItem(w *model.Wishlist, item model.WishlistItem) error {
+	for _, existing := range w.Items {
+		if existing.ProductId == item.ProductId && existing.SKU == item.SKU {
+			return ErrAlreadyWishlisted
+		}
+	}
+	if len(w.Items) >= maxWishlistItems {
+		return fmt.Errorf("%w: a list holds at most %d items", ErrWishlistLimit, maxWishlistItems)
+	}
+	w.Items = append(w.Items, item)
+	return nil
+}
+
+// CartSKU picks the SKU to put in the cart for a wishlisted item: its own,
+// or the product's only one.
+func CartSKU(p model.Product, sku string) (string, error) {
+	if sku != "" {
+		return sku, nil
+	}
+	skus := SellableSKUs(p)
+	if len(skus) != 1 {
+		return "", errors.New("choose a variant to add to the cart")
+	}
+	return skus[0], nil
+}
+
+// PriceDropped reports whether a price fell by at least a cent.
+func PriceDropped(before, after float64) bool {
+	return roundCents(before-after) >= 0.01
+}
+
+// WishlistLine is a list item with its product as it is now.
+type WishlistLine struct {
+	model.WishlistItem
+	Title string  `json:"title"`
+	URL   string  `json:"url,omitempty"`
+	Image string  `json:"image,omitempty"`
+	Price float64 `json:"price"`
+	// PriceDropped is set when the item is cheaper than when it was saved.
+	PriceDropped bool `json:"price_dropped"`
+	// Available is false once the product is unpublished or deleted.
+	Available bool `json:"available"`
+}
+
+// WishlistView is a list as shown to its owner or the people it is shared
+// with.
+type WishlistView struct {
+	Id         primitive.ObjectID `json:"id"`
+	Name       string             `json:"name"`
+	Items      []WishlistLine     `json:"items"`
+	ShareToken string             `json:"share_token,omitempty"`
+	TimeStamp  model.TimeStamp    `json:"time_stamp"`
+}
+
+// PriceWishlist resolves the items of a list against their products.
+func PriceWishlist(w model.Wishlist, products []model.Product) WishlistView {
+	byId := map[primitive.ObjectID]model.Product{}
+	for _, p := range products {
+		byId[p.Id] = p
+	}
+	view := WishlistView{Id: w.Id, Name: w.Name, Items: []WishlistLine{}, ShareToken: w.ShareToken, TimeStamp: w.TimeStamp}
+	for _, item := range w.Items {
+		line := WishlistLine{WishlistItem: item, Price: item.PriceWhenAdded}
+		if p, ok := byId[item.ProductId]; ok && ProductLive(p) {
+			line.Title, line.URL = p.Title, ProductPath(p)
+			if len(p.Images) > 0 {
+				line.Image = p.Images[0]
+			}
+			line.Price, line.Available = ItemPrice(p, item.SKU)
+			line.PriceDropped = line.Available && PriceDropped(item.PriceWhenAdded, line.Price)
+		}
+		view.Items = append(view.Items, line)
+	}
+	return view
+}
+
+// PriceDrop is the payload of price drop events: a wishlisted item got
+// cheaper.
+type PriceDrop struct {
+	UserId     primitive.ObjectID `json:"user_id"`
+	WishlistId primitive.ObjectID `json:"wishlist_id"`
+	ProductId  primitive.ObjectID `json:"product_id"`
+	SKU        string             `json:"sku,omitempty"`
+	Title      string             `json:"title"`
+	OldPrice   float64            `json:"old_price"`
+	NewPrice   float64            `json:"new_price"`
+}
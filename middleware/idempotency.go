@@ -0,0 +1,279 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/joshua/casify/controllers"
+	"github.com/joshua/casify/helpers"
+	"github.com/joshua/casify/model"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// IdempotencyHeader is the request header clients put a retry key in.
+const IdempotencyHeader = "Idempotency-Key"
+
+// ReplayedHeader is set on responses replayed from an earlier request.
+const ReplayedHeader = "Idempotent-Replayed"
+
+// credentialHeaders carry credentials and are never stored or replayed.
+var credentialHeaders = []string{"Set-Cookie", "Authorization"}
+
+// recordingWriter keeps a copy of the response body as it is written.
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Idempotency makes mutating requests sent with an Idempotency-Key safe to
+// retry. The first response is stored per user and key and replayed for
+// retries of the same request. A retry while the first request is still
+// running gets 409, until the first request's lease lapses, e.g. because
+// the server crashed, and the retry runs instead. Reusing a key for a
+// different request gets 422.
+// Server errors, conflicts and rate limiting are not stored, so the
+// request can be retried.
+func Idempotency(ctx *gin.Context) {
+	key := ctx.GetHeader(IdempotencyHeader)
+	if key == "" || ctx.Request.Method == http.MethodGet || ctx.Request.Method == http.MethodHead || ctx.Request.Method == http.MethodOptions {
+		ctx.Next()
+		return
+	}
+	if err := helpers.ValidateIdempotencyKey(key); err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid Idempotency-Key",
+			"error":   err.Error(),
+		})
+		return
+	}
+
+	body, err := io.ReadAll(ctx.Request.Body)
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid request body",
+			"error":   err.Error(),
+		})
+		return
+	}
+	ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	// Keys are scoped to the user, or a guest's cart, so clients cannot see
+	// each other's responses. Guests without a cart cannot be told apart
+	// and are not covered.
+	var scope string
+	if user, err := requestUser(ctx); err == nil {
+		scope = user.Id.Hex()
+	} else if cart, err := ctx.Cookie(controllers.CartCookie); err == nil && cart != "" {
+		scope = "guest-" + cart
+	} else {
+		ctx.Next()
+		return
+	}
+	now := time.Now()
+	lease := helpers.IdempotencyLeaseFromEnv()
+	record := model.IdempotencyRecord{
+		Id:          scope + ":" + key,
+		RequestHash: helpers.RequestHash(ctx.Request.Method, ctx.Request.URL.RequestURI(), body),
+		State:       model.IdempotencyInFlight,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(helpers.IdempotencyTTLFromEnv()),
+		LockedUntil: now.Add(lease),
+		Lease:       primitive.NewObjectID().Hex(),
+	}
+	// Only the request holding the lease may renew, release or complete the
+	// key
+	held := bson.M{"_id": record.Id, "lease": record.Lease}
+
+	claimed, err := claimIdempotencyKey(record)
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{
+			"message": "Failed to check Idempotency-Key",
+			"error":   err.Error(),
+		})
+		return
+	}
+	if claimed != nil {
+		replayIdempotent(ctx, record, claimed)
+		return
+	}
+
+	collection := controllers.IdempotencyCollection()
+	completed := false
+	defer func() {
+		// Failed or panicking requests give the key back for a retry
+		if completed {
+			return
+		}
+		if _, err := collection.DeleteOne(context.Background(), held); err != nil {
+			log.Printf("failed to release idempotency key %s: %v", record.Id, err)
+		}
+	}()
+
+	// Keep the lease while the request runs, however long it takes
+	stop := make(chan struct{})
+	defer close(stop)
+	go renewIdempotencyLease(record.Id, held, lease, stop)
+
+	writer := &recordingWriter{ResponseWriter: ctx.Writer}
+	ctx.Writer = writer
+	ctx.Next()
+
+	status := writer.Status()
+	if !storableStatus(status) {
+		return
+	}
+	header := writer.Header().Clone()
+	for _, name := range credentialHeaders {
+		header.Del(name)
+	}
+	result, err := collection.UpdateOne(context.Background(), held, bson.M{
+		"$set": bson.M{
+			"state":  model.IdempotencyCompleted,
+			"status": status,
+			"header": map[string][]string(header),
+			"body":   writer.body.Bytes(),
+		},
+		"$unset": bson.M{"locked_until": "", "lease": ""},
+	})
+	if err != nil {
+		log.Printf("failed to store response for idempotency key %s: %v", record.Id, err)
+		return
+	}
+	if result.MatchedCount == 0 {
+		log.Printf("idempotency key %s was taken over before its response was stored", record.Id)
+	}
+	completed = true
+}
+
+// renewIdempotencyLease extends the lease on an in flight key every third of
+// its length until stop is closed, or the key is completed or taken over.
+func renewIdempotencyLease(id string, held bson.M, lease time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(lease / 3)
+	defer ticker.Stop()
+
+	filter := bson.M{"state": model.IdempotencyInFlight}
+	for k, v := range held {
+		filter[k] = v
+	}
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		result, err := controllers.IdempotencyCollection().UpdateOne(context.Background(), filter, bson.M{
+			"$set": bson.M{"locked_until": time.Now().Add(lease)},
+		})
+		if err != nil {
+			log.Printf("failed to renew lease on idempotency key %s: %v", id, err)
+			continue
+		}
+		if result.MatchedCount == 0 {
+			return
+		}
+	}
+}
+
+// storableStatus reports whether a response is final. Server errors,
+// conflicts and rate limiting are transient and a retry may succeed.
+func storableStatus(status int) bool {
+	switch status {
+	case http.StatusConflict, http.StatusTooManyRequests:
+		return false
+	}
+	return status < http.StatusInternalServerError
+}
+
+// claimIdempotencyKey stores record as in flight. When the key is already
+// taken the existing record is returned instead. Records past their expiry
+// that the TTL monitor has not removed yet are replaced, and so are in
+// flight records of the same request whose lease ran out.
+func claimIdempotencyKey(record model.IdempotencyRecord) (*model.IdempotencyRecord, error) {
+	collection := controllers.IdempotencyCollection()
+	for attempt := 0; attempt < 2; attempt++ {
+		_, err := collection.InsertOne(context.Background(), record)
+		if err == nil {
+			return nil, nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return nil, err
+		}
+		var existing model.IdempotencyRecord
+		err = collection.FindOne(context.Background(), bson.M{"_id": record.Id}).Decode(&existing)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if existing.ExpiresAt.After(time.Now()) {
+			if existing.State != model.IdempotencyInFlight || existing.RequestHash != record.RequestHash || existing.LockedUntil.After(time.Now()) {
+				return &existing, nil
+			}
+			// The request holding the key never finished, e.g. the server
+			// crashed, so this retry takes over
+			filter := bson.M{"_id": record.Id, "state": model.IdempotencyInFlight, "locked_until": existing.LockedUntil}
+			if existing.LockedUntil.IsZero() {
+				filter["locked_until"] = bson.M{"$exists": false}
+			}
+			result, err := collection.ReplaceOne(context.Background(), filter, record)
+			if err != nil {
+				return nil, err
+			}
+			if result.MatchedCount == 1 {
+				return nil, nil
+			}
+			continue
+		}
+		if _, err := collection.DeleteOne(context.Background(), bson.M{"_id": record.Id, "expires_at": existing.ExpiresAt}); err != nil {
+			return nil, err
+		}
+	}
+	return nil, errors.New("idempotency key is being reused concurrently")
+}
+
+// replayIdempotent answers a request whose key was already used.
+func replayIdempotent(ctx *gin.Context, record model.IdempotencyRecord, existing *model.IdempotencyRecord) {
+	switch {
+	case existing.RequestHash != record.RequestHash:
+		ctx.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
+			"message": "Idempotency-Key was used for a different request",
+		})
+	case existing.State != model.IdempotencyCompleted:
+		ctx.AbortWithStatusJSON(http.StatusConflict, gin.H{
+			"message": "A request with this Idempotency-Key is still in progress",
+		})
+	default:
+		header := http.Header(existing.Header).Clone()
+		for _, name := range credentialHeaders {
+			header.Del(name)
+		}
+		for name, values := range header {
+			for _, value := range values {
+				ctx.Writer.Header().Add(name, value)
+			}
+		}
+		ctx.Header(ReplayedHeader, "true")
+		ctx.Status(existing.Status)
+		ctx.Writer.Write(existing.Body)
+		ctx.Abort()
+	}
+}
@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -15,12 +16,84 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+var (
+	errNoToken      = errors.New("no token")
+	errInvalidToken = errors.New("Invalid token")
+	errUserNotFound = errors.New("user not found")
+)
+
+// authResultKey is the context key of the cached authenticate result.
+const authResultKey = "auth_result"
+
+type authResult struct {
+	user *model.AuthUser
+	err  error
+}
+
 func ValidateAuth(ctx *gin.Context) {
 
+	user, err := requestUser(ctx)
+	switch {
+	case errors.Is(err, errInvalidToken), errors.Is(err, errUserNotFound):
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	case err != nil:
+		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+
+	// attach the user to the request
+	ctx.Set("user", *user)
+
+	ctx.Next()
+}
+
+// OptionalAuth attaches the user to the request when a valid token is sent,
+// and lets anonymous requests through untouched.
+func OptionalAuth(ctx *gin.Context) {
+	if user, err := requestUser(ctx); err == nil {
+		ctx.Set("user", *user)
+	}
+	ctx.Next()
+}
+
+// RequireAdmin rejects users without the admin role. It must run after
+// ValidateAuth.
+func RequireAdmin(ctx *gin.Context) {
+	value, ok := ctx.Get("user")
+	user, isUser := value.(model.AuthUser)
+	if !ok || !isUser || user.Role != model.RoleAdmin {
+		ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"message": "Admin access required",
+		})
+		return
+	}
+	ctx.Next()
+}
+
+// requestUser authenticates the request once, and returns the same result
+// to every middleware that asks after that.
+func requestUser(ctx *gin.Context) (*model.AuthUser, error) {
+	if value, ok := ctx.Get(authResultKey); ok {
+		result := value.(authResult)
+		return result.user, result.err
+	}
+	user, err := authenticate(ctx)
+	ctx.Set(authResultKey, authResult{user: user, err: err})
+	return user, err
+}
+
+// authenticate reads the Authorization cookie, validates the token and loads
+// the user it belongs to.
+func authenticate(ctx *gin.Context) (*model.AuthUser, error) {
+
 	// get cookie of the request
 	tokenString, err := ctx.Cookie("Authorization")
 	if err != nil {
-		ctx.AbortWithStatus(http.StatusUnauthorized)
+		return nil, errNoToken
 	}
 
 	// decode/ validate the token
@@ -35,60 +108,43 @@ func ValidateAuth(ctx *gin.Context) {
 	})
 	if err != nil {
 		fmt.Printf("JWT parsing error: %v\n", err)
-		ctx.JSON(http.StatusBadRequest, gin.H{
-			"message": "Invalid token",
-		})
-		ctx.AbortWithStatus(http.StatusUnauthorized)
-		return
+		return nil, errInvalidToken
 	}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok {
-		// check the expiration time
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errInvalidToken
+	}
 
-		if float64(time.Now().Unix()) > claims["exp"].(float64) {
-			ctx.AbortWithStatus(http.StatusUnauthorized)
-		}
+	// check the expiration time
+	exp, ok := claims["exp"].(float64)
+	if !ok || float64(time.Now().Unix()) > exp {
+		return nil, errInvalidToken
+	}
 
-		// find the user sub
-		if controllers.Client == nil {
-			if err := controllers.ConnectToMongoDB(); err != nil {
-				ctx.AbortWithStatus(http.StatusUnauthorized)
-			}
+	// find the user sub
+	if controllers.Client == nil {
+		if err := controllers.ConnectToMongoDB(); err != nil {
+			return nil, err
 		}
+	}
 
-		var user model.User
-		id, err := primitive.ObjectIDFromHex(claims["sub"].(string))
-
-		if err != nil {
-			ctx.JSON(http.StatusBadRequest, gin.H{
-				"message": "user not found",
-			})
-			ctx.AbortWithStatus(http.StatusUnauthorized)
-		}
-		filter := bson.M{"_id": id}
-		collection := controllers.Client.Database("casify").Collection("usersAuth")
-		if err := collection.FindOne(context.Background(), filter).Decode(&user); err != nil {
-			ctx.JSON(http.StatusBadRequest, gin.H{
-				"message": "user not found",
-			})
-			ctx.AbortWithStatus(http.StatusUnauthorized)
-		}
-		// attach the user to the request
-		type UserResponse struct {
-			Id   primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
-			Name string             `json:"name,omitempty" bson:"name,omitempty"`
-			Role string             `json:"role,omitempty" bson:"role,omitempty"`
-		}
-		// we only want to return the id, name and role
-		userDetails := UserResponse{
-			Id:   user.Id,
-			Role: user.Role,
-		}
-		ctx.Set("user", userDetails)
-	} else {
-		ctx.AbortWithStatus(http.StatusUnauthorized)
+	sub, _ := claims["sub"].(string)
+	id, err := primitive.ObjectIDFromHex(sub)
+	if err != nil {
+		return nil, errUserNotFound
+	}
 
+	var user model.User
+	filter := bson.M{"_id": id}
+	collection := controllers.Client.Database("casify").Collection("usersAuth")
+	if err := collection.FindOne(context.Background(), filter).Decode(&user); err != nil {
+		return nil, errUserNotFound
 	}
 
-	ctx.Next()
+	// we only want to return the id, name and role
+	return &model.AuthUser{
+		Id:   user.Id,
+		Role: user.Role,
+	}, nil
 }
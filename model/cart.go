@@ -0,0 +1,33 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Cart holds what a shopper intends to buy. Signed in users keep their cart
+// in their own collection; guest carts are keyed by the cart cookie and
+// expire.
+type Cart struct {
+	Id        string     `json:"-" bson:"_id"`
+	Items     []CartItem `json:"items" bson:"items"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty" bson:"expires_at,omitempty"`
+	UpdatedAt time.Time  `json:"updated_at" bson:"updated_at"`
+	// Version counts saves, so concurrent changes do not overwrite each
+	// other.
+	Version int `json:"-" bson:"version"`
+}
+
+// CartItem is one SKU in a cart.
+type CartItem struct {
+	SKU       string             `json:"sku" bson:"sku"`
+	ProductId primitive.ObjectID `json:"product_id" bson:"product_id"`
+	Title     string             `json:"title" bson:"title"`
+	Options   map[string]string  `json:"options,omitempty" bson:"options,omitempty"`
+	Quantity  int                `json:"quantity" bson:"quantity"`
+	// UnitPrice is the sale price when the item was added, so shoppers
+	// can be told when it changes.
+	UnitPrice float64   `json:"unit_price" bson:"unit_price"`
+	AddedAt   time.Time `json:"added_at" bson:"added_at"`
+}
@@ -0,0 +1,22 @@
+package model
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Category is a node of the category tree. Root categories have no parent.
+type Category struct {
+	Id          primitive.ObjectID  `json:"id,omitempty" bson:"_id,omitempty"`
+	Name        string              `json:"name" bson:"name" binding:"required"`
+	Slug        string              `json:"slug" bson:"slug"`
+	ParentId    *primitive.ObjectID `json:"parent_id,omitempty" bson:"parent_id,omitempty"`
+	SortOrder   int                 `json:"sort_order" bson:"sort_order"`
+	Description string              `json:"description,omitempty" bson:"description,omitempty"`
+	SEO         CategorySEO         `json:"seo,omitempty" bson:"seo,omitempty"`
+	TimeStamp   TimeStamp           `json:"time_stamp,omitempty" bson:"time_stamp,omitempty"`
+}
+
+// CategorySEO holds the search engine metadata of a category page.
+type CategorySEO struct {
+	Title       string   `json:"title,omitempty" bson:"title,omitempty"`
+	Description string   `json:"description,omitempty" bson:"description,omitempty"`
+	Keywords    []string `json:"keywords,omitempty" bson:"keywords,omitempty"`
+}
@@ -0,0 +1,36 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Collection types
+const (
+	CollectionManual = "manual"
+	CollectionRule   = "rule"
+)
+
+// Collection is a merchandised group of products such as "Summer 2026".
+// Manual collections list their products in order; rule collections hold
+// every product matching Rule, a filter expression like
+// `category_id = "..." AND price <= 30 AND rating >= 4`.
+type Collection struct {
+	Id          primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	Title       string             `json:"title" bson:"title" binding:"required"`
+	Slug        string             `json:"slug" bson:"slug"`
+	Description string             `json:"description,omitempty" bson:"description,omitempty"`
+	Type        string             `json:"type" bson:"type" binding:"required"`
+	// ProductIds are the products of a manual collection in display order.
+	ProductIds []primitive.ObjectID `json:"product_ids,omitempty" bson:"product_ids,omitempty"`
+	Rule       string               `json:"rule,omitempty" bson:"rule,omitempty"`
+	// Sort orders a rule collection, using the listing sort syntax.
+	Sort string `json:"sort,omitempty" bson:"sort,omitempty"`
+	// Pinned products are shown first, in this order, in either type.
+	Pinned []primitive.ObjectID `json:"pinned,omitempty" bson:"pinned,omitempty"`
+	// StartsAt and EndsAt bound when the collection is visible to shoppers.
+	StartsAt  *time.Time `json:"starts_at,omitempty" bson:"starts_at,omitempty"`
+	EndsAt    *time.Time `json:"ends_at,omitempty" bson:"ends_at,omitempty"`
+	TimeStamp TimeStamp  `json:"time_stamp,omitempty" bson:"time_stamp,omitempty"`
+}
@@ -0,0 +1,26 @@
+package model
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Device is a phone model that cases can be made for.
+type Device struct {
+	Id           primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	Brand        string             `json:"brand" bson:"brand" binding:"required"`
+	Model        string             `json:"model" bson:"model" binding:"required"`
+	Generation   string             `json:"generation,omitempty" bson:"generation,omitempty"`
+	Dimensions   DeviceDimensions   `json:"dimensions,omitempty" bson:"dimensions,omitempty"`
+	CameraLayout string             `json:"camera_layout,omitempty" bson:"camera_layout,omitempty"`
+	TimeStamp    TimeStamp          `json:"time_stamp,omitempty" bson:"time_stamp,omitempty"`
+}
+
+// DeviceDimensions are the body measurements of a device in millimetres.
+type DeviceDimensions struct {
+	Height float64 `json:"height_mm,omitempty" bson:"height_mm,omitempty"`
+	Width  float64 `json:"width_mm,omitempty" bson:"width_mm,omitempty"`
+	Depth  float64 `json:"depth_mm,omitempty" bson:"depth_mm,omitempty"`
+}
+
+// Name is the display name of the device, e.g. "Samsung Galaxy S24".
+func (d Device) Name() string {
+	return d.Brand + " " + d.Model
+}
@@ -0,0 +1,29 @@
+package model
+
+import "time"
+
+// Idempotency record states
+const (
+	IdempotencyInFlight  = "in_flight"
+	IdempotencyCompleted = "completed"
+)
+
+// IdempotencyRecord stores the first response to a request sent with an
+// Idempotency-Key, so retries of it get the same response.
+type IdempotencyRecord struct {
+	// Id is the requesting user and the key.
+	Id          string              `json:"id" bson:"_id"`
+	RequestHash string              `json:"request_hash" bson:"request_hash"`
+	State       string              `json:"state" bson:"state"`
+	Status      int                 `json:"status,omitempty" bson:"status,omitempty"`
+	Header      map[string][]string `json:"header,omitempty" bson:"header,omitempty"`
+	Body        []byte              `json:"body,omitempty" bson:"body,omitempty"`
+	CreatedAt   time.Time           `json:"created_at" bson:"created_at"`
+	ExpiresAt   time.Time           `json:"expires_at" bson:"expires_at"`
+	// LockedUntil is when an in flight request's hold on the key lapses,
+	// so a retry can take over from a request that never finished.
+	LockedUntil time.Time `json:"locked_until,omitempty" bson:"locked_until,omitempty"`
+	// Lease identifies the request holding an in flight key, which renews
+	// LockedUntil while it runs.
+	Lease string `json:"-" bson:"lease,omitempty"`
+}
@@ -0,0 +1,73 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// StockLevel is the stock held for one SKU. Available stock is OnHand minus
+// Reserved.
+type StockLevel struct {
+	Id        primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	SKU       string             `json:"sku" bson:"sku"`
+	ProductId primitive.ObjectID `json:"product_id,omitempty" bson:"product_id,omitempty"`
+	OnHand    int                `json:"on_hand" bson:"on_hand"`
+	Reserved  int                `json:"reserved" bson:"reserved"`
+	// LowStockThreshold triggers a low-stock event once available stock
+	// falls to it. Zero disables the alert.
+	LowStockThreshold int       `json:"low_stock_threshold" bson:"low_stock_threshold"`
+	UpdatedAt         time.Time `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+}
+
+// Available is the quantity that can still be reserved.
+func (s StockLevel) Available() int {
+	return s.OnHand - s.Reserved
+}
+
+// Reservation states
+const (
+	ReservationHeld      = "held"
+	ReservationCommitted = "committed"
+	ReservationReleased  = "released"
+)
+
+// StockReservation holds stock for a checkout until it is committed or
+// released. Held reservations are released automatically once they expire.
+type StockReservation struct {
+	Id        primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	SKU       string             `json:"sku" bson:"sku"`
+	Quantity  int                `json:"quantity" bson:"quantity"`
+	Reference string             `json:"reference,omitempty" bson:"reference,omitempty"`
+	Status    string             `json:"status" bson:"status"`
+	ExpiresAt time.Time          `json:"expires_at" bson:"expires_at"`
+	TimeStamp TimeStamp          `json:"time_stamp,omitempty" bson:"time_stamp,omitempty"`
+}
+
+// Stock adjustment reasons
+const (
+	AdjustRestock    = "restock"
+	AdjustSale       = "sale"
+	AdjustReturn     = "return"
+	AdjustDamage     = "damage"
+	AdjustCorrection = "correction"
+	AdjustTransfer   = "transfer"
+)
+
+// StockAdjustment is one entry of the inventory ledger. Every change to an
+// on-hand quantity is recorded with its reason. OnHandAfter is the SKU's
+// total on hand after the change, except for transfers, which leave the
+// total alone and record the warehouse's own quantity.
+type StockAdjustment struct {
+	Id          primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	SKU         string             `json:"sku" bson:"sku"`
+	Delta       int                `json:"delta" bson:"delta"`
+	OnHandAfter int                `json:"on_hand_after" bson:"on_hand_after"`
+	// WarehouseId is the location the change happened at, when known.
+	WarehouseId primitive.ObjectID `json:"warehouse_id,omitempty" bson:"warehouse_id,omitempty"`
+	Reason      string             `json:"reason" bson:"reason"`
+	Note        string             `json:"note,omitempty" bson:"note,omitempty"`
+	Reference   string             `json:"reference,omitempty" bson:"reference,omitempty"`
+	Actor       primitive.ObjectID `json:"actor,omitempty" bson:"actor,omitempty"`
+	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+}
@@ -0,0 +1,26 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Notification kinds
+const (
+	NotifyReviewRejected = "review_rejected"
+	NotifyPriceDrop      = "price_drop"
+)
+
+// Notification is a message for one user, shown in their inbox.
+type Notification struct {
+	Id      primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	UserId  primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Kind    string             `json:"kind" bson:"kind"`
+	Title   string             `json:"title" bson:"title"`
+	Message string             `json:"message" bson:"message"`
+	// Reference is the id of what the notification is about.
+	Reference primitive.ObjectID `json:"reference,omitempty" bson:"reference,omitempty"`
+	Read      bool               `json:"read" bson:"read"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
@@ -0,0 +1,115 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Order states. Orders move between them only along the transitions in
+// helpers.OrderTransitions.
+const (
+	OrderPending    = "pending"
+	OrderPaid       = "paid"
+	OrderFulfilling = "fulfilling"
+	OrderShipped    = "shipped"
+	OrderDelivered  = "delivered"
+	OrderCancelled  = "cancelled"
+	OrderRefunded   = "refunded"
+)
+
+// Payment states
+const (
+	PaymentRequired  = "requires_payment"
+	PaymentSucceeded = "succeeded"
+	PaymentFailed    = "failed"
+	PaymentRefunded  = "refunded"
+)
+
+// Address is where an order is shipped.
+type Address struct {
+	Name       string `json:"name" bson:"name" binding:"required"`
+	Line1      string `json:"line1" bson:"line1" binding:"required"`
+	Line2      string `json:"line2,omitempty" bson:"line2,omitempty"`
+	City       string `json:"city" bson:"city" binding:"required"`
+	Region     string `json:"region,omitempty" bson:"region,omitempty"`
+	PostalCode string `json:"postal_code" bson:"postal_code" binding:"required"`
+	Country    string `json:"country" bson:"country" binding:"required"`
+	Phone      string `json:"phone,omitempty" bson:"phone,omitempty"`
+}
+
+// OrderLine is a SKU as it was sold. Titles and prices are copied so later
+// catalog changes do not alter the order.
+type OrderLine struct {
+	SKU       string             `json:"sku" bson:"sku"`
+	ProductId primitive.ObjectID `json:"product_id" bson:"product_id"`
+	Title     string             `json:"title" bson:"title"`
+	Options   map[string]string  `json:"options,omitempty" bson:"options,omitempty"`
+	Quantity  int                `json:"quantity" bson:"quantity"`
+	UnitPrice float64            `json:"unit_price" bson:"unit_price"`
+	LineTotal float64            `json:"line_total" bson:"line_total"`
+}
+
+// Payment is the payment intent of an order.
+type Payment struct {
+	// Method is the payment provider the intent was opened with.
+	Method   string `json:"method" bson:"method"`
+	IntentId string `json:"intent_id" bson:"intent_id"`
+	// ClientSecret lets the customer's browser complete the payment.
+	ClientSecret string  `json:"client_secret,omitempty" bson:"client_secret,omitempty"`
+	Status       string  `json:"status" bson:"status"`
+	Amount       float64 `json:"amount" bson:"amount"`
+	Currency     string  `json:"currency" bson:"currency"`
+}
+
+// OrderTransition is one entry of an order's history.
+type OrderTransition struct {
+	From  string             `json:"from,omitempty" bson:"from,omitempty"`
+	To    string             `json:"to" bson:"to"`
+	Actor primitive.ObjectID `json:"actor,omitempty" bson:"actor,omitempty"`
+	Note  string             `json:"note,omitempty" bson:"note,omitempty"`
+	At    time.Time          `json:"at" bson:"at"`
+}
+
+// Order is a checked out cart.
+type Order struct {
+	Id     primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	Number string             `json:"number" bson:"number"`
+	UserId primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Status string             `json:"status" bson:"status"`
+	Lines  []OrderLine        `json:"lines" bson:"lines"`
+	// ProductIds lists the products in Lines, to find the orders of a
+	// product.
+	ProductIds      []primitive.ObjectID `json:"-" bson:"product_ids"`
+	Subtotal        float64              `json:"subtotal" bson:"subtotal"`
+	ShippingMethod  string               `json:"shipping_method" bson:"shipping_method"`
+	ShippingCost    float64              `json:"shipping_cost" bson:"shipping_cost"`
+	Total           float64              `json:"total" bson:"total"`
+	Currency        string               `json:"currency" bson:"currency"`
+	ShippingAddress Address              `json:"shipping_address" bson:"shipping_address"`
+	Payment         Payment              `json:"payment" bson:"payment"`
+	TrackingNumber  string               `json:"tracking_number,omitempty" bson:"tracking_number,omitempty"`
+	// ReservationIds hold the order's stock until it is paid.
+	ReservationIds []primitive.ObjectID `json:"-" bson:"reservation_ids"`
+	// ExpiresAt is when an unpaid order is cancelled and its stock
+	// released.
+	ExpiresAt time.Time `json:"expires_at" bson:"expires_at"`
+	// StockIssue flags a paid order whose stock could not be committed,
+	// e.g. because it was paid after its reservation expired, for staff to
+	// restock or refund.
+	StockIssue string            `json:"stock_issue,omitempty" bson:"stock_issue,omitempty"`
+	History    []OrderTransition `json:"history" bson:"history"`
+	TimeStamp  TimeStamp         `json:"time_stamp,omitempty" bson:"time_stamp,omitempty"`
+}
+
+// PaymentEventRecord remembers a processed payment webhook, so a replayed
+// event is recognised and skipped.
+type PaymentEventRecord struct {
+	// Id is the provider name and its event id.
+	Id         string             `json:"id" bson:"_id"`
+	Type       string             `json:"type" bson:"type"`
+	IntentId   string             `json:"intent_id,omitempty" bson:"intent_id,omitempty"`
+	OrderId    primitive.ObjectID `json:"order_id,omitempty" bson:"order_id,omitempty"`
+	Outcome    string             `json:"outcome" bson:"outcome"`
+	ReceivedAt time.Time          `json:"received_at" bson:"received_at"`
+}
@@ -1,23 +1,78 @@
 package model
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"time"
 
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Product statuses. Only published products are shown to shoppers.
+const (
+	ProductDraft     = "draft"
+	ProductScheduled = "scheduled"
+	ProductPublished = "published"
+	ProductArchived  = "archived"
+)
+
+// Product is a catalog item. Shopper feedback lives in reviews; the old
+// free-text comments had no author or rating and were not carried over.
 type Product struct {
 	Id          primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
 	Title       string             `json:"title" bson:"title" binding:"required"`
+	Slug        string             `json:"slug,omitempty" bson:"slug,omitempty"`
 	Description string             `json:"description" bson:"description" binding:"required"`
 	Price       float64            `json:"price" bson:"price" binding:"required"`
 	Images      []string           `json:"images" bson:"images" binding:"required"`
 	Discount    float64            `json:"discount,omitempty" bson:"discount,omitempty"`
 	Details     ProductDetails     `json:"details" bson:"details" binding:"required"`
-	Rating      float64            `json:"rating,omitempty" bson:"rating,omitempty"`
 	Color       string             `json:"color,omitempty" bson:"color,omitempty"`
 	Category    []string           `json:"category,omitempty" bson:"category,omitempty"`
-	Comments    []string           `json:"comments,omitempty" bson:"comments,omitempty"`
-	TimeStamp   TimeStamp          `json:"time_stamp,omitempty" bson:"time_stamp,omitempty"`
+	// Rating is the average of the product's reviews. It and the review
+	// counts are kept up to date by the server and cannot be set.
+	Rating             float64        `json:"rating,omitempty" bson:"rating,omitempty"`
+	ReviewCount        int            `json:"review_count" bson:"review_count"`
+	RatingDistribution map[string]int `json:"rating_distribution,omitempty" bson:"rating_distribution,omitempty"`
+	// CategoryIds references the category tree. When set, Category holds
+	// the names of these categories.
+	CategoryIds []primitive.ObjectID `json:"category_ids,omitempty" bson:"category_ids,omitempty"`
+	// PreviousSlugs are former slugs that redirect to the current one.
+	PreviousSlugs []string `json:"previous_slugs,omitempty" bson:"previous_slugs,omitempty"`
+	// SKU identifies products sold without variants.
+	SKU string `json:"sku,omitempty" bson:"sku,omitempty"`
+	// Options are the axes variants differ on, e.g. color and device.
+	Options  []ProductOption  `json:"options,omitempty" bson:"options,omitempty"`
+	Variants []ProductVariant `json:"variants,omitempty" bson:"variants,omitempty"`
+	// CompatibleDevices lists the ids of the devices the product fits.
+	CompatibleDevices []primitive.ObjectID `json:"compatible_devices,omitempty" bson:"compatible_devices,omitempty"`
+	// Status is the lifecycle state. Scheduled products are published at
+	// PublishAt; published ones are archived at UnpublishAt when set.
+	Status      string     `json:"status,omitempty" bson:"status,omitempty"`
+	PublishAt   *time.Time `json:"publish_at,omitempty" bson:"publish_at,omitempty"`
+	UnpublishAt *time.Time `json:"unpublish_at,omitempty" bson:"unpublish_at,omitempty"`
+	TimeStamp   TimeStamp  `json:"time_stamp,omitempty" bson:"time_stamp,omitempty"`
 }
 
 type ProductDetails struct {
 	Details  []string `json:"details,omitempty" bson:"details,omitempty"`
 	Features []string `json:"features,omitempty" bson:"features,omitempty"`
 }
+
+// ProductOption is one axis variants differ on and the values it can take.
+type ProductOption struct {
+	Name   string   `json:"name" bson:"name"`
+	Values []string `json:"values" bson:"values"`
+}
+
+// ProductVariant is one purchasable combination of option values.
+type ProductVariant struct {
+	SKU string `json:"sku" bson:"sku"`
+	// Options maps each option name to the value this variant has.
+	Options map[string]string `json:"options" bson:"options"`
+	// Price overrides the product price when set.
+	Price  *float64 `json:"price,omitempty" bson:"price,omitempty"`
+	Images []string `json:"images,omitempty" bson:"images,omitempty"`
+	// Stock is the quantity the variant starts with. Afterwards stock is
+	// tracked in the inventory and shown here on product reads.
+	Stock   int    `json:"stock" bson:"stock"`
+	Barcode string `json:"barcode,omitempty" bson:"barcode,omitempty"`
+}
@@ -0,0 +1,49 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Question is a shopper's question about a product. Questions and answers
+// are moderated like reviews and use the review statuses.
+type Question struct {
+	Id        primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	ProductId primitive.ObjectID `json:"product_id" bson:"product_id"`
+	UserId    primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Body      string             `json:"body" bson:"body"`
+	// AnswerCount is the number of approved answers.
+	AnswerCount     int                `json:"answer_count" bson:"answer_count"`
+	Status          string             `json:"status" bson:"status"`
+	Flags           []string           `json:"flags,omitempty" bson:"flags,omitempty"`
+	RejectionReason string             `json:"rejection_reason,omitempty" bson:"rejection_reason,omitempty"`
+	ModeratedBy     primitive.ObjectID `json:"moderated_by,omitempty" bson:"moderated_by,omitempty"`
+	ModeratedAt     *time.Time         `json:"moderated_at,omitempty" bson:"moderated_at,omitempty"`
+	TimeStamp       TimeStamp          `json:"time_stamp,omitempty" bson:"time_stamp,omitempty"`
+	// Answers holds the approved answers when questions are listed. It is
+	// not stored.
+	Answers []Answer `json:"answers,omitempty" bson:"-"`
+}
+
+// Answer replies to a question. Staff and customers who bought the product
+// may answer.
+type Answer struct {
+	Id         primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	QuestionId primitive.ObjectID `json:"question_id" bson:"question_id"`
+	ProductId  primitive.ObjectID `json:"product_id" bson:"product_id"`
+	UserId     primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Body       string             `json:"body" bson:"body"`
+	// Staff marks answers written by the store.
+	Staff            bool `json:"staff" bson:"staff"`
+	VerifiedPurchase bool `json:"verified_purchase" bson:"verified_purchase"`
+	Upvotes          int  `json:"upvotes" bson:"upvotes"`
+	// Voters are the users who upvoted, so each votes once.
+	Voters          []primitive.ObjectID `json:"-" bson:"voters,omitempty"`
+	Status          string               `json:"status" bson:"status"`
+	Flags           []string             `json:"flags,omitempty" bson:"flags,omitempty"`
+	RejectionReason string               `json:"rejection_reason,omitempty" bson:"rejection_reason,omitempty"`
+	ModeratedBy     primitive.ObjectID   `json:"moderated_by,omitempty" bson:"moderated_by,omitempty"`
+	ModeratedAt     *time.Time           `json:"moderated_at,omitempty" bson:"moderated_at,omitempty"`
+	TimeStamp       TimeStamp            `json:"time_stamp,omitempty" bson:"time_stamp,omitempty"`
+}
@@ -0,0 +1,38 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Review statuses. Only approved reviews are shown and counted in ratings.
+const (
+	ReviewPending  = "pending"
+	ReviewApproved = "approved"
+	ReviewRejected = "rejected"
+)
+
+// Review is a shopper's rating of a product. Each user reviews a product at
+// most once.
+type Review struct {
+	Id        primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	ProductId primitive.ObjectID `json:"product_id" bson:"product_id"`
+	UserId    primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Rating    int                `json:"rating" bson:"rating"`
+	Title     string             `json:"title" bson:"title"`
+	Body      string             `json:"body" bson:"body"`
+	Photos    []string           `json:"photos,omitempty" bson:"photos,omitempty"`
+	// VerifiedPurchase is set when the author bought the product.
+	VerifiedPurchase bool   `json:"verified_purchase" bson:"verified_purchase"`
+	Status           string `json:"status" bson:"status"`
+	// Flags are the reasons the automated screen held the review for a
+	// moderator.
+	Flags           []string           `json:"flags,omitempty" bson:"flags,omitempty"`
+	RejectionReason string             `json:"rejection_reason,omitempty" bson:"rejection_reason,omitempty"`
+	ModeratedBy     primitive.ObjectID `json:"moderated_by,omitempty" bson:"moderated_by,omitempty"`
+	ModeratedAt     *time.Time         `json:"moderated_at,omitempty" bson:"moderated_at,omitempty"`
+	// Fingerprint identifies the normalized text, to catch copied reviews.
+	Fingerprint string    `json:"-" bson:"fingerprint,omitempty"`
+	TimeStamp   TimeStamp `json:"time_stamp,omitempty" bson:"time_stamp,omitempty"`
+}
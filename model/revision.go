@@ -0,0 +1,38 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Revision actions
+const (
+	RevisionCreate   = "create"
+	RevisionUpdate   = "update"
+	RevisionDelete   = "delete"
+	RevisionRollback = "rollback"
+)
+
+// ProductRevision is an immutable snapshot of a product taken after each
+// write. Versions count up from 1 per product.
+type ProductRevision struct {
+	Id        primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	ProductId primitive.ObjectID `json:"product_id" bson:"product_id"`
+	Version   int                `json:"version" bson:"version"`
+	Action    string             `json:"action" bson:"action"`
+	Snapshot  Product            `json:"snapshot" bson:"snapshot"`
+	// Changes lists the fields that differ from the previous revision.
+	Changes []FieldChange `json:"changes" bson:"changes"`
+	// RestoredFrom is the version a rollback brought back.
+	RestoredFrom int                `json:"restored_from,omitempty" bson:"restored_from,omitempty"`
+	Actor        primitive.ObjectID `json:"actor,omitempty" bson:"actor,omitempty"`
+	CreatedAt    time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// FieldChange is the value of one product field before and after a change.
+type FieldChange struct {
+	Field  string      `json:"field" bson:"field"`
+	Before interface{} `json:"before" bson:"before"`
+	After  interface{} `json:"after" bson:"after"`
+}
@@ -14,6 +14,19 @@ type User struct {
 	TimeStamp TimeStamp          `json:"time_stamp,omitempty" bson:"time_stamp,omitempty"`
 }
 
+// User roles
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// AuthUser is the authenticated user attached to a request by the auth
+// middleware.
+type AuthUser struct {
+	Id   primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	Role string             `json:"role,omitempty" bson:"role,omitempty"`
+}
+
 type TimeStamp struct {
 	CreatedAt time.Time `json:"created_at,omitempty" bson:"created_at,omitempty"`
 	UpdatedAt time.Time `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
@@ -0,0 +1,15 @@
+package model
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// ProductTemplate holds the defaults a new product can start from, such as
+// the shared description and features of a case design.
+type ProductTemplate struct {
+	Id          primitive.ObjectID   `json:"id,omitempty" bson:"_id,omitempty"`
+	Name        string               `json:"name" bson:"name" binding:"required"`
+	Description string               `json:"description,omitempty" bson:"description,omitempty"`
+	Details     ProductDetails       `json:"details" bson:"details"`
+	Category    []string             `json:"category,omitempty" bson:"category,omitempty"`
+	CategoryIds []primitive.ObjectID `json:"category_ids,omitempty" bson:"category_ids,omitempty"`
+	TimeStamp   TimeStamp            `json:"time_stamp,omitempty" bson:"time_stamp,omitempty"`
+}
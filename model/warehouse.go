@@ -0,0 +1,29 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Warehouse is a location stock is held at and shipped from.
+type Warehouse struct {
+	Id      primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	Code    string             `json:"code" bson:"code" binding:"required"`
+	Name    string             `json:"name" bson:"name" binding:"required"`
+	Address string             `json:"address,omitempty" bson:"address,omitempty"`
+	// Priority orders warehouses for fulfillment, lowest first.
+	Priority  int       `json:"priority" bson:"priority"`
+	Active    bool      `json:"active" bson:"active"`
+	TimeStamp TimeStamp `json:"time_stamp,omitempty" bson:"time_stamp,omitempty"`
+}
+
+// WarehouseStock is the on-hand quantity of one SKU at one warehouse. The
+// quantities of a SKU across warehouses add up to its StockLevel.OnHand.
+type WarehouseStock struct {
+	Id          primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	SKU         string             `json:"sku" bson:"sku"`
+	WarehouseId primitive.ObjectID `json:"warehouse_id" bson:"warehouse_id"`
+	OnHand      int                `json:"on_hand" bson:"on_hand"`
+	UpdatedAt   time.Time          `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+}
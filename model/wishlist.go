@@ -0,0 +1,59 @@
+package model
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// WishlistType marks wishlist documents in a user's collection, which also
+// holds their cart.
+const WishlistType = "wishlist"
+
+// SavedForLater is the name of the list cart items are saved to.
+const SavedForLater = "Saved for later"
+
+// Wishlist is a named list of products a user wants to buy later.
+type Wishlist struct {
+	Id    primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	Type  string             `json:"-" bson:"type"`
+	Name  string             `json:"name" bson:"name" binding:"required"`
+	Items []WishlistItem     `json:"items" bson:"items"`
+	// ShareToken makes the list readable by anyone with the link.
+	ShareToken string    `json:"share_token,omitempty" bson:"share_token,omitempty"`
+	TimeStamp  TimeStamp `json:"time_stamp,omitempty" bson:"time_stamp,omitempty"`
+	// Version counts item changes, so concurrent changes do not overwrite
+	// each other.
+	Version int `json:"-" bson:"version"`
+}
+
+// WishlistItem is a product on a wishlist, optionally a single variant.
+type WishlistItem struct {
+	Id        primitive.ObjectID `json:"id" bson:"_id"`
+	ProductId primitive.ObjectID `json:"product_id" bson:"product_id"`
+	SKU       string             `json:"sku,omitempty" bson:"sku,omitempty"`
+	// PriceWhenAdded is the sale price when the item was saved.
+	PriceWhenAdded float64   `json:"price_when_added" bson:"price_when_added"`
+	AddedAt        time.Time `json:"added_at" bson:"added_at"`
+}
+
+// WishlistShare maps a share token to the list it opens. Lists live in
+// per-user collections, so shared lists are looked up through it.
+type WishlistShare struct {
+	Token      string             `json:"token" bson:"_id"`
+	UserId     primitive.ObjectID `json:"user_id" bson:"user_id"`
+	WishlistId primitive.ObjectID `json:"wishlist_id" bson:"wishlist_id"`
+	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// PriceWatch remembers the last price a user saw for a wishlisted item, to
+// notify them when it drops.
+type PriceWatch struct {
+	Id         primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	UserId     primitive.ObjectID `json:"user_id" bson:"user_id"`
+	WishlistId primitive.ObjectID `json:"wishlist_id" bson:"wishlist_id"`
+	ItemId     primitive.ObjectID `json:"item_id" bson:"item_id"`
+	ProductId  primitive.ObjectID `json:"product_id" bson:"product_id"`
+	SKU        string             `json:"sku,omitempty" bson:"sku,omitempty"`
+	Price      float64            `json:"price" bson:"price"`
+}
@@ -23,25 +23,153 @@ func Router() *gin.Engine {
 		AllowCredentials: true,
 		AllowOrigins:     allowOrigins,
 		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "X-Requested-With"},
-		ExposeHeaders:    []string{"Content-Length", "Content-Type", "Authorization"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "X-Requested-With", middleware.IdempotencyHeader},
+		ExposeHeaders:    []string{"Content-Length", "Content-Type", "Authorization", middleware.ReplayedHeader},
 		AllowWildcard:    true,
 		MaxAge:           12 * time.Hour,
 	}))
 
 	v1 := r.Group("/api/v1")
+	v1.Use(middleware.Idempotency)
 
 	v1.POST("/register", controllers.RegisterClient)
 	v1.POST("/login", controllers.LoginClient)
-	v1.POST("/addProduct", controllers.AddProduct)
-	v1.POST("/addManyProducts", controllers.AddManyProducts)
 	v1.GET("/getProducts", controllers.GetProducts)
 	v1.GET("/validate", middleware.ValidateAuth, controllers.Validate)
 	v1.GET("/filterProducts", controllers.FilterProducts)
+	v1.GET("/search", controllers.SearchProducts)
+	v1.GET("/search/suggest", controllers.SuggestSearch)
+	v1.GET("/feeds/google.xml", controllers.GoogleProductFeed)
+	v1.GET("/feeds/meta.csv", controllers.MetaProductFeed)
+	v1.GET("/feeds/report", controllers.FeedReport)
+	v1.GET("/sitemap.xml", controllers.Sitemap)
+	v1.GET("/sitemaps/:file", controllers.SitemapPage)
 	v1.GET("/getProduct/:id", controllers.GetById)
-	v1.PUT("/updateProduct/:id", controllers.UpdateProduct)
-	v1.DELETE("/deleteProduct/:id", controllers.DeleteProduct)
-	v1.DELETE("/deleteProducts", controllers.DeleteManyProducts)
+
+	v1.GET("/products/:id", controllers.GetProductBySlug)
+	v1.GET("/products/:id/variants", controllers.GetProductVariants)
+	v1.GET("/products/:id/collections", controllers.GetProductCollections)
+	v1.GET("/products/:id/reviews", controllers.GetProductReviews)
+	v1.POST("/products/:id/reviews", middleware.ValidateAuth, controllers.AddReview)
+	v1.PUT("/reviews/:id", middleware.ValidateAuth, controllers.UpdateReview)
+	v1.DELETE("/reviews/:id", middleware.ValidateAuth, controllers.DeleteReview)
+
+	v1.GET("/products/:id/questions", controllers.GetProductQuestions)
+	v1.POST("/products/:id/questions", middleware.ValidateAuth, controllers.AddQuestion)
+	v1.DELETE("/questions/:id", middleware.ValidateAuth, controllers.DeleteQuestion)
+	v1.POST("/questions/:id/answers", middleware.ValidateAuth, controllers.AddAnswer)
+	v1.DELETE("/answers/:id", middleware.ValidateAuth, controllers.DeleteAnswer)
+	v1.POST("/answers/:id/upvote", middleware.ValidateAuth, controllers.UpvoteAnswer)
+
+	v1.GET("/cart", middleware.OptionalAuth, controllers.GetCart)
+	v1.DELETE("/cart", middleware.OptionalAuth, controllers.ClearCart)
+	v1.POST("/cart/items", middleware.OptionalAuth, controllers.AddCartItem)
+	v1.PUT("/cart/items/:sku", middleware.OptionalAuth, controllers.UpdateCartItem)
+	v1.DELETE("/cart/items/:sku", middleware.OptionalAuth, controllers.RemoveCartItem)
+
+	v1.POST("/cart/items/:sku/save-for-later", middleware.ValidateAuth, controllers.SaveCartItemForLater)
+
+	v1.GET("/wishlists/shared/:token", controllers.GetSharedWishlist)
+	wishlists := v1.Group("/wishlists", middleware.ValidateAuth)
+	wishlists.GET("", controllers.GetWishlists)
+	wishlists.POST("", controllers.AddWishlist)
+	wishlists.GET("/:id", controllers.GetWishlist)
+	wishlists.PUT("/:id", controllers.RenameWishlist)
+	wishlists.DELETE("/:id", controllers.DeleteWishlist)
+	wishlists.POST("/:id/items", controllers.AddWishlistItem)
+	wishlists.DELETE("/:id/items/:item", controllers.RemoveWishlistItem)
+	wishlists.POST("/:id/items/:item/move-to-cart", controllers.MoveWishlistItemToCart)
+	wishlists.POST("/:id/share", controllers.ShareWishlist)
+	wishlists.DELETE("/:id/share", controllers.UnshareWishlist)
+
+	v1.GET("/shipping-methods", controllers.GetShippingMethods)
+	v1.POST("/checkout", middleware.ValidateAuth, controllers.RequirePayments, controllers.Checkout)
+	v1.GET("/orders", middleware.ValidateAuth, controllers.GetOrders)
+	v1.GET("/orders/:id", middleware.ValidateAuth, controllers.GetOrder)
+	v1.POST("/orders/:id/cancel", middleware.ValidateAuth, controllers.CancelOrder)
+	v1.POST("/orders/:id/pay", middleware.ValidateAuth, controllers.RequirePayments, controllers.PayOrder)
+	v1.POST("/payments/webhook", controllers.RequirePayments, controllers.PaymentWebhook)
+
+	v1.GET("/notifications", middleware.ValidateAuth, controllers.GetNotifications)
+	v1.POST("/notifications/:id/read", middleware.ValidateAuth, controllers.MarkNotificationRead)
+
+	v1.GET("/categories", controllers.GetCategories)
+	v1.GET("/categories/:id", controllers.GetCategory)
+	v1.GET("/categories/:id/products", controllers.GetCategoryProducts)
+
+	v1.GET("/collections", controllers.GetCollections)
+	v1.GET("/collections/:slug", controllers.GetCollection)
+
+	v1.GET("/devices", controllers.GetDevices)
+	v1.GET("/devices/:id", controllers.GetDevice)
+	v1.GET("/devices/:id/products", controllers.GetDeviceProducts)
+
+	admin := v1.Group("", middleware.ValidateAuth, middleware.RequireAdmin)
+	admin.POST("/addProduct", controllers.AddProduct)
+	admin.POST("/addManyProducts", controllers.AddManyProducts)
+	admin.PUT("/updateProduct/:id", controllers.UpdateProduct)
+	admin.DELETE("/deleteProduct/:id", controllers.DeleteProduct)
+	admin.DELETE("/deleteProducts", controllers.DeleteManyProducts)
+	admin.GET("/exportProducts", controllers.ExportProducts)
+
+	admin.POST("/devices", controllers.AddDevice)
+	admin.PUT("/devices/:id", controllers.UpdateDevice)
+	admin.DELETE("/devices/:id", controllers.DeleteDevice)
+
+	admin.GET("/admin/products", controllers.GetAdminProducts)
+	admin.GET("/admin/products/:id", controllers.GetAdminProduct)
+	admin.PUT("/products/:id/status", controllers.SetProductStatus)
+	admin.POST("/products/:id/clone", controllers.CloneProduct)
+	admin.GET("/products/:id/revisions", controllers.GetProductRevisions)
+	admin.GET("/products/:id/revisions/diff", controllers.DiffProductRevisions)
+	admin.GET("/products/:id/revisions/:version", controllers.GetProductRevision)
+	admin.POST("/products/:id/revisions/:version/rollback", controllers.RollbackProduct)
+
+	admin.GET("/admin/orders", controllers.GetAdminOrders)
+	admin.GET("/admin/orders/:id", controllers.GetOrder)
+	admin.PUT("/admin/orders/:id/status", controllers.SetOrderStatus)
+
+	admin.GET("/admin/reviews", controllers.GetReviewQueue)
+	admin.POST("/admin/reviews/:id/approve", controllers.ApproveReview)
+	admin.POST("/admin/reviews/:id/reject", controllers.RejectReview)
+	admin.GET("/admin/questions", controllers.GetQuestionQueue)
+	admin.POST("/admin/questions/:id/approve", controllers.ApproveQuestion)
+	admin.POST("/admin/questions/:id/reject", controllers.RejectQuestion)
+	admin.GET("/admin/answers", controllers.GetAnswerQueue)
+	admin.POST("/admin/answers/:id/approve", controllers.ApproveAnswer)
+	admin.POST("/admin/answers/:id/reject", controllers.RejectAnswer)
+
+	admin.GET("/templates", controllers.GetTemplates)
+	admin.GET("/templates/:id", controllers.GetTemplate)
+	admin.POST("/templates", controllers.AddTemplate)
+	admin.PUT("/templates/:id", controllers.UpdateTemplate)
+	admin.DELETE("/templates/:id", controllers.DeleteTemplate)
+
+	admin.POST("/categories", controllers.AddCategory)
+	admin.POST("/categories/migrate", controllers.MigrateCategories)
+	admin.PUT("/categories/:id", controllers.UpdateCategory)
+	admin.DELETE("/categories/:id", controllers.DeleteCategory)
+
+	admin.GET("/admin/collections", controllers.GetAllCollections)
+	admin.POST("/collections", controllers.AddCollection)
+	admin.PUT("/collections/:id", controllers.UpdateCollection)
+	admin.DELETE("/collections/:id", controllers.DeleteCollection)
+
+	admin.GET("/inventory", controllers.GetInventory)
+	admin.GET("/inventory/:sku", controllers.GetStockLevel)
+	admin.GET("/inventory/:sku/ledger", controllers.GetStockLedger)
+	admin.POST("/inventory/:sku/adjust", controllers.AdjustStock)
+	admin.PUT("/inventory/:sku/threshold", controllers.SetStockThreshold)
+	admin.POST("/inventory/transfers", controllers.TransferStock)
+	admin.POST("/reservations", controllers.ReserveStock)
+	admin.POST("/reservations/:id/commit", controllers.CommitReservation)
+	admin.POST("/reservations/:id/release", controllers.ReleaseReservation)
+
+	admin.GET("/warehouses", controllers.GetWarehouses)
+	admin.POST("/warehouses", controllers.AddWarehouse)
+	admin.PUT("/warehouses/:id", controllers.UpdateWarehouse)
+	admin.DELETE("/warehouses/:id", controllers.DeleteWarehouse)
+	admin.POST("/fulfillment/allocate", controllers.AllocateOrder)
 
 	return r
 }